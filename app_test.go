@@ -19,9 +19,12 @@ func TestNewBookmarchiveApp_Success(t *testing.T) {
 
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -36,14 +39,22 @@ func TestNewBookmarchiveApp_Success(t *testing.T) {
 			BusyTimeout: "1s",
 		},
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   0, // Use port 0 for testing
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -98,9 +109,12 @@ func TestNewBookmarchiveApp_DatabaseError(t *testing.T) {
 			Path: "/invalid/path/that/does/not/exist/db.sqlite",
 		},
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -135,9 +149,12 @@ func TestNewBookmarchiveApp_MastodonClientError(t *testing.T) {
 			Path: dbPath,
 		},
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "", // Invalid empty server
 			AccessToken: "test-token",
@@ -165,9 +182,12 @@ func TestBookmarchiveApp_Start_Success(t *testing.T) {
 
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -180,14 +200,22 @@ func TestBookmarchiveApp_Start_Success(t *testing.T) {
 			Path: dbPath,
 		},
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   0, // Use port 0 for testing
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -219,9 +247,12 @@ func TestBookmarchiveApp_Stop_Success(t *testing.T) {
 
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -234,14 +265,22 @@ func TestBookmarchiveApp_Stop_Success(t *testing.T) {
 			Path: dbPath,
 		},
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   0,
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -273,9 +312,12 @@ func TestBookmarchiveApp_Stop_WithoutStart(t *testing.T) {
 
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -311,9 +353,12 @@ func TestBookmarchiveApp_Run_WithSignal(t *testing.T) {
 
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -326,14 +371,22 @@ func TestBookmarchiveApp_Run_WithSignal(t *testing.T) {
 			Path: dbPath,
 		},
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   0,
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -425,3 +478,46 @@ func TestSetupLogging_WithCaller(t *testing.T) {
 
 	// Should not panic
 }
+
+func TestNewBookmarchiveApp_SharesConnectionPerAccount(t *testing.T) {
+	cfg := &Config{
+		Accounts: []AccountConfig{
+			{Name: "alice", Server: "https://mastodon.example.com", AccessToken: "alice-token"},
+			{Name: "bob", Server: "https://fosstodon.example.com", AccessToken: "bob-token"},
+		},
+	}
+	cfg.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	cfg.Polling.Sources = []string{SourceBookmark, SourceFavourite, SourceOwnStatus}
+
+	app, err := newBookmarchiveApp(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error creating app, got %v", err)
+	}
+	defer app.stop()
+
+	if len(app.bookmarkServices) != 6 {
+		t.Fatalf("Expected 6 services, got %d", len(app.bookmarkServices))
+	}
+
+	connections := make(map[string]*accountConnection)
+	refreshers := make(map[string]int)
+	for _, service := range app.bookmarkServices {
+		name := service.account.Name
+		if existing, ok := connections[name]; ok && existing != service.connection {
+			t.Errorf("Expected %s's services to share one connection", name)
+		}
+		connections[name] = service.connection
+		if !service.refreshDelegated {
+			refreshers[name]++
+		}
+	}
+
+	if connections["alice"] == connections["bob"] {
+		t.Error("Expected separate connections per account")
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if refreshers[name] != 1 {
+			t.Errorf("Expected one refresher for %s, got %d", name, refreshers[name])
+		}
+	}
+}
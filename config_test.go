@@ -61,7 +61,7 @@ func TestDefaultConfig(t *testing.T) {
 	}
 
 	// Test Search defaults
-	expectedFields := []string{"content", "spoiler_text", "username", "display_name", "media_descriptions", "hashtags"}
+	expectedFields := []string{"content", "spoiler_text", "username", "display_name", "media_descriptions", "hashtags", "card"}
 	if !reflect.DeepEqual(cfg.Search.IndexedFields, expectedFields) {
 		t.Errorf("Expected search indexed_fields %v, got %v", expectedFields, cfg.Search.IndexedFields)
 	}
@@ -380,6 +380,33 @@ indexed_fields = []
 	}
 }
 
+func TestLoadConfigSearchWeights(t *testing.T) {
+	configContent := `[search.weights]
+author = 2
+links = 0
+`
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "weights_config.toml")
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+
+	cfg := defaultConfig()
+	if err := loadConfig(configPath, &cfg); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	expected := map[string]float64{"author": 2, "links": 0}
+	if !reflect.DeepEqual(cfg.Search.Weights, expected) {
+		t.Errorf("Expected search weights %v, got %v", expected, cfg.Search.Weights)
+	}
+	if len(cfg.Search.IndexedFields) == 0 {
+		t.Error("Expected default indexed_fields to be kept")
+	}
+}
+
 func TestMergeStructsDirectly(t *testing.T) {
 	// Test the mergeStructs function directly to understand its behavior
 	type TestStruct struct {
@@ -647,3 +674,86 @@ backfill_delay = "15s"
 		t.Errorf("Expected backfill delay 15s, got %v", backfillDelay)
 	}
 }
+
+func TestLoadConfigAccounts(t *testing.T) {
+	configContent := `[[accounts]]
+name = "alice"
+server = "https://mastodon.social"
+access_token = "alice-token"
+
+[[accounts]]
+name = "bob"
+server = "https://fosstodon.org"
+access_token = "bob-token"
+client_timeout = "10s"
+`
+
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "accounts_config.toml")
+
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("Failed to write test config file: %v", err)
+	}
+
+	cfg := defaultConfig()
+	if err := loadConfig(configPath, &cfg); err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	accounts, err := cfg.accounts()
+	if err != nil {
+		t.Fatalf("Expected valid accounts, got %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("Expected 2 accounts, got %d", len(accounts))
+	}
+	if accounts[1].Name != "bob" || accounts[1].Server != "https://fosstodon.org" || accounts[1].ClientTimeout != "10s" {
+		t.Errorf("Unexpected second account: %+v", accounts[1])
+	}
+}
+
+func TestConfigAccounts(t *testing.T) {
+	cfg := defaultConfig()
+
+	accounts, err := cfg.accounts()
+	if err != nil {
+		t.Fatalf("Expected legacy account, got %v", err)
+	}
+	if len(accounts) != 1 || accounts[0].Name != "" || accounts[0].Server != cfg.Mastodon.Server {
+		t.Errorf("Expected [mastodon] section as the unnamed account, got %+v", accounts)
+	}
+
+	cfg.Accounts = []AccountConfig{{Name: "alice"}, {Name: "alice"}}
+	if _, err := cfg.accounts(); err == nil {
+		t.Error("Expected error for duplicate account names")
+	}
+
+	cfg.Accounts = []AccountConfig{{Server: "https://mastodon.social"}}
+	if _, err := cfg.accounts(); err == nil {
+		t.Error("Expected error for unnamed account")
+	}
+}
+
+func TestConfigSources(t *testing.T) {
+	cfg := defaultConfig()
+
+	sources, err := cfg.sources()
+	if err != nil || !reflect.DeepEqual(sources, []string{SourceBookmark}) {
+		t.Errorf("Expected bookmarks by default, got %v (%v)", sources, err)
+	}
+
+	cfg.Polling.Sources = []string{SourceBookmark, SourceFavourite, SourceOwnStatus}
+	if _, err := cfg.sources(); err != nil {
+		t.Errorf("Expected all sources to be valid, got %v", err)
+	}
+
+	cfg.Polling.Sources = []string{"boosts"}
+	if _, err := cfg.sources(); err == nil {
+		t.Error("Expected error for unknown source")
+	}
+
+	cfg.Polling.Sources = []string{SourceFavourite, SourceFavourite}
+	if _, err := cfg.sources(); err == nil {
+		t.Error("Expected error for duplicate source")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -212,6 +213,119 @@ func TestBuildSearchText_AllFields(t *testing.T) {
 	}
 }
 
+func TestBuildSearchFields_Columns(t *testing.T) {
+	bookmark := Bookmark{
+		Status: Status{
+			Content:          "<p>HTML <strong>content</strong></p>",
+			SpoilerText:      "Spoiler text",
+			Account:          Account{Username: "testuser", DisplayName: "Test User"},
+			MediaAttachments: []Media{{Description: "First image"}, {Description: ""}, {Description: "Second image"}},
+			Tags:             []Tag{{Name: "golang"}, {Name: "testing"}},
+			Card:             &Card{Title: "A card", ProviderName: "Example"},
+		},
+		LinkText:   "Page text",
+		ThreadText: "A reply",
+	}
+
+	fields := buildSearchFields(bookmark, []string{"content", "spoiler_text", "username", "display_name",
+		"media_descriptions", "hashtags", "card", "link_text", "thread"})
+	expected := map[string]string{
+		"content":  "HTML content",
+		"spoiler":  "Spoiler text",
+		"author":   "testuser Test User",
+		"media":    "First image Second image",
+		"hashtags": "golang testing",
+		"links":    "A card Example Page text",
+		"thread":   "A reply",
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected fields %v, got %v", expected, fields)
+	}
+
+	dbBookmark := convertBookmarkToDatabase(bookmark, []string{"username", "hashtags"})
+	if !reflect.DeepEqual(dbBookmark.SearchFields, map[string]string{"author": "testuser", "hashtags": "golang testing"}) {
+		t.Errorf("Expected only the indexed fields, got %v", dbBookmark.SearchFields)
+	}
+	if dbBookmark.SearchText != "testuser golang testing" {
+		t.Errorf("Expected search text to join the fields, got %q", dbBookmark.SearchText)
+	}
+}
+
+func TestBuildSearchText_CardAndLinkText(t *testing.T) {
+	bookmark := Bookmark{
+		Status: Status{
+			Content: "<p>Worth a read</p>",
+			Card: &Card{
+				URL:          "https://example.com/article",
+				Title:        "Garbage collection explained",
+				Description:  "A tour of tricolor marking",
+				ProviderName: "Example News",
+			},
+		},
+		LinkText: "The write barrier keeps the invariant",
+	}
+
+	searchText := buildSearchText(bookmark, []string{"content", "card", "link_text"})
+	for _, part := range []string{"Worth a read", "Garbage collection explained", "tricolor marking", "Example News", "write barrier"} {
+		if !strings.Contains(searchText, part) {
+			t.Errorf("Expected search text to contain '%s', got: %s", part, searchText)
+		}
+	}
+
+	searchText = buildSearchText(bookmark, []string{"content"})
+	if strings.Contains(searchText, "Garbage collection") || strings.Contains(searchText, "write barrier") {
+		t.Errorf("Expected card and link text to be indexed only when configured, got: %s", searchText)
+	}
+}
+
+func TestConvertBookmarkToDatabase_LinkTextNotInRawJSON(t *testing.T) {
+	bookmark := Bookmark{
+		ID:       "1",
+		Status:   Status{ID: "1", Card: &Card{URL: "https://example.com/article", Title: "An article"}},
+		LinkText: "full article body",
+	}
+
+	dbBookmark := convertBookmarkToDatabase(bookmark, []string{"link_text"})
+	if strings.Contains(dbBookmark.RawJSON, "full article body") {
+		t.Error("Expected link text to be kept out of raw_json")
+	}
+	if !strings.Contains(dbBookmark.RawJSON, "https://example.com/article") {
+		t.Error("Expected card to be stored in raw_json")
+	}
+	if dbBookmark.SearchText != "full article body" {
+		t.Errorf("Expected link text to be indexed, got '%s'", dbBookmark.SearchText)
+	}
+}
+
+func TestStatusHasLink(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  Status
+		hasLink bool
+	}{
+		{"PlainText", Status{Content: "<p>Nothing to see</p>"}, false},
+		{"Link", Status{Content: `<p>See <a href="https://go.dev/blog" rel="nofollow noopener">go.dev/blog</a></p>`}, true},
+		{"Card", Status{Content: "<p>Nothing to see</p>", Card: &Card{URL: "https://example.com"}}, true},
+		{"Mention", Status{Content: `<p><span class="h-card"><a href="https://mastodon.example/@alice" class="u-url mention">@<span>alice</span></a></span> hi</p>`}, false},
+		{"Hashtag", Status{Content: `<p><a href="https://mastodon.example/tags/go" class="mention hashtag" rel="tag">#<span>go</span></a></p>`}, false},
+		{"PleromaHashtag", Status{Content: `<a class="hashtag" data-tag="go" href="https://pleroma.example/tag/go">#go</a>`}, false},
+		{"MentionWordWithLink", Status{Content: `<p>Worth a mention, and another mention: <a href="https://example.com">example.com</a></p>`}, true},
+		{"MentionWordWithoutLink", Status{Content: "<p>No mention of links here</p>"}, false},
+		{"MentionAndLink", Status{Content: `<p><a href="https://mastodon.example/@alice" class="u-url mention">@alice</a> see <a href="https://example.com">example.com</a></p>`}, true},
+		{"MisskeyURL", Status{Content: misskeyTextToHTML("Release notes\n\nhttps://example.com/notes")}, true},
+		{"MisskeyMentionAndHashtag", Status{Content: misskeyTextToHTML("@alice@misskey.example a mention about #golang")}, false},
+		{"Empty", Status{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if hasLink := statusHasLink(tt.status); hasLink != tt.hasLink {
+				t.Errorf("Expected hasLink=%v, got %v", tt.hasLink, hasLink)
+			}
+		})
+	}
+}
+
 func TestBuildSearchText_SelectiveFields(t *testing.T) {
 	bookmark := Bookmark{
 		Status: Status{
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -976,7 +979,16 @@ func TestDatabase_SearchOrRecentBookmarks_WhitespaceQuery(t *testing.T) {
 // QUERY PREPARATION TESTS
 // =============================================================================
 
-func TestPrepareFTS5Query_SimpleWord(t *testing.T) {
+func searchQueryMatch(t *testing.T, query string) string {
+	t.Helper()
+	parsed, err := parseSearchQuery(query)
+	if err != nil {
+		t.Fatalf("parseSearchQuery(%q) failed: %v", query, err)
+	}
+	return parsed.Match
+}
+
+func TestParseSearchQuery_SimpleWord(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -987,14 +999,14 @@ func TestPrepareFTS5Query_SimpleWord(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := prepareFTS5Query(test.input)
+		result := searchQueryMatch(t, test.input)
 		if result != test.expected {
-			t.Errorf("prepareFTS5Query(%q) = %q, expected %q", test.input, result, test.expected)
+			t.Errorf("parseSearchQuery(%q).Match = %q, expected %q", test.input, result, test.expected)
 		}
 	}
 }
 
-func TestPrepareFTS5Query_MultipleWords(t *testing.T) {
+func TestParseSearchQuery_MultipleWords(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -1005,14 +1017,14 @@ func TestPrepareFTS5Query_MultipleWords(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := prepareFTS5Query(test.input)
+		result := searchQueryMatch(t, test.input)
 		if result != test.expected {
-			t.Errorf("prepareFTS5Query(%q) = %q, expected %q", test.input, result, test.expected)
+			t.Errorf("parseSearchQuery(%q).Match = %q, expected %q", test.input, result, test.expected)
 		}
 	}
 }
 
-func TestPrepareFTS5Query_QuotedString(t *testing.T) {
+func TestParseSearchQuery_QuotedString(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -1023,33 +1035,33 @@ func TestPrepareFTS5Query_QuotedString(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := prepareFTS5Query(test.input)
+		result := searchQueryMatch(t, test.input)
 		if result != test.expected {
-			t.Errorf("prepareFTS5Query(%q) = %q, expected %q", test.input, result, test.expected)
+			t.Errorf("parseSearchQuery(%q).Match = %q, expected %q", test.input, result, test.expected)
 		}
 	}
 }
 
-func TestPrepareFTS5Query_BooleanOperators(t *testing.T) {
+func TestParseSearchQuery_BooleanOperators(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
 	}{
-		{"hello AND world", "hello AND world"},
-		{"test OR example", "test OR example"},
-		{"golang NOT python", "golang NOT python"},
-		{"first AND second OR third", "first AND second OR third"},
+		{"hello AND world", "hello* world*"},
+		{"test OR example", "test* OR example*"},
+		{"golang NOT python", "golang*"},
+		{"first AND second OR third", "first* (second* OR third*)"},
 	}
 
 	for _, test := range tests {
-		result := prepareFTS5Query(test.input)
+		result := searchQueryMatch(t, test.input)
 		if result != test.expected {
-			t.Errorf("prepareFTS5Query(%q) = %q, expected %q", test.input, result, test.expected)
+			t.Errorf("parseSearchQuery(%q).Match = %q, expected %q", test.input, result, test.expected)
 		}
 	}
 }
 
-func TestPrepareFTS5Query_ExistingWildcards(t *testing.T) {
+func TestParseSearchQuery_ExistingWildcards(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -1060,14 +1072,14 @@ func TestPrepareFTS5Query_ExistingWildcards(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := prepareFTS5Query(test.input)
+		result := searchQueryMatch(t, test.input)
 		if result != test.expected {
-			t.Errorf("prepareFTS5Query(%q) = %q, expected %q", test.input, result, test.expected)
+			t.Errorf("parseSearchQuery(%q).Match = %q, expected %q", test.input, result, test.expected)
 		}
 	}
 }
 
-func TestPrepareFTS5Query_WhitespaceHandling(t *testing.T) {
+func TestParseSearchQuery_WhitespaceHandling(t *testing.T) {
 	tests := []struct {
 		input    string
 		expected string
@@ -1078,9 +1090,682 @@ func TestPrepareFTS5Query_WhitespaceHandling(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		result := prepareFTS5Query(test.input)
+		result := searchQueryMatch(t, test.input)
 		if result != test.expected {
-			t.Errorf("prepareFTS5Query(%q) = %q, expected %q", test.input, result, test.expected)
+			t.Errorf("parseSearchQuery(%q).Match = %q, expected %q", test.input, result, test.expected)
+		}
+	}
+}
+
+// =============================================================================
+// RECONCILIATION STATE TESTS
+// =============================================================================
+
+func TestDatabase_BookmarkStatesAndCounts(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	for _, id := range []string{"status-1", "status-2"} {
+		if err := db.insertBookmark(createTestBookmark(id, "content")); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	now := time.Now()
+	if err := db.setBookmarksUnbookmarked("", SourceBookmark, []string{"status-2"}, &now); err != nil {
+		t.Fatalf("Failed to mark bookmark removed: %v", err)
+	}
+
+	states, err := db.getBookmarkStates("", SourceBookmark)
+	if err != nil {
+		t.Fatalf("Failed to get bookmark states: %v", err)
+	}
+	if !states["status-1"] || states["status-2"] {
+		t.Errorf("Unexpected bookmark states: %v", states)
+	}
+
+	total, removed, err := db.getBookmarkCounts("")
+	if err != nil {
+		t.Fatalf("Failed to count bookmarks: %v", err)
+	}
+	if total != 2 || removed != 1 {
+		t.Errorf("Expected 2 total and 1 removed, got %d and %d", total, removed)
+	}
+
+	// Re-inserting a bookmark (re-bookmarked and polled again) clears the flag
+	if err := db.insertBookmark(createTestBookmark("status-2", "content")); err != nil {
+		t.Fatalf("Failed to re-insert bookmark: %v", err)
+	}
+	bookmark, err := db.getBookmark("status-2")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if bookmark.UnbookmarkedAt != nil {
+		t.Error("Expected re-inserted bookmark to be active")
+	}
+}
+
+func TestMigrations_VersionsIncrease(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Expected migration %q to have version %d, got %d", m.name, i+1, m.version)
+		}
+		if m.name == "" || len(m.steps) == 0 {
+			t.Errorf("Expected migration %d to have a name and steps", m.version)
+		}
+	}
+}
+
+func TestDatabase_RunMigrations_RecordsVersions(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	appliedCount := func() int {
+		t.Helper()
+		var count int
+		if err := db.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count); err != nil {
+			t.Fatalf("Failed to count applied migrations: %v", err)
+		}
+		return count
+	}
+	if count := appliedCount(); count != len(migrations) {
+		t.Fatalf("Expected %d applied migrations, got %d", len(migrations), count)
+	}
+
+	if err := db.runMigrations(); err != nil {
+		t.Fatalf("Failed to rerun migrations: %v", err)
+	}
+	if count := appliedCount(); count != len(migrations) {
+		t.Errorf("Expected rerunning migrations to apply nothing, got %d applied", count)
+	}
+}
+
+func TestDatabase_RunMigrations_FailureRollsBack(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	original := migrations
+	defer func() { migrations = original }()
+	version := latestSchemaVersion() + 1
+	migrations = append(slices.Clone(original), migration{version, "broken", []migrationStep{
+		execSQL(`CREATE TABLE half_done (id INTEGER)`, `NOT VALID SQL`),
+	}})
+
+	err := db.runMigrations()
+	if err == nil || !strings.Contains(err.Error(), fmt.Sprintf("migration %d (broken) failed", version)) {
+		t.Fatalf("Expected the broken migration to fail, got %v", err)
+	}
+
+	var count int
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'half_done'`).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected the failed migration to be rolled back, found %d tables (%v)", count, err)
+	}
+	if err := db.db.QueryRow(`SELECT COUNT(*) FROM schema_migrations WHERE version = ?`, version).Scan(&count); err != nil || count != 0 {
+		t.Errorf("Expected the failed migration not to be recorded, found %d (%v)", count, err)
+	}
+}
+
+func TestDatabase_RunMigrations_RefusesNewerSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "newer.db")
+	cfg := Config{}
+	cfg.Database.Path = dbPath
+
+	db, err := newDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create database: %v", err)
+	}
+	if _, err := db.db.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, 'from the future')`, latestSchemaVersion()+1); err != nil {
+		t.Fatalf("Failed to record newer migration: %v", err)
+	}
+	db.close()
+
+	if _, err := newDatabase(cfg); err == nil || !strings.Contains(err.Error(), "newer than this release") {
+		t.Fatalf("Expected a newer schema to be refused, got %v", err)
+	}
+
+	db, err = openDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database without migrating: %v", err)
+	}
+	defer db.close()
+	statuses, err := db.migrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	last := statuses[len(statuses)-1]
+	if !last.Unknown || last.Version != latestSchemaVersion()+1 || last.Name != "from the future" {
+		t.Errorf("Expected the newer migration to be listed as unknown, got %+v", last)
+	}
+}
+
+func TestDatabase_MigrationStatus(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "status.db")
+	cfg := Config{}
+	cfg.Database.Path = dbPath
+
+	db, err := openDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.close()
+
+	statuses, err := db.migrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status of a new database: %v", err)
+	}
+	if len(statuses) != len(migrations) || statuses[0].AppliedAt != nil {
+		t.Fatalf("Expected every migration to be pending, got %+v", statuses)
+	}
+
+	if err := db.runMigrations(); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if _, err := db.db.Exec(`DELETE FROM schema_migrations WHERE version = ?`, latestSchemaVersion()); err != nil {
+		t.Fatalf("Failed to forget migration: %v", err)
+	}
+	statuses, err = db.migrationStatus()
+	if err != nil {
+		t.Fatalf("Failed to get migration status: %v", err)
+	}
+	for _, status := range statuses {
+		if pending := status.AppliedAt == nil; pending != (status.Version == latestSchemaVersion()) {
+			t.Errorf("Unexpected status for migration %d: %+v", status.Version, status)
+		}
+	}
+
+	var out strings.Builder
+	printMigrationStatus(&out, statuses)
+	if !strings.Contains(out.String(), fmt.Sprintf("Schema version %d, 1 pending", latestSchemaVersion())) {
+		t.Errorf("Unexpected status output:\n%s", out.String())
+	}
+}
+
+// =============================================================================
+// ACCOUNT ARCHIVE TESTS
+// =============================================================================
+
+func TestDatabase_AccountArchivesAreSeparate(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	for _, owner := range []string{"alice", "bob"} {
+		bookmark := createTestBookmark("shared-status", owner+" archived this")
+		bookmark.OwnerAccount = owner
+		if err := db.insertBookmark(bookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark for %s: %v", owner, err)
+		}
+		if err := db.ensureBackfillState(owner, SourceBookmark); err != nil {
+			t.Fatalf("Failed to create backfill state for %s: %v", owner, err)
+		}
+	}
+
+	bookmark, err := db.getBookmarkForOwner("bob", SourceBookmark, "shared-status")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Failed to get bob's bookmark: %v", err)
+	}
+	if bookmark.OwnerAccount != "bob" || bookmark.SearchText != "bob archived this" {
+		t.Errorf("Unexpected bookmark for bob: %+v", bookmark)
+	}
+
+	total, _, err := db.getBookmarkCounts("")
+	if err != nil || total != 2 {
+		t.Errorf("Expected 2 bookmarks across accounts, got %d (%v)", total, err)
+	}
+	total, _, err = db.getBookmarkCounts("alice")
+	if err != nil || total != 1 {
+		t.Errorf("Expected 1 bookmark for alice, got %d (%v)", total, err)
+	}
+
+	results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: "archived", Account: "alice"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.OwnerAccount != "alice" {
+		t.Errorf("Expected only alice's bookmark, got %d results", len(results))
+	}
+
+	if err := db.updateBackfillStateForOwner("alice", SourceBookmark, "", true, nil); err != nil {
+		t.Fatalf("Failed to update alice's backfill state: %v", err)
+	}
+	state, err := db.getBackfillStateForOwner("bob", SourceBookmark)
+	if err != nil {
+		t.Fatalf("Failed to get bob's backfill state: %v", err)
+	}
+	if state.BackfillComplete {
+		t.Error("Expected bob's backfill to be unaffected by alice's")
+	}
+}
+
+func TestDatabase_ClaimUnownedRows(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	if err := db.insertBookmark(createTestBookmark("status-1", "content")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	if err := db.updateBackfillState("next-url", false, nil); err != nil {
+		t.Fatalf("Failed to update backfill state: %v", err)
+	}
+
+	if err := db.claimUnownedRows("alice"); err != nil {
+		t.Fatalf("Failed to claim rows: %v", err)
+	}
+
+	bookmark, err := db.getBookmarkForOwner("alice", SourceBookmark, "status-1")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Expected claimed bookmark for alice: %v", err)
+	}
+	state, err := db.getBackfillStateForOwner("alice", SourceBookmark)
+	if err != nil {
+		t.Fatalf("Expected claimed backfill state for alice: %v", err)
+	}
+	if state.LastProcessedID != "next-url" {
+		t.Errorf("Expected backfill position to carry over, got %q", state.LastProcessedID)
+	}
+}
+
+func TestDatabase_MigratesSingleAccountSchema(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "legacy.db")
+
+	// Build an archive the way a single-account release left it
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open legacy database: %v", err)
+	}
+	for _, stmt := range []string{
+		`CREATE TABLE bookmarks (status_id TEXT PRIMARY KEY, created_at DATETIME NOT NULL,
+			bookmarked_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, search_text TEXT NOT NULL, raw_json TEXT NOT NULL)`,
+		`CREATE VIRTUAL TABLE bookmarks_fts USING fts5(status_id UNINDEXED, search_text,
+			content='bookmarks', content_rowid='rowid', tokenize='porter unicode61 remove_diacritics 1')`,
+		`CREATE TRIGGER bookmarks_fts_insert AFTER INSERT ON bookmarks BEGIN
+			INSERT INTO bookmarks_fts(rowid, status_id, search_text) VALUES (new.rowid, new.status_id, new.search_text);
+		END`,
+		`CREATE TABLE backfill_state (id INTEGER PRIMARY KEY DEFAULT 1, last_processed_id TEXT,
+			backfill_complete BOOLEAN NOT NULL DEFAULT FALSE, last_poll_time DATETIME,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP, updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			CHECK (id = 1))`,
+		`INSERT INTO backfill_state (id, backfill_complete) VALUES (1, TRUE)`,
+		`INSERT INTO bookmarks (status_id, created_at, search_text, raw_json) VALUES ('old-1', '2024-01-01 00:00:00', 'legacy elephant', '{}')`,
+		`INSERT INTO bookmarks (status_id, created_at, search_text, raw_json) VALUES ('old-2', '2024-01-02 00:00:00', 'legacy mammoth', '{}')`,
+	} {
+		if _, err := legacy.Exec(stmt); err != nil {
+			t.Fatalf("Failed to build legacy schema: %v", err)
+		}
+	}
+	legacy.Close()
+
+	cfg := Config{}
+	cfg.Database.Path = dbPath
+	db, err := newDatabase(cfg)
+	if err != nil {
+		t.Fatalf("Failed to migrate legacy database: %v", err)
+	}
+	defer db.close()
+
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "mammoth"})
+	if err != nil {
+		t.Fatalf("Search failed after migration: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.StatusID != "old-2" {
+		t.Fatalf("Expected FTS index to survive the rebuild, got %d results", len(results))
+	}
+
+	state, err := db.getBackfillState()
+	if err != nil || !state.BackfillComplete {
+		t.Errorf("Expected backfill state to carry over, got %+v (%v)", state, err)
+	}
+
+	// A second account can now get its own state row and copy of a status
+	if err := db.ensureBackfillState("alice", SourceBookmark); err != nil {
+		t.Fatalf("Failed to add backfill state for a second account: %v", err)
+	}
+	bookmark := createTestBookmark("old-1", "alice elephant")
+	bookmark.OwnerAccount = "alice"
+	if err := db.insertBookmark(bookmark); err != nil {
+		t.Fatalf("Failed to insert bookmark for a second account: %v", err)
+	}
+	total, _, err := db.getBookmarkCounts("")
+	if err != nil || total != 3 {
+		t.Errorf("Expected 3 bookmarks after migration, got %d (%v)", total, err)
+	}
+}
+
+func TestDatabase_FTSUpdateTriggerOnlyWatchesSearchColumns(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	conn, err := db.getDB()
+	if err != nil {
+		t.Fatalf("Failed to get database: %v", err)
+	}
+
+	// Databases created before the fix carry a trigger that fires on any
+	// update, and predate recorded migrations
+	for _, stmt := range []string{
+		`CREATE TRIGGER bookmarks_fts_update AFTER UPDATE ON bookmarks BEGIN SELECT 1; END`,
+		`DROP TABLE schema_migrations`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Failed to build legacy schema: %v", err)
+		}
+	}
+	if err := db.runMigrations(); err != nil {
+		t.Fatalf("Failed to rerun migrations: %v", err)
+	}
+
+	rows, err := conn.Query(`SELECT name, sql FROM sqlite_master WHERE type = 'trigger' AND tbl_name = 'bookmarks' AND sql LIKE '%AFTER UPDATE%'`)
+	if err != nil {
+		t.Fatalf("Failed to list triggers: %v", err)
+	}
+	defer rows.Close()
+
+	var triggers []string
+	for rows.Next() {
+		var name, sql string
+		if err := rows.Scan(&name, &sql); err != nil {
+			t.Fatalf("Failed to scan trigger: %v", err)
+		}
+		if !strings.Contains(sql, "AFTER UPDATE OF "+searchColumnList("")+" ON") {
+			t.Errorf("Expected update trigger %s to watch the search columns only, got %s", name, sql)
 		}
+		triggers = append(triggers, name)
+	}
+	if len(triggers) != 1 {
+		t.Fatalf("Expected exactly one update trigger, got %v", triggers)
+	}
+
+	if err := db.insertBookmark(createTestBookmark("status-1", "original walrus")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	now := time.Now()
+	edited := &DBBookmark{StatusID: "status-1", SearchText: "edited narwhal", RawJSON: "{}"}
+	if err := db.updateBookmarkContent("", SourceBookmark, edited, now, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to update bookmark: %v", err)
+	}
+
+	for query, expected := range map[string]int{"narwhal": 1, "walrus": 0} {
+		results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != expected {
+			t.Errorf("Expected %d results for %q after an edit, got %d", expected, query, len(results))
+		}
+	}
+}
+
+func TestDatabase_MigrationFlagsLinkedBookmarks(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	linked := convertBookmarkToDatabase(Bookmark{ID: "linked", Status: Status{ID: "linked",
+		Content: `<p>See <a href="https://example.com">example.com</a></p>`}}, []string{"content"})
+	plain := convertBookmarkToDatabase(Bookmark{ID: "plain", Status: Status{ID: "plain",
+		Content: "<p>Just a mention of something</p>"}}, []string{"content"})
+	for _, bookmark := range []*DBBookmark{linked, plain} {
+		if err := db.insertBookmark(bookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	// Put the archive back as it was before the column was filled in
+	conn, err := db.getDB()
+	if err != nil {
+		t.Fatalf("Failed to get database: %v", err)
+	}
+	for _, stmt := range []string{
+		`UPDATE bookmarks SET has_link = 0`,
+		`DELETE FROM schema_migrations WHERE version = 16`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Failed to reset has_link: %v", err)
+		}
+	}
+
+	if err := db.runMigrations(); err != nil {
+		t.Fatalf("Failed to rerun migrations: %v", err)
+	}
+
+	for statusID, expected := range map[string]bool{"linked": true, "plain": false} {
+		var hasLink bool
+		if err := conn.QueryRow(`SELECT has_link FROM bookmarks WHERE status_id = ?`, statusID).Scan(&hasLink); err != nil {
+			t.Fatalf("Failed to read has_link: %v", err)
+		}
+		if hasLink != expected {
+			t.Errorf("Expected has_link=%v for %s, got %v", expected, statusID, hasLink)
+		}
+	}
+}
+
+func TestDatabase_MigratesSingleColumnSearchIndex(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	conn, err := db.getDB()
+	if err != nil {
+		t.Fatalf("Failed to get database: %v", err)
+	}
+
+	// Put back the index as it was before it had a column per field
+	for _, stmt := range []string{
+		`DROP TRIGGER bookmarks_fts_insert`,
+		`DROP TRIGGER bookmarks_fts_delete`,
+		`DROP TRIGGER bookmarks_fts_update_fields`,
+		`DROP TABLE bookmarks_fts`,
+		`CREATE VIRTUAL TABLE bookmarks_fts USING fts5(status_id UNINDEXED, search_text,
+			content='bookmarks', content_rowid='rowid', tokenize='porter unicode61 remove_diacritics 1')`,
+		`CREATE TRIGGER bookmarks_fts_update_search_text AFTER UPDATE OF search_text ON bookmarks BEGIN SELECT 1; END`,
+		`INSERT INTO bookmarks (status_id, created_at, search_text, raw_json) VALUES ('old-1', '2024-01-01 00:00:00', 'legacy pangolin', '{}')`,
+		`INSERT INTO bookmarks_fts(bookmarks_fts) VALUES('rebuild')`,
+		`DROP TABLE schema_migrations`,
+	} {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatalf("Failed to build single-column index: %v", err)
+		}
+	}
+
+	if err := db.runMigrations(); err != nil {
+		t.Fatalf("Failed to rerun migrations: %v", err)
+	}
+
+	exists, err := func() (bool, error) {
+		tx, err := conn.Begin()
+		if err != nil {
+			return false, err
+		}
+		defer tx.Rollback()
+		return columnExists(tx, "bookmarks_fts", "author_text")
+	}()
+	if err != nil || !exists {
+		t.Fatalf("Expected the index to have an author column (%v)", err)
+	}
+
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "content:pangolin", EnableHighlighting: true})
+	if err != nil {
+		t.Fatalf("Search failed after migration: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.StatusID != "old-1" {
+		t.Fatalf("Expected the existing bookmark to be searchable as content, got %d results", len(results))
+	}
+	if !strings.Contains(results[0].Snippet, "<mark>pangolin</mark>") {
+		t.Errorf("Expected a snippet from the migrated text, got %q", results[0].Snippet)
+	}
+}
+
+func TestDatabase_ReplaceStatusContext(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	first := StatusContext{
+		Ancestors:   []Status{{ID: "root"}, {ID: "parent"}},
+		Descendants: []Status{{ID: "reply-1"}},
+	}
+	if err := db.replaceStatusContext("", "status-1", first, time.Now()); err != nil {
+		t.Fatalf("Failed to store status context: %v", err)
+	}
+
+	thread, err := db.getStatusContext("", "status-1")
+	if err != nil {
+		t.Fatalf("Failed to get status context: %v", err)
+	}
+	if len(thread.Ancestors) != 2 || thread.Ancestors[0].ID != "root" || thread.Ancestors[1].ID != "parent" {
+		t.Errorf("Expected ancestors in thread order, got %+v", thread.Ancestors)
+	}
+
+	// A later fetch replaces the stored thread, dropping deleted replies
+	second := StatusContext{Ancestors: []Status{{ID: "root"}, {ID: "parent"}}}
+	if err := db.replaceStatusContext("", "status-1", second, time.Now()); err != nil {
+		t.Fatalf("Failed to replace status context: %v", err)
+	}
+	thread, err = db.getStatusContext("", "status-1")
+	if err != nil {
+		t.Fatalf("Failed to get status context: %v", err)
+	}
+	if len(thread.Descendants) != 0 {
+		t.Errorf("Expected descendants to be replaced, got %+v", thread.Descendants)
+	}
+
+	// Other accounts' archives have their own threads
+	thread, err = db.getStatusContext("alice", "status-1")
+	if err != nil {
+		t.Fatalf("Failed to get status context: %v", err)
+	}
+	if len(thread.Ancestors) != 0 {
+		t.Errorf("Expected no thread for another account, got %+v", thread.Ancestors)
+	}
+}
+
+// =============================================================================
+// ANNOTATION TESTS
+// =============================================================================
+
+func TestDatabase_Annotations(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	// The same status bookmarked and favourited shares its annotations
+	for _, source := range []string{SourceBookmark, SourceFavourite} {
+		bookmark := createTestBookmark("status-1", "a post about ferns")
+		bookmark.OwnerAccount = "alice"
+		bookmark.Source = source
+		if err := db.insertBookmark(bookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+	other := createTestBookmark("status-2", "another post")
+	other.OwnerAccount = "alice"
+	if err := db.insertBookmark(other); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	if _, err := db.setBookmarkTags("alice", "status-1", []string{"botany", "later"}); err != nil {
+		t.Fatalf("Failed to set tags: %v", err)
+	}
+	if _, err := db.setBookmarkNote("alice", "status-1", "remember the walrus"); err != nil {
+		t.Fatalf("Failed to set note: %v", err)
+	}
+	annotations, err := db.setBookmarkCollections("alice", "status-1", []string{"Garden", "Reading list"})
+	if err != nil {
+		t.Fatalf("Failed to set collections: %v", err)
+	}
+	if !reflect.DeepEqual(annotations.Tags, []string{"botany", "later"}) || annotations.Note != "remember the walrus" ||
+		!reflect.DeepEqual(annotations.Collections, []string{"Garden", "Reading list"}) || annotations.UpdatedAt == nil {
+		t.Errorf("Unexpected annotations: %+v", annotations)
+	}
+
+	search := func(query string) int {
+		t.Helper()
+		results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("Search for %q failed: %v", query, err)
+		}
+		for _, result := range results {
+			if result.Bookmark.StatusID != "status-1" {
+				t.Errorf("Expected %q to find status-1 only, got %s", query, result.Bookmark.StatusID)
+			}
+		}
+		return len(results)
+	}
+	for _, query := range []string{"walrus", "notes:botany", "tag:later", `in:"reading list"`} {
+		if count := search(query); count != 2 {
+			t.Errorf("Expected %q to find both copies of the status, got %d", query, count)
+		}
+	}
+
+	// Archiving the status again or refreshing it keeps the note indexed
+	bookmark := createTestBookmark("status-1", "a post about mosses")
+	bookmark.OwnerAccount = "alice"
+	if err := db.insertBookmark(bookmark); err != nil {
+		t.Fatalf("Failed to re-insert bookmark: %v", err)
+	}
+	now := time.Now()
+	edited := &DBBookmark{StatusID: "status-1", SearchText: "an edited post", RawJSON: "{}"}
+	if err := db.updateBookmarkContent("alice", SourceFavourite, edited, now, now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to update bookmark: %v", err)
+	}
+	if count := search("notes:walrus"); count != 2 {
+		t.Errorf("Expected the note to survive updates, found %d copies", count)
+	}
+
+	// Clearing annotations takes them out of the index
+	if _, err := db.setBookmarkNote("alice", "status-1", ""); err != nil {
+		t.Fatalf("Failed to clear note: %v", err)
+	}
+	if _, err := db.setBookmarkTags("alice", "status-1", nil); err != nil {
+		t.Fatalf("Failed to clear tags: %v", err)
+	}
+	if count := search("notes:walrus OR notes:botany"); count != 0 {
+		t.Errorf("Expected cleared annotations not to match, got %d results", count)
+	}
+}
+
+func TestDatabase_Collections(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	for _, id := range []string{"status-1", "status-2"} {
+		if _, err := db.setBookmarkCollections("", id, []string{"Recipes"}); err != nil {
+			t.Fatalf("Failed to set collections: %v", err)
+		}
+	}
+	if _, err := db.setBookmarkCollections("bob", "status-1", []string{"Recipes", "Travel"}); err != nil {
+		t.Fatalf("Failed to set collections: %v", err)
+	}
+
+	collections, err := db.listCollections("")
+	if err != nil {
+		t.Fatalf("Failed to list collections: %v", err)
+	}
+	if len(collections) != 3 || collections[0].Name != "Recipes" || collections[0].OwnerAccount != "" || collections[0].Count != 2 {
+		t.Errorf("Unexpected collections: %+v", collections)
+	}
+
+	// Collections are removed once nothing is in them
+	if _, err := db.setBookmarkCollections("bob", "status-1", []string{"Recipes"}); err != nil {
+		t.Fatalf("Failed to set collections: %v", err)
+	}
+	collections, err = db.listCollections("bob")
+	if err != nil {
+		t.Fatalf("Failed to list collections: %v", err)
+	}
+	if len(collections) != 1 || collections[0].Name != "Recipes" || collections[0].Count != 1 {
+		t.Errorf("Expected only bob's Recipes collection, got %+v", collections)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	tags, err := normalizeTags([]string{" #Later", "botany", "later", ""})
+	if err != nil || !reflect.DeepEqual(tags, []string{"botany", "later"}) {
+		t.Errorf("Unexpected tags %v (%v)", tags, err)
+	}
+	for _, invalid := range []string{"two words", strings.Repeat("x", maxTagLength+1)} {
+		if _, err := normalizeTags([]string{invalid}); err == nil {
+			t.Errorf("Expected tag %q to be rejected", invalid)
+		}
+	}
+
+	collections, err := normalizeCollections([]string{" Reading   list ", "Recipes", "Reading list"})
+	if err != nil || !reflect.DeepEqual(collections, []string{"Reading list", "Recipes"}) {
+		t.Errorf("Unexpected collections %v (%v)", collections, err)
 	}
 }
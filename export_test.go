@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// ARCHIVE EXPORT TESTS
+// =============================================================================
+
+// insertExportTestBookmarks archives two full statuses for alice, one of
+// them annotated, and a row without a full status for the unnamed account.
+func insertExportTestBookmarks(t *testing.T, db *Database) {
+	t.Helper()
+
+	first := testBookmark("status-1")
+	first.Status.URL = "https://example.social/@bob/1"
+	first.Status.Content = "<p>Ferns &amp; <b>mosses</b></p>"
+	first.Status.Account = Account{ID: "2", Username: "bob", Acct: "bob@example.social", DisplayName: "Bob"}
+	first.Status.Tags = []Tag{{Name: "botany"}}
+	second := testBookmark("status-2")
+	second.Status.URL = "https://example.social/@carol/2"
+	second.Status.Account = Account{ID: "3", Username: "carol"}
+
+	for i, bookmark := range []Bookmark{first, second} {
+		converted := convertBookmarkToDatabase(bookmark, []string{"content"})
+		converted.OwnerAccount = "alice"
+		converted.BookmarkedAt = time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := db.insertBookmark(converted); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+	if err := db.insertBookmark(createTestBookmark("legacy-1", "legacy text")); err != nil {
+		t.Fatalf("Failed to insert legacy bookmark: %v", err)
+	}
+
+	if _, err := db.setBookmarkTags("alice", "status-1", []string{"later"}); err != nil {
+		t.Fatalf("Failed to set tags: %v", err)
+	}
+	if _, err := db.setBookmarkNote("alice", "status-1", "read <this>"); err != nil {
+		t.Fatalf("Failed to set note: %v", err)
+	}
+	if _, err := db.setBookmarkCollections("alice", "status-1", []string{"Garden"}); err != nil {
+		t.Fatalf("Failed to set collections: %v", err)
+	}
+}
+
+func exportToString(t *testing.T, db *Database, request *SearchRequest, format string) string {
+	t.Helper()
+	var out bytes.Buffer
+	if _, err := exportBookmarks(context.Background(), db, request, format, &out); err != nil {
+		t.Fatalf("Export as %s failed: %v", format, err)
+	}
+	return out.String()
+}
+
+func TestExportBookmarks_JSON(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	var exported []ExportedBookmark
+	if err := json.Unmarshal([]byte(exportToString(t, db, &SearchRequest{}, "json")), &exported); err != nil {
+		t.Fatalf("Failed to parse JSON export: %v", err)
+	}
+	if len(exported) != 3 {
+		t.Fatalf("Expected 3 bookmarks, got %d", len(exported))
+	}
+
+	var annotated *ExportedBookmark
+	for i := range exported {
+		if exported[i].StatusID == "status-1" {
+			annotated = &exported[i]
+		}
+	}
+	if annotated == nil {
+		t.Fatal("Expected status-1 in the export")
+	}
+	if annotated.Account != "alice" || annotated.Text != "Ferns & mosses" || annotated.Author != "bob@example.social" ||
+		annotated.Note != "read <this>" || len(annotated.Tags) != 1 || len(annotated.Collections) != 1 ||
+		len(annotated.Hashtags) != 1 || annotated.Raw == nil {
+		t.Errorf("Unexpected exported bookmark: %+v", annotated)
+	}
+
+	var empty []ExportedBookmark
+	if err := json.Unmarshal([]byte(exportToString(t, db, &SearchRequest{Query: "nothing-matches"}, "json")), &empty); err != nil || len(empty) != 0 {
+		t.Errorf("Expected an empty array for no matches, got %v (%v)", empty, err)
+	}
+}
+
+func TestExportBookmarks_JSONLines(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	scanner := bufio.NewScanner(strings.NewReader(exportToString(t, db, &SearchRequest{Account: "alice"}, "jsonl")))
+	var ids []string
+	for scanner.Scan() {
+		var exported ExportedBookmark
+		if err := json.Unmarshal(scanner.Bytes(), &exported); err != nil {
+			t.Fatalf("Failed to parse line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, exported.StatusID)
+	}
+	// Newest bookmark first
+	if len(ids) != 2 || ids[0] != "status-2" || ids[1] != "status-1" {
+		t.Errorf("Expected alice's bookmarks newest first, got %v", ids)
+	}
+}
+
+func TestExportBookmarks_CSV(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	records, err := csv.NewReader(strings.NewReader(exportToString(t, db, &SearchRequest{Query: "tag:later"}, "csv"))).ReadAll()
+	if err != nil {
+		t.Fatalf("Failed to parse CSV export: %v", err)
+	}
+	if len(records) != 2 || strings.Join(records[0], ",") != strings.Join(csvExportHeader, ",") {
+		t.Fatalf("Expected a header and one row, got %v", records)
+	}
+	row := make(map[string]string)
+	for i, column := range csvExportHeader {
+		row[column] = records[1][i]
+	}
+	if row["status_id"] != "status-1" || row["tags"] != "later" || row["collections"] != "Garden" ||
+		row["hashtags"] != "botany" || row["bookmarked_at"] != "2024-01-01T00:00:00Z" {
+		t.Errorf("Unexpected CSV row: %v", row)
+	}
+}
+
+func TestExportBookmarks_Markdown(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	out := exportToString(t, db, &SearchRequest{Account: "alice", Limit: 1, Offset: 1}, "markdown")
+	for _, expected := range []string{
+		"# Bookmarks\n",
+		"## [Bob: Ferns & mosses](<https://example.social/@bob/1>)",
+		"> Ferns & mosses",
+		"Tags: \\#later · Collections: Garden",
+		"Note: read \\<this\\>",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in the Markdown export:\n%s", expected, out)
+		}
+	}
+	if strings.Contains(out, "status-2") || strings.Contains(out, "carol") {
+		t.Errorf("Expected the limit and offset to leave out status-2:\n%s", out)
+	}
+}
+
+func TestExportBookmarks_NetscapeHTML(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	out := exportToString(t, db, &SearchRequest{}, "netscape-html")
+	if !strings.HasPrefix(out, "<!DOCTYPE NETSCAPE-Bookmark-file-1>") || !strings.HasSuffix(out, "</DL><p>\n") {
+		t.Errorf("Expected a complete bookmark file:\n%s", out)
+	}
+	if !strings.Contains(out, `<DT><A HREF="https://example.social/@bob/1" ADD_DATE="1704067200" TAGS="botany,later">Bob: Ferns &amp; mosses</A>`) {
+		t.Errorf("Expected status-1 with its tags:\n%s", out)
+	}
+	if !strings.Contains(out, "<DD>read &lt;this&gt;") {
+		t.Errorf("Expected the note as the description:\n%s", out)
+	}
+	if strings.Count(out, "<DT>") != 2 {
+		t.Errorf("Expected statuses without a URL to be left out:\n%s", out)
+	}
+}
+
+func TestExportBookmarks_UnknownFormat(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	if _, err := exportBookmarks(context.Background(), db, &SearchRequest{}, "xml", &bytes.Buffer{}); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
+
+func TestSearchRequestFromQuery(t *testing.T) {
+	values := url.Values{"query": {"ferns"}, "account": {"alice"}, "filter_by_state": {"active"}, "limit": {"5"}, "sort_by": {"bookmarked"}}
+	request, err := searchRequestFromQuery(values)
+	if err != nil {
+		t.Fatalf("Failed to read search request: %v", err)
+	}
+	if request.Query != "ferns" || request.Account != "alice" || request.FilterByState != "active" || request.Limit != 5 || request.SortBy != sortByBookmarked {
+		t.Errorf("Unexpected search request: %+v", request)
+	}
+
+	for _, invalid := range []url.Values{{"limit": {"many"}}, {"offset": {"-1"}}, {"query": {`"unclosed`}}, {"sort_by": {"title"}}} {
+		if _, err := searchRequestFromQuery(invalid); err == nil {
+			t.Errorf("Expected %v to be rejected", invalid)
+		}
+	}
+}
+
+func TestWebServer_HandleExport(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/export?format=csv&account=alice", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/csv; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", got)
+	}
+	if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, "bookmarchive.csv") {
+		t.Errorf("Unexpected content disposition %q", got)
+	}
+	if lines := strings.Count(w.Body.String(), "\n"); lines != 3 {
+		t.Errorf("Expected a header and 2 rows, got %d lines", lines)
+	}
+
+	for _, target := range []string{"/api/export?format=xml", "/api/export?query=from:", "/api/export?limit=-1"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, w.Code)
+		}
+	}
+}
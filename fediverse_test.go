@@ -0,0 +1,434 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// =============================================================================
+// FEDIVERSE SERVER TESTS
+// =============================================================================
+
+// fediverseFixtureServer replays the responses recorded from a server in
+// testdata/fediverse/<dialect>: its nodeinfo, the authenticated account and
+// one page of bookmarks holding the same post.
+func fediverseFixtureServer(t *testing.T, dialect string) *httptest.Server {
+	t.Helper()
+
+	fixture := func(name string) []byte {
+		data, err := os.ReadFile(filepath.Join("testdata", "fediverse", dialect, name))
+		if err != nil {
+			t.Fatalf("Failed to read fixture: %v", err)
+		}
+		return data
+	}
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/nodeinfo", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"links":[
+			{"rel":"http://nodeinfo.diaspora.software/ns/schema/2.0","href":"%[1]s/nodeinfo/2.0"},
+			{"rel":"http://nodeinfo.diaspora.software/ns/schema/2.1","href":"%[1]s/nodeinfo/2.1"}
+		]}`, server.URL)
+	})
+	mux.HandleFunc("GET /nodeinfo/2.1", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fixture("nodeinfo.json"))
+	})
+
+	if dialect == "misskey" {
+		checkToken := func(w http.ResponseWriter, r *http.Request) bool {
+			var body map[string]interface{}
+			json.NewDecoder(r.Body).Decode(&body)
+			if body["i"] != "test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":{"code":"CREDENTIAL_REQUIRED"}}`))
+				return false
+			}
+			return true
+		}
+		mux.HandleFunc("POST /api/i", func(w http.ResponseWriter, r *http.Request) {
+			if checkToken(w, r) {
+				w.Write(fixture("i.json"))
+			}
+		})
+		mux.HandleFunc("POST /api/i/favorites", func(w http.ResponseWriter, r *http.Request) {
+			if checkToken(w, r) {
+				w.Write(fixture("favorites.json"))
+			}
+		})
+	} else {
+		mux.HandleFunc("GET /api/v1/accounts/verify_credentials", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(fixture("verify_credentials.json"))
+		})
+		mux.HandleFunc("GET /api/v1/bookmarks", func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer test-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/bookmarks?max_id=42>; rel="next"`, server.URL))
+			w.Write(fixture("bookmarks.json"))
+		})
+	}
+
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestAccountConnection_FediverseDialects(t *testing.T) {
+	tests := []struct {
+		dialect  string
+		software string
+		userID   string
+		statusID string
+		hasNext  bool
+	}{
+		{"mastodon", SoftwareMastodon, "109876543210", "111222333444555666", true},
+		{"gotosocial", SoftwareGoToSocial, "01HQ8ZK5V3Y2X1W0V9U8T7S6R5", "01HR3K7M2N4P6Q8R0S2T4V6W8X", true},
+		{"akkoma", SoftwarePleroma, "AfD3rT6yq0ZbL8dQZk", "AfKq2yB4mZcRtV9x1E", true},
+		{"misskey", SoftwareMisskey, "9qwe8rty7u", "9rnote0001", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect, func(t *testing.T) {
+			server := fediverseFixtureServer(t, tt.dialect)
+			db := setupTestDatabase(t)
+			defer db.close()
+
+			connection := newAccountConnection(AccountConfig{
+				Name:        "test",
+				Server:      server.URL,
+				AccessToken: "test-token",
+			}, db)
+			client, err := connection.bookmarkClient()
+			if err != nil {
+				t.Fatalf("Failed to create bookmark client: %v", err)
+			}
+
+			if connection.software != tt.software {
+				t.Errorf("Expected software %q, got %q", tt.software, connection.software)
+			}
+			_, isMisskey := client.(*MisskeyBookmarkClient)
+			if isMisskey != (tt.software == SoftwareMisskey) {
+				t.Errorf("Unexpected client type %T for %s", client, tt.dialect)
+			}
+
+			user, err := db.getUserAccountForOwner("test")
+			if err != nil {
+				t.Fatalf("Failed to get stored user account: %v", err)
+			}
+			if user.AccountID != tt.userID || user.Username != "alice" {
+				t.Errorf("Unexpected stored account %s/%s", user.AccountID, user.Username)
+			}
+
+			bookmarks, next, err := client.GetBookmarks(context.Background(), 20, "")
+			if err != nil {
+				t.Fatalf("Failed to get bookmarks: %v", err)
+			}
+			if (next != "") != tt.hasNext {
+				t.Errorf("Unexpected next page %q", next)
+			}
+			if len(bookmarks) != 1 {
+				t.Fatalf("Expected 1 bookmark, got %d", len(bookmarks))
+			}
+
+			// Every dialect normalizes to the same post
+			status := bookmarks[0].Status
+			if bookmarks[0].ID != tt.statusID || status.ID != tt.statusID {
+				t.Errorf("Expected status ID %s, got %s", tt.statusID, status.ID)
+			}
+			if status.Account.Username != "bob" {
+				t.Errorf("Expected author bob, got %q", status.Account.Username)
+			}
+			if !strings.Contains(status.Content, "Fixture post about") || !strings.Contains(status.Content, "sqlite") {
+				t.Errorf("Unexpected content %q", status.Content)
+			}
+			if !strings.HasPrefix(status.URI, "https://other.example/") || status.URL == "" {
+				t.Errorf("Unexpected URI %q and URL %q", status.URI, status.URL)
+			}
+			if status.CreatedAt.IsZero() {
+				t.Error("Expected creation time to be set")
+			}
+			if len(status.MediaAttachments) != 1 || status.MediaAttachments[0].Type != "image" || status.MediaAttachments[0].Description != "A diagram" {
+				t.Errorf("Unexpected media %+v", status.MediaAttachments)
+			}
+			if len(status.Tags) != 1 || status.Tags[0].Name != "sqlite" {
+				t.Errorf("Unexpected tags %+v", status.Tags)
+			}
+
+			// The post is archived and found like any Mastodon bookmark
+			dbBookmark := convertBookmarkToDatabase(bookmarks[0], []string{"content", "media_descriptions", "hashtags"})
+			if !strings.Contains(dbBookmark.SearchText, "A diagram") {
+				t.Errorf("Expected media description in search text, got %q", dbBookmark.SearchText)
+			}
+		})
+	}
+}
+
+func TestAccountConnection_DetectionFailureAssumesMastodon(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/accounts/verify_credentials", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"1","username":"alice","acct":"alice"}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	connection := newAccountConnection(AccountConfig{Name: "test", Server: server.URL, AccessToken: "test-token"}, db)
+	client, err := connection.bookmarkClient()
+	if err != nil {
+		t.Fatalf("Failed to create bookmark client: %v", err)
+	}
+	if _, ok := client.(*MastodonBookmarkClient); !ok {
+		t.Errorf("Expected Mastodon client, got %T", client)
+	}
+	if connection.software != SoftwareMastodon || connection.accountID != "1" {
+		t.Errorf("Unexpected software %q and account ID %q", connection.software, connection.accountID)
+	}
+}
+
+func TestDetectServerSoftware(t *testing.T) {
+	tests := []struct {
+		name     string
+		software string
+		family   string
+	}{
+		{"Sharkey", "Sharkey", SoftwareMisskey},
+		{"Firefish", "firefish", SoftwareMisskey},
+		{"Pleroma", "pleroma", SoftwarePleroma},
+		{"Hometown", "hometown", SoftwareMastodon},
+		{"Unknown", "someday-server", SoftwareMastodon},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/.well-known/nodeinfo":
+					fmt.Fprintf(w, `{"links":[{"rel":"http://nodeinfo.diaspora.software/ns/schema/2.0","href":"%s/nodeinfo/2.0"}]}`, server.URL)
+				case "/nodeinfo/2.0":
+					fmt.Fprintf(w, `{"version":"2.0","software":{"name":%q,"version":"1.0"}}`, tt.software)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer server.Close()
+
+			family, name, err := detectServerSoftware(context.Background(), server.Client(), server.URL+"/")
+			if err != nil {
+				t.Fatalf("Failed to detect software: %v", err)
+			}
+			if family != tt.family {
+				t.Errorf("Expected family %q, got %q", tt.family, family)
+			}
+			if name != strings.ToLower(tt.software) {
+				t.Errorf("Expected name %q, got %q", strings.ToLower(tt.software), name)
+			}
+		})
+	}
+}
+
+func TestDetectServerSoftware_NoNodeinfo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/.well-known/nodeinfo" {
+			w.Write([]byte(`{"links":[]}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	if _, _, err := detectServerSoftware(context.Background(), server.Client(), server.URL); err == nil {
+		t.Error("Expected error for server without nodeinfo")
+	}
+}
+
+// misskeyTestServer answers Misskey API calls with the response the handler
+// returns for the endpoint and its decoded parameters.
+func misskeyTestServer(t *testing.T, handler func(endpoint string, params map[string]interface{}) (int, string)) *MisskeyBookmarkClient {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			t.Errorf("Expected POST, got %s", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var params map[string]interface{}
+		if err := json.Unmarshal(body, &params); err != nil {
+			t.Errorf("Invalid request body: %v", err)
+		}
+		if params["i"] != "test-token" {
+			t.Errorf("Expected token in body, got %v", params["i"])
+		}
+		status, response := handler(strings.TrimPrefix(r.URL.Path, "/api/"), params)
+		w.WriteHeader(status)
+		w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	client := newMisskeyBookmarkClient(server.URL, "test-token", 0)
+	client.accountID = "9qwe8rty7u"
+	return client
+}
+
+func TestMisskeyBookmarkClient_GetCollection_OwnNotesPaging(t *testing.T) {
+	client := misskeyTestServer(t, func(endpoint string, params map[string]interface{}) (int, string) {
+		if endpoint != "users/notes" {
+			t.Errorf("Unexpected endpoint %s", endpoint)
+		}
+		if params["userId"] != "9qwe8rty7u" || params["withRenotes"] != false {
+			t.Errorf("Unexpected params %v", params)
+		}
+		if params["untilId"] == "n2" {
+			return 200, `[{"id":"n3","createdAt":"2024-01-01T00:00:00Z","text":"third","user":{"id":"9qwe8rty7u","username":"alice"}}]`
+		}
+		return 200, `[
+			{"id":"n1","createdAt":"2024-01-03T00:00:00Z","text":"first","user":{"id":"9qwe8rty7u","username":"alice"}},
+			{"id":"n2","createdAt":"2024-01-02T00:00:00Z","text":"second","user":{"id":"9qwe8rty7u","username":"alice"}}
+		]`
+	})
+
+	page, next, err := client.GetCollection(context.Background(), SourceOwnStatus, 2, "")
+	if err != nil {
+		t.Fatalf("Failed to get own notes: %v", err)
+	}
+	if len(page) != 2 || next != "n2" {
+		t.Fatalf("Expected 2 notes and next page n2, got %d and %q", len(page), next)
+	}
+	if page[0].Status.URI != client.server+"/notes/n1" || page[0].Status.Content != "<p>first</p>" {
+		t.Errorf("Unexpected status %+v", page[0].Status)
+	}
+
+	page, next, err = client.GetCollection(context.Background(), SourceOwnStatus, 2, next)
+	if err != nil {
+		t.Fatalf("Failed to get second page: %v", err)
+	}
+	if len(page) != 1 || next != "" {
+		t.Errorf("Expected last page of 1 note, got %d and %q", len(page), next)
+	}
+}
+
+func TestMisskeyBookmarkClient_GetCollection_Reactions(t *testing.T) {
+	client := misskeyTestServer(t, func(endpoint string, params map[string]interface{}) (int, string) {
+		if endpoint != "users/reactions" || params["userId"] != "9qwe8rty7u" {
+			t.Errorf("Unexpected call %s %v", endpoint, params)
+		}
+		return 200, `[{"id":"r1","createdAt":"2024-02-01T00:00:00Z","note":{"id":"n1","createdAt":"2024-01-01T00:00:00Z","text":"liked","user":{"id":"u2","username":"bob","host":"other.example"}}}]`
+	})
+
+	page, _, err := client.GetCollection(context.Background(), SourceFavourite, 20, "")
+	if err != nil {
+		t.Fatalf("Failed to get reactions: %v", err)
+	}
+	if len(page) != 1 || page[0].ID != "n1" || page[0].CreatedAt.Month() != 2 {
+		t.Errorf("Unexpected reactions %+v", page)
+	}
+}
+
+func TestMisskeyBookmarkClient_GetStatus_NotFound(t *testing.T) {
+	client := misskeyTestServer(t, func(endpoint string, params map[string]interface{}) (int, string) {
+		return 400, `{"error":{"message":"No such note.","code":"NO_SUCH_NOTE","id":"24fcbfc6-2e37-42b6-8388-c29b3861a08d"}}`
+	})
+
+	_, err := client.GetStatus(context.Background(), "gone")
+	if !errors.Is(err, errStatusNotFound) {
+		t.Errorf("Expected errStatusNotFound, got %v", err)
+	}
+}
+
+func TestMisskeyBookmarkClient_GetContext(t *testing.T) {
+	client := misskeyTestServer(t, func(endpoint string, params map[string]interface{}) (int, string) {
+		if params["noteId"] != "n3" {
+			t.Errorf("Unexpected note ID %v", params["noteId"])
+		}
+		switch endpoint {
+		case "notes/conversation":
+			return 200, `[{"id":"n2","text":"parent"},{"id":"n1","text":"root"}]`
+		case "notes/children":
+			return 200, `[{"id":"n4","text":"reply"}]`
+		}
+		t.Errorf("Unexpected endpoint %s", endpoint)
+		return 404, `{}`
+	})
+
+	thread, err := client.GetContext(context.Background(), "n3")
+	if err != nil {
+		t.Fatalf("Failed to get context: %v", err)
+	}
+	if len(thread.Ancestors) != 2 || thread.Ancestors[0].ID != "n1" || thread.Ancestors[1].ID != "n2" {
+		t.Errorf("Expected ancestors oldest first, got %+v", thread.Ancestors)
+	}
+	if len(thread.Descendants) != 1 || thread.Descendants[0].ID != "n4" {
+		t.Errorf("Unexpected descendants %+v", thread.Descendants)
+	}
+}
+
+func TestMisskeyBookmarkClient_ResolveStatus(t *testing.T) {
+	client := misskeyTestServer(t, func(endpoint string, params map[string]interface{}) (int, string) {
+		if endpoint != "ap/show" {
+			t.Errorf("Unexpected endpoint %s", endpoint)
+		}
+		if params["uri"] == "https://other.example/@bob" {
+			return 200, `{"type":"User","object":{"id":"u2"}}`
+		}
+		return 200, `{"type":"Note","object":{"id":"n9","text":"resolved","uri":"https://other.example/notes/n9"}}`
+	})
+
+	status, err := client.ResolveStatus(context.Background(), "https://other.example/notes/n9")
+	if err != nil {
+		t.Fatalf("Failed to resolve note: %v", err)
+	}
+	if status.ID != "n9" || status.URL != "https://other.example/notes/n9" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+
+	if _, err := client.ResolveStatus(context.Background(), "https://other.example/@bob"); !errors.Is(err, errStatusNotFound) {
+		t.Errorf("Expected errStatusNotFound for a user, got %v", err)
+	}
+}
+
+func TestConvertMisskeyNote_PureRenote(t *testing.T) {
+	note := misskeyNote{
+		ID:     "boost",
+		User:   misskeyUser{ID: "u1", Username: "alice"},
+		Renote: &misskeyNote{ID: "orig", Text: "original", User: misskeyUser{ID: "u2", Username: "bob"}},
+	}
+
+	status := convertMisskeyNote(note, "https://sharkey.example")
+	if status.ID != "orig" || status.Account.Username != "bob" {
+		t.Errorf("Expected renoted note, got %s by %s", status.ID, status.Account.Username)
+	}
+}
+
+func TestMisskeyTextToHTML(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected string
+	}{
+		{"", ""},
+		{"hello", "<p>hello</p>"},
+		{"a <b> & c", "<p>a &lt;b&gt; &amp; c</p>"},
+		{"line one\nline two", "<p>line one<br>line two</p>"},
+		{"first\r\n\r\nsecond\n\n\n", "<p>first</p><p>second</p>"},
+	}
+
+	for _, tt := range tests {
+		if got := misskeyTextToHTML(tt.text); got != tt.expected {
+			t.Errorf("misskeyTextToHTML(%q) = %q, want %q", tt.text, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// BOOKMARK FEEDS TESTS
+// =============================================================================
+
+const feedTestMediaHash = "4a5f2c0e8b3d1f6a7c9e0b2d4f6a8c0e2b4d6f8a0c2e4b6d8f0a2c4e6b8d0f2a"
+
+// insertFeedTestBookmarks archives the export test bookmarks, with an image
+// on status-1 that was archived and one on status-2 that was not.
+func insertFeedTestBookmarks(t *testing.T, db *Database) {
+	t.Helper()
+	insertExportTestBookmarks(t, db)
+
+	first := testBookmark("status-1")
+	first.Status.URL = "https://example.social/@bob/1"
+	first.Status.Content = `<p>Ferns &amp; <b>mosses</b><script>alert(1)</script></p>`
+	first.Status.SpoilerText = "plants"
+	first.Status.Account = Account{ID: "2", Username: "bob", Acct: "bob@example.social", DisplayName: "Bob"}
+	first.Status.Tags = []Tag{{Name: "botany"}}
+	first.Status.MediaAttachments = []Media{{ID: "m1", Type: "image", URL: "https://files.example.social/m1.png"}}
+	second := testBookmark("status-2")
+	second.Status.URL = "https://example.social/@carol/2"
+	second.Status.Account = Account{ID: "3", Username: "carol"}
+	second.Status.MediaAttachments = []Media{{ID: "m2", Type: "image", URL: "https://files.example.social/m2.png"}}
+
+	for i, bookmark := range []Bookmark{first, second} {
+		converted := convertBookmarkToDatabase(bookmark, []string{"content"})
+		converted.OwnerAccount = "alice"
+		converted.BookmarkedAt = time.Date(2024, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		if err := db.insertBookmark(converted); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+	if err := db.insertMediaFile(&MediaFile{
+		RemoteURL:    "https://files.example.social/m1.png",
+		StatusID:     "status-1",
+		MediaID:      "m1",
+		Variant:      "original",
+		SHA256:       feedTestMediaHash,
+		Size:         1234,
+		MimeType:     "image/png",
+		DownloadedAt: time.Now(),
+	}); err != nil {
+		t.Fatalf("Failed to insert media file: %v", err)
+	}
+}
+
+func getFeed(t *testing.T, mux *http.ServeMux, target string, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest("GET", target, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebServer_HandleFeed_Atom(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertFeedTestBookmarks(t, db)
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := getFeed(t, mux, "/feeds/bookmarks.atom?account=alice", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/atom+xml; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", got)
+	}
+
+	var doc atomFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse Atom feed: %v", err)
+	}
+	if len(doc.Entries) != 2 {
+		t.Fatalf("Expected 2 entries, got %d", len(doc.Entries))
+	}
+	// The annotated status changed last but was bookmarked first
+	newest, annotated := doc.Entries[0], doc.Entries[1]
+	if newest.ID != "urn:bookmarchive:alice:bookmark:status-2" {
+		t.Errorf("Expected the newest bookmark first, got %q", newest.ID)
+	}
+	if annotated.Published != "2024-01-01T00:00:00Z" || annotated.Updated <= annotated.Published {
+		t.Errorf("Expected the annotation to update the entry, got published %s and updated %s", annotated.Published, annotated.Updated)
+	}
+	if doc.Updated != annotated.Updated {
+		t.Errorf("Expected the feed to be updated at its latest entry, got %s", doc.Updated)
+	}
+	if doc.Links[0].Href != "http://example.com/feeds/bookmarks.atom?account=alice" {
+		t.Errorf("Unexpected self link %q", doc.Links[0].Href)
+	}
+
+	content := annotated.Content.Body
+	if strings.Contains(content, "<script") || !strings.Contains(content, "<b>mosses</b>") {
+		t.Errorf("Expected sanitized status HTML, got %q", content)
+	}
+	if !strings.Contains(content, "CW: plants") || !strings.Contains(content, "Note: read &lt;this&gt;") {
+		t.Errorf("Expected the content warning and note, got %q", content)
+	}
+	var enclosure *atomLink
+	for i, link := range annotated.Links {
+		if link.Rel == "enclosure" {
+			enclosure = &annotated.Links[i]
+		}
+	}
+	if enclosure == nil || enclosure.Href != "http://example.com/media/"+feedTestMediaHash || enclosure.Type != "image/png" || enclosure.Length != 1234 {
+		t.Errorf("Expected the archived image as an enclosure, got %+v", enclosure)
+	}
+	var terms []string
+	for _, category := range annotated.Categories {
+		terms = append(terms, category.Term)
+	}
+	if strings.Join(terms, ",") != "botany,later" {
+		t.Errorf("Expected hashtags and tags as categories, got %v", terms)
+	}
+}
+
+func TestWebServer_HandleFeed_RSS(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertFeedTestBookmarks(t, db)
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	cfg := &Config{}
+	cfg.Web.BaseURL = "https://archive.example.net/"
+	mux := newWebServer(cfg, db, eventChan).setupRoutes()
+
+	w := getFeed(t, mux, "/feeds/bookmarks.rss?query=mosses", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, `xmlns:atom="http://www.w3.org/2005/Atom"`) || !strings.Contains(body, `<atom:link rel="self" href="https://archive.example.net/feeds/bookmarks.rss?query=mosses"`) {
+		t.Errorf("Expected a self link in the Atom namespace, got %s", body)
+	}
+	if !strings.Contains(body, "<link>https://archive.example.net/</link>") {
+		t.Errorf("Expected the channel to link the configured base URL, got %s", body)
+	}
+
+	var doc rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse RSS feed: %v", err)
+	}
+	if doc.Channel.Title != "Bookmarchive: mosses" {
+		t.Errorf("Unexpected channel title %q", doc.Channel.Title)
+	}
+	if len(doc.Channel.Items) != 1 {
+		t.Fatalf("Expected the query to match 1 item, got %d", len(doc.Channel.Items))
+	}
+	item := doc.Channel.Items[0]
+	if item.PubDate != "Mon, 01 Jan 2024 00:00:00 +0000" || item.Link != "https://example.social/@bob/1" {
+		t.Errorf("Unexpected item date %q or link %q", item.PubDate, item.Link)
+	}
+	if item.Enclosure == nil || item.Enclosure.URL != "https://archive.example.net/media/"+feedTestMediaHash {
+		t.Errorf("Expected the archived image as the enclosure, got %+v", item.Enclosure)
+	}
+}
+
+func TestWebServer_HandleFeed_JSON(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertFeedTestBookmarks(t, db)
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := getFeed(t, mux, "/feeds/bookmarks.json?account=alice&limit=1", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/feed+json; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", got)
+	}
+
+	var doc jsonFeed
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("Failed to parse JSON feed: %v", err)
+	}
+	if doc.Version != "https://jsonfeed.org/version/1.1" || len(doc.Items) != 1 {
+		t.Fatalf("Expected a JSON Feed 1.1 with 1 item, got %q with %d", doc.Version, len(doc.Items))
+	}
+	item := doc.Items[0]
+	if item.ID != "urn:bookmarchive:alice:bookmark:status-2" {
+		t.Errorf("Expected the newest bookmark, got %q", item.ID)
+	}
+	// Media that was not archived links the remote copy
+	if len(item.Attachments) != 1 || item.Attachments[0].URL != "https://files.example.social/m2.png" || item.Attachments[0].MimeType != "image/png" {
+		t.Errorf("Expected the remote image as an attachment, got %+v", item.Attachments)
+	}
+	if len(item.Authors) != 1 || item.Authors[0].Name != "carol" {
+		t.Errorf("Unexpected authors %+v", item.Authors)
+	}
+}
+
+func TestWebServer_HandleFeed_Conditional(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertFeedTestBookmarks(t, db)
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := getFeed(t, mux, "/feeds/bookmarks.atom", nil)
+	etag, lastModified := w.Header().Get("ETag"), w.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatalf("Expected ETag and Last-Modified, got %q and %q", etag, lastModified)
+	}
+
+	if w := getFeed(t, mux, "/feeds/bookmarks.atom", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 for a matching ETag, got %d", w.Code)
+	}
+	if w := getFeed(t, mux, "/feeds/bookmarks.atom", http.Header{"If-Modified-Since": {lastModified}}); w.Code != http.StatusNotModified {
+		t.Errorf("Expected 304 when not modified since, got %d", w.Code)
+	}
+
+	if _, err := db.setBookmarkNote("alice", "status-2", "changed"); err != nil {
+		t.Fatalf("Failed to set note: %v", err)
+	}
+	if w := getFeed(t, mux, "/feeds/bookmarks.atom", http.Header{"If-None-Match": {etag}}); w.Code != http.StatusOK {
+		t.Errorf("Expected the changed feed after a new note, got %d", w.Code)
+	}
+
+	for _, target := range []string{"/feeds/bookmarks.atom?query=from:", "/feeds/bookmarks.rss?limit=x", "/feeds/bookmarks.json?sort_by=title"} {
+		if w := getFeed(t, mux, target, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestDatabase_SearchSortByBookmarked(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertFeedTestBookmarks(t, db)
+
+	for _, sortBy := range []string{"", sortByBookmarked} {
+		results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "mosses OR content", Account: "alice", SortBy: sortBy})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		var ids []string
+		for _, result := range results {
+			ids = append(ids, result.Bookmark.StatusID)
+		}
+		if len(ids) != 2 {
+			t.Fatalf("Expected 2 matches, got %v", ids)
+		}
+		if sortBy == sortByBookmarked && ids[0] != "status-2" {
+			t.Errorf("Expected the newest bookmark first, got %v", ids)
+		}
+	}
+}
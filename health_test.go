@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// HEALTH CHECKS TESTS
+// =============================================================================
+
+func getHealthReport(t *testing.T, mux *http.ServeMux, target string) (int, healthReport) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected JSON, got %s", contentType)
+	}
+	var report healthReport
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("Failed to decode health report: %v", err)
+	}
+	return w.Code, report
+}
+
+func TestWebServer_HandleHealth(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	code, report := getHealthReport(t, mux, "/healthz")
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("Expected a healthy report, got %d %+v", code, report)
+	}
+	if len(report.Components) != 1 || report.Components[0].Name != "database" {
+		t.Errorf("Expected the database component, got %+v", report.Components)
+	}
+
+	db.close()
+	code, report = getHealthReport(t, mux, "/healthz")
+	if code != http.StatusServiceUnavailable || report.Status != "failing" {
+		t.Errorf("Expected a failing report with the database closed, got %d %+v", code, report)
+	}
+	if !slices.Equal(report.Failing, []string{"database"}) {
+		t.Errorf("Expected the database to fail, got %v", report.Failing)
+	}
+}
+
+func TestWebServer_HandleReady(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	if err := db.ensureBackfillState("alice", SourceBookmark); err != nil {
+		t.Fatalf("Failed to create backfill state: %v", err)
+	}
+
+	now := time.Now()
+	tests := []struct {
+		name     string
+		health   func(*syncHealth)
+		lastPoll time.Time
+		reason   string
+	}{
+		{
+			name:   "not started",
+			health: func(h *syncHealth) {},
+			reason: "not started",
+		},
+		{
+			name:   "credentials not verified",
+			health: func(h *syncHealth) { h.started() },
+			reason: "credentials not verified",
+		},
+		{
+			name: "stopped",
+			health: func(h *syncHealth) {
+				h.started()
+				h.failed(errors.New("backfill failed"))
+			},
+			reason: "stopped after an error",
+		},
+		{
+			name: "failing polls",
+			health: func(h *syncHealth) {
+				h.started()
+				h.verified()
+				for range 3 {
+					h.polled(errors.New("server unavailable"))
+				}
+			},
+			lastPoll: now,
+			reason:   "last 3 polls failed",
+		},
+		{
+			name: "stale",
+			health: func(h *syncHealth) {
+				h.started()
+				h.verified()
+				h.startedAt = now.Add(-time.Hour)
+			},
+			lastPoll: now.Add(-40 * time.Minute),
+			reason:   "no successful poll for",
+		},
+		{
+			name: "ready",
+			health: func(h *syncHealth) {
+				h.started()
+				h.verified()
+				h.polled(errors.New("server unavailable"))
+				h.polled(nil)
+				h.startedAt = now.Add(-time.Hour)
+			},
+			lastPoll: now.Add(-5 * time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastPoll *time.Time
+			if !tt.lastPoll.IsZero() {
+				lastPoll = &tt.lastPoll
+			}
+			if err := db.updateBackfillStateForOwner("alice", SourceBookmark, "", true, lastPoll); err != nil {
+				t.Fatalf("Failed to update backfill state: %v", err)
+			}
+
+			health := newSyncHealth("alice", SourceBookmark)
+			tt.health(health)
+			cfg := &Config{}
+			cfg.Polling.Interval = "10m"
+			eventChan := make(chan ServerEvent, 10)
+			defer close(eventChan)
+			webServer := newWebServer(cfg, db, eventChan)
+			webServer.syncHealth = []*syncHealth{health}
+
+			code, report := getHealthReport(t, webServer.setupRoutes(), "/readyz")
+			if len(report.Components) != 2 {
+				t.Fatalf("Expected database and collection components, got %+v", report.Components)
+			}
+			component := report.Components[1]
+			if component.Name != "sync:alice/bookmark" {
+				t.Errorf("Expected component sync:alice/bookmark, got %s", component.Name)
+			}
+			if tt.reason == "" {
+				if code != http.StatusOK || component.Status != "ok" || report.Failing != nil {
+					t.Errorf("Expected ready, got %d %+v", code, report)
+				}
+				return
+			}
+			if code != http.StatusServiceUnavailable || component.Status != "failing" {
+				t.Errorf("Expected not ready, got %d %+v", code, report)
+			}
+			if !strings.HasPrefix(component.Reason, tt.reason) {
+				t.Errorf("Expected reason %q, got %q", tt.reason, component.Reason)
+			}
+			if !slices.Equal(report.Failing, []string{"sync:alice/bookmark"}) {
+				t.Errorf("Expected the collection to fail, got %v", report.Failing)
+			}
+		})
+	}
+}
+
+func TestBookmarkService_Start_RecordsError(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	service, err := newAccountBookmarkService(&Config{}, db, nil, AccountConfig{
+		Name:        "alice",
+		Server:      "http://127.0.0.1:1",
+		AccessToken: "token",
+	}, SourceBookmark)
+	if err != nil {
+		t.Fatalf("Failed to create service: %v", err)
+	}
+	if err := service.start(); err == nil {
+		t.Fatal("Expected start to fail without a reachable server")
+	}
+
+	status := service.health.snapshot()
+	if !status.Stopped || status.CredentialsVerified {
+		t.Errorf("Expected a stopped service with unverified credentials, got %+v", status)
+	}
+	if !strings.Contains(status.LastError, "failed to create bookmark client") || status.LastErrorAt == nil {
+		t.Errorf("Expected the start error to be recorded, got %+v", status)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	cfg := &Config{}
+	cfg.Accounts = []AccountConfig{{Name: "alice", Server: "http://127.0.0.1:1", AccessToken: "token"}}
+	webServer := newWebServer(cfg, db, eventChan)
+	webServer.syncHealth = []*syncHealth{service.health}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	w := httptest.NewRecorder()
+	webServer.setupRoutes().ServeHTTP(w, req)
+	var stats map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to decode stats: %v", err)
+	}
+	if lastError, _ := stats["last_error"].(string); lastError != status.LastError {
+		t.Errorf("Expected last_error %q in stats, got %v", status.LastError, stats["last_error"])
+	}
+	if stats["sync_stopped"] != true {
+		t.Errorf("Expected sync_stopped in stats, got %v", stats["sync_stopped"])
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// =============================================================================
+// ARCHIVE IMPORT TESTS
+// =============================================================================
+
+const testOutbox = `{
+	"@context": "https://www.w3.org/ns/activitystreams",
+	"type": "OrderedCollection",
+	"orderedItems": [
+		{
+			"type": "Create",
+			"object": {
+				"id": "https://mastodon.example.com/users/alice/statuses/1001",
+				"type": "Note",
+				"url": "https://mastodon.example.com/@alice/1001",
+				"published": "2023-05-01T10:00:00Z",
+				"summary": "",
+				"content": "<p>Notes on <a href=\"https://mastodon.example.com/tags/sqlite\">#sqlite</a> full text search</p>",
+				"attachment": [
+					{"type": "Document", "mediaType": "image/png", "url": "/media_attachments/files/1.png", "name": "A query plan"}
+				],
+				"tag": [
+					{"type": "Hashtag", "name": "#sqlite", "href": "https://mastodon.example.com/tags/sqlite"},
+					{"type": "Mention", "name": "@bob", "href": "https://other.example.com/users/bob"}
+				]
+			}
+		},
+		{
+			"type": "Announce",
+			"object": "https://other.example.com/users/bob/statuses/5"
+		}
+	]
+}`
+
+const testActor = `{
+	"type": "Person",
+	"preferredUsername": "alice",
+	"name": "Alice",
+	"icon": {"type": "Image", "url": "avatar.png"}
+}`
+
+const testLikes = `{
+	"type": "OrderedCollection",
+	"orderedItems": [
+		"https://other.example.com/users/bob/statuses/7",
+		"https://other.example.com/users/carol/statuses/8"
+	]
+}`
+
+const testArchiveBookmarks = `{
+	"type": "OrderedCollection",
+	"orderedItems": ["https://other.example.com/users/bob/statuses/9"]
+}`
+
+// writeTestArchive writes a ZIP holding the given files and returns its path.
+func writeTestArchive(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "archive.zip")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	w := zip.NewWriter(f)
+	for name, content := range files {
+		entry, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s: %v", name, err)
+		}
+		if _, err := entry.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+	return path
+}
+
+// MockResolver resolves the statuses it knows by URI.
+type MockResolver struct {
+	statuses map[string]Status
+	resolved []string
+}
+
+func (m *MockResolver) ResolveStatus(ctx context.Context, uri string) (Status, error) {
+	m.resolved = append(m.resolved, uri)
+	status, ok := m.statuses[uri]
+	if !ok {
+		return Status{}, errStatusNotFound
+	}
+	return status, nil
+}
+
+func newTestImporter(t *testing.T) (*Importer, *Database) {
+	t.Helper()
+
+	db := setupTestDatabase(t)
+	t.Cleanup(func() { db.close() })
+
+	cfg := &Config{}
+	cfg.Search.IndexedFields = []string{"content", "media_descriptions", "hashtags"}
+	return newImporter(cfg, db, ""), db
+}
+
+func TestImporter_AccountArchive_Offline(t *testing.T) {
+	importer, db := newTestImporter(t)
+	path := writeTestArchive(t, map[string]string{
+		"actor.json":     testActor,
+		"outbox.json":    testOutbox,
+		"likes.json":     testLikes,
+		"bookmarks.json": testArchiveBookmarks,
+	})
+
+	result, err := importer.importFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result != (ImportResult{Imported: 1, Unresolved: 3}) {
+		t.Errorf("Expected 1 imported and 3 unresolved, got %+v", result)
+	}
+
+	own, err := db.getBookmarkForOwner("", SourceOwnStatus, "1001")
+	if err != nil || own == nil {
+		t.Fatalf("Expected own post archived under its server ID: %v", err)
+	}
+	for _, want := range []string{"full text search", "A query plan", "sqlite"} {
+		if !strings.Contains(own.SearchText, want) {
+			t.Errorf("Expected own post search text to contain %q, got %q", want, own.SearchText)
+		}
+	}
+	if !strings.Contains(own.RawJSON, `"username":"alice"`) {
+		t.Errorf("Expected author from actor.json, got %s", own.RawJSON)
+	}
+	if strings.Contains(own.RawJSON, "media_attachments/files") {
+		t.Errorf("Expected archive-relative media URLs to be dropped, got %s", own.RawJSON)
+	}
+
+	liked, err := db.getBookmarkForOwner("", SourceFavourite, "https://other.example.com/users/bob/statuses/7")
+	if err != nil || liked == nil {
+		t.Fatalf("Expected unresolved favourite stored by URI: %v", err)
+	}
+	bookmarked, err := db.getBookmarkForOwner("", SourceBookmark, "https://other.example.com/users/bob/statuses/9")
+	if err != nil || bookmarked == nil {
+		t.Fatalf("Expected unresolved bookmark stored by URI: %v", err)
+	}
+
+	// Importing the same archive again changes nothing
+	result, err = importer.importFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Second import failed: %v", err)
+	}
+	if result != (ImportResult{Duplicates: 4}) {
+		t.Errorf("Expected 4 duplicates on reimport, got %+v", result)
+	}
+}
+
+func TestImporter_BookmarksCSV_Resolves(t *testing.T) {
+	importer, db := newTestImporter(t)
+	resolver := &MockResolver{statuses: map[string]Status{
+		"https://other.example.com/@bob/7": {ID: "7", Content: "resolved through search"},
+	}}
+	importer.resolver = resolver
+
+	path := filepath.Join(t.TempDir(), "bookmarks.csv")
+	content := "https://other.example.com/@bob/7\nhttps://gone.example.com/@carol/8\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write CSV: %v", err)
+	}
+
+	result, err := importer.importFile(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	if result != (ImportResult{Imported: 1, Unresolved: 1}) {
+		t.Errorf("Expected 1 imported and 1 unresolved, got %+v", result)
+	}
+	if len(resolver.resolved) != 2 {
+		t.Errorf("Expected both URLs to be looked up, got %v", resolver.resolved)
+	}
+
+	bookmark, err := db.getBookmarkForOwner("", SourceBookmark, "7")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Expected resolved bookmark stored under its ID: %v", err)
+	}
+	if !strings.Contains(bookmark.SearchText, "resolved through search") {
+		t.Errorf("Expected resolved status to be indexed, got %q", bookmark.SearchText)
+	}
+}
+
+func TestReadBookmarksCSV_SkipsHeaderAndBlankLines(t *testing.T) {
+	entries, err := readBookmarksCSV(strings.NewReader("url\n\nhttps://a.example.com/@x/1\r\nhttps://b.example.com/@y/2,extra\n"))
+	if err != nil {
+		t.Fatalf("Failed to read CSV: %v", err)
+	}
+	if len(entries) != 2 || entries[0].uri != "https://a.example.com/@x/1" || entries[1].uri != "https://b.example.com/@y/2" {
+		t.Errorf("Unexpected entries: %+v", entries)
+	}
+	for _, entry := range entries {
+		if entry.source != SourceBookmark {
+			t.Errorf("Expected bookmark source, got %q", entry.source)
+		}
+	}
+}
+
+func TestImporter_ArchiveWithoutCollections(t *testing.T) {
+	importer, _ := newTestImporter(t)
+	path := writeTestArchive(t, map[string]string{"actor.json": testActor})
+
+	if _, err := importer.importFile(context.Background(), path); err == nil {
+		t.Error("Expected error for archive without outbox, likes or bookmarks")
+	}
+}
+
+func TestImporter_UnsupportedFile(t *testing.T) {
+	importer, _ := newTestImporter(t)
+
+	_, err := importer.importFile(context.Background(), "bookmarks.json")
+	if err == nil || !strings.Contains(err.Error(), "unsupported file type") {
+		t.Errorf("Expected unsupported file type error, got %v", err)
+	}
+}
+
+func TestImporter_CanceledContext(t *testing.T) {
+	importer, _ := newTestImporter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := importer.importEntries(ctx, []importEntry{{source: SourceBookmark, uri: "https://a.example.com/1"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context canceled, got %v", err)
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// =============================================================================
+// LINK ARCHIVER TESTS
+// =============================================================================
+
+const testArticlePage = `<!DOCTYPE html>
+<html>
+<head>
+	<title>Garbage collection
+		explained</title>
+	<style>body { color: red; }</style>
+	<script>var tracking = "ignore me";</script>
+</head>
+<body>
+	<nav><a href="/">Home</a> <a href="/about">About</a></nav>
+	<article>
+		<h1>Garbage collection explained</h1>
+		<p>The <em>write barrier</em> keeps the tricolor invariant.</p>
+		<aside>Related: ten other articles</aside>
+		<p>Marking runs concurrently with the program.</p>
+	</article>
+	<footer>Copyright Example News</footer>
+</body>
+</html>`
+
+// linkTestServer serves fixed pages by path and counts requests.
+type linkTestServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests int
+}
+
+func newLinkTestServer(t *testing.T, pages map[string]string, contentType string) *linkTestServer {
+	t.Helper()
+
+	ls := &linkTestServer{}
+	ls.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ls.mu.Lock()
+		ls.requests++
+		ls.mu.Unlock()
+
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		w.Write([]byte(page))
+	}))
+	t.Cleanup(ls.Close)
+	return ls
+}
+
+func (ls *linkTestServer) requestCount() int {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	return ls.requests
+}
+
+func newTestLinkArchiver(t *testing.T, db *Database, maxPageSize int64, maxTextLength int) *LinkArchiver {
+	t.Helper()
+
+	cfg := &Config{}
+	cfg.Links.MaxPageSize = maxPageSize
+	cfg.Links.MaxTextLength = maxTextLength
+
+	archiver, err := newLinkArchiver(cfg, db)
+	if err != nil {
+		t.Fatalf("Failed to create link archiver: %v", err)
+	}
+	// The test servers listen on loopback, which the archiver refuses
+	archiver.httpClient = &http.Client{}
+	return archiver
+}
+
+func TestExtractPageText(t *testing.T) {
+	title, text, err := extractPageText(strings.NewReader(testArticlePage))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if title != "Garbage collection explained" {
+		t.Errorf("Expected collapsed title, got '%s'", title)
+	}
+
+	expected := "Garbage collection explained The write barrier keeps the tricolor invariant. Marking runs concurrently with the program."
+	if text != expected {
+		t.Errorf("Expected article text\n%q\ngot\n%q", expected, text)
+	}
+}
+
+func TestExtractPageText_FallsBackToBody(t *testing.T) {
+	_, text, err := extractPageText(strings.NewReader(`<html><body><nav>Menu</nav><p>Plain page</p></body></html>`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if text != "Plain page" {
+		t.Errorf("Expected body text without navigation, got '%s'", text)
+	}
+}
+
+func TestTruncateText(t *testing.T) {
+	if got := truncateText("héllo", 2); got != "h" {
+		t.Errorf("Expected truncation before a split rune, got %q", got)
+	}
+	if got := truncateText("hello", 0); got != "hello" {
+		t.Errorf("Expected no limit to keep the text, got %q", got)
+	}
+}
+
+func TestLinkArchiver_Snapshot_StoresAndReuses(t *testing.T) {
+	server := newLinkTestServer(t, map[string]string{"/article": testArticlePage}, "text/html; charset=utf-8")
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestLinkArchiver(t, db, 0, 0)
+
+	snapshot, err := archiver.snapshot(context.Background(), server.URL+"/article")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if snapshot.Title != "Garbage collection explained" || !strings.Contains(snapshot.Text, "write barrier") {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	stored, err := db.getLinkSnapshot(server.URL + "/article")
+	if err != nil || stored == nil {
+		t.Fatalf("Expected snapshot to be stored, got %v, %v", stored, err)
+	}
+
+	if _, err := archiver.snapshot(context.Background(), server.URL+"/article"); err != nil {
+		t.Fatalf("Expected no error on second snapshot, got %v", err)
+	}
+	if count := server.requestCount(); count != 1 {
+		t.Errorf("Expected the page to be fetched once, got %d requests", count)
+	}
+}
+
+func TestLinkArchiver_Snapshot_Limits(t *testing.T) {
+	server := newLinkTestServer(t, map[string]string{"/article": testArticlePage}, "text/html")
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestLinkArchiver(t, db, 0, 20)
+
+	snapshot, err := archiver.snapshot(context.Background(), server.URL+"/article")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(snapshot.Text) != 20 {
+		t.Errorf("Expected text cut to 20 bytes, got %q", snapshot.Text)
+	}
+
+	// A page cut off by the size limit still yields the text read so far
+	archiver = newTestLinkArchiver(t, db, int64(strings.Index(testArticlePage, "<aside>")), 0)
+	snapshot, err = archiver.fetch(context.Background(), server.URL+"/article")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(snapshot.Text, "write barrier") || strings.Contains(snapshot.Text, "concurrently") {
+		t.Errorf("Expected only the text before the size limit, got %q", snapshot.Text)
+	}
+}
+
+func TestLinkArchiver_Snapshot_NonHTML(t *testing.T) {
+	server := newLinkTestServer(t, map[string]string{"/paper.pdf": "%PDF-1.7"}, "application/pdf")
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestLinkArchiver(t, db, 0, 0)
+
+	for i := 0; i < 2; i++ {
+		snapshot, err := archiver.snapshot(context.Background(), server.URL+"/paper.pdf")
+		if err != nil {
+			t.Fatalf("Expected non-HTML pages to be skipped without error, got %v", err)
+		}
+		if snapshot.Text != "" {
+			t.Errorf("Expected no text for a PDF, got %q", snapshot.Text)
+		}
+	}
+	if count := server.requestCount(); count != 1 {
+		t.Errorf("Expected a skipped page not to be fetched again, got %d requests", count)
+	}
+}
+
+func TestLinkArchiver_Snapshot_ReportsFailures(t *testing.T) {
+	server := newLinkTestServer(t, map[string]string{}, "text/html")
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestLinkArchiver(t, db, 0, 0)
+
+	if _, err := archiver.snapshot(context.Background(), server.URL+"/missing"); err == nil {
+		t.Fatal("Expected an error for a missing page")
+	}
+	stored, err := db.getLinkSnapshot(server.URL + "/missing")
+	if err != nil || stored != nil {
+		t.Errorf("Expected nothing stored for a failed fetch, got %v, %v", stored, err)
+	}
+}
+
+func TestLinkArchiver_Snapshot_RefusesNonPublicAddresses(t *testing.T) {
+	server := newLinkTestServer(t, map[string]string{"/admin": testArticlePage}, "text/html")
+
+	service, db := newReconcileTestService(t, &MockPagedBookmarkClient{})
+	service.config.Search.IndexedFields = []string{"content", "link_text"}
+	links, err := newLinkArchiver(&Config{}, db)
+	if err != nil {
+		t.Fatalf("Failed to create link archiver: %v", err)
+	}
+	service.links = links
+
+	// The test server listens on 127.0.0.1, standing in for the host's own
+	// services
+	cardURL := server.URL + "/admin"
+	bookmark := testBookmark("status-1")
+	bookmark.Status.Card = &Card{URL: cardURL, Title: "Admin"}
+	if err := service.processBookmarkBatch([]Bookmark{bookmark}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if count := server.requestCount(); count != 0 {
+		t.Errorf("Expected no request to a loopback address, got %d", count)
+	}
+	snapshot, err := db.getLinkSnapshot(cardURL)
+	if err != nil || snapshot == nil {
+		t.Fatalf("Expected the refused page to be recorded, got %v, %v", snapshot, err)
+	}
+	if snapshot.Text != "" {
+		t.Errorf("Expected no text for a refused page, got %q", snapshot.Text)
+	}
+
+	if _, err := links.fetch(context.Background(), "gopher://127.0.0.1/admin"); err == nil || !strings.Contains(err.Error(), "unsupported URL scheme") {
+		t.Errorf("Expected a gopher URL to be refused, got %v", err)
+	}
+}
+
+func TestBookmarkService_ProcessBookmarkBatch_IndexesLinkText(t *testing.T) {
+	server := newLinkTestServer(t, map[string]string{"/article": testArticlePage}, "text/html")
+
+	service, db := newReconcileTestService(t, &MockPagedBookmarkClient{})
+	service.config.Search.IndexedFields = []string{"content", "card", "link_text"}
+	service.links = newTestLinkArchiver(t, db, 0, 0)
+
+	bookmark := testBookmark("status-1")
+	bookmark.Status.Card = &Card{URL: server.URL + "/article", Title: "Garbage collection explained"}
+
+	if err := service.processBookmarkBatch([]Bookmark{bookmark}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "tricolor"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.StatusID != "status-1" {
+		t.Errorf("Expected the article body to be searchable, got %+v", results)
+	}
+}
+
+func TestNewBookmarchiveApp_LinksEnabled(t *testing.T) {
+	cfg := &Config{}
+	cfg.Mastodon.Server = "https://mastodon.example.com"
+	cfg.Mastodon.AccessToken = "test-token"
+	cfg.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	cfg.Links.Enabled = true
+
+	app, err := newBookmarchiveApp(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error creating app, got %v", err)
+	}
+	defer app.stop()
+
+	if app.bookmarkService.links == nil {
+		t.Error("Expected services to get a link archiver when links are enabled")
+	}
+}
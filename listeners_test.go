@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// WEB SERVER LISTENERS TESTS
+// =============================================================================
+
+// writeTestCertificate writes a self-signed certificate for 127.0.0.1 with
+// the given serial number to cert.pem and key.pem in dir.
+func writeTestCertificate(t *testing.T, dir string, serial int64) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func servedSerial(t *testing.T, reloader *certificateReloader) int64 {
+	t.Helper()
+	certificate, err := reloader.getCertificate(nil)
+	if err != nil {
+		t.Fatalf("Failed to get certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return parsed.SerialNumber.Int64()
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := writeTestCertificate(t, dir, 1)
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load certificate: %v", err)
+	}
+	if serial := servedSerial(t, reloader); serial != 1 {
+		t.Fatalf("Expected serial 1, got %d", serial)
+	}
+
+	writeTestCertificate(t, dir, 2)
+	if err := reloader.reload(); err != nil {
+		t.Fatalf("Failed to reload certificate: %v", err)
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("Expected the renewed certificate after reloading, got serial %d", serial)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	if err := reloader.reload(); err == nil {
+		t.Error("Expected reloading a broken key to fail")
+	}
+	if serial := servedSerial(t, reloader); serial != 2 {
+		t.Errorf("Expected the previous certificate to stay, got serial %d", serial)
+	}
+
+	if _, err := newCertificateReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("Expected a missing certificate to fail")
+	}
+}
+
+func TestValidateListenConfig(t *testing.T) {
+	cfg := &Config{}
+	if err := validateListenConfig(cfg); err != nil {
+		t.Errorf("Expected the defaults to be valid, got %v", err)
+	}
+	cfg.Web.TLSCert = "cert.pem"
+	if err := validateListenConfig(cfg); err == nil {
+		t.Error("Expected a certificate without a key to be rejected")
+	}
+	cfg.Web.TLSKey = "key.pem"
+	cfg.Web.SocketMode = "rw-rw----"
+	if err := validateListenConfig(cfg); err == nil {
+		t.Error("Expected a socket mode that is not octal to be rejected")
+	}
+
+	for value, expected := range map[string]os.FileMode{"": 0o660, "0600": 0o600, "666": 0o666} {
+		if mode, err := parseSocketMode(value); err != nil || mode != expected {
+			t.Errorf("Expected %q to be %o, got %o (%v)", value, expected, mode, err)
+		}
+	}
+	if _, err := parseSocketMode("1777"); err == nil {
+		t.Error("Expected special permission bits to be rejected")
+	}
+}
+
+func TestWebServer_Start_TLS(t *testing.T) {
+	// Find a free port for the server to listen on
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	cfg := &Config{}
+	cfg.Web.Listen = "127.0.0.1"
+	cfg.Web.Port = port
+	cfg.Web.TLSCert, cfg.Web.TLSKey = writeTestCertificate(t, t.TempDir(), 1)
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(cfg, db, eventChan)
+	if err := webServer.start(); err != nil {
+		t.Fatalf("Expected no error starting web server, got %v", err)
+	}
+	defer webServer.stop()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	resp, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/api/session")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("Expected an HTTPS response, got %d", resp.StatusCode)
+	}
+}
+
+func TestWebServer_Start_UnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "web.sock")
+
+	// A socket file left behind by an earlier process is replaced
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to create stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	cfg := &Config{}
+	cfg.Web.Socket = socket
+	cfg.Web.SocketMode = "0600"
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(cfg, db, eventChan)
+	if err := webServer.start(); err != nil {
+		t.Fatalf("Expected no error starting web server, got %v", err)
+	}
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Expected the socket to exist: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("Expected socket permissions 0600, got %o", info.Mode().Perm())
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+		},
+	}}
+	resp, err := client.Get("http://bookmarchive/api/session")
+	if err != nil {
+		t.Fatalf("Request over the socket failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", resp.StatusCode)
+	}
+
+	// A second server must not take over a socket in use
+	second := newWebServer(cfg, db, eventChan)
+	if err := second.start(); err == nil {
+		second.stop()
+		t.Error("Expected a socket in use to be refused")
+	}
+
+	if err := webServer.stop(); err != nil {
+		t.Errorf("Error stopping web server: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket to be removed on stop, got %v", err)
+	}
+}
+
+func TestSystemdListeners_OtherProcess(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) != 0 {
+		t.Errorf("Expected no sockets meant for another process, got %d (%v)", len(listeners), err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Error("Expected the environment of another process's sockets to be left alone")
+	}
+}
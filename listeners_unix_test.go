@@ -0,0 +1,39 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	file, err := listener.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("Failed to get listener file: %v", err)
+	}
+	// Pass a descriptor of its own, as systemd would
+	fd, err := syscall.Dup(int(file.Fd()))
+	file.Close()
+	listener.Close()
+	if err != nil {
+		t.Fatalf("Failed to duplicate descriptor: %v", err)
+	}
+
+	inherited, err := inheritedListeners([]uintptr{uintptr(fd)}, []string{"web"})
+	if err != nil {
+		t.Fatalf("Failed to use inherited descriptor: %v", err)
+	}
+	defer inherited[0].Close()
+
+	conn, err := net.Dial("tcp", inherited[0].Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to connect to the inherited socket: %v", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// =============================================================================
+// LOGIN COMMAND TESTS
+// =============================================================================
+
+// oauthTestServer fakes the app registration, token and account endpoints
+// of a Mastodon server. It accepts the code "good-code" only.
+func oauthTestServer(t *testing.T) (*httptest.Server, *url.Values) {
+	t.Helper()
+	registered := &url.Values{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/apps", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		*registered = r.PostForm
+		json.NewEncoder(w).Encode(map[string]string{
+			"client_id":     "app-id",
+			"client_secret": "app-secret",
+		})
+	})
+	mux.HandleFunc("POST /oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("grant_type") != "authorization_code" ||
+			r.PostForm.Get("code") != "good-code" ||
+			r.PostForm.Get("client_id") != "app-id" ||
+			r.PostForm.Get("client_secret") != "app-secret" ||
+			r.PostForm.Get("redirect_uri") != registered.Get("redirect_uris") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{
+				"error":             "invalid_grant",
+				"error_description": "The provided authorization grant is invalid",
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "user-token", "token_type": "Bearer"})
+	})
+	mux.HandleFunc("GET /api/v1/accounts/verify_credentials", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id": "1", "acct": "alice"})
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, registered
+}
+
+func TestOAuthLogin_OutOfBand(t *testing.T) {
+	server, registered := oauthTestServer(t)
+
+	var out strings.Builder
+	login, err := newOAuthLogin(server.URL+"/", "read:bookmarks read:accounts", strings.NewReader("good-code\n"), &out)
+	if err != nil {
+		t.Fatalf("Failed to create login: %v", err)
+	}
+
+	creds, err := login.login(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	if registered.Get("scopes") != "read:bookmarks read:accounts" {
+		t.Errorf("Expected app registered with read scopes, got %q", registered.Get("scopes"))
+	}
+	if registered.Get("redirect_uris") != noRedirectURI {
+		t.Errorf("Expected out-of-band redirect, got %q", registered.Get("redirect_uris"))
+	}
+	want := Credentials{Server: server.URL, ClientID: "app-id", ClientSecret: "app-secret", AccessToken: "user-token"}
+	if *creds != want {
+		t.Errorf("Expected credentials %+v, got %+v", want, *creds)
+	}
+	if !strings.Contains(out.String(), server.URL+"/oauth/authorize?") || !strings.Contains(out.String(), "client_id=app-id") {
+		t.Errorf("Expected authorize URL in output, got %q", out.String())
+	}
+	if !strings.Contains(out.String(), "Logged in as @alice") {
+		t.Errorf("Expected account in output, got %q", out.String())
+	}
+}
+
+func TestOAuthLogin_InvalidCode(t *testing.T) {
+	server, _ := oauthTestServer(t)
+
+	login, err := newOAuthLogin(server.URL, "read:bookmarks", strings.NewReader("bad-code\n"), io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to create login: %v", err)
+	}
+
+	_, err = login.login(context.Background(), false)
+	if err == nil || !strings.Contains(err.Error(), "authorization grant is invalid") {
+		t.Errorf("Expected token error from server, got %v", err)
+	}
+}
+
+func TestOAuthLogin_LocalRedirect(t *testing.T) {
+	server, registered := oauthTestServer(t)
+
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	login, err := newOAuthLogin(server.URL, "read:bookmarks", strings.NewReader(""), writer)
+	if err != nil {
+		t.Fatalf("Failed to create login: %v", err)
+	}
+
+	// Play the browser: follow the printed authorize URL back to the
+	// redirect listener, first with a forged state. The output is drained
+	// meanwhile so that the login never blocks writing to it.
+	browserErrs := make(chan error, 1)
+	browse := func(authURL *url.URL) error {
+		redirect := authURL.Query().Get("redirect_uri")
+
+		resp, err := http.Get(redirect + "?code=good-code&state=forged")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected forged state to be rejected, got %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(redirect + "?code=good-code&state=" + authURL.Query().Get("state"))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	go func() {
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if !strings.HasPrefix(line, "http") {
+				continue
+			}
+			authURL, err := url.Parse(line)
+			if err != nil {
+				browserErrs <- err
+				continue
+			}
+			go func() { browserErrs <- browse(authURL) }()
+		}
+	}()
+
+	creds, err := login.login(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	if err := <-browserErrs; err != nil {
+		t.Fatalf("Browser request failed: %v", err)
+	}
+
+	if !strings.HasPrefix(registered.Get("redirect_uris"), "http://127.0.0.1:") {
+		t.Errorf("Expected loopback redirect URI, got %q", registered.Get("redirect_uris"))
+	}
+	if creds.AccessToken != "user-token" {
+		t.Errorf("Expected access token 'user-token', got %q", creds.AccessToken)
+	}
+}
+
+func TestOAuthLogin_CallbackCanceled(t *testing.T) {
+	login, err := newOAuthLogin("https://mastodon.example.com", "read:bookmarks", strings.NewReader(""), io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to create login: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = login.login(ctx, true)
+	if err == nil {
+		t.Error("Expected error with canceled context")
+	}
+}
+
+func TestLoginScopes(t *testing.T) {
+	cfg := &Config{}
+	if scopes := loginScopes(cfg); scopes != "read:bookmarks read:accounts" {
+		t.Errorf("Expected bookmark scopes, got %q", scopes)
+	}
+
+	cfg.Polling.Sources = []string{SourceBookmark, SourceFavourite, SourceOwnStatus}
+	if scopes := loginScopes(cfg); scopes != "read:bookmarks read:accounts read:favourites read:statuses" {
+		t.Errorf("Expected favourite and status scopes, got %q", scopes)
+	}
+}
+
+func TestUpdateConfigCredentials_KeepsComments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	original := `# Bookmarchive configuration
+
+[mastodon]
+# Your server
+server = "https://old.example.com"
+access_token = "your-access-token-here"
+client_timeout = "30s"
+
+[database]
+path = "./bookmarchive.db"
+`
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	creds := Credentials{Server: "https://new.example.com", ClientID: "id", ClientSecret: "secret", AccessToken: "token"}
+	if err := updateConfigCredentials(path, creds); err != nil {
+		t.Fatalf("Failed to update config: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	for _, want := range []string{"# Bookmarchive configuration", "# Your server", `client_timeout = "30s"`} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Expected config to keep %q, got:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "your-access-token-here") {
+		t.Errorf("Expected placeholder token to be replaced, got:\n%s", content)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat config: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected config mode 0600, got %v", info.Mode().Perm())
+	}
+
+	cfg := defaultConfig()
+	if err := loadConfig(path, &cfg); err != nil {
+		t.Fatalf("Failed to load updated config: %v", err)
+	}
+	if cfg.Mastodon.Server != "https://new.example.com" || cfg.Mastodon.AccessToken != "token" ||
+		cfg.Mastodon.ClientID != "id" || cfg.Mastodon.ClientSecret != "secret" {
+		t.Errorf("Unexpected [mastodon] section after update: %+v", cfg.Mastodon)
+	}
+	if cfg.Database.Path != "./bookmarchive.db" {
+		t.Errorf("Expected other sections unchanged, got database path %q", cfg.Database.Path)
+	}
+}
+
+func TestUpdateConfigCredentials_Accounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	original := `[[accounts]]
+name = "alice"
+server = "https://a.example.com"
+access_token = "alice-token"
+
+[[accounts]]
+name = "bob" # work account
+server = "https://b.example.com"
+`
+	if err := os.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if err := updateConfigCredentials(path, Credentials{Account: "bob", Server: "https://b.example.com", AccessToken: "bob-token"}); err != nil {
+		t.Fatalf("Failed to update bob: %v", err)
+	}
+	if err := updateConfigCredentials(path, Credentials{Account: "carol", Server: "https://c.example.com", AccessToken: "carol-token"}); err != nil {
+		t.Fatalf("Failed to add carol: %v", err)
+	}
+
+	cfg := defaultConfig()
+	if err := loadConfig(path, &cfg); err != nil {
+		t.Fatalf("Failed to load updated config: %v", err)
+	}
+	tokens := make(map[string]string)
+	for _, account := range cfg.Accounts {
+		tokens[account.Name] = account.AccessToken
+	}
+	want := map[string]string{"alice": "alice-token", "bob": "bob-token", "carol": "carol-token"}
+	if len(tokens) != len(want) {
+		t.Fatalf("Expected accounts %v, got %v", want, tokens)
+	}
+	for name, token := range want {
+		if tokens[name] != token {
+			t.Errorf("Expected %s token %q, got %q", name, token, tokens[name])
+		}
+	}
+}
+
+func TestUpdateConfigCredentials_NewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+
+	if err := updateConfigCredentials(path, Credentials{Server: "https://mastodon.example.com", AccessToken: "token"}); err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+
+	cfg := defaultConfig()
+	if err := loadConfig(path, &cfg); err != nil {
+		t.Fatalf("Failed to load new config: %v", err)
+	}
+	if cfg.Mastodon.AccessToken != "token" {
+		t.Errorf("Expected access token 'token', got %q", cfg.Mastodon.AccessToken)
+	}
+}
+
+func TestCredentialsFile_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.toml")
+
+	if err := saveCredentialsFile(path, Credentials{Server: "https://a.example.com", AccessToken: "old"}); err != nil {
+		t.Fatalf("Failed to save credentials: %v", err)
+	}
+	if err := saveCredentialsFile(path, Credentials{Server: "https://a.example.com", ClientID: "id", AccessToken: "new"}); err != nil {
+		t.Fatalf("Failed to replace credentials: %v", err)
+	}
+	if err := saveCredentialsFile(path, Credentials{Account: "work", Server: "https://w.example.com", AccessToken: "work-token"}); err != nil {
+		t.Fatalf("Failed to save account credentials: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat credentials file: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected credentials file mode 0600, got %v", info.Mode().Perm())
+	}
+
+	credentials, err := loadCredentialsFile(path)
+	if err != nil {
+		t.Fatalf("Failed to load credentials: %v", err)
+	}
+	if len(credentials) != 2 {
+		t.Fatalf("Expected 2 credentials, got %d", len(credentials))
+	}
+
+	cfg := defaultConfig()
+	cfg.Mastodon.CredentialsFile = path
+	if err := cfg.loadCredentials(); err != nil {
+		t.Fatalf("Failed to apply credentials: %v", err)
+	}
+	if cfg.Mastodon.AccessToken != "new" || cfg.Mastodon.ClientID != "id" || cfg.Mastodon.Server != "https://a.example.com" {
+		t.Errorf("Unexpected [mastodon] section: %+v", cfg.Mastodon)
+	}
+	if len(cfg.Accounts) != 1 || cfg.Accounts[0].Name != "work" || cfg.Accounts[0].AccessToken != "work-token" {
+		t.Errorf("Expected credentials to add the work account, got %+v", cfg.Accounts)
+	}
+}
+
+func TestConfig_LoadCredentials_MissingFile(t *testing.T) {
+	cfg := defaultConfig()
+	if err := cfg.loadCredentials(); err != nil {
+		t.Errorf("Expected no error without credentials file, got %v", err)
+	}
+
+	cfg.Mastodon.CredentialsFile = filepath.Join(t.TempDir(), "missing.toml")
+	if err := cfg.loadCredentials(); err == nil {
+		t.Error("Expected error for missing credentials file")
+	}
+}
@@ -1,26 +1,49 @@
 package main
 
 import (
+	"archive/zip"
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
 	"database/sql"
 	"embed"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
+	"math"
+	"math/rand/v2"
+	"mime"
+	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/signal"
+	"path"
 	"path/filepath"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/BurntSushi/toml"
 	"github.com/McKael/madon/v3"
@@ -28,6 +51,8 @@ import (
 	"github.com/peterhellberg/link"
 	"github.com/rs/zerolog"
 	zlog "github.com/rs/zerolog/log"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 	_ "modernc.org/sqlite"
 )
 
@@ -50,41 +75,119 @@ var (
 // CONFIGURATION
 // =============================================================================
 
+// AccountConfig describes one Mastodon account archived by this instance.
+// Name identifies the account's archive in the database and the API.
+type AccountConfig struct {
+	Name          string `toml:"name"`
+	Server        string `toml:"server"`
+	AccessToken   string `toml:"access_token"`
+	ClientTimeout string `toml:"client_timeout"`
+	ClientID      string `toml:"client_id"`
+	ClientSecret  string `toml:"client_secret"`
+}
+
 type Config struct {
 	Mastodon struct {
-		Server        string `toml:"server"`
-		AccessToken   string `toml:"access_token"`
-		ClientTimeout string `toml:"client_timeout"`
+		Server          string `toml:"server"`
+		AccessToken     string `toml:"access_token"`
+		ClientTimeout   string `toml:"client_timeout"`
+		ClientID        string `toml:"client_id"`
+		ClientSecret    string `toml:"client_secret"`
+		CredentialsFile string `toml:"credentials_file"`
 	} `toml:"mastodon"`
+	Accounts []AccountConfig `toml:"accounts"`
 	Database struct {
 		Path        string `toml:"path"`
 		WalMode     bool   `toml:"wal_mode"`
 		BusyTimeout string `toml:"busy_timeout"`
 	} `toml:"database"`
 	Polling struct {
-		Interval      string `toml:"interval"`
-		BatchSize     int    `toml:"batch_size"`
-		BackfillDelay string `toml:"backfill_delay"`
+		Interval          string   `toml:"interval"`
+		BatchSize         int      `toml:"batch_size"`
+		BackfillDelay     string   `toml:"backfill_delay"`
+		ReconcileInterval string   `toml:"reconcile_interval"`
+		MaxPollPages      int      `toml:"max_poll_pages"`
+		ReadyIntervals    int      `toml:"ready_intervals"`
+		Sources           []string `toml:"sources"`
 	} `toml:"polling"`
 	Web struct {
 		Listen string `toml:"listen"`
 		Port   int    `toml:"port"`
+		// BaseURL is the public address of the web interface, used for the
+		// links in feeds; empty takes it from each request.
+		BaseURL string `toml:"base_url"`
+		// CORSOrigins are the origins of other sites whose scripts may call
+		// the API, or "*" for any; empty allows none.
+		CORSOrigins []string `toml:"cors_origins"`
+		// TLSCert and TLSKey are PEM files to serve HTTPS with, reread on
+		// SIGHUP.
+		TLSCert string `toml:"tls_cert"`
+		TLSKey  string `toml:"tls_key"`
+		// Socket is a Unix socket to listen on instead of listen and port,
+		// created with the octal permissions in SocketMode.
+		Socket     string `toml:"socket"`
+		SocketMode string `toml:"socket_mode"`
 	} `toml:"web"`
+	Auth struct {
+		// Tokens are bearer tokens accepted from API clients and feed
+		// readers.
+		Tokens []string `toml:"tokens"`
+		// PasswordHash is the web interface password as printed by the
+		// hash-password command. With neither it nor tokens set, anyone
+		// who can reach the web server can use it.
+		PasswordHash    string `toml:"password_hash"`
+		SessionLifetime string `toml:"session_lifetime"`
+	} `toml:"auth"`
 	Logging struct {
 		Level  string `toml:"level"`
 		Format string `toml:"format"`
 	} `toml:"logging"`
 	Search struct {
-		IndexedFields []string `toml:"indexed_fields"`
+		IndexedFields []string           `toml:"indexed_fields"`
+		Weights       map[string]float64 `toml:"weights"`
+		AutoReindex   bool               `toml:"auto_reindex"`
 	} `toml:"search"`
+	Refresh struct {
+		Enabled     bool   `toml:"enabled"`
+		Interval    string `toml:"interval"`
+		BatchSize   int    `toml:"batch_size"`
+		MinInterval string `toml:"min_interval"`
+		MaxInterval string `toml:"max_interval"`
+	} `toml:"refresh"`
+	Media struct {
+		Enabled      bool     `toml:"enabled"`
+		Directory    string   `toml:"directory"`
+		MaxFileSize  int64    `toml:"max_file_size"`
+		AllowedTypes []string `toml:"allowed_types"`
+		Timeout      string   `toml:"timeout"`
+	} `toml:"media"`
+	Links struct {
+		Enabled       bool   `toml:"enabled"`
+		MaxPageSize   int64  `toml:"max_page_size"`
+		MaxTextLength int    `toml:"max_text_length"`
+		Timeout       string `toml:"timeout"`
+	} `toml:"links"`
+	Threads struct {
+		Enabled bool `toml:"enabled"`
+	} `toml:"threads"`
+	Streaming struct {
+		Enabled           bool   `toml:"enabled"`
+		URL               string `toml:"url"`
+		MinPollInterval   string `toml:"min_poll_interval"`
+		ReconnectDelay    string `toml:"reconnect_delay"`
+		MaxReconnectDelay string `toml:"max_reconnect_delay"`
+	} `toml:"streaming"`
 }
 
 func defaultConfig() Config {
 	return Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:        "https://mastodon.social",
 			AccessToken:   "your-access-token-here",
@@ -100,20 +203,42 @@ func defaultConfig() Config {
 			BusyTimeout: "5s",
 		},
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
-			Interval:      "10m",
-			BatchSize:     20,
-			BackfillDelay: "10s",
+			Interval:          "10m",
+			BatchSize:         20,
+			BackfillDelay:     "10s",
+			ReconcileInterval: "24h",
+			MaxPollPages:      10,
+			ReadyIntervals:    defaultReadyIntervals,
+			Sources:           []string{SourceBookmark},
 		},
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
+		}{
+			Listen:     "127.0.0.1",
+			Port:       8080,
+			SocketMode: "0660",
+		},
+		Auth: struct {
+			Tokens          []string `toml:"tokens"`
+			PasswordHash    string   `toml:"password_hash"`
+			SessionLifetime string   `toml:"session_lifetime"`
 		}{
-			Listen: "127.0.0.1",
-			Port:   8080,
+			SessionLifetime: "720h",
 		},
 		Logging: struct {
 			Level  string `toml:"level"`
@@ -123,11 +248,116 @@ func defaultConfig() Config {
 			Format: "console",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
+		}{
+			IndexedFields: []string{"content", "spoiler_text", "username", "display_name", "media_descriptions", "hashtags", "card"},
+		},
+		Refresh: struct {
+			Enabled     bool   `toml:"enabled"`
+			Interval    string `toml:"interval"`
+			BatchSize   int    `toml:"batch_size"`
+			MinInterval string `toml:"min_interval"`
+			MaxInterval string `toml:"max_interval"`
+		}{
+			Enabled:     false,
+			Interval:    "15m",
+			BatchSize:   20,
+			MinInterval: "1h",
+			MaxInterval: "720h",
+		},
+		Media: struct {
+			Enabled      bool     `toml:"enabled"`
+			Directory    string   `toml:"directory"`
+			MaxFileSize  int64    `toml:"max_file_size"`
+			AllowedTypes []string `toml:"allowed_types"`
+			Timeout      string   `toml:"timeout"`
 		}{
-			IndexedFields: []string{"content", "spoiler_text", "username", "display_name", "media_descriptions", "hashtags"},
+			Enabled:      false,
+			Directory:    "",
+			MaxFileSize:  50 * 1024 * 1024,
+			AllowedTypes: []string{"image/", "video/", "audio/"},
+			Timeout:      "60s",
 		},
+		Links: struct {
+			Enabled       bool   `toml:"enabled"`
+			MaxPageSize   int64  `toml:"max_page_size"`
+			MaxTextLength int    `toml:"max_text_length"`
+			Timeout       string `toml:"timeout"`
+		}{
+			Enabled:       false,
+			MaxPageSize:   5 * 1024 * 1024,
+			MaxTextLength: 100000,
+			Timeout:       "30s",
+		},
+		Threads: struct {
+			Enabled bool `toml:"enabled"`
+		}{
+			Enabled: false,
+		},
+		Streaming: struct {
+			Enabled           bool   `toml:"enabled"`
+			URL               string `toml:"url"`
+			MinPollInterval   string `toml:"min_poll_interval"`
+			ReconnectDelay    string `toml:"reconnect_delay"`
+			MaxReconnectDelay string `toml:"max_reconnect_delay"`
+		}{
+			Enabled:           false,
+			URL:               "",
+			MinPollInterval:   "1m",
+			ReconnectDelay:    "5s",
+			MaxReconnectDelay: "5m",
+		},
+	}
+}
+
+// accounts returns the configured [[accounts]] entries, or the [mastodon]
+// section as a single unnamed account when none are configured.
+func (c *Config) accounts() ([]AccountConfig, error) {
+	if len(c.Accounts) == 0 {
+		return []AccountConfig{{
+			Server:        c.Mastodon.Server,
+			AccessToken:   c.Mastodon.AccessToken,
+			ClientTimeout: c.Mastodon.ClientTimeout,
+			ClientID:      c.Mastodon.ClientID,
+			ClientSecret:  c.Mastodon.ClientSecret,
+		}}, nil
+	}
+
+	seen := make(map[string]bool)
+	for i, account := range c.Accounts {
+		if account.Name == "" {
+			return nil, fmt.Errorf("account %d: name is required", i+1)
+		}
+		if seen[account.Name] {
+			return nil, fmt.Errorf("duplicate account name: %s", account.Name)
+		}
+		seen[account.Name] = true
+	}
+	return c.Accounts, nil
+}
+
+// sources returns the status collections to archive for every account,
+// defaulting to bookmarks only.
+func (c *Config) sources() ([]string, error) {
+	if len(c.Polling.Sources) == 0 {
+		return []string{SourceBookmark}, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, source := range c.Polling.Sources {
+		switch source {
+		case SourceBookmark, SourceFavourite, SourceOwnStatus:
+		default:
+			return nil, fmt.Errorf("unknown source: %s", source)
+		}
+		if seen[source] {
+			return nil, fmt.Errorf("duplicate source: %s", source)
+		}
+		seen[source] = true
 	}
+	return c.Polling.Sources, nil
 }
 
 func loadConfig(path string, cfg interface{}) error {
@@ -268,20 +498,98 @@ type DBBookmark struct {
 	SearchText   string    `json:"search_text"`
 	RawJSON      string    `json:"raw_json"`
 	AccountID    string    `json:"account_id"`
+	// SearchFields holds the text of each full-text index column, keyed by
+	// the names in searchColumns; SearchText is all of it joined. Bookmarks
+	// without it are indexed with SearchText as their content.
+	SearchFields map[string]string `json:"-"`
+	// OwnerAccount is the name of the configured account whose bookmark
+	// this is, empty for the single [mastodon] account.
+	OwnerAccount string `json:"owner_account,omitempty"`
+	// Source is the collection the status was archived from: bookmark,
+	// favourite or own_status.
+	Source string `json:"source"`
+	// UnbookmarkedAt is set once reconciliation finds the status is no longer
+	// bookmarked on the server; the archived copy is kept.
+	UnbookmarkedAt *time.Time `json:"unbookmarked_at,omitempty"`
+	// DeletedAt is set once the refresher finds the status was deleted by
+	// its author; the archived copy is kept.
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// HasLink is set when the status links somewhere other than mentioned
+	// accounts and hashtags, for the has:link search filter.
+	HasLink bool `json:"-"`
+}
+
+type BookmarkRevision struct {
+	ID         int64     `json:"id"`
+	StatusID   string    `json:"status_id"`
+	SearchText string    `json:"search_text"`
+	RawJSON    string    `json:"raw_json"`
+	RevisedAt  time.Time `json:"revised_at"`
 }
 
 type BackfillState struct {
-	LastProcessedID  string     `json:"last_processed_id,omitempty"`
-	BackfillComplete bool       `json:"backfill_complete"`
-	LastPollTime     *time.Time `json:"last_poll_time,omitempty"`
-	CreatedAt        time.Time  `json:"created_at"`
-	UpdatedAt        time.Time  `json:"updated_at"`
+	LastProcessedID   string     `json:"last_processed_id,omitempty"`
+	BackfillComplete  bool       `json:"backfill_complete"`
+	LastPollTime      *time.Time `json:"last_poll_time,omitempty"`
+	LastReconcileTime *time.Time `json:"last_reconcile_time,omitempty"`
+	LastPollPages     int        `json:"last_poll_pages"`
+	// PollResumeURL is the next page link where the last poll stopped at
+	// the page cap; the next poll continues from there first.
+	PollResumeURL string    `json:"poll_resume_url,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 type SearchResult struct {
 	Bookmark *DBBookmark `json:"bookmark"`
 	Rank     float64     `json:"rank"`
 	Snippet  string      `json:"snippet,omitempty"`
+	// Media lists the locally archived copies of the status's attachments.
+	Media []*MediaFile `json:"media,omitempty"`
+	// Annotations are the archive owner's own tags, note and collections,
+	// left out when there are none.
+	Annotations *Annotations `json:"annotations,omitempty"`
+}
+
+// Annotations are what the archive owner added to a status: local tags, a
+// free-text note and the named collections it belongs to. They are kept
+// per account, shared by every collection of its archive holding the
+// status, and the tags and note are searchable in the notes column.
+type Annotations struct {
+	Tags        []string   `json:"tags"`
+	Note        string     `json:"note"`
+	Collections []string   `json:"collections"`
+	UpdatedAt   *time.Time `json:"updated_at,omitempty"`
+}
+
+// Collection is a named set of statuses in an account's archive.
+type Collection struct {
+	OwnerAccount string    `json:"owner_account,omitempty"`
+	Name         string    `json:"name"`
+	Count        int       `json:"count"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// MediaFile records a downloaded attachment or preview, stored under its
+// SHA-256 hash in the media directory.
+type MediaFile struct {
+	RemoteURL    string    `json:"remote_url"`
+	StatusID     string    `json:"status_id"`
+	MediaID      string    `json:"media_id"`
+	Variant      string    `json:"variant"`
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	MimeType     string    `json:"mime_type"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// LinkSnapshot is the readable text of a page linked from a status's preview
+// card, fetched once per URL.
+type LinkSnapshot struct {
+	URL       string    `json:"url"`
+	Title     string    `json:"title"`
+	Text      string    `json:"text"`
+	FetchedAt time.Time `json:"fetched_at"`
 }
 
 type SearchRequest struct {
@@ -291,16 +599,33 @@ type SearchRequest struct {
 	EnableHighlighting bool   `json:"enable_highlighting,omitempty"`
 	SnippetLength      int    `json:"snippet_length,omitempty"`
 	FilterByAccount    string `json:"filter_by_account,omitempty"`
+	FilterByState      string `json:"filter_by_state,omitempty"`
+	FilterBySource     string `json:"filter_by_source,omitempty"`
+	// Account limits results to one configured account's archive; empty
+	// searches all of them.
+	Account string `json:"account,omitempty"`
+	// SortBy orders matches for search terms: "rank", the default, puts
+	// the best first and "bookmarked" the newest.
+	SortBy string `json:"sort_by,omitempty"`
+	// after limits results to those listed after a cursor, newest first.
+	after *bookmarkCursor
 }
 
+// Orders for SearchRequest.SortBy
+const (
+	sortByRank       = "rank"
+	sortByBookmarked = "bookmarked"
+)
+
 type UserAccount struct {
-	AccountID   string    `json:"account_id"`
-	Username    string    `json:"username"`
-	DisplayName string    `json:"display_name"`
-	Acct        string    `json:"acct"`
-	Avatar      string    `json:"avatar"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	OwnerAccount string    `json:"owner_account,omitempty"`
+	AccountID    string    `json:"account_id"`
+	Username     string    `json:"username"`
+	DisplayName  string    `json:"display_name"`
+	Acct         string    `json:"acct"`
+	Avatar       string    `json:"avatar"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 // =============================================================================
@@ -311,9 +636,28 @@ type Database struct {
 	db   *sql.DB
 	path string
 	mu   sync.RWMutex
+	// rank is the bm25() call ordering search results, with the
+	// configured weight of each index column.
+	rank string
 }
 
+// newDatabase opens the database and brings its schema up to date.
 func newDatabase(cfg Config) (*Database, error) {
+	database, err := openDatabase(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := database.runMigrations(); err != nil {
+		database.close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return database, nil
+}
+
+// openDatabase opens the database without migrating it.
+func openDatabase(cfg Config) (*Database, error) {
 	var busyTimeout time.Duration
 	var err error
 	if cfg.Database.BusyTimeout != "" {
@@ -325,6 +669,11 @@ func newDatabase(cfg Config) (*Database, error) {
 		busyTimeout = 5 * time.Second
 	}
 
+	weights, err := searchWeights(cfg.Search.Weights)
+	if err != nil {
+		return nil, err
+	}
+
 	dir := filepath.Dir(cfg.Database.Path)
 	if dir != "." && dir != "/" {
 		if err := os.MkdirAll(dir, 0755); err != nil {
@@ -360,14 +709,7 @@ func newDatabase(cfg Config) (*Database, error) {
 		return nil, fmt.Errorf("failed to enable foreign keys: %w", err)
 	}
 
-	database := &Database{db: db, path: cfg.Database.Path}
-
-	if err := database.runMigrations(); err != nil {
-		db.Close()
-		return nil, fmt.Errorf("failed to run migrations: %w", err)
-	}
-
-	return database, nil
+	return &Database{db: db, path: cfg.Database.Path, rank: bm25Rank(weights)}, nil
 }
 
 func (d *Database) close() error {
@@ -393,12 +735,61 @@ func (d *Database) getDB() (*sql.DB, error) {
 	return d.db, nil
 }
 
+// ping checks that the database answers queries.
+func (d *Database) ping(ctx context.Context) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	var one int
+	if err := db.QueryRowContext(ctx, `SELECT 1`).Scan(&one); err != nil {
+		return fmt.Errorf("failed to query database: %w", err)
+	}
+	return nil
+}
+
+// runMigrations applies the migrations the database has not had yet, each
+// in its own transaction. It refuses a database migrated by a newer
+// release, whose schema this one may not understand.
 func (d *Database) runMigrations() error {
 	db, err := d.getDB()
 	if err != nil {
 		return err
 	}
 
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return err
+	}
+	latest := latestSchemaVersion()
+	for version := range applied {
+		if version > latest {
+			return fmt.Errorf("database schema version %d is newer than this release of bookmarchive supports (%d); upgrade bookmarchive to use this database", version, latest)
+		}
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.name, err)
+		}
+		zlog.Debug().Int("version", m.version).Str("name", m.name).Msg("Applied database migration")
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
 	tx, err := db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin migration transaction: %w", err)
@@ -409,45 +800,145 @@ func (d *Database) runMigrations() error {
 		}
 	}()
 
-	for _, stmt := range getMigrationStatements() {
-		// Handle special case for ALTER TABLE ADD COLUMN account_id
-		if stmt == `ALTER TABLE bookmarks ADD COLUMN account_id TEXT` {
-			// Check if column already exists
-			var count int
-			err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('bookmarks') WHERE name = 'account_id'`).Scan(&count)
-			if err != nil {
-				return fmt.Errorf("failed to check for account_id column existence: %w", err)
-			}
-			if count > 0 {
-				// Column already exists, skip this migration
-				continue
-			}
+	for _, step := range m.steps {
+		if err := step(tx); err != nil {
+			return err
 		}
+	}
 
-		// Handle special case for account_id index creation
-		if stmt == `CREATE INDEX IF NOT EXISTS idx_account_id ON bookmarks(account_id)` {
-			// Check if column exists before creating index
-			var count int
-			err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('bookmarks') WHERE name = 'account_id'`).Scan(&count)
-			if err != nil {
-				return fmt.Errorf("failed to check for account_id column existence for index: %w", err)
-			}
-			if count == 0 {
-				// Column doesn't exist yet, skip index creation
-				continue
-			}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.version, m.name, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// MigrationStatus describes a migration for the migrate status command.
+type MigrationStatus struct {
+	Version int
+	Name    string
+	// AppliedAt is nil for migrations the database has not had yet.
+	AppliedAt *time.Time
+	// Unknown is set for migrations applied by a newer release.
+	Unknown bool
+}
+
+// appliedMigrations returns the migrations recorded in the database by
+// version, none when it has no schema_migrations table yet.
+func (d *Database) appliedMigrations() (map[int]MigrationStatus, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to check for schema_migrations table: %w", err)
+	}
+	applied := make(map[int]MigrationStatus)
+	if count == 0 {
+		return applied, nil
+	}
+
+	rows, err := db.Query(`SELECT version, name, applied_at FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status MigrationStatus
+		var appliedAt time.Time
+		if err := rows.Scan(&status.Version, &status.Name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
 		}
+		status.AppliedAt = &appliedAt
+		applied[status.Version] = status
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over applied migrations: %w", err)
+	}
+	return applied, nil
+}
 
-		if _, err := tx.Exec(stmt); err != nil {
-			return fmt.Errorf("failed to execute migration statement: %w", err)
+// migrationStatus lists every known migration in order, followed by any
+// the database had from a newer release.
+func (d *Database) migrationStatus() ([]MigrationStatus, error) {
+	applied, err := d.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if recorded, ok := applied[m.version]; ok {
+			status.AppliedAt = recorded.AppliedAt
+			delete(applied, m.version)
 		}
+		statuses = append(statuses, status)
 	}
 
-	return tx.Commit()
+	var unknown []MigrationStatus
+	for _, status := range applied {
+		status.Unknown = true
+		unknown = append(unknown, status)
+	}
+	slices.SortFunc(unknown, func(a, b MigrationStatus) int { return a.Version - b.Version })
+	return append(statuses, unknown...), nil
+}
+
+func latestSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migration is one numbered change to the database schema. Databases from
+// before migrations were recorded have none applied, so every step also
+// copes with finding its change already made.
+type migration struct {
+	version int
+	name    string
+	steps   []migrationStep
+}
+
+// migrationStep makes part of a migration's change in its transaction.
+type migrationStep func(tx *sql.Tx) error
+
+// execSQL runs statements in order.
+func execSQL(statements ...string) migrationStep {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return fmt.Errorf("failed to execute migration statement: %w", err)
+			}
+		}
+		return nil
+	}
+}
+
+// addColumn adds a column unless the table already has it, as SQLite has
+// no ADD COLUMN IF NOT EXISTS.
+func addColumn(table, column, definition string) migrationStep {
+	return func(tx *sql.Tx) error {
+		exists, err := columnExists(tx, table, column)
+		if err != nil {
+			return fmt.Errorf("failed to check for %s column existence: %w", column, err)
+		}
+		if exists {
+			return nil
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, table, column, definition)); err != nil {
+			return fmt.Errorf("failed to add %s column to %s: %w", column, table, err)
+		}
+		return nil
+	}
 }
 
-func getMigrationStatements() []string {
-	return []string{
+// migrations is every schema change in the order it was made. New changes
+// are appended with the next version; released migrations are never
+// renumbered or changed.
+var migrations = []migration{
+	{1, "create bookmarks", []migrationStep{execSQL(
 		`CREATE TABLE IF NOT EXISTS bookmarks (
 			status_id TEXT PRIMARY KEY,
 			created_at DATETIME NOT NULL,
@@ -457,27 +948,8 @@ func getMigrationStatements() []string {
 		)`,
 		`CREATE INDEX IF NOT EXISTS idx_created_at ON bookmarks(created_at)`,
 		`CREATE INDEX IF NOT EXISTS idx_bookmarked_at ON bookmarks(bookmarked_at)`,
-		`CREATE VIRTUAL TABLE IF NOT EXISTS bookmarks_fts USING fts5(
-			status_id UNINDEXED,
-			search_text,
-			content='bookmarks',
-			content_rowid='rowid',
-			tokenize='porter unicode61 remove_diacritics 1'
-		)`,
-		`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_insert AFTER INSERT ON bookmarks BEGIN
-			INSERT INTO bookmarks_fts(rowid, status_id, search_text)
-			VALUES (new.rowid, new.status_id, new.search_text);
-		END`,
-		`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_delete AFTER DELETE ON bookmarks BEGIN
-			INSERT INTO bookmarks_fts(bookmarks_fts, rowid, status_id, search_text)
-			VALUES('delete', old.rowid, old.status_id, old.search_text);
-		END`,
-		`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_update AFTER UPDATE ON bookmarks BEGIN
-			INSERT INTO bookmarks_fts(bookmarks_fts, rowid, status_id, search_text)
-			VALUES('delete', old.rowid, old.status_id, old.search_text);
-			INSERT INTO bookmarks_fts(rowid, status_id, search_text)
-			VALUES (new.rowid, new.status_id, new.search_text);
-		END`,
+	)}},
+	{2, "create backfill state", []migrationStep{execSQL(
 		`CREATE TABLE IF NOT EXISTS backfill_state (
 			id INTEGER PRIMARY KEY DEFAULT 1,
 			last_processed_id TEXT,
@@ -488,8 +960,19 @@ func getMigrationStatements() []string {
 			CHECK (id = 1)
 		)`,
 		`INSERT OR IGNORE INTO backfill_state (id) VALUES (1)`,
-		`ALTER TABLE bookmarks ADD COLUMN account_id TEXT`,
-		`CREATE INDEX IF NOT EXISTS idx_account_id ON bookmarks(account_id)`,
+	)}},
+	{3, "add bookmark account ids", []migrationStep{
+		addColumn("bookmarks", "account_id", "TEXT"),
+		execSQL(
+			`CREATE INDEX IF NOT EXISTS idx_account_id ON bookmarks(account_id)`,
+			// Populate account_id from existing raw_json data
+			`UPDATE bookmarks SET account_id = (
+				SELECT json_extract(raw_json, '$.status.account.id')
+				WHERE json_extract(raw_json, '$.status.account.id') IS NOT NULL
+			) WHERE account_id IS NULL`,
+		),
+	}},
+	{4, "create user account", []migrationStep{execSQL(
 		`CREATE TABLE IF NOT EXISTS user_account (
 			id INTEGER PRIMARY KEY DEFAULT 1,
 			account_id TEXT NOT NULL,
@@ -501,116 +984,744 @@ func getMigrationStatements() []string {
 			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			CHECK (id = 1)
 		)`,
-		// Populate account_id from existing raw_json data
-		`UPDATE bookmarks SET account_id = (
-			SELECT json_extract(raw_json, '$.status.account.id')
-			WHERE json_extract(raw_json, '$.status.account.id') IS NOT NULL
-		) WHERE account_id IS NULL`,
-	}
+	)}},
+	{5, "track removed bookmarks", []migrationStep{
+		addColumn("bookmarks", "unbookmarked_at", "DATETIME"),
+		execSQL(`CREATE INDEX IF NOT EXISTS idx_unbookmarked_at ON bookmarks(unbookmarked_at)`),
+		addColumn("backfill_state", "last_reconcile_time", "DATETIME"),
+		addColumn("backfill_state", "last_poll_pages", "INTEGER NOT NULL DEFAULT 0"),
+	}},
+	{6, "add status refresh", []migrationStep{
+		addColumn("bookmarks", "last_refreshed_at", "DATETIME"),
+		addColumn("bookmarks", "next_refresh_at", "DATETIME"),
+		addColumn("bookmarks", "deleted_at", "DATETIME"),
+		execSQL(
+			`CREATE INDEX IF NOT EXISTS idx_next_refresh_at ON bookmarks(next_refresh_at)`,
+			`CREATE TABLE IF NOT EXISTS bookmark_revisions (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				status_id TEXT NOT NULL,
+				search_text TEXT NOT NULL,
+				raw_json TEXT NOT NULL,
+				revised_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_bookmark_revisions_status_id ON bookmark_revisions(status_id)`,
+		),
+	}},
+	{7, "create media files", []migrationStep{execSQL(
+		`CREATE TABLE IF NOT EXISTS media_files (
+			remote_url TEXT PRIMARY KEY,
+			status_id TEXT NOT NULL,
+			media_id TEXT NOT NULL,
+			variant TEXT NOT NULL,
+			sha256 TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			mime_type TEXT NOT NULL,
+			downloaded_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_media_files_status_id ON media_files(status_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_media_files_sha256 ON media_files(sha256)`,
+	)}},
+	{8, "add poll resume url", []migrationStep{
+		addColumn("backfill_state", "poll_resume_url", "TEXT"),
+	}},
+	{9, "create link snapshots", []migrationStep{execSQL(
+		`CREATE TABLE IF NOT EXISTS link_snapshots (
+			url TEXT PRIMARY KEY,
+			title TEXT NOT NULL DEFAULT '',
+			text TEXT NOT NULL,
+			fetched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	)}},
+	{10, "create status context", []migrationStep{execSQL(
+		`CREATE TABLE IF NOT EXISTS status_context (
+			owner_account TEXT NOT NULL DEFAULT '',
+			status_id TEXT NOT NULL,
+			context_status_id TEXT NOT NULL,
+			relation TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			raw_json TEXT NOT NULL,
+			fetched_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (owner_account, status_id, context_status_id)
+		)`,
+	)}},
+	{11, "separate account archives", []migrationStep{
+		addColumn("bookmark_revisions", "owner_account", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmark_revisions", "source", "TEXT NOT NULL DEFAULT 'bookmark'"),
+		rebuildKeyedTables,
+		// Indexes go with the old tables
+		execSQL(
+			`CREATE INDEX IF NOT EXISTS idx_created_at ON bookmarks(created_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_bookmarked_at ON bookmarks(bookmarked_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_account_id ON bookmarks(account_id)`,
+			`CREATE INDEX IF NOT EXISTS idx_unbookmarked_at ON bookmarks(unbookmarked_at)`,
+			`CREATE INDEX IF NOT EXISTS idx_next_refresh_at ON bookmarks(next_refresh_at)`,
+		),
+	}},
+	{12, "index search fields in columns", []migrationStep{indexSearchFields}},
+	{13, "create archive metadata", []migrationStep{execSQL(
+		`CREATE TABLE IF NOT EXISTS archive_metadata (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)`,
+	)}},
+	{14, "add tags, notes and collections", []migrationStep{
+		execSQL(
+			`CREATE TABLE IF NOT EXISTS bookmark_annotations (
+				owner_account TEXT NOT NULL DEFAULT '',
+				status_id TEXT NOT NULL,
+				note TEXT NOT NULL DEFAULT '',
+				search_text TEXT NOT NULL DEFAULT '',
+				updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (owner_account, status_id)
+			)`,
+			`CREATE TABLE IF NOT EXISTS bookmark_tags (
+				owner_account TEXT NOT NULL DEFAULT '',
+				status_id TEXT NOT NULL,
+				tag TEXT NOT NULL,
+				PRIMARY KEY (owner_account, status_id, tag)
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_bookmark_tags_tag ON bookmark_tags(owner_account, tag)`,
+			`CREATE TABLE IF NOT EXISTS collections (
+				owner_account TEXT NOT NULL DEFAULT '',
+				name TEXT NOT NULL,
+				created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (owner_account, name)
+			)`,
+			`CREATE TABLE IF NOT EXISTS collection_bookmarks (
+				owner_account TEXT NOT NULL DEFAULT '',
+				collection TEXT NOT NULL,
+				status_id TEXT NOT NULL,
+				added_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				PRIMARY KEY (owner_account, collection, status_id),
+				FOREIGN KEY (owner_account, collection) REFERENCES collections(owner_account, name) ON DELETE CASCADE
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_collection_bookmarks_status_id ON collection_bookmarks(owner_account, status_id)`,
+		),
+		addColumn("bookmarks", "note_text", "TEXT NOT NULL DEFAULT ''"),
+		// The index gains a notes column, so it is created afresh
+		execSQL(
+			`DROP TRIGGER IF EXISTS bookmarks_fts_insert`,
+			`DROP TRIGGER IF EXISTS bookmarks_fts_delete`,
+			`DROP TRIGGER IF EXISTS bookmarks_fts_update_fields`,
+			`DROP TABLE IF EXISTS bookmarks_fts`,
+			`CREATE VIRTUAL TABLE bookmarks_fts USING fts5(
+				status_id UNINDEXED,
+				content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text, note_text,
+				content='bookmarks',
+				content_rowid='rowid',
+				tokenize='porter unicode61 remove_diacritics 1'
+			)`,
+			`CREATE TRIGGER bookmarks_fts_insert AFTER INSERT ON bookmarks BEGIN
+				INSERT INTO bookmarks_fts(rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text, note_text)
+				VALUES (new.rowid, new.status_id, new.content_text, new.spoiler_text, new.author_text, new.media_text, new.hashtag_text, new.link_text, new.thread_text, new.note_text);
+			END`,
+			`CREATE TRIGGER bookmarks_fts_delete AFTER DELETE ON bookmarks BEGIN
+				INSERT INTO bookmarks_fts(bookmarks_fts, rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text, note_text)
+				VALUES('delete', old.rowid, old.status_id, old.content_text, old.spoiler_text, old.author_text, old.media_text, old.hashtag_text, old.link_text, old.thread_text, old.note_text);
+			END`,
+			`CREATE TRIGGER bookmarks_fts_update_fields AFTER UPDATE OF content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text, note_text ON bookmarks BEGIN
+				INSERT INTO bookmarks_fts(bookmarks_fts, rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text, note_text)
+				VALUES('delete', old.rowid, old.status_id, old.content_text, old.spoiler_text, old.author_text, old.media_text, old.hashtag_text, old.link_text, old.thread_text, old.note_text);
+				INSERT INTO bookmarks_fts(rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text, note_text)
+				VALUES (new.rowid, new.status_id, new.content_text, new.spoiler_text, new.author_text, new.media_text, new.hashtag_text, new.link_text, new.thread_text, new.note_text);
+			END`,
+			`INSERT INTO bookmarks_fts(bookmarks_fts) VALUES('rebuild')`,
+		),
+	}},
+	{15, "create web sessions", []migrationStep{execSQL(
+		`CREATE TABLE IF NOT EXISTS web_sessions (
+			token_hash TEXT PRIMARY KEY,
+			csrf_token TEXT NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL
+		)`,
+	)}},
+	{16, "add has_link column", []migrationStep{
+		addColumn("bookmarks", "has_link", "INTEGER NOT NULL DEFAULT 0"),
+		flagLinkedBookmarks,
+	}},
 }
 
-func (d *Database) insertBookmark(bookmark *DBBookmark) error {
-	db, err := d.getDB()
+// flagLinkedBookmarks sets has_link on the archived statuses that link
+// somewhere, which SQL cannot tell from the stored HTML.
+func flagLinkedBookmarks(tx *sql.Tx) error {
+	rows, err := tx.Query(`SELECT rowid, raw_json FROM bookmarks`)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to query bookmarks: %w", err)
+	}
+	var linked []int64
+	for rows.Next() {
+		var rowID int64
+		var rawJSON string
+		if err := rows.Scan(&rowID, &rawJSON); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan bookmark: %w", err)
+		}
+		var bookmark Bookmark
+		if err := json.Unmarshal([]byte(rawJSON), &bookmark); err == nil && statusHasLink(bookmark.Status) {
+			linked = append(linked, rowID)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read bookmarks: %w", err)
 	}
 
-	query := `INSERT OR REPLACE INTO bookmarks 
-		(status_id, created_at, bookmarked_at, search_text, raw_json, account_id)
-		VALUES (?, ?, ?, ?, ?, ?)`
+	for _, rowID := range linked {
+		if _, err := tx.Exec(`UPDATE bookmarks SET has_link = 1 WHERE rowid = ?`, rowID); err != nil {
+			return fmt.Errorf("failed to flag linked bookmark: %w", err)
+		}
+	}
+	return nil
+}
 
-	_, err = db.Exec(query,
-		bookmark.StatusID,
-		bookmark.CreatedAt.UTC(),
-		bookmark.BookmarkedAt.UTC(),
-		bookmark.SearchText,
-		bookmark.RawJSON,
-		bookmark.AccountID,
-	)
+// rebuildKeyedTables rebuilds the tables in tableRebuilds that lack any of
+// their listed columns, as changing a primary key or unique constraint
+// means rebuilding the table in SQLite.
+func rebuildKeyedTables(tx *sql.Tx) error {
+	for _, table := range tableRebuilds {
+		current := true
+		for _, column := range table.columns {
+			exists, err := columnExists(tx, table.name, column)
+			if err != nil {
+				return fmt.Errorf("failed to check for %s column existence: %w", column, err)
+			}
+			current = current && exists
+		}
+		if current {
+			continue
+		}
 
-	if err != nil {
-		return fmt.Errorf("failed to insert bookmark: %w", err)
+		if err := rebuildTable(tx, table.name, table.schema); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (d *Database) getBookmark(statusID string) (*DBBookmark, error) {
-	db, err := d.getDB()
-	if err != nil {
-		return nil, err
+// indexSearchFields gives the search index a column per searchable field,
+// read from matching bookmarks columns. The single-column index of earlier
+// releases is replaced, and its text kept as each bookmark's content until
+// the archive is reindexed. Only changes to the text columns touch the
+// index, so bookkeeping updates such as refresh scheduling do not rewrite
+// FTS rows.
+func indexSearchFields(tx *sql.Tx) error {
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'bookmarks_fts'`).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check for search index: %w", err)
+	}
+	legacyIndex := false
+	if count > 0 {
+		current, err := columnExists(tx, "bookmarks_fts", "content_text")
+		if err != nil {
+			return fmt.Errorf("failed to check search index columns: %w", err)
+		}
+		legacyIndex = !current
+	}
+
+	steps := []migrationStep{
+		execSQL(
+			`DROP TRIGGER IF EXISTS bookmarks_fts_insert`,
+			`DROP TRIGGER IF EXISTS bookmarks_fts_delete`,
+			`DROP TRIGGER IF EXISTS bookmarks_fts_update`,
+			`DROP TRIGGER IF EXISTS bookmarks_fts_update_search_text`,
+		),
+		addColumn("bookmarks", "content_text", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmarks", "spoiler_text", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmarks", "author_text", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmarks", "media_text", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmarks", "hashtag_text", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmarks", "link_text", "TEXT NOT NULL DEFAULT ''"),
+		addColumn("bookmarks", "thread_text", "TEXT NOT NULL DEFAULT ''"),
+	}
+	if legacyIndex {
+		steps = append(steps, execSQL(
+			`DROP TABLE bookmarks_fts`,
+			`UPDATE bookmarks SET content_text = search_text`,
+		))
+	}
+	steps = append(steps, execSQL(
+		`CREATE VIRTUAL TABLE IF NOT EXISTS bookmarks_fts USING fts5(
+			status_id UNINDEXED,
+			content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text,
+			content='bookmarks',
+			content_rowid='rowid',
+			tokenize='porter unicode61 remove_diacritics 1'
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_insert AFTER INSERT ON bookmarks BEGIN
+			INSERT INTO bookmarks_fts(rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text)
+			VALUES (new.rowid, new.status_id, new.content_text, new.spoiler_text, new.author_text, new.media_text, new.hashtag_text, new.link_text, new.thread_text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_delete AFTER DELETE ON bookmarks BEGIN
+			INSERT INTO bookmarks_fts(bookmarks_fts, rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text)
+			VALUES('delete', old.rowid, old.status_id, old.content_text, old.spoiler_text, old.author_text, old.media_text, old.hashtag_text, old.link_text, old.thread_text);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS bookmarks_fts_update_fields AFTER UPDATE OF content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text ON bookmarks BEGIN
+			INSERT INTO bookmarks_fts(bookmarks_fts, rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text)
+			VALUES('delete', old.rowid, old.status_id, old.content_text, old.spoiler_text, old.author_text, old.media_text, old.hashtag_text, old.link_text, old.thread_text);
+			INSERT INTO bookmarks_fts(rowid, status_id, content_text, spoiler_text, author_text, media_text, hashtag_text, link_text, thread_text)
+			VALUES (new.rowid, new.status_id, new.content_text, new.spoiler_text, new.author_text, new.media_text, new.hashtag_text, new.link_text, new.thread_text);
+		END`,
+	))
+	if legacyIndex {
+		steps = append(steps, execSQL(`INSERT INTO bookmarks_fts(bookmarks_fts) VALUES('rebuild')`))
 	}
 
-	query := `SELECT status_id, created_at, bookmarked_at, search_text, raw_json, COALESCE(account_id, '') as account_id
-		FROM bookmarks WHERE status_id = ?`
-
-	var bookmark DBBookmark
-	err = db.QueryRow(query, statusID).Scan(
-		&bookmark.StatusID,
-		&bookmark.CreatedAt,
-		&bookmark.BookmarkedAt,
-		&bookmark.SearchText,
-		&bookmark.RawJSON,
-		&bookmark.AccountID,
-	)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+	for _, step := range steps {
+		if err := step(tx); err != nil {
+			return err
 		}
-		return nil, fmt.Errorf("failed to get bookmark: %w", err)
 	}
-	return &bookmark, nil
+	return nil
 }
 
-func (d *Database) getBackfillState() (*BackfillState, error) {
-	db, err := d.getDB()
+func columnExists(tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&count)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
+	return count > 0, nil
+}
 
-	var state BackfillState
-	var lastProcessedID sql.NullString
-	var lastPollTime sql.NullTime
-
-	query := `SELECT last_processed_id, backfill_complete, last_poll_time, created_at, updated_at
-		FROM backfill_state WHERE id = 1`
+// tableRebuilds holds the schemas of tables whose keys changed after they
+// were first created, as of the migration that changed them. A table is
+// rebuilt when it lacks any of the listed columns. Rows from before a rebuild belong to the unnamed account's
+// bookmark collection.
+var tableRebuilds = []struct {
+	name    string
+	columns []string
+	schema  string
+}{
+	{"bookmarks", []string{"owner_account", "source"}, `CREATE TABLE bookmarks_rebuild (
+		owner_account TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT 'bookmark',
+		status_id TEXT NOT NULL,
+		created_at DATETIME NOT NULL,
+		bookmarked_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		search_text TEXT NOT NULL,
+		raw_json TEXT NOT NULL,
+		account_id TEXT,
+		unbookmarked_at DATETIME,
+		last_refreshed_at DATETIME,
+		next_refresh_at DATETIME,
+		deleted_at DATETIME,
+		PRIMARY KEY (owner_account, source, status_id)
+	)`},
+	{"backfill_state", []string{"owner_account", "source"}, `CREATE TABLE backfill_state_rebuild (
+		id INTEGER PRIMARY KEY,
+		owner_account TEXT NOT NULL DEFAULT '',
+		source TEXT NOT NULL DEFAULT 'bookmark',
+		last_processed_id TEXT,
+		backfill_complete BOOLEAN NOT NULL DEFAULT FALSE,
+		last_poll_time DATETIME,
+		last_reconcile_time DATETIME,
+		last_poll_pages INTEGER NOT NULL DEFAULT 0,
+		poll_resume_url TEXT,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (owner_account, source)
+	)`},
+	{"user_account", []string{"owner_account"}, `CREATE TABLE user_account_rebuild (
+		id INTEGER PRIMARY KEY,
+		owner_account TEXT NOT NULL DEFAULT '' UNIQUE,
+		account_id TEXT NOT NULL,
+		username TEXT NOT NULL,
+		display_name TEXT NOT NULL,
+		acct TEXT NOT NULL,
+		avatar TEXT,
+		created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`},
+}
 
-	err = db.QueryRow(query).Scan(
-		&lastProcessedID,
-		&state.BackfillComplete,
-		&lastPollTime,
-		&state.CreatedAt,
-		&state.UpdatedAt,
-	)
+// rebuildTable recreates a table from its <name>_rebuild schema, copying
+// every row across. Bookmark rowids are kept so the external-content FTS
+// index still points at the right rows.
+func rebuildTable(tx *sql.Tx, name, schema string) error {
+	if _, err := tx.Exec(schema); err != nil {
+		return fmt.Errorf("failed to create rebuilt %s table: %w", name, err)
+	}
 
+	rows, err := tx.Query(`SELECT name FROM pragma_table_info(?)
+		WHERE name IN (SELECT name FROM pragma_table_info(?))`, name, name+"_rebuild")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("backfill state not initialized")
+		return fmt.Errorf("failed to list %s columns: %w", name, err)
+	}
+	var columns []string
+	if name == "bookmarks" {
+		// bookmarks has no INTEGER PRIMARY KEY carrying its rowid, so copy it explicitly
+		columns = append(columns, "rowid")
+	}
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan %s column: %w", name, err)
 		}
-		return nil, fmt.Errorf("failed to get backfill state: %w", err)
+		columns = append(columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over %s columns: %w", name, err)
 	}
 
-	if lastProcessedID.Valid {
-		state.LastProcessedID = lastProcessedID.String
+	columnList := strings.Join(columns, ", ")
+	statements := []string{
+		fmt.Sprintf(`INSERT INTO %[1]s_rebuild (%[2]s) SELECT %[2]s FROM %[1]s`, name, columnList),
+		fmt.Sprintf(`DROP TABLE %s`, name),
+		fmt.Sprintf(`ALTER TABLE %[1]s_rebuild RENAME TO %[1]s`, name),
 	}
-	if lastPollTime.Valid {
-		state.LastPollTime = &lastPollTime.Time
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to rebuild %s table: %w", name, err)
+		}
 	}
-
+	return nil
+}
+
+// searchColumns are the columns of the full-text index after status_id, in
+// index order. name is how search queries and [search] weights refer to a
+// column, and column its name in the index and the bookmarks table that
+// holds its text. Content comes first, as bookmarks without per-field text
+// are indexed as content.
+var searchColumns = []searchColumn{
+	{"content", "content_text", 1},
+	{"spoiler", "spoiler_text", 1},
+	{"author", "author_text", 0.5},
+	{"media", "media_text", 0.75},
+	{"hashtags", "hashtag_text", 1.5},
+	{"links", "link_text", 0.25},
+	{"thread", "thread_text", 0.25},
+	{"notes", noteSearchColumn, 1},
+}
+
+type searchColumn struct {
+	name   string
+	column string
+	weight float64
+}
+
+// noteSearchColumn holds the archive owner's own tags and note for a status.
+// It is written when they change rather than from the status, so converting
+// or reindexing a bookmark leaves it alone.
+const noteSearchColumn = "note_text"
+
+// statusSearchColumns returns the index columns whose text comes from the
+// status, in index order.
+func statusSearchColumns() []searchColumn {
+	columns := make([]searchColumn, 0, len(searchColumns))
+	for _, column := range searchColumns {
+		if column.column != noteSearchColumn {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// searchColumnList returns the index columns, each prefixed with prefix.
+func searchColumnList(prefix string) string {
+	columns := make([]string, len(searchColumns))
+	for i, column := range searchColumns {
+		columns[i] = prefix + column.column
+	}
+	return strings.Join(columns, ", ")
+}
+
+// searchColumnNamed returns the index column a query or weight name refers
+// to, or "" for an unknown name.
+func searchColumnNamed(name string) string {
+	for _, column := range searchColumns {
+		if column.name == name {
+			return column.column
+		}
+	}
+	return ""
+}
+
+// searchWeights returns the bm25 weight of each index column, taking the
+// configured weights over the defaults.
+func searchWeights(configured map[string]float64) ([]float64, error) {
+	for name, weight := range configured {
+		if searchColumnNamed(name) == "" {
+			names := make([]string, len(searchColumns))
+			for i, column := range searchColumns {
+				names[i] = column.name
+			}
+			return nil, fmt.Errorf("unknown search weight %q (use %s)", name, strings.Join(names, ", "))
+		}
+		if weight < 0 || math.IsNaN(weight) || math.IsInf(weight, 0) {
+			return nil, fmt.Errorf("search weight for %s must be a non-negative number", name)
+		}
+	}
+
+	weights := make([]float64, len(searchColumns))
+	for i, column := range searchColumns {
+		weights[i] = column.weight
+		if weight, ok := configured[column.name]; ok {
+			weights[i] = weight
+		}
+	}
+	return weights, nil
+}
+
+// bm25Rank returns the bm25() call ranking search results with the given
+// column weights. status_id is not indexed, so its weight does not matter.
+func bm25Rank(weights []float64) string {
+	args := []string{"bookmarks_fts", "0"}
+	for _, weight := range weights {
+		args = append(args, strconv.FormatFloat(weight, 'g', -1, 64))
+	}
+	return "bm25(" + strings.Join(args, ", ") + ")"
+}
+
+func (d *Database) insertBookmark(bookmark *DBBookmark) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	// Replacing a row keeps the owner's tags and note in the index
+	columns := statusSearchColumns()
+	var columnList strings.Builder
+	for _, column := range columns {
+		columnList.WriteString(column.column + ", ")
+	}
+	query := `INSERT OR REPLACE INTO bookmarks 
+		(owner_account, source, status_id, created_at, bookmarked_at, search_text, raw_json, account_id, has_link, ` + columnList.String() + noteSearchColumn + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?` + strings.Repeat(", ?", len(columns)) + `,
+			COALESCE((SELECT search_text FROM bookmark_annotations WHERE owner_account = ? AND status_id = ?), ''))`
+
+	source := bookmark.Source
+	if source == "" {
+		source = SourceBookmark
+	}
+
+	args := []interface{}{
+		bookmark.OwnerAccount,
+		source,
+		bookmark.StatusID,
+		bookmark.CreatedAt.UTC(),
+		bookmark.BookmarkedAt.UTC(),
+		bookmark.SearchText,
+		bookmark.RawJSON,
+		bookmark.AccountID,
+		bookmark.HasLink,
+	}
+	args = append(args, bookmark.searchColumnValues()...)
+	_, err = db.Exec(query, append(args, bookmark.OwnerAccount, bookmark.StatusID)...)
+
+	if err != nil {
+		return fmt.Errorf("failed to insert bookmark: %w", err)
+	}
+	return nil
+}
+
+// searchColumnValues returns the bookmark's text for each index column
+// drawn from the status, in statusSearchColumns order.
+func (b *DBBookmark) searchColumnValues() []interface{} {
+	columns := statusSearchColumns()
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		values[i] = b.SearchFields[column.name]
+	}
+	if b.SearchFields == nil {
+		values[0] = b.SearchText
+	}
+	return values
+}
+
+func (d *Database) getBookmark(statusID string) (*DBBookmark, error) {
+	return d.getBookmarkForOwner("", SourceBookmark, statusID)
+}
+
+func (d *Database) getBookmarkForOwner(owner, source, statusID string) (*DBBookmark, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + bookmarkColumns("") + ` FROM bookmarks WHERE owner_account = ? AND source = ? AND status_id = ?`
+
+	bookmark, err := scanBookmark(db.QueryRow(query, owner, source, statusID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get bookmark: %w", err)
+	}
+	return bookmark, nil
+}
+
+func (d *Database) getBackfillState() (*BackfillState, error) {
+	return d.getBackfillStateForOwner("", SourceBookmark)
+}
+
+func (d *Database) getBackfillStateForOwner(owner, source string) (*BackfillState, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var state BackfillState
+	var lastProcessedID sql.NullString
+	var lastPollTime sql.NullTime
+	var lastReconcileTime sql.NullTime
+	var pollResumeURL sql.NullString
+
+	query := `SELECT last_processed_id, backfill_complete, last_poll_time, last_reconcile_time, last_poll_pages, poll_resume_url, created_at, updated_at
+		FROM backfill_state WHERE owner_account = ? AND source = ?`
+
+	err = db.QueryRow(query, owner, source).Scan(
+		&lastProcessedID,
+		&state.BackfillComplete,
+		&lastPollTime,
+		&lastReconcileTime,
+		&state.LastPollPages,
+		&pollResumeURL,
+		&state.CreatedAt,
+		&state.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("backfill state not initialized")
+		}
+		return nil, fmt.Errorf("failed to get backfill state: %w", err)
+	}
+
+	if lastProcessedID.Valid {
+		state.LastProcessedID = lastProcessedID.String
+	}
+	if lastPollTime.Valid {
+		state.LastPollTime = &lastPollTime.Time
+	}
+	if lastReconcileTime.Valid {
+		state.LastReconcileTime = &lastReconcileTime.Time
+	}
+	if pollResumeURL.Valid {
+		state.PollResumeURL = pollResumeURL.String
+	}
+
 	return &state, nil
 }
 
+// collectionSyncState is the progress of one archived collection, as
+// reported by the metrics.
+type collectionSyncState struct {
+	Account          string
+	Source           string
+	BackfillComplete bool
+	LastPollTime     *time.Time
+}
+
+// getCollectionSyncStates returns the backfill and poll progress of every
+// archived collection.
+func (d *Database) getCollectionSyncStates() ([]collectionSyncState, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT owner_account, source, backfill_complete, last_poll_time
+		FROM backfill_state ORDER BY owner_account, source`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get backfill states: %w", err)
+	}
+	defer rows.Close()
+
+	var states []collectionSyncState
+	for rows.Next() {
+		var state collectionSyncState
+		var lastPollTime sql.NullTime
+		if err := rows.Scan(&state.Account, &state.Source, &state.BackfillComplete, &lastPollTime); err != nil {
+			return nil, fmt.Errorf("failed to scan backfill state: %w", err)
+		}
+		if lastPollTime.Valid {
+			state.LastPollTime = &lastPollTime.Time
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// databaseSize returns the size in bytes of the main database file.
+func (d *Database) databaseSize() (int64, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	if err := db.QueryRow(`SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()`).Scan(&size); err != nil {
+		return 0, fmt.Errorf("failed to get database size: %w", err)
+	}
+	return size, nil
+}
+
+// ensureBackfillState creates the backfill state row for one collection of an
+// account archive if it does not exist yet.
+func (d *Database) ensureBackfillState(owner, source string) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`INSERT OR IGNORE INTO backfill_state (owner_account, source) VALUES (?, ?)`, owner, source); err != nil {
+		return fmt.Errorf("failed to create backfill state: %w", err)
+	}
+	return nil
+}
+
+// claimUnownedRows assigns everything archived by the unnamed [mastodon]
+// account to the named account, so switching to [[accounts]] keeps the
+// existing archive. Rows the named account already has are left alone.
+func (d *Database) claimUnownedRows(owner string) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback account claim transaction")
+		}
+	}()
+
+	for _, table := range []string{"bookmarks", "backfill_state", "user_account", "bookmark_revisions"} {
+		query := fmt.Sprintf(`UPDATE OR IGNORE %s SET owner_account = ? WHERE owner_account = ''`, table)
+		if _, err := tx.Exec(query, owner); err != nil {
+			return fmt.Errorf("failed to claim %s rows: %w", table, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (d *Database) insertUserAccount(account *UserAccount) error {
 	db, err := d.getDB()
 	if err != nil {
 		return err
 	}
 
-	query := `INSERT OR REPLACE INTO user_account 
-		(id, account_id, username, display_name, acct, avatar, updated_at)
-		VALUES (1, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	query := `INSERT INTO user_account 
+		(owner_account, account_id, username, display_name, acct, avatar, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(owner_account) DO UPDATE SET
+			account_id = excluded.account_id,
+			username = excluded.username,
+			display_name = excluded.display_name,
+			acct = excluded.acct,
+			avatar = excluded.avatar,
+			updated_at = excluded.updated_at`
 
 	_, err = db.Exec(query,
+		account.OwnerAccount,
 		account.AccountID,
 		account.Username,
 		account.DisplayName,
@@ -625,16 +1736,21 @@ func (d *Database) insertUserAccount(account *UserAccount) error {
 }
 
 func (d *Database) getUserAccount() (*UserAccount, error) {
+	return d.getUserAccountForOwner("")
+}
+
+func (d *Database) getUserAccountForOwner(owner string) (*UserAccount, error) {
 	db, err := d.getDB()
 	if err != nil {
 		return nil, err
 	}
 
-	query := `SELECT account_id, username, display_name, acct, COALESCE(avatar, '') as avatar, created_at, updated_at
-		FROM user_account WHERE id = 1`
+	query := `SELECT owner_account, account_id, username, display_name, acct, COALESCE(avatar, '') as avatar, created_at, updated_at
+		FROM user_account WHERE owner_account = ?`
 
 	var account UserAccount
-	err = db.QueryRow(query).Scan(
+	err = db.QueryRow(query, owner).Scan(
+		&account.OwnerAccount,
 		&account.AccountID,
 		&account.Username,
 		&account.DisplayName,
@@ -654,6 +1770,10 @@ func (d *Database) getUserAccount() (*UserAccount, error) {
 }
 
 func (d *Database) updateBackfillState(lastProcessedID string, backfillComplete bool, lastPollTime *time.Time) error {
+	return d.updateBackfillStateForOwner("", SourceBookmark, lastProcessedID, backfillComplete, lastPollTime)
+}
+
+func (d *Database) updateBackfillStateForOwner(owner, source, lastProcessedID string, backfillComplete bool, lastPollTime *time.Time) error {
 	db, err := d.getDB()
 	if err != nil {
 		return err
@@ -661,14 +1781,14 @@ func (d *Database) updateBackfillState(lastProcessedID string, backfillComplete
 
 	query := `UPDATE backfill_state 
 		SET last_processed_id = ?, backfill_complete = ?, last_poll_time = ?, updated_at = CURRENT_TIMESTAMP 
-		WHERE id = 1`
+		WHERE owner_account = ? AND source = ?`
 
 	var lastPollTimeParam interface{}
 	if lastPollTime != nil {
 		lastPollTimeParam = lastPollTime.UTC()
 	}
 
-	result, err := db.Exec(query, lastProcessedID, backfillComplete, lastPollTimeParam)
+	result, err := db.Exec(query, lastProcessedID, backfillComplete, lastPollTimeParam, owner, source)
 	if err != nil {
 		return fmt.Errorf("failed to update backfill state: %w", err)
 	}
@@ -684,1497 +1804,9928 @@ func (d *Database) updateBackfillState(lastProcessedID string, backfillComplete
 	return nil
 }
 
-func (d *Database) searchBookmarksWithFTS5(request *SearchRequest) ([]*SearchResult, error) {
-	if d.db == nil {
-		return nil, fmt.Errorf("database connection is nil")
+// updatePollProgress records how many pages the last poll walked and where
+// the next poll should resume, clearing the resume point when resumeURL is
+// empty.
+func (d *Database) updatePollProgress(owner, source string, pages int, resumeURL string) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
 	}
 
-	if request.Query == "" {
-		return []*SearchResult{}, nil
+	var resumeParam interface{}
+	if resumeURL != "" {
+		resumeParam = resumeURL
 	}
 
-	limit := request.Limit
-	if limit <= 0 {
-		limit = 100
+	query := `UPDATE backfill_state SET last_poll_pages = ?, poll_resume_url = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE owner_account = ? AND source = ?`
+	if _, err := db.Exec(query, pages, resumeParam, owner, source); err != nil {
+		return fmt.Errorf("failed to update poll progress: %w", err)
 	}
+	return nil
+}
 
-	offset := request.Offset
-	if offset < 0 {
-		offset = 0
+func (d *Database) updateReconcileTime(owner, source string, reconcileTime time.Time) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
 	}
 
-	snippetLength := request.SnippetLength
-	if snippetLength <= 0 {
-		snippetLength = 200
+	query := `UPDATE backfill_state SET last_reconcile_time = ?, updated_at = CURRENT_TIMESTAMP WHERE owner_account = ? AND source = ?`
+	if _, err := db.Exec(query, reconcileTime.UTC(), owner, source); err != nil {
+		return fmt.Errorf("failed to update reconcile time: %w", err)
 	}
+	return nil
+}
 
-	searchQuery := prepareFTS5Query(request.Query)
+// getBookmarkStates returns every status ID in one collection of an account's
+// archive mapped to whether it is still in that collection on the server.
+func (d *Database) getBookmarkStates(owner, source string) (map[string]bool, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
 
-	var query string
-	var args []interface{}
+	rows, err := db.Query(`SELECT status_id, unbookmarked_at IS NULL FROM bookmarks WHERE owner_account = ? AND source = ?`, owner, source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bookmark states: %w", err)
+	}
+	defer rows.Close()
 
-	// Build WHERE clause for account filtering
-	accountFilter := ""
-	var userAccountID string
-	if request.FilterByAccount == "my_posts" {
-		// Get current user account to filter by
-		userAccount, err := d.getUserAccount()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get user account for filtering: %w", err)
-		}
-		if userAccount != nil {
-			accountFilter = " AND b.account_id = ?"
-			userAccountID = userAccount.AccountID
-		} else {
-			// No user account configured, return empty results for my_posts filter
-			return []*SearchResult{}, nil
+	states := make(map[string]bool)
+	for rows.Next() {
+		var statusID string
+		var active bool
+		if err := rows.Scan(&statusID, &active); err != nil {
+			return nil, fmt.Errorf("failed to scan bookmark state: %w", err)
 		}
+		states[statusID] = active
 	}
 
-	if request.EnableHighlighting {
-		query = `
-			SELECT 
-				b.status_id, b.created_at, b.bookmarked_at, b.search_text, b.raw_json, COALESCE(b.account_id, '') as account_id,
-				bm25(bookmarks_fts) as rank,
-				snippet(bookmarks_fts, 1, '<mark>', '</mark>', '...', ?) as snippet
-			FROM bookmarks_fts
-			JOIN bookmarks b ON b.rowid = bookmarks_fts.rowid
-			WHERE bookmarks_fts MATCH ?` + accountFilter + `
-			ORDER BY rank
-			LIMIT ? OFFSET ?`
-		args = []interface{}{snippetLength, searchQuery}
-		if accountFilter != "" {
-			args = append(args, userAccountID)
-		}
-		args = append(args, limit, offset)
-	} else {
-		query = `
-			SELECT 
-				b.status_id, b.created_at, b.bookmarked_at, b.search_text, b.raw_json, COALESCE(b.account_id, '') as account_id,
-				bm25(bookmarks_fts) as rank
-			FROM bookmarks_fts
-			JOIN bookmarks b ON b.rowid = bookmarks_fts.rowid
-			WHERE bookmarks_fts MATCH ?` + accountFilter + `
-			ORDER BY rank
-			LIMIT ? OFFSET ?`
-		args = []interface{}{searchQuery}
-		if accountFilter != "" {
-			args = append(args, userAccountID)
-		}
-		args = append(args, limit, offset)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over bookmark states: %w", err)
 	}
+	return states, nil
+}
 
-	rows, err := d.db.Query(query, args...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute FTS5 search: %w", err)
+// setBookmarksUnbookmarked marks the given statuses as removed on the server
+// at the given time, or as bookmarked again when unbookmarkedAt is nil.
+func (d *Database) setBookmarksUnbookmarked(owner, source string, statusIDs []string, unbookmarkedAt *time.Time) error {
+	if len(statusIDs) == 0 {
+		return nil
 	}
-	defer rows.Close()
 
-	results := []*SearchResult{}
-	for rows.Next() {
-		var bookmark DBBookmark
-		var rank float64
-		var snippet sql.NullString
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
 
-		if request.EnableHighlighting {
-			err = rows.Scan(
-				&bookmark.StatusID,
-				&bookmark.CreatedAt,
-				&bookmark.BookmarkedAt,
-				&bookmark.SearchText,
-				&bookmark.RawJSON,
-				&bookmark.AccountID,
-				&rank,
-				&snippet,
-			)
-		} else {
-			err = rows.Scan(
-				&bookmark.StatusID,
-				&bookmark.CreatedAt,
-				&bookmark.BookmarkedAt,
-				&bookmark.SearchText,
-				&bookmark.RawJSON,
-				&bookmark.AccountID,
-				&rank,
-			)
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback bookmark state transaction")
 		}
+	}()
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan search result: %w", err)
-		}
+	var param interface{}
+	if unbookmarkedAt != nil {
+		param = unbookmarkedAt.UTC()
+	}
 
-		result := &SearchResult{
-			Bookmark: &bookmark,
-			Rank:     rank,
-		}
+	stmt, err := tx.Prepare(`UPDATE bookmarks SET unbookmarked_at = ? WHERE owner_account = ? AND source = ? AND status_id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare bookmark state update: %w", err)
+	}
+	defer stmt.Close()
 
-		if snippet.Valid {
-			result.Snippet = snippet.String
+	for _, statusID := range statusIDs {
+		if _, err := stmt.Exec(param, owner, source, statusID); err != nil {
+			return fmt.Errorf("failed to update bookmark state for %s: %w", statusID, err)
 		}
-
-		results = append(results, result)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over search results: %w", err)
-	}
-
-	return results, nil
+	return tx.Commit()
 }
 
-func (d *Database) getRecentBookmarks(limit, offset int, filterByAccount string) ([]*SearchResult, error) {
-	if d.db == nil {
-		return nil, fmt.Errorf("database connection is nil")
+// getBookmarksDueForRefresh returns up to limit archived statuses of an
+// account, from any collection, whose scheduled refresh time has passed,
+// never-refreshed statuses first.
+func (d *Database) getBookmarksDueForRefresh(owner string, now time.Time, limit int) ([]*DBBookmark, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
 
-	if limit <= 0 {
-		limit = 100
-	}
-	if offset < 0 {
-		offset = 0
-	}
+	query := `SELECT ` + bookmarkColumns("") + ` FROM bookmarks
+		WHERE owner_account = ? AND deleted_at IS NULL AND (next_refresh_at IS NULL OR next_refresh_at <= ?)
+		ORDER BY next_refresh_at IS NOT NULL, next_refresh_at, bookmarked_at DESC
+		LIMIT ?`
 
-	var query string
-	var args []interface{}
+	rows, err := db.Query(query, owner, now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bookmarks due for refresh: %w", err)
+	}
+	defer rows.Close()
 
-	// Build WHERE clause for account filtering
-	accountFilter := ""
-	if filterByAccount == "my_posts" {
-		// Get current user account to filter by
-		userAccount, err := d.getUserAccount()
+	var bookmarks []*DBBookmark
+	for rows.Next() {
+		bookmark, err := scanBookmark(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get user account for filtering: %w", err)
-		}
-		if userAccount != nil {
-			accountFilter = " WHERE account_id = ?"
-			args = append(args, userAccount.AccountID)
-		} else {
-			// No user account configured, return empty results for my_posts filter
-			return []*SearchResult{}, nil
+			return nil, fmt.Errorf("failed to scan bookmark due for refresh: %w", err)
 		}
+		bookmarks = append(bookmarks, bookmark)
 	}
 
-	query = `SELECT status_id, created_at, bookmarked_at, search_text, raw_json, COALESCE(account_id, '') as account_id
-		FROM bookmarks` + accountFilter + ` ORDER BY bookmarked_at DESC LIMIT ? OFFSET ?`
-	args = append(args, limit, offset)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over bookmarks due for refresh: %w", err)
+	}
+	return bookmarks, nil
+}
 
-	rows, err := d.db.Query(query, args...)
+// scheduleBookmarkRefresh records when a status should next be refreshed,
+// and when it was last refreshed successfully if refreshedAt is non-nil.
+func (d *Database) scheduleBookmarkRefresh(owner, source, statusID string, refreshedAt *time.Time, nextRefresh time.Time) error {
+	db, err := d.getDB()
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute recent bookmarks query: %w", err)
+		return err
 	}
-	defer rows.Close()
 
-	results := []*SearchResult{}
-	for rows.Next() {
-		var bookmark DBBookmark
+	var refreshedParam interface{}
+	if refreshedAt != nil {
+		refreshedParam = refreshedAt.UTC()
+	}
 
-		err = rows.Scan(
-			&bookmark.StatusID,
-			&bookmark.CreatedAt,
-			&bookmark.BookmarkedAt,
-			&bookmark.SearchText,
-			&bookmark.RawJSON,
-			&bookmark.AccountID,
-		)
+	query := `UPDATE bookmarks SET last_refreshed_at = COALESCE(?, last_refreshed_at), next_refresh_at = ?
+		WHERE owner_account = ? AND source = ? AND status_id = ?`
+	if _, err := db.Exec(query, refreshedParam, nextRefresh.UTC(), owner, source, statusID); err != nil {
+		return fmt.Errorf("failed to schedule bookmark refresh: %w", err)
+	}
+	return nil
+}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan recent bookmark result: %w", err)
-		}
+// updateBookmarkContent stores the current copy of a status as a revision and
+// replaces it with the refreshed content. The FTS triggers keep the search
+// index in sync with the update.
+func (d *Database) updateBookmarkContent(owner, source string, bookmark *DBBookmark, refreshedAt, nextRefresh time.Time) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
 
-		result := &SearchResult{
-			Bookmark: &bookmark,
-			Rank:     0.0,
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback bookmark update transaction")
 		}
-		results = append(results, result)
+	}()
+
+	if _, err := tx.Exec(`INSERT INTO bookmark_revisions (owner_account, source, status_id, search_text, raw_json, revised_at)
+		SELECT owner_account, source, status_id, search_text, raw_json, ? FROM bookmarks
+		WHERE owner_account = ? AND source = ? AND status_id = ?`,
+		refreshedAt.UTC(), owner, source, bookmark.StatusID); err != nil {
+		return fmt.Errorf("failed to store bookmark revision: %w", err)
 	}
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating over recent bookmark results: %w", err)
+	set := "search_text = ?, raw_json = ?, has_link = ?, last_refreshed_at = ?, next_refresh_at = ?"
+	for _, column := range statusSearchColumns() {
+		set += ", " + column.column + " = ?"
+	}
+	args := []interface{}{bookmark.SearchText, bookmark.RawJSON, bookmark.HasLink, refreshedAt.UTC(), nextRefresh.UTC()}
+	args = append(args, bookmark.searchColumnValues()...)
+	args = append(args, owner, source, bookmark.StatusID)
+	result, err := tx.Exec(`UPDATE bookmarks SET `+set+`
+		WHERE owner_account = ? AND source = ? AND status_id = ?`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to update bookmark: %w", err)
 	}
 
-	return results, nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("bookmark %s not found", bookmark.StatusID)
+	}
+
+	return tx.Commit()
 }
 
-func (d *Database) searchOrRecentBookmarks(request *SearchRequest) ([]*SearchResult, error) {
-	if strings.TrimSpace(request.Query) == "" {
-		return d.getRecentBookmarks(request.Limit, request.Offset, request.FilterByAccount)
+func (d *Database) markBookmarkDeleted(owner, source, statusID string, deletedAt time.Time) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
 	}
-	return d.searchBookmarksWithFTS5(request)
-}
 
-func prepareFTS5Query(query string) string {
-	query = strings.TrimSpace(query)
+	query := `UPDATE bookmarks SET deleted_at = ?, last_refreshed_at = ? WHERE owner_account = ? AND source = ? AND status_id = ?`
+	if _, err := db.Exec(query, deletedAt.UTC(), deletedAt.UTC(), owner, source, statusID); err != nil {
+		return fmt.Errorf("failed to mark bookmark deleted: %w", err)
+	}
+	return nil
+}
 
-	if strings.HasPrefix(query, "\"") && strings.HasSuffix(query, "\"") {
-		return query
+func (d *Database) getBookmarkRevisions(owner, source, statusID string) ([]*BookmarkRevision, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
 
-	upperQuery := strings.ToUpper(query)
-	if strings.Contains(upperQuery, " AND ") || strings.Contains(upperQuery, " OR ") || strings.Contains(upperQuery, " NOT ") {
-		return query
+	rows, err := db.Query(`SELECT id, status_id, search_text, raw_json, revised_at
+		FROM bookmark_revisions WHERE owner_account = ? AND source = ? AND status_id = ?
+		ORDER BY revised_at DESC, id DESC`, owner, source, statusID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bookmark revisions: %w", err)
 	}
+	defer rows.Close()
 
-	if !strings.HasSuffix(query, "*") && !strings.HasSuffix(query, "\"") && !strings.Contains(query, "\"") {
-		words := strings.Fields(query)
-		for i, word := range words {
-			if !strings.HasSuffix(word, "*") && !strings.HasSuffix(word, "\"") && !strings.Contains(word, "\"") {
-				words[i] = word + "*"
-			}
+	revisions := []*BookmarkRevision{}
+	for rows.Next() {
+		var revision BookmarkRevision
+		if err := rows.Scan(&revision.ID, &revision.StatusID, &revision.SearchText, &revision.RawJSON, &revision.RevisedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan bookmark revision: %w", err)
 		}
-		return strings.Join(words, " ")
+		revisions = append(revisions, &revision)
 	}
 
-	return query
-}
-
-func minInt(a, b int) int {
-	if a < b {
-		return a
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over bookmark revisions: %w", err)
 	}
-	return b
+	return revisions, nil
 }
 
-// =============================================================================
-// MASTODON CLIENT
-// =============================================================================
+// getBookmarkCounts returns the number of archived statuses and how many of
+// them are no longer bookmarked on the server, for one account's archive or
+// for all of them when owner is empty.
+func (d *Database) getBookmarkCounts(owner string) (total int, removed int, err error) {
+	db, err := d.getDB()
+	if err != nil {
+		return 0, 0, err
+	}
 
-type MastodonClient struct {
-	server      string
-	accessToken string
-	timeout     time.Duration
-	madonClient *madon.Client
+	query := `SELECT COUNT(*), COUNT(unbookmarked_at) FROM bookmarks WHERE ? = '' OR owner_account = ?`
+	if err := db.QueryRow(query, owner, owner).Scan(&total, &removed); err != nil {
+		return 0, 0, fmt.Errorf("failed to count bookmarks: %w", err)
+	}
+	return total, removed, nil
 }
 
-func newMastodonClient(cfg *Config) (*MastodonClient, error) {
-	if cfg.Mastodon.Server == "" {
-		return nil, fmt.Errorf("mastodon server URL is required")
+// getSourceCounts returns the number of archived statuses per collection, for
+// one account's archive or for all of them when owner is empty.
+func (d *Database) getSourceCounts(owner string) (map[string]int, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
-	if cfg.Mastodon.AccessToken == "" {
-		return nil, fmt.Errorf("mastodon access token is required")
+
+	rows, err := db.Query(`SELECT source, COUNT(*) FROM bookmarks WHERE ? = '' OR owner_account = ? GROUP BY source`, owner, owner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count collections: %w", err)
 	}
+	defer rows.Close()
 
-	timeout := 30 * time.Second
-	if cfg.Mastodon.ClientTimeout != "" {
-		var err error
-		timeout, err = time.ParseDuration(cfg.Mastodon.ClientTimeout)
-		if err != nil {
-			return nil, fmt.Errorf("invalid client timeout: %w", err)
+	counts := make(map[string]int)
+	for rows.Next() {
+		var source string
+		var count int
+		if err := rows.Scan(&source, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan collection count: %w", err)
 		}
+		counts[source] = count
 	}
 
-	return &MastodonClient{
-		server:      cfg.Mastodon.Server,
-		accessToken: cfg.Mastodon.AccessToken,
-		timeout:     timeout,
-		madonClient: nil,
-	}, nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over collection counts: %w", err)
+	}
+	return counts, nil
 }
 
-func (c *MastodonClient) initMadonClient() error {
-	if c.madonClient != nil {
-		return nil
+// getStatusSources returns the collections of an account's archive that hold
+// the given status.
+func (d *Database) getStatusSources(owner, statusID string) ([]string, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
 
-	userToken := &madon.UserToken{
-		AccessToken: c.accessToken,
-		TokenType:   "Bearer",
+	rows, err := db.Query(`SELECT source FROM bookmarks WHERE owner_account = ? AND status_id = ? ORDER BY source`, owner, statusID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status sources: %w", err)
 	}
+	defer rows.Close()
 
-	madonClient, err := madon.RestoreApp("bookmarchive", c.server, "dummy-app-id", "dummy-app-secret", userToken)
-	if err != nil {
-		return fmt.Errorf("failed to create madon client: %w", err)
+	var sources []string
+	for rows.Next() {
+		var source string
+		if err := rows.Scan(&source); err != nil {
+			return nil, fmt.Errorf("failed to scan status source: %w", err)
+		}
+		sources = append(sources, source)
 	}
 
-	c.madonClient = madonClient
-	return nil
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over status sources: %w", err)
+	}
+	return sources, nil
 }
 
-func (c *MastodonClient) verifyCredentials() error {
-	if err := c.initMadonClient(); err != nil {
+func (d *Database) insertMediaFile(file *MediaFile) error {
+	db, err := d.getDB()
+	if err != nil {
 		return err
 	}
 
-	_, err := c.madonClient.GetCurrentAccount()
-	if err != nil {
-		return fmt.Errorf("failed to verify credentials: %w", err)
+	query := `INSERT OR REPLACE INTO media_files
+		(remote_url, status_id, media_id, variant, sha256, size, mime_type, downloaded_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	if _, err := db.Exec(query, file.RemoteURL, file.StatusID, file.MediaID, file.Variant,
+		file.SHA256, file.Size, file.MimeType, file.DownloadedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to insert media file: %w", err)
 	}
 	return nil
 }
 
-func (c *MastodonClient) getMadonClient() (*madon.Client, error) {
-	if err := c.initMadonClient(); err != nil {
+const mediaFileColumns = `remote_url, status_id, media_id, variant, sha256, size, mime_type, downloaded_at`
+
+func scanMediaFile(row rowScanner) (*MediaFile, error) {
+	var file MediaFile
+	if err := row.Scan(&file.RemoteURL, &file.StatusID, &file.MediaID, &file.Variant,
+		&file.SHA256, &file.Size, &file.MimeType, &file.DownloadedAt); err != nil {
 		return nil, err
 	}
-	return c.madonClient, nil
+	return &file, nil
 }
 
-// =============================================================================
-// BOOKMARK SERVICE MODELS
-// =============================================================================
+func (d *Database) getMediaFile(remoteURL string) (*MediaFile, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
 
-type Bookmark struct {
-	ID        string    `json:"id"`
-	Status    Status    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
+	file, err := scanMediaFile(db.QueryRow(`SELECT `+mediaFileColumns+` FROM media_files WHERE remote_url = ?`, remoteURL))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get media file: %w", err)
+	}
+	return file, nil
 }
 
-type Status struct {
-	ID               string    `json:"id"`
-	URI              string    `json:"uri"`
-	URL              string    `json:"url"`
-	Content          string    `json:"content"`
-	SpoilerText      string    `json:"spoiler_text"`
-	CreatedAt        time.Time `json:"created_at"`
-	Account          Account   `json:"account"`
-	MediaAttachments []Media   `json:"media_attachments"`
-	Tags             []Tag     `json:"tags"`
-}
+func (d *Database) getMediaFileByHash(hash string) (*MediaFile, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
 
-type Account struct {
-	ID          string `json:"id"`
-	Username    string `json:"username"`
-	DisplayName string `json:"display_name"`
-	Avatar      string `json:"avatar"`
+	file, err := scanMediaFile(db.QueryRow(`SELECT `+mediaFileColumns+` FROM media_files WHERE sha256 = ? LIMIT 1`, hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get media file: %w", err)
+	}
+	return file, nil
 }
 
-type Media struct {
-	ID          string `json:"id"`
-	Type        string `json:"type"`
-	URL         string `json:"url"`
-	Description string `json:"description"`
-}
+// getMediaFilesForStatuses returns the archived media of the given statuses,
+// keyed by status ID.
+func (d *Database) getMediaFilesForStatuses(statusIDs []string) (map[string][]*MediaFile, error) {
+	files := make(map[string][]*MediaFile)
+	if len(statusIDs) == 0 {
+		return files, nil
+	}
 
-type Tag struct {
-	Name string `json:"name"`
-	URL  string `json:"url"`
-}
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
 
-type BookmarkClient interface {
-	GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error)
-}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statusIDs)), ", ")
+	args := make([]interface{}, len(statusIDs))
+	for i, statusID := range statusIDs {
+		args[i] = statusID
+	}
 
-// =============================================================================
-// RATE LIMITER
-// =============================================================================
+	rows, err := db.Query(`SELECT `+mediaFileColumns+` FROM media_files
+		WHERE status_id IN (`+placeholders+`) ORDER BY media_id, variant`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query media files: %w", err)
+	}
+	defer rows.Close()
 
-type RateLimiter struct {
-	maxRequests int
-	timeWindow  time.Duration
-	requests    []time.Time
-}
+	for rows.Next() {
+		file, err := scanMediaFile(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan media file: %w", err)
+		}
+		files[file.StatusID] = append(files[file.StatusID], file)
+	}
 
-func newRateLimiter(maxRequests int, timeWindow time.Duration) *RateLimiter {
-	return &RateLimiter{
-		maxRequests: maxRequests,
-		timeWindow:  timeWindow,
-		requests:    make([]time.Time, 0),
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over media files: %w", err)
 	}
+	return files, nil
 }
 
-func (rl *RateLimiter) allow() bool {
-	now := time.Now()
-	cutoff := now.Add(-rl.timeWindow)
-	newRequests := make([]time.Time, 0, len(rl.requests))
-	for _, req := range rl.requests {
-		if req.After(cutoff) {
-			newRequests = append(newRequests, req)
-		}
+// attachMediaFiles fills in the archived media of each search result.
+func (d *Database) attachMediaFiles(results []*SearchResult) error {
+	statusIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		statusIDs = append(statusIDs, result.Bookmark.StatusID)
 	}
-	rl.requests = newRequests
 
-	if len(rl.requests) >= rl.maxRequests {
-		return false
+	files, err := d.getMediaFilesForStatuses(statusIDs)
+	if err != nil {
+		return err
 	}
+	for _, result := range results {
+		result.Media = files[result.Bookmark.StatusID]
+	}
+	return nil
+}
 
-	rl.requests = append(rl.requests, now)
-	return true
+// annotationKey identifies the annotations of a status in an account's
+// archive.
+type annotationKey struct {
+	owner    string
+	statusID string
 }
 
-func (rl *RateLimiter) wait(ctx context.Context) error {
-	for !rl.allow() {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(time.Second):
-		}
+// getAnnotations returns an account's annotations of a status, empty when
+// there are none.
+func (d *Database) getAnnotations(owner, statusID string) (*Annotations, error) {
+	annotations, err := d.getAnnotationsForStatuses([]string{statusID})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	if found, ok := annotations[annotationKey{owner, statusID}]; ok {
+		return found, nil
+	}
+	return &Annotations{Tags: []string{}, Collections: []string{}}, nil
 }
 
-// =============================================================================
-// MASTODON BOOKMARK CLIENT
-// =============================================================================
+// getAnnotationsForStatuses returns the annotations of the given statuses in
+// every archive that has any.
+func (d *Database) getAnnotationsForStatuses(statusIDs []string) (map[annotationKey]*Annotations, error) {
+	annotations := make(map[annotationKey]*Annotations)
+	if len(statusIDs) == 0 {
+		return annotations, nil
+	}
 
-type MastodonBookmarkClient struct {
-	client      *madon.Client
-	rateLimiter *RateLimiter
-	maxRetries  int
-}
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
 
-func newMastodonBookmarkClient(client *madon.Client, maxRetries int) *MastodonBookmarkClient {
-	rateLimiter := newRateLimiter(150, 5*time.Minute)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(statusIDs)), ", ")
+	args := make([]interface{}, len(statusIDs))
+	for i, statusID := range statusIDs {
+		args[i] = statusID
+	}
 
-	return &MastodonBookmarkClient{
-		client:      client,
-		rateLimiter: rateLimiter,
-		maxRetries:  maxRetries,
+	get := func(key annotationKey) *Annotations {
+		found, ok := annotations[key]
+		if !ok {
+			found = &Annotations{Tags: []string{}, Collections: []string{}}
+			annotations[key] = found
+		}
+		return found
+	}
+
+	queries := []struct {
+		name  string
+		query string
+		add   func(found *Annotations, value string, updatedAt time.Time)
+	}{
+		{"notes", `SELECT owner_account, status_id, note, updated_at FROM bookmark_annotations
+			WHERE status_id IN (` + placeholders + `)`,
+			func(found *Annotations, note string, updatedAt time.Time) {
+				found.Note = note
+				found.UpdatedAt = &updatedAt
+			}},
+		{"tags", `SELECT owner_account, status_id, tag, NULL FROM bookmark_tags
+			WHERE status_id IN (` + placeholders + `) ORDER BY tag`,
+			func(found *Annotations, tag string, _ time.Time) {
+				found.Tags = append(found.Tags, tag)
+			}},
+		{"collections", `SELECT owner_account, status_id, collection, NULL FROM collection_bookmarks
+			WHERE status_id IN (` + placeholders + `) ORDER BY collection`,
+			func(found *Annotations, collection string, _ time.Time) {
+				found.Collections = append(found.Collections, collection)
+			}},
+	}
+	for _, q := range queries {
+		rows, err := db.Query(q.query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", q.name, err)
+		}
+		for rows.Next() {
+			var key annotationKey
+			var value string
+			var updatedAt sql.NullTime
+			if err := rows.Scan(&key.owner, &key.statusID, &value, &updatedAt); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan %s: %w", q.name, err)
+			}
+			q.add(get(key), value, updatedAt.Time)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating over %s: %w", q.name, err)
+		}
 	}
+	return annotations, nil
 }
 
-func (bc *MastodonBookmarkClient) GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error) {
-	if err := bc.rateLimiter.wait(ctx); err != nil {
-		return nil, "", fmt.Errorf("rate limit wait failed: %w", err)
+// attachAnnotations fills in the annotations of each search result from its
+// own account's archive.
+func (d *Database) attachAnnotations(results []*SearchResult) error {
+	statusIDs := make([]string, 0, len(results))
+	for _, result := range results {
+		statusIDs = append(statusIDs, result.Bookmark.StatusID)
 	}
 
-	if bc.client == nil {
-		return []Bookmark{}, "", nil
+	annotations, err := d.getAnnotationsForStatuses(statusIDs)
+	if err != nil {
+		return err
 	}
+	for _, result := range results {
+		result.Annotations = annotations[annotationKey{result.Bookmark.OwnerAccount, result.Bookmark.StatusID}]
+	}
+	return nil
+}
 
-	var requestURL string
-	if nextURL != "" {
-		requestURL = nextURL
-	} else {
-		baseURL := fmt.Sprintf("%s/api/v1/bookmarks", bc.client.InstanceURL)
-		reqURL, err := url.Parse(baseURL)
-		if err != nil {
-			return nil, "", fmt.Errorf("failed to parse URL: %w", err)
-		}
-
-		params := url.Values{}
-		if limit > 0 {
-			params.Set("limit", strconv.Itoa(limit))
-		}
-		reqURL.RawQuery = params.Encode()
-		requestURL = reqURL.String()
+// deleteBookmark removes a status from an account's archive, from every
+// collection or only from source when given, and returns how many copies
+// it removed. Once no collection holds the status, its annotations,
+// revisions and thread go too; archived media files are kept, as other
+// statuses may share them.
+func (d *Database) deleteBookmark(owner, source, statusID string) (int64, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return 0, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	tx, err := db.Begin()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback bookmark deletion")
+		}
+	}()
 
-	if bc.client.UserToken != nil && bc.client.UserToken.AccessToken != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bc.client.UserToken.AccessToken))
+	result, err := tx.Exec(`DELETE FROM bookmarks WHERE owner_account = ? AND status_id = ? AND (? = '' OR source = ?)`,
+		owner, statusID, source, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete bookmark: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted bookmarks: %w", err)
+	}
+	if deleted == 0 {
+		return 0, nil
 	}
-	req.Header.Set("User-Agent", "bookmarchive/1.0")
 
-	var resp *http.Response
-	var lastErr error
+	if _, err := tx.Exec(`DELETE FROM bookmark_revisions WHERE owner_account = ? AND status_id = ? AND (? = '' OR source = ?)`,
+		owner, statusID, source, source); err != nil {
+		return 0, fmt.Errorf("failed to delete revisions: %w", err)
+	}
 
-	for attempt := 0; attempt <= bc.maxRetries; attempt++ {
-		resp, lastErr = http.DefaultClient.Do(req)
-		if lastErr == nil && resp.StatusCode == http.StatusOK {
-			break
+	var remaining int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM bookmarks WHERE owner_account = ? AND status_id = ?`, owner, statusID).Scan(&remaining); err != nil {
+		return 0, fmt.Errorf("failed to count remaining copies: %w", err)
+	}
+	if remaining == 0 {
+		for _, statement := range []string{
+			`DELETE FROM bookmark_annotations WHERE owner_account = ? AND status_id = ?`,
+			`DELETE FROM bookmark_tags WHERE owner_account = ? AND status_id = ?`,
+			`DELETE FROM collection_bookmarks WHERE owner_account = ? AND status_id = ?`,
+			`DELETE FROM status_context WHERE owner_account = ? AND status_id = ?`,
+		} {
+			if _, err := tx.Exec(statement, owner, statusID); err != nil {
+				return 0, fmt.Errorf("failed to delete annotations: %w", err)
+			}
 		}
+		if _, err := tx.Exec(`DELETE FROM collections WHERE owner_account = ?
+			AND name NOT IN (SELECT collection FROM collection_bookmarks WHERE owner_account = ?)`, owner, owner); err != nil {
+			return 0, fmt.Errorf("failed to remove empty collections: %w", err)
+		}
+	}
 
-		if resp != nil {
-			resp.Body.Close()
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit bookmark deletion: %w", err)
+	}
+	return deleted, nil
+}
+
+// setBookmarkTags replaces an account's tags of a status, which are expected
+// to be normalized with normalizeTags.
+func (d *Database) setBookmarkTags(owner, statusID string, tags []string) (*Annotations, error) {
+	return d.updateAnnotations(owner, statusID, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM bookmark_tags WHERE owner_account = ? AND status_id = ?`, owner, statusID); err != nil {
+			return fmt.Errorf("failed to clear tags: %w", err)
+		}
+		for _, tag := range tags {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO bookmark_tags (owner_account, status_id, tag) VALUES (?, ?, ?)`,
+				owner, statusID, tag); err != nil {
+				return fmt.Errorf("failed to add tag: %w", err)
+			}
 		}
+		return nil
+	})
+}
 
-		if resp != nil && resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != 429 {
-			break
+// setBookmarkNote replaces an account's note on a status; an empty note
+// removes it.
+func (d *Database) setBookmarkNote(owner, statusID, note string) (*Annotations, error) {
+	return d.updateAnnotations(owner, statusID, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`INSERT INTO bookmark_annotations (owner_account, status_id, note) VALUES (?, ?, ?)
+			ON CONFLICT (owner_account, status_id) DO UPDATE SET note = excluded.note`, owner, statusID, note); err != nil {
+			return fmt.Errorf("failed to store note: %w", err)
 		}
+		return nil
+	})
+}
 
-		if attempt < bc.maxRetries {
-			delay := time.Duration(attempt+1) * time.Second
-			time.Sleep(delay)
+// setBookmarkCollections replaces the collections of an account's archive
+// holding a status. Collections are created on first use and removed once
+// they hold nothing.
+func (d *Database) setBookmarkCollections(owner, statusID string, collections []string) (*Annotations, error) {
+	return d.updateAnnotations(owner, statusID, func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM collection_bookmarks WHERE owner_account = ? AND status_id = ?`, owner, statusID); err != nil {
+			return fmt.Errorf("failed to clear collections: %w", err)
+		}
+		for _, collection := range collections {
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO collections (owner_account, name) VALUES (?, ?)`, owner, collection); err != nil {
+				return fmt.Errorf("failed to create collection: %w", err)
+			}
+			if _, err := tx.Exec(`INSERT OR IGNORE INTO collection_bookmarks (owner_account, collection, status_id) VALUES (?, ?, ?)`,
+				owner, collection, statusID); err != nil {
+				return fmt.Errorf("failed to add to collection: %w", err)
+			}
+		}
+		if _, err := tx.Exec(`DELETE FROM collections WHERE owner_account = ?
+			AND name NOT IN (SELECT collection FROM collection_bookmarks WHERE owner_account = ?)`, owner, owner); err != nil {
+			return fmt.Errorf("failed to remove empty collections: %w", err)
 		}
+		return nil
+	})
+}
+
+// updateAnnotations applies a change to an account's annotations of a
+// status, then refreshes the text they add to the search index.
+func (d *Database) updateAnnotations(owner, statusID string, change func(tx *sql.Tx) error) (*Annotations, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
 
-	if lastErr != nil {
-		return nil, "", fmt.Errorf("HTTP request failed after %d retries: %w", bc.maxRetries, lastErr)
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback annotation transaction")
+		}
+	}()
 
-	defer resp.Body.Close()
+	if err := change(tx); err != nil {
+		return nil, err
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, "", fmt.Errorf("API request failed with status %d", resp.StatusCode)
+	var note string
+	err = tx.QueryRow(`SELECT note FROM bookmark_annotations WHERE owner_account = ? AND status_id = ?`, owner, statusID).Scan(&note)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get note: %w", err)
 	}
+	var tags sql.NullString
+	if err := tx.QueryRow(`SELECT group_concat(tag, ' ') FROM (SELECT tag FROM bookmark_tags
+		WHERE owner_account = ? AND status_id = ? ORDER BY tag)`, owner, statusID).Scan(&tags); err != nil {
+		return nil, fmt.Errorf("failed to get tags: %w", err)
+	}
+	searchText := strings.TrimSpace(tags.String + " " + note)
 
-	var nextURLFromHeader string
-	links := link.ParseResponse(resp)
-	for _, l := range links {
-		if l.Rel == "next" {
-			nextURLFromHeader = l.URI
-			break
-		}
+	if _, err := tx.Exec(`INSERT INTO bookmark_annotations (owner_account, status_id, note, search_text, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (owner_account, status_id) DO UPDATE SET search_text = excluded.search_text, updated_at = excluded.updated_at`,
+		owner, statusID, note, searchText, time.Now().UTC()); err != nil {
+		return nil, fmt.Errorf("failed to store annotations: %w", err)
+	}
+	// The FTS triggers re-index each copy of the status
+	if _, err := tx.Exec(`UPDATE bookmarks SET `+noteSearchColumn+` = ? WHERE owner_account = ? AND status_id = ?`,
+		searchText, owner, statusID); err != nil {
+		return nil, fmt.Errorf("failed to index annotations: %w", err)
 	}
 
-	var madonStatuses []madon.Status
-	if err := json.NewDecoder(resp.Body).Decode(&madonStatuses); err != nil {
-		return nil, "", fmt.Errorf("failed to decode JSON response: %w", err)
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit annotations: %w", err)
 	}
+	return d.getAnnotations(owner, statusID)
+}
 
-	bookmarks := make([]Bookmark, len(madonStatuses))
-	for i, status := range madonStatuses {
-		bookmarks[i] = convertMadonStatusToBookmark(status)
+// listCollections returns the collections of an account's archive with the
+// number of statuses in each, or those of every archive for an empty
+// account.
+func (d *Database) listCollections(owner string) ([]*Collection, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
 
-	return bookmarks, nextURLFromHeader, nil
-}
+	query := `SELECT c.owner_account, c.name, c.created_at, COUNT(cb.status_id) FROM collections c
+		LEFT JOIN collection_bookmarks cb ON cb.owner_account = c.owner_account AND cb.collection = c.name`
+	var args []interface{}
+	if owner != "" {
+		query += ` WHERE c.owner_account = ?`
+		args = append(args, owner)
+	}
+	query += ` GROUP BY c.owner_account, c.name ORDER BY c.name COLLATE NOCASE, c.owner_account`
 
-func convertMadonStatusToBookmark(status madon.Status) Bookmark {
-	account := Account{
-		ID:          string(status.Account.ID),
-		Username:    status.Account.Username,
-		DisplayName: status.Account.DisplayName,
-		Avatar:      status.Account.Avatar,
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collections: %w", err)
 	}
+	defer rows.Close()
 
-	var mediaAttachments []Media
-	for _, media := range status.MediaAttachments {
-		description := ""
-		if media.Description != nil {
-			description = *media.Description
+	collections := []*Collection{}
+	for rows.Next() {
+		var collection Collection
+		if err := rows.Scan(&collection.OwnerAccount, &collection.Name, &collection.CreatedAt, &collection.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan collection: %w", err)
 		}
-
-		mediaAttachments = append(mediaAttachments, Media{
-			ID:          string(media.ID),
-			Type:        media.Type,
-			URL:         media.URL,
-			Description: description,
-		})
+		collections = append(collections, &collection)
 	}
 
-	var tags []Tag
-	for _, tag := range status.Tags {
-		tags = append(tags, Tag{
-			Name: tag.Name,
-			URL:  tag.URL,
-		})
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over collections: %w", err)
 	}
+	return collections, nil
+}
 
-	serviceStatus := Status{
-		ID:               string(status.ID),
-		URI:              status.URI,
-		URL:              status.URL,
-		Content:          status.Content,
-		SpoilerText:      status.SpoilerText,
-		CreatedAt:        status.CreatedAt,
-		Account:          account,
-		MediaAttachments: mediaAttachments,
-		Tags:             tags,
+func (d *Database) insertLinkSnapshot(snapshot *LinkSnapshot) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
 	}
 
-	return Bookmark{
-		ID:        string(status.ID),
-		Status:    serviceStatus,
-		CreatedAt: status.CreatedAt,
+	query := `INSERT OR REPLACE INTO link_snapshots (url, title, text, fetched_at) VALUES (?, ?, ?, ?)`
+	if _, err := db.Exec(query, snapshot.URL, snapshot.Title, snapshot.Text, snapshot.FetchedAt.UTC()); err != nil {
+		return fmt.Errorf("failed to insert link snapshot: %w", err)
 	}
+	return nil
 }
 
-func convertBookmarkToDatabase(bookmark Bookmark, indexedFields []string) *DBBookmark {
-	rawJSON, err := json.Marshal(bookmark)
+func (d *Database) getLinkSnapshot(pageURL string) (*LinkSnapshot, error) {
+	db, err := d.getDB()
 	if err != nil {
-		rawJSON = []byte(fmt.Sprintf(`{"id":"%s","status_id":"%s","created_at":"%s"}`,
-			bookmark.ID,
-			bookmark.Status.ID,
-			bookmark.CreatedAt.Format(time.RFC3339)))
+		return nil, err
 	}
 
-	searchText := buildSearchText(bookmark, indexedFields)
+	var snapshot LinkSnapshot
+	err = db.QueryRow(`SELECT url, title, text, fetched_at FROM link_snapshots WHERE url = ?`, pageURL).
+		Scan(&snapshot.URL, &snapshot.Title, &snapshot.Text, &snapshot.FetchedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get link snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
 
-	// Extract account_id from the status account
-	accountID := ""
-	if bookmark.Status.Account.ID != "" {
-		accountID = bookmark.Status.Account.ID
+// replaceStatusContext stores the thread around an archived status, replacing
+// whatever was stored for it before.
+func (d *Database) replaceStatusContext(owner, statusID string, thread StatusContext, fetchedAt time.Time) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
 	}
 
-	return &DBBookmark{
-		StatusID:     bookmark.Status.ID,
-		CreatedAt:    bookmark.Status.CreatedAt,
-		BookmarkedAt: bookmark.CreatedAt,
-		SearchText:   searchText,
-		RawJSON:      string(rawJSON),
-		AccountID:    accountID,
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin status context transaction: %w", err)
 	}
-}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback status context transaction")
+		}
+	}()
 
-func buildSearchText(bookmark Bookmark, indexedFields []string) string {
-	var searchParts []string
+	if _, err := tx.Exec(`DELETE FROM status_context WHERE owner_account = ? AND status_id = ?`, owner, statusID); err != nil {
+		return fmt.Errorf("failed to clear status context: %w", err)
+	}
 
-	shouldIndex := func(field string) bool {
-		for _, f := range indexedFields {
-			if f == field {
-				return true
+	relations := []struct {
+		name     string
+		statuses []Status
+	}{
+		{"ancestor", thread.Ancestors},
+		{"descendant", thread.Descendants},
+	}
+	for _, relation := range relations {
+		for i, status := range relation.statuses {
+			rawJSON, err := json.Marshal(status)
+			if err != nil {
+				return fmt.Errorf("failed to encode context status: %w", err)
+			}
+			if _, err := tx.Exec(`INSERT OR REPLACE INTO status_context
+				(owner_account, status_id, context_status_id, relation, position, raw_json, fetched_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				owner, statusID, status.ID, relation.name, i, string(rawJSON), fetchedAt.UTC()); err != nil {
+				return fmt.Errorf("failed to insert context status: %w", err)
 			}
 		}
-		return false
 	}
 
-	if shouldIndex("content") && bookmark.Status.Content != "" {
-		searchParts = append(searchParts, stripHTML(bookmark.Status.Content))
+	return tx.Commit()
+}
+
+// getStatusContext returns the stored thread around a status, with
+// ancestors oldest first and descendants in the order the server gave.
+func (d *Database) getStatusContext(owner, statusID string) (*StatusContext, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
 	}
 
-	if shouldIndex("spoiler_text") && bookmark.Status.SpoilerText != "" {
-		searchParts = append(searchParts, bookmark.Status.SpoilerText)
+	rows, err := db.Query(`SELECT relation, raw_json FROM status_context
+		WHERE owner_account = ? AND status_id = ? ORDER BY relation, position`, owner, statusID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query status context: %w", err)
 	}
+	defer rows.Close()
 
-	if shouldIndex("username") && bookmark.Status.Account.Username != "" {
-		searchParts = append(searchParts, bookmark.Status.Account.Username)
+	thread := &StatusContext{Ancestors: []Status{}, Descendants: []Status{}}
+	for rows.Next() {
+		var relation, rawJSON string
+		if err := rows.Scan(&relation, &rawJSON); err != nil {
+			return nil, fmt.Errorf("failed to scan context status: %w", err)
+		}
+
+		var status Status
+		if err := json.Unmarshal([]byte(rawJSON), &status); err != nil {
+			return nil, fmt.Errorf("failed to decode context status: %w", err)
+		}
+		if relation == "ancestor" {
+			thread.Ancestors = append(thread.Ancestors, status)
+		} else {
+			thread.Descendants = append(thread.Descendants, status)
+		}
 	}
 
-	if shouldIndex("display_name") && bookmark.Status.Account.DisplayName != "" {
-		searchParts = append(searchParts, bookmark.Status.Account.DisplayName)
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over status context: %w", err)
 	}
+	return thread, nil
+}
 
-	if shouldIndex("media_descriptions") {
-		for _, media := range bookmark.Status.MediaAttachments {
-			if media.Description != "" {
-				searchParts = append(searchParts, media.Description)
-			}
-		}
+// getMetadata returns a value stored about the archive as a whole, with ok
+// false when it was never set.
+func (d *Database) getMetadata(key string) (value string, ok bool, err error) {
+	db, err := d.getDB()
+	if err != nil {
+		return "", false, err
 	}
 
-	if shouldIndex("hashtags") {
-		for _, tag := range bookmark.Status.Tags {
-			if tag.Name != "" {
-				searchParts = append(searchParts, tag.Name)
-			}
+	err = db.QueryRow(`SELECT value FROM archive_metadata WHERE key = ?`, key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
 		}
+		return "", false, fmt.Errorf("failed to get %s: %w", key, err)
 	}
-
-	return strings.Join(searchParts, " ")
+	return value, true, nil
 }
 
-func stripHTML(html string) string {
-	// Use BlueMondaty's StrictPolicy to strip all HTML tags securely
-	policy := bluemonday.StrictPolicy()
-	// Add space when stripping tags to prevent words from being merged
-	policy = policy.AddSpaceWhenStrippingTag(true)
+func (d *Database) setMetadata(key, value string) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
 
-	// Strip all HTML tags and return clean text
-	cleaned := policy.Sanitize(html)
+	_, err = db.Exec(`INSERT INTO archive_metadata (key, value, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value, updated_at = excluded.updated_at`,
+		key, value, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+	return nil
+}
 
-	// Clean up extra whitespace
-	cleaned = strings.ReplaceAll(cleaned, "  ", " ")
-	cleaned = strings.TrimSpace(cleaned)
+// WebSession is a browser login to the web interface. Only a hash of its
+// token is stored, and requests that change anything must also send its
+// CSRF token.
+type WebSession struct {
+	CSRFToken string
+	ExpiresAt time.Time
+}
 
-	return cleaned
+// sessionTokenHash is how a session token is stored, so the database alone
+// does not give away live sessions.
+func sessionTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-// =============================================================================
-// BOOKMARK SERVICE
-// =============================================================================
+// createWebSession starts a session lasting lifetime and returns its token,
+// dropping sessions that have expired.
+func (d *Database) createWebSession(lifetime time.Duration) (string, *WebSession, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return "", nil, err
+	}
 
-type BookmarkService struct {
-	config    *Config
+	token, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	csrfToken, err := randomToken()
+	if err != nil {
+		return "", nil, err
+	}
+	now := time.Now().UTC()
+	session := &WebSession{CSRFToken: csrfToken, ExpiresAt: now.Add(lifetime)}
+
+	if _, err := db.Exec(`DELETE FROM web_sessions WHERE expires_at <= ?`, now); err != nil {
+		return "", nil, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+	_, err = db.Exec(`INSERT INTO web_sessions (token_hash, csrf_token, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+		sessionTokenHash(token), session.CSRFToken, now, session.ExpiresAt)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	return token, session, nil
+}
+
+// getWebSession returns the unexpired session with the given token, or nil.
+func (d *Database) getWebSession(token string) (*WebSession, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	var session WebSession
+	err = db.QueryRow(`SELECT csrf_token, expires_at FROM web_sessions WHERE token_hash = ? AND expires_at > ?`,
+		sessionTokenHash(token), time.Now().UTC()).Scan(&session.CSRFToken, &session.ExpiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	return &session, nil
+}
+
+func (d *Database) deleteWebSession(token string) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(`DELETE FROM web_sessions WHERE token_hash = ?`, sessionTokenHash(token)); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+// getBookmarksAfterRow returns up to limit bookmarks in rowid order, starting
+// after the given rowid, along with the rowid of the last one, so a caller
+// can walk every row in batches while rows are added.
+func (d *Database) getBookmarksAfterRow(afterRowID int64, limit int) ([]*DBBookmark, int64, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`SELECT `+bookmarkColumns("")+`, rowid FROM bookmarks
+		WHERE rowid > ? ORDER BY rowid LIMIT ?`, afterRowID, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	var bookmarks []*DBBookmark
+	lastRowID := afterRowID
+	for rows.Next() {
+		var rowID int64
+		bookmark, err := scanBookmark(rows, &rowID)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan bookmark: %w", err)
+		}
+		bookmarks = append(bookmarks, bookmark)
+		lastRowID = rowID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating over bookmarks: %w", err)
+	}
+	return bookmarks, lastRowID, nil
+}
+
+// updateSearchText replaces the indexed text of bookmarks in one
+// transaction, leaving their content and refresh schedule alone. The FTS
+// triggers re-index each row.
+func (d *Database) updateSearchText(bookmarks []*DBBookmark) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			zlog.Warn().Err(err).Msg("failed to rollback search text update transaction")
+		}
+	}()
+
+	set := "search_text = ?"
+	for _, column := range statusSearchColumns() {
+		set += ", " + column.column + " = ?"
+	}
+	stmt, err := tx.Prepare(`UPDATE bookmarks SET ` + set + ` WHERE owner_account = ? AND source = ? AND status_id = ?`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare search text update: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, bookmark := range bookmarks {
+		args := append([]interface{}{bookmark.SearchText}, bookmark.searchColumnValues()...)
+		args = append(args, bookmark.OwnerAccount, bookmark.Source, bookmark.StatusID)
+		if _, err := stmt.Exec(args...); err != nil {
+			return fmt.Errorf("failed to update search text of %s: %w", bookmark.StatusID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (d *Database) searchBookmarksWithFTS5(request *SearchRequest) ([]*SearchResult, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Query == "" {
+		return []*SearchResult{}, nil
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	offset := request.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	snippetLength := request.SnippetLength
+	if snippetLength <= 0 {
+		snippetLength = 200
+	}
+
+	parsed, err := parseSearchQuery(request.Query)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Match == "" {
+		// Filters and excluded terms alone list the newest matches
+		return d.listRecentBookmarks(request)
+	}
+	searchQuery := parsed.Match
+
+	filters, filterArgs := buildSearchFilters(request, "b")
+	queryFilters, queryArgs := parsed.predicates("b")
+	filters = append(filters, queryFilters...)
+	filterArgs = append(filterArgs, queryArgs...)
+
+	filterClause := ""
+	if len(filters) > 0 {
+		filterClause = " AND " + strings.Join(filters, " AND ")
+	}
+
+	var query string
+	var args []interface{}
+
+	// A negative column lets snippet() take it from whichever column matched
+	if request.EnableHighlighting {
+		query = `
+			SELECT 
+				` + bookmarkColumns("b") + `,
+				` + d.rank + ` as rank,
+				snippet(bookmarks_fts, -1, '<mark>', '</mark>', '...', ?) as snippet
+			FROM bookmarks_fts
+			JOIN bookmarks b ON b.rowid = bookmarks_fts.rowid
+			WHERE bookmarks_fts MATCH ?` + filterClause + `
+			ORDER BY ` + request.matchOrder("rank") + `
+			LIMIT ? OFFSET ?`
+		args = []interface{}{snippetLength, searchQuery}
+	} else {
+		query = `
+			SELECT 
+				` + bookmarkColumns("b") + `,
+				` + d.rank + ` as rank
+			FROM bookmarks_fts
+			JOIN bookmarks b ON b.rowid = bookmarks_fts.rowid
+			WHERE bookmarks_fts MATCH ?` + filterClause + `
+			ORDER BY ` + request.matchOrder("rank") + `
+			LIMIT ? OFFSET ?`
+		args = []interface{}{searchQuery}
+	}
+	args = append(args, filterArgs...)
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute FTS5 search: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		var rank float64
+		var snippet sql.NullString
+
+		var bookmark *DBBookmark
+		if request.EnableHighlighting {
+			bookmark, err = scanBookmark(rows, &rank, &snippet)
+		} else {
+			bookmark, err = scanBookmark(rows, &rank)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan search result: %w", err)
+		}
+
+		result := &SearchResult{
+			Bookmark: bookmark,
+			Rank:     rank,
+		}
+
+		if snippet.Valid {
+			result.Snippet = snippet.String
+		}
+
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over search results: %w", err)
+	}
+
+	return results, nil
+}
+
+func (d *Database) getRecentBookmarks(limit, offset int, filterByAccount string) ([]*SearchResult, error) {
+	return d.listRecentBookmarks(&SearchRequest{
+		Limit:           limit,
+		Offset:          offset,
+		FilterByAccount: filterByAccount,
+	})
+}
+
+func (d *Database) listRecentBookmarks(request *SearchRequest) ([]*SearchResult, error) {
+	db, err := d.getDB()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	offset := request.Offset
+	if offset < 0 {
+		offset = 0
+	}
+
+	// Only the query's filters apply; its search terms need the FTS index
+	parsed, err := parseSearchQuery(request.Query)
+	if err != nil {
+		return nil, err
+	}
+	filters, args := buildSearchFilters(request, "")
+	queryFilters, queryArgs := parsed.predicates("")
+	filters = append(filters, queryFilters...)
+	args = append(args, queryArgs...)
+
+	whereClause := ""
+	if len(filters) > 0 {
+		whereClause = " WHERE " + strings.Join(filters, " AND ")
+	}
+
+	query := `SELECT ` + bookmarkColumns("") + `
+		FROM bookmarks` + whereClause + ` ORDER BY bookmarked_at DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute recent bookmarks query: %w", err)
+	}
+	defer rows.Close()
+
+	results := []*SearchResult{}
+	for rows.Next() {
+		bookmark, err := scanBookmark(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan recent bookmark result: %w", err)
+		}
+
+		result := &SearchResult{
+			Bookmark: bookmark,
+			Rank:     0.0,
+		}
+		results = append(results, result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over recent bookmark results: %w", err)
+	}
+
+	return results, nil
+}
+
+// eachBookmark calls fn with every bookmark matching a search request and
+// its annotations, reading rows as fn goes rather than loading them all.
+// Matches for search terms come by rank and others newest first; a request
+// without a limit has none.
+func (d *Database) eachBookmark(ctx context.Context, request *SearchRequest, fn func(*DBBookmark, *Annotations) error) error {
+	db, err := d.getDB()
+	if err != nil {
+		return err
+	}
+
+	parsed, err := parseSearchQuery(request.Query)
+	if err != nil {
+		return err
+	}
+	filters, filterArgs := buildSearchFilters(request, "b")
+	queryFilters, queryArgs := parsed.predicates("b")
+	filters = append(filters, queryFilters...)
+	filterArgs = append(filterArgs, queryArgs...)
+
+	annotationColumns := `
+		COALESCE((SELECT note FROM bookmark_annotations a WHERE ` + annotationJoin("a", "b.") + `), ''),
+		(SELECT json_group_array(tag) FROM bookmark_tags t WHERE ` + annotationJoin("t", "b.") + `),
+		(SELECT json_group_array(collection) FROM collection_bookmarks c WHERE ` + annotationJoin("c", "b.") + `)`
+
+	var query string
+	var args []interface{}
+	if parsed.Match != "" {
+		query = `SELECT ` + bookmarkColumns("b") + `,` + annotationColumns + `
+			FROM bookmarks_fts
+			JOIN bookmarks b ON b.rowid = bookmarks_fts.rowid
+			WHERE bookmarks_fts MATCH ?`
+		args = append(args, parsed.Match)
+		for _, filter := range filters {
+			query += " AND " + filter
+		}
+		query += ` ORDER BY ` + request.matchOrder(d.rank)
+	} else {
+		query = `SELECT ` + bookmarkColumns("b") + `,` + annotationColumns + `
+			FROM bookmarks b`
+		if len(filters) > 0 {
+			query += " WHERE " + strings.Join(filters, " AND ")
+		}
+		query += ` ORDER BY ` + newestFirst
+	}
+	args = append(args, filterArgs...)
+
+	limit := request.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	query += ` LIMIT ? OFFSET ?`
+	args = append(args, limit, max(request.Offset, 0))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query bookmarks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		annotations := &Annotations{}
+		var tags, collections string
+		bookmark, err := scanBookmark(rows, &annotations.Note, &tags, &collections)
+		if err != nil {
+			return fmt.Errorf("failed to scan bookmark: %w", err)
+		}
+		if err := json.Unmarshal([]byte(tags), &annotations.Tags); err != nil {
+			return fmt.Errorf("failed to read tags of %s: %w", bookmark.StatusID, err)
+		}
+		if err := json.Unmarshal([]byte(collections), &annotations.Collections); err != nil {
+			return fmt.Errorf("failed to read collections of %s: %w", bookmark.StatusID, err)
+		}
+		slices.Sort(annotations.Tags)
+		slices.Sort(annotations.Collections)
+
+		if err := fn(bookmark, annotations); err != nil {
+			return err
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating over bookmarks: %w", err)
+	}
+	return nil
+}
+
+// buildSearchFilters translates the filter options of a search request into
+// SQL predicates on the bookmarks table, qualified with alias when given.
+func buildSearchFilters(request *SearchRequest, alias string) (clauses []string, args []interface{}) {
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+
+	if request.Account != "" {
+		clauses = append(clauses, prefix+"owner_account = ?")
+		args = append(args, request.Account)
+	}
+
+	if request.FilterByAccount == "my_posts" {
+		// Match posts by the archive owner's own account; nothing matches
+		// before the user account is known.
+		if request.Account != "" {
+			clauses = append(clauses, prefix+"account_id IN (SELECT account_id FROM user_account WHERE owner_account = ?)")
+			args = append(args, request.Account)
+		} else {
+			clauses = append(clauses, prefix+"account_id IN (SELECT account_id FROM user_account)")
+		}
+	}
+
+	if request.FilterBySource != "" && request.FilterBySource != "all" {
+		clauses = append(clauses, prefix+"source = ?")
+		args = append(args, request.FilterBySource)
+	}
+
+	switch request.FilterByState {
+	case "active":
+		clauses = append(clauses, prefix+"unbookmarked_at IS NULL")
+	case "removed":
+		clauses = append(clauses, prefix+"unbookmarked_at IS NOT NULL")
+	}
+
+	if after := request.after; after != nil {
+		clauses = append(clauses, "("+prefix+"bookmarked_at, "+prefix+"owner_account, "+prefix+"source, "+prefix+"status_id) < (?, ?, ?, ?)")
+		args = append(args, after.BookmarkedAt.UTC(), after.Account, after.Source, after.StatusID)
+	}
+
+	return clauses, args
+}
+
+// bookmarkColumns returns the select list shared by bookmark queries so that
+// rows can be read back with scanBookmark.
+func bookmarkColumns(alias string) string {
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+	return fmt.Sprintf("%[1]sstatus_id, %[1]screated_at, %[1]sbookmarked_at, %[1]ssearch_text, %[1]sraw_json, "+
+		"COALESCE(%[1]saccount_id, '') as account_id, %[1]sowner_account, %[1]ssource, %[1]sunbookmarked_at, %[1]sdeleted_at", prefix)
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanBookmark reads a row selected with bookmarkColumns, followed by any
+// extra columns the caller asked for.
+func scanBookmark(row rowScanner, extra ...interface{}) (*DBBookmark, error) {
+	var bookmark DBBookmark
+	var unbookmarkedAt sql.NullTime
+	var deletedAt sql.NullTime
+
+	dest := []interface{}{
+		&bookmark.StatusID,
+		&bookmark.CreatedAt,
+		&bookmark.BookmarkedAt,
+		&bookmark.SearchText,
+		&bookmark.RawJSON,
+		&bookmark.AccountID,
+		&bookmark.OwnerAccount,
+		&bookmark.Source,
+		&unbookmarkedAt,
+		&deletedAt,
+	}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+
+	if unbookmarkedAt.Valid {
+		bookmark.UnbookmarkedAt = &unbookmarkedAt.Time
+	}
+	if deletedAt.Valid {
+		bookmark.DeletedAt = &deletedAt.Time
+	}
+	return &bookmark, nil
+}
+
+// newestFirst orders bookmarks aliased b newest first, breaking ties by
+// their key so that cursors into the order are stable.
+const newestFirst = "b.bookmarked_at DESC, b.owner_account DESC, b.source DESC, b.status_id DESC"
+
+// matchOrder returns the ORDER BY expression for full-text matches, given
+// the one that ranks them.
+func (r *SearchRequest) matchOrder(rank string) string {
+	if r.SortBy == sortByBookmarked {
+		return newestFirst
+	}
+	return rank
+}
+
+func (d *Database) searchOrRecentBookmarks(request *SearchRequest) ([]*SearchResult, error) {
+	if strings.TrimSpace(request.Query) == "" {
+		return d.listRecentBookmarks(request)
+	}
+	return d.searchBookmarksWithFTS5(request)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// =============================================================================
+// SEARCH QUERIES
+// =============================================================================
+
+// QueryError reports a search query that cannot be parsed. The web server
+// answers it with 400 Bad Request and its message.
+type QueryError struct {
+	Message string
+}
+
+func (e *QueryError) Error() string {
+	return "invalid search query: " + e.Message
+}
+
+func queryErrorf(format string, args ...interface{}) error {
+	return &QueryError{Message: fmt.Sprintf(format, args...)}
+}
+
+// SearchQuery is a parsed search query. Match is the FTS5 expression for its
+// search terms, empty when it has none; filters are its field filters and
+// negated terms, turned into SQL by predicates.
+//
+// Words match as prefixes and "quoted phrases" exactly. Terms are ANDed,
+// with OR binding tighter than AND, so "go rust OR zig" finds go posts
+// mentioning rust or zig. A leading - or NOT excludes a term or filter.
+// Filters are from:user[@server], tag:name for hashtags and local tags,
+// in:collection, has:media|image|video|audio|link|cw, lang:code, before:
+// and after: on the post date, and
+// bookmarked-before: and bookmarked-after: on the archive date. Dates are
+// YYYY, YYYY-MM or YYYY-MM-DD in UTC, and after: starts once the whole
+// period is over. A word or phrase prefixed with the name of an index
+// column, such as author:alice or media:"bar chart", only searches that
+// column.
+type SearchQuery struct {
+	Match   string
+	filters []queryFilter
+}
+
+// queryFilter builds one SQL predicate on the bookmarks table, given the
+// column prefix of the table alias.
+type queryFilter struct {
+	negated bool
+	build   func(prefix string) (string, []interface{})
+}
+
+type queryToken struct {
+	text  string
+	field string
+	// column is the index column a column-qualified term searches
+	column string
+	phrase bool
+	// negated is set for terms written with a leading -
+	negated bool
+}
+
+// parseSearchQuery parses a query typed into the search box.
+func parseSearchQuery(query string) (*SearchQuery, error) {
+	tokens, err := tokenizeSearchQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := &SearchQuery{}
+	var groups [][]string
+	pendingOr, pendingNot, afterTerm := false, false, false
+	for i, token := range tokens {
+		if token.field == "" && token.column == "" && !token.phrase && !token.negated {
+			switch token.text {
+			case "AND":
+				if !afterTerm || i == len(tokens)-1 {
+					return nil, queryErrorf("AND must be between two search terms")
+				}
+				continue
+			case "OR":
+				if !afterTerm || pendingOr {
+					return nil, queryErrorf("OR must be between two search terms")
+				}
+				pendingOr = true
+				continue
+			case "NOT":
+				if pendingNot || pendingOr {
+					return nil, queryErrorf("NOT must be followed by a search term or filter")
+				}
+				pendingNot = true
+				continue
+			}
+		}
+
+		negated := token.negated != pendingNot
+		pendingNot = false
+		if pendingOr && (negated || token.field != "") {
+			return nil, queryErrorf("OR can only join search terms, not filters or excluded terms")
+		}
+
+		if token.field != "" {
+			filter, err := newQueryFilter(token.field, token.text)
+			if err != nil {
+				return nil, err
+			}
+			filter.negated = negated
+			parsed.filters = append(parsed.filters, filter)
+			afterTerm = false
+			continue
+		}
+
+		term := ftsTerm(token.text, token.phrase)
+		if term == "" {
+			// Punctuation alone has nothing to match
+			continue
+		}
+		if token.column != "" {
+			term = token.column + " : " + term
+		}
+		if negated {
+			parsed.filters = append(parsed.filters, queryFilter{
+				negated: true,
+				build: func(prefix string) (string, []interface{}) {
+					return prefix + "rowid IN (SELECT rowid FROM bookmarks_fts WHERE bookmarks_fts MATCH ?)", []interface{}{term}
+				},
+			})
+			afterTerm = false
+			continue
+		}
+
+		if pendingOr {
+			groups[len(groups)-1] = append(groups[len(groups)-1], term)
+			pendingOr = false
+		} else {
+			groups = append(groups, []string{term})
+		}
+		afterTerm = true
+	}
+	if pendingOr {
+		return nil, queryErrorf("OR must be between two search terms")
+	}
+	if pendingNot {
+		return nil, queryErrorf("NOT must be followed by a search term or filter")
+	}
+
+	parts := make([]string, len(groups))
+	for i, group := range groups {
+		parts[i] = strings.Join(group, " OR ")
+		if len(group) > 1 && len(groups) > 1 {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	parsed.Match = strings.Join(parts, " ")
+	return parsed, nil
+}
+
+// tokenizeSearchQuery splits a query into words, quoted phrases and
+// field:value filters, whose value may be quoted too.
+func tokenizeSearchQuery(query string) ([]queryToken, error) {
+	var tokens []queryToken
+	runes := []rune(query)
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var token queryToken
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			token.negated = true
+			i++
+		}
+
+		if runes[i] == '"' {
+			end := indexRune(runes, i+1, '"')
+			if end < 0 {
+				return nil, queryErrorf("missing closing quote")
+			}
+			token.text = string(runes[i+1 : end])
+			token.phrase = true
+			tokens = append(tokens, token)
+			i = end + 1
+			continue
+		}
+
+		start := i
+		for i < len(runes) && !unicode.IsSpace(runes[i]) {
+			i++
+		}
+		word := string(runes[start:i])
+
+		name, value, ok := strings.Cut(word, ":")
+		name = strings.ToLower(name)
+		if column := searchColumnNamed(name); ok && (searchFilterNames[name] || column != "") {
+			if searchFilterNames[name] {
+				token.field = name
+			} else {
+				token.column = column
+			}
+			if strings.HasPrefix(value, `"`) {
+				// Quoted value, which may hold spaces
+				valueStart := start + len([]rune(name)) + 2
+				end := indexRune(runes, valueStart, '"')
+				if end < 0 {
+					return nil, queryErrorf("missing closing quote")
+				}
+				value = string(runes[valueStart:end])
+				token.phrase = token.column != ""
+				i = end + 1
+			}
+			if strings.TrimSpace(value) == "" {
+				return nil, queryErrorf("%s: needs a value", name)
+			}
+			token.text = value
+		} else {
+			token.text = word
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// searchFilterNames are the field names recognized before a colon, along
+// with the names of index columns; other words with colons, such as URLs,
+// are searched as text.
+var searchFilterNames = map[string]bool{
+	"from":              true,
+	"tag":               true,
+	"in":                true,
+	"has":               true,
+	"lang":              true,
+	"before":            true,
+	"after":             true,
+	"bookmarked-before": true,
+	"bookmarked-after":  true,
+}
+
+// ftsTerm turns a word into an FTS5 prefix term and a phrase into an exact
+// one, quoting anything FTS5 would read as syntax. It returns "" for text
+// with no letters or digits, which matches nothing.
+func ftsTerm(text string, phrase bool) string {
+	core := text
+	if !phrase {
+		core = strings.TrimRight(text, "*")
+	}
+	if strings.IndexFunc(core, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) < 0 {
+		return ""
+	}
+	if phrase {
+		return `"` + strings.ReplaceAll(core, `"`, `""`) + `"`
+	}
+
+	bare := core != "NEAR" && strings.IndexFunc(core, func(r rune) bool {
+		return r < 0x80 && !(r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r))
+	}) < 0
+	if bare {
+		return core + "*"
+	}
+	return `"` + strings.ReplaceAll(core, `"`, `""`) + `"*`
+}
+
+// newQueryFilter builds the filter for a field:value token.
+func newQueryFilter(field, value string) (queryFilter, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	column := func(prefix, path string) string {
+		return "json_extract(" + prefix + "raw_json, '" + path + "')"
+	}
+
+	switch field {
+	case "from":
+		account := strings.TrimPrefix(value, "@")
+		username, server, remote := strings.Cut(account, "@")
+		if username == "" {
+			return queryFilter{}, queryErrorf("from: needs an account name")
+		}
+		if !remote {
+			return queryFilter{build: func(prefix string) (string, []interface{}) {
+				return "(lower(" + column(prefix, "$.status.account.username") + ") = ? OR lower(" + column(prefix, "$.status.account.acct") + ") = ?)",
+					[]interface{}{username, username}
+			}}, nil
+		}
+		// Statuses archived before the account's server was recorded are
+		// matched on the username and the server of their URL
+		serverPattern := "https://" + escapeLike(server) + "/%"
+		return queryFilter{build: func(prefix string) (string, []interface{}) {
+			return "(lower(" + column(prefix, "$.status.account.acct") + ") = ? OR (lower(" + column(prefix, "$.status.account.username") + ") = ? AND " +
+					"(lower(" + column(prefix, "$.status.uri") + ") LIKE ? ESCAPE '\\' OR lower(" + column(prefix, "$.status.url") + ") LIKE ? ESCAPE '\\')))",
+				[]interface{}{account, username, serverPattern, serverPattern}
+		}}, nil
+	case "tag":
+		tag := strings.TrimPrefix(value, "#")
+		if tag == "" {
+			return queryFilter{}, queryErrorf("tag: needs a hashtag")
+		}
+		// The status's hashtags or the archive owner's own tags
+		return queryFilter{build: func(prefix string) (string, []interface{}) {
+			return "(EXISTS (SELECT 1 FROM json_each(" + prefix + "raw_json, '$.status.tags') WHERE lower(json_extract(value, '$.name')) = ?) OR " +
+					"EXISTS (SELECT 1 FROM bookmark_tags t WHERE " + annotationJoin("t", prefix) + " AND t.tag = ?))",
+				[]interface{}{tag, tag}
+		}}, nil
+	case "in":
+		return queryFilter{build: func(prefix string) (string, []interface{}) {
+			return "EXISTS (SELECT 1 FROM collection_bookmarks c WHERE " + annotationJoin("c", prefix) + " AND lower(c.collection) = ?)",
+				[]interface{}{value}
+		}}, nil
+	case "has":
+		switch value {
+		case "media":
+			return queryFilter{build: func(prefix string) (string, []interface{}) {
+				return "COALESCE(json_array_length(" + prefix + "raw_json, '$.status.media_attachments'), 0) > 0", nil
+			}}, nil
+		case "image", "video", "audio":
+			types := []interface{}{value}
+			if value == "video" {
+				types = append(types, "gifv")
+			}
+			return queryFilter{build: func(prefix string) (string, []interface{}) {
+				return "EXISTS (SELECT 1 FROM json_each(" + prefix + "raw_json, '$.status.media_attachments') WHERE json_extract(value, '$.type') IN (?" +
+					strings.Repeat(", ?", len(types)-1) + "))", types
+			}}, nil
+		case "cw":
+			return queryFilter{build: func(prefix string) (string, []interface{}) {
+				return "COALESCE(" + column(prefix, "$.status.spoiler_text") + ", '') != ''", nil
+			}}, nil
+		case "link":
+			return queryFilter{build: func(prefix string) (string, []interface{}) {
+				return prefix + "has_link = 1", nil
+			}}, nil
+		}
+		return queryFilter{}, queryErrorf("unknown has:%s (use media, image, video, audio, link or cw)", value)
+	case "lang":
+		return queryFilter{build: func(prefix string) (string, []interface{}) {
+			return "lower(COALESCE(" + column(prefix, "$.status.language") + ", '')) = ?", []interface{}{value}
+		}}, nil
+	case "before", "after", "bookmarked-before", "bookmarked-after":
+		start, end, err := parseQueryDate(value)
+		if err != nil {
+			return queryFilter{}, queryErrorf("invalid date %q for %s: (use YYYY, YYYY-MM or YYYY-MM-DD)", value, field)
+		}
+		dateColumn := "created_at"
+		if strings.HasPrefix(field, "bookmarked-") {
+			dateColumn = "bookmarked_at"
+		}
+		if strings.HasSuffix(field, "before") {
+			return queryFilter{build: func(prefix string) (string, []interface{}) {
+				return prefix + dateColumn + " < ?", []interface{}{start}
+			}}, nil
+		}
+		return queryFilter{build: func(prefix string) (string, []interface{}) {
+			return prefix + dateColumn + " >= ?", []interface{}{end}
+		}}, nil
+	}
+	return queryFilter{}, queryErrorf("unknown filter %s:", field)
+}
+
+// annotationJoin matches the annotation rows aliased alias with the bookmark
+// whose columns have the given prefix, naming the bookmarks table when the
+// prefix is empty so the columns are not taken for the annotation's own.
+func annotationJoin(alias, prefix string) string {
+	if prefix == "" {
+		prefix = "bookmarks."
+	}
+	return alias + ".owner_account = " + prefix + "owner_account AND " + alias + ".status_id = " + prefix + "status_id"
+}
+
+// parseQueryDate returns the start and end of the year, month or day a
+// query date names, or the instant of a full RFC 3339 timestamp.
+func parseQueryDate(value string) (start, end time.Time, err error) {
+	if t, err := time.Parse(time.RFC3339, strings.ToUpper(value)); err == nil {
+		return t.UTC(), t.UTC(), nil
+	}
+	for _, layout := range []struct {
+		format string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006-01-02", 0, 0, 1},
+		{"2006-01", 0, 1, 0},
+		{"2006", 1, 0, 0},
+	} {
+		if t, err := time.Parse(layout.format, value); err == nil {
+			return t, t.AddDate(layout.years, layout.months, layout.days), nil
+		}
+	}
+	return time.Time{}, time.Time{}, fmt.Errorf("unrecognized date %q", value)
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern with backslashes.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// predicates returns the query's filters as SQL predicates on the bookmarks
+// table, qualified with alias when given.
+func (q *SearchQuery) predicates(alias string) (clauses []string, args []interface{}) {
+	prefix := ""
+	if alias != "" {
+		prefix = alias + "."
+	}
+	for _, filter := range q.filters {
+		clause, filterArgs := filter.build(prefix)
+		if filter.negated {
+			// A filter on a missing field is NULL, which NOT would keep NULL
+			clause = "NOT COALESCE((" + clause + "), 0)"
+		}
+		clauses = append(clauses, clause)
+		args = append(args, filterArgs...)
+	}
+	return clauses, args
+}
+
+// =============================================================================
+// MASTODON CLIENT
+// =============================================================================
+
+type MastodonClient struct {
+	server      string
+	accessToken string
+	// clientID and clientSecret identify the app registered by the login
+	// command; they are empty for hand-made access tokens.
+	clientID     string
+	clientSecret string
+	timeout      time.Duration
+	madonClient  *madon.Client
+}
+
+func newMastodonClient(cfg *Config) (*MastodonClient, error) {
+	return newAccountMastodonClient(AccountConfig{
+		Server:        cfg.Mastodon.Server,
+		AccessToken:   cfg.Mastodon.AccessToken,
+		ClientTimeout: cfg.Mastodon.ClientTimeout,
+		ClientID:      cfg.Mastodon.ClientID,
+		ClientSecret:  cfg.Mastodon.ClientSecret,
+	})
+}
+
+func newAccountMastodonClient(account AccountConfig) (*MastodonClient, error) {
+	if account.Server == "" {
+		return nil, fmt.Errorf("mastodon server URL is required")
+	}
+	if account.AccessToken == "" {
+		return nil, fmt.Errorf("mastodon access token is required")
+	}
+
+	timeout := 30 * time.Second
+	if account.ClientTimeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(account.ClientTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client timeout: %w", err)
+		}
+	}
+
+	return &MastodonClient{
+		server:       account.Server,
+		accessToken:  account.AccessToken,
+		clientID:     account.ClientID,
+		clientSecret: account.ClientSecret,
+		timeout:      timeout,
+		madonClient:  nil,
+	}, nil
+}
+
+func (c *MastodonClient) initMadonClient() error {
+	if c.madonClient != nil {
+		return nil
+	}
+
+	userToken := &madon.UserToken{
+		AccessToken: c.accessToken,
+		TokenType:   "Bearer",
+	}
+
+	madonClient, err := madon.RestoreApp("bookmarchive", c.server, c.clientID, c.clientSecret, userToken)
+	if err != nil {
+		return fmt.Errorf("failed to create madon client: %w", err)
+	}
+
+	c.madonClient = madonClient
+	return nil
+}
+
+func (c *MastodonClient) verifyCredentials() error {
+	if err := c.initMadonClient(); err != nil {
+		return err
+	}
+
+	_, err := c.madonClient.GetCurrentAccount()
+	if err != nil {
+		return fmt.Errorf("failed to verify credentials: %w", err)
+	}
+	return nil
+}
+
+func (c *MastodonClient) getMadonClient() (*madon.Client, error) {
+	if err := c.initMadonClient(); err != nil {
+		return nil, err
+	}
+	return c.madonClient, nil
+}
+
+// =============================================================================
+// BOOKMARK SERVICE MODELS
+// =============================================================================
+
+// Status collections that can be archived for an account, as stored in the
+// source column.
+const (
+	SourceBookmark  = "bookmark"
+	SourceFavourite = "favourite"
+	SourceOwnStatus = "own_status"
+)
+
+type Bookmark struct {
+	ID        string    `json:"id"`
+	Status    Status    `json:"status"`
+	CreatedAt time.Time `json:"created_at"`
+	// LinkText is the readable text of the page the status's card links to,
+	// indexed as link_text. It lives in link_snapshots, not in raw_json.
+	LinkText string `json:"-"`
+	// ThreadText is the text of the statuses around this one in its thread,
+	// indexed as thread. It lives in status_context, not in raw_json.
+	ThreadText string `json:"-"`
+}
+
+type Status struct {
+	ID               string    `json:"id"`
+	URI              string    `json:"uri"`
+	URL              string    `json:"url"`
+	InReplyToID      string    `json:"in_reply_to_id,omitempty"`
+	Content          string    `json:"content"`
+	SpoilerText      string    `json:"spoiler_text"`
+	CreatedAt        time.Time `json:"created_at"`
+	Account          Account   `json:"account"`
+	MediaAttachments []Media   `json:"media_attachments"`
+	Tags             []Tag     `json:"tags"`
+	Card             *Card     `json:"card,omitempty"`
+	// Language is the ISO 639 code of the status's language, when known.
+	Language string `json:"language,omitempty"`
+}
+
+type Account struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	Avatar      string `json:"avatar"`
+	// Acct is the username, followed by @ and the server for remote accounts.
+	Acct string `json:"acct,omitempty"`
+}
+
+type Media struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	URL         string `json:"url"`
+	PreviewURL  string `json:"preview_url,omitempty"`
+	Description string `json:"description"`
+}
+
+type Tag struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// StatusContext is the thread around a status: the replies it answers and
+// the replies to it.
+type StatusContext struct {
+	Ancestors   []Status `json:"ancestors"`
+	Descendants []Status `json:"descendants"`
+}
+
+// text returns the plain text of the statuses in the thread for indexing.
+func (c *StatusContext) text() string {
+	var parts []string
+	for _, status := range append(c.Ancestors, c.Descendants...) {
+		if status.SpoilerText != "" {
+			parts = append(parts, status.SpoilerText)
+		}
+		if text := stripHTML(status.Content); text != "" {
+			parts = append(parts, text)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Card is the preview the server generates for the first link in a status.
+type Card struct {
+	URL          string `json:"url"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	Type         string `json:"type,omitempty"`
+	ProviderName string `json:"provider_name,omitempty"`
+	Image        string `json:"image,omitempty"`
+}
+
+type BookmarkClient interface {
+	GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error)
+}
+
+// CollectionClient is implemented by bookmark clients that can also page
+// through the user's favourites and own statuses.
+type CollectionClient interface {
+	GetCollection(ctx context.Context, source string, limit int, nextURL string) ([]Bookmark, string, error)
+}
+
+// StatusClient is implemented by bookmark clients that can fetch a single
+// status, which the refresher needs to pick up edits and deletions.
+type StatusClient interface {
+	GetStatus(ctx context.Context, statusID string) (Status, error)
+}
+
+// ContextClient is implemented by bookmark clients that can fetch the thread
+// around a status.
+type ContextClient interface {
+	GetContext(ctx context.Context, statusID string) (StatusContext, error)
+}
+
+// ResolverClient is implemented by bookmark clients that can look up a
+// status by its URI or URL, which importing from an account archive needs.
+type ResolverClient interface {
+	ResolveStatus(ctx context.Context, uri string) (Status, error)
+}
+
+// errStatusNotFound is returned by GetStatus when the status no longer exists.
+var errStatusNotFound = errors.New("status not found")
+
+// apiStatusError reports a non-200 response from the API.
+type apiStatusError struct {
+	StatusCode int
+	// Body is the start of the response body, which some servers use to
+	// say what went wrong.
+	Body []byte
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("API request failed with status %d", e.StatusCode)
+}
+
+// =============================================================================
+// RATE LIMITER
+// =============================================================================
+
+// RateLimiter paces requests to one account's server. It follows the
+// X-RateLimit-* headers of the server's responses while they are current,
+// falls back to a local sliding window when the server sends none, and holds
+// all requests back for as long as a Retry-After header asks. It is safe for
+// concurrent use, as the services archiving one account's collections share
+// it.
+type RateLimiter struct {
+	maxRequests int
+	timeWindow  time.Duration
+	requests    []time.Time
+	// limit, remaining and reset are the server's view of the current
+	// window, valid until reset.
+	limit     int
+	remaining int
+	reset     time.Time
+	// blockedUntil is when a Retry-After pause ends.
+	blockedUntil time.Time
+	mu           sync.Mutex
+}
+
+// RateLimiterState describes a limiter for /api/stats.
+type RateLimiterState struct {
+	Limit          int        `json:"limit,omitempty"`
+	Remaining      *int       `json:"remaining,omitempty"`
+	ResetAt        *time.Time `json:"reset_at,omitempty"`
+	BlockedUntil   *time.Time `json:"blocked_until,omitempty"`
+	RecentRequests int        `json:"recent_requests"`
+}
+
+func newRateLimiter(maxRequests int, timeWindow time.Duration) *RateLimiter {
+	return &RateLimiter{
+		maxRequests: maxRequests,
+		timeWindow:  timeWindow,
+		requests:    make([]time.Time, 0),
+	}
+}
+
+func (rl *RateLimiter) allow() bool {
+	return rl.reserve(time.Now()) == 0
+}
+
+// reserve takes a request slot and returns zero if one is free, or else how
+// long to wait before asking again.
+func (rl *RateLimiter) reserve(now time.Time) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Before(rl.blockedUntil) {
+		return rl.blockedUntil.Sub(now)
+	}
+
+	cutoff := now.Add(-rl.timeWindow)
+	newRequests := make([]time.Time, 0, len(rl.requests))
+	for _, req := range rl.requests {
+		if req.After(cutoff) {
+			newRequests = append(newRequests, req)
+		}
+	}
+	rl.requests = newRequests
+
+	if now.Before(rl.reset) {
+		// The server's count is authoritative; requests sent since its last
+		// response are taken off it here.
+		if rl.remaining <= 0 {
+			return rl.reset.Sub(now)
+		}
+		rl.remaining--
+	} else if len(rl.requests) >= rl.maxRequests {
+		return rl.requests[0].Add(rl.timeWindow).Sub(now)
+	}
+
+	rl.requests = append(rl.requests, now)
+	return 0
+}
+
+func (rl *RateLimiter) wait(ctx context.Context) error {
+	for {
+		delay := rl.reserve(time.Now())
+		if delay <= 0 {
+			return nil
+		}
+		if err := sleepContext(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// update records the rate limit the server reported in a response. Headers
+// without a usable remaining count and reset time are ignored.
+func (rl *RateLimiter) update(header http.Header, now time.Time) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+	reset, ok := parseRateLimitReset(header.Get("X-RateLimit-Reset"), now)
+	if !ok {
+		return
+	}
+	limit, _ := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit = limit
+	rl.remaining = remaining
+	rl.reset = reset
+}
+
+// block holds every request back until the given time.
+func (rl *RateLimiter) block(until time.Time) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if until.After(rl.blockedUntil) {
+		rl.blockedUntil = until
+	}
+}
+
+func (rl *RateLimiter) state() RateLimiterState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	state := RateLimiterState{RecentRequests: len(rl.requests)}
+	if now.Before(rl.reset) {
+		remaining := rl.remaining
+		reset := rl.reset
+		state.Limit = rl.limit
+		state.Remaining = &remaining
+		state.ResetAt = &reset
+	}
+	if now.Before(rl.blockedUntil) {
+		blockedUntil := rl.blockedUntil
+		state.BlockedUntil = &blockedUntil
+	}
+	return state
+}
+
+// parseRateLimitReset reads an X-RateLimit-Reset header, which Mastodon sends
+// as an ISO 8601 timestamp and other servers as a Unix time or a number of
+// seconds from now.
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	if reset, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return reset, true
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return time.Time{}, false
+	}
+	// Anything past a year of seconds is a Unix time rather than a delay
+	if seconds > 365*24*60*60 {
+		return time.Unix(seconds, 0), true
+	}
+	return now.Add(time.Duration(seconds) * time.Second), true
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if date.Before(now) {
+			return 0, true
+		}
+		return date.Sub(now), true
+	}
+	return 0, false
+}
+
+// retryDelay returns the jittered exponential backoff before retry number
+// attempt (starting at 1): a random delay between half and all of
+// base * 2^(attempt-1), capped at maxDelay.
+func retryDelay(attempt int, base, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempt < 31 && base<<(attempt-1) < maxDelay && base<<(attempt-1) > 0 {
+		delay = base << (attempt - 1)
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int64N(int64(delay-half)+1))
+}
+
+// maxRetryDelay caps the backoff between retries of one request.
+const maxRetryDelay = time.Minute
+
+// sleepContext waits for d, returning early with the context's error if it
+// is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// =============================================================================
+// MASTODON BOOKMARK CLIENT
+// =============================================================================
+
+type MastodonBookmarkClient struct {
+	client      *madon.Client
+	rateLimiter *RateLimiter
+	maxRetries  int
+	// retryBaseDelay is the backoff before the first retry; it doubles for
+	// each further one.
+	retryBaseDelay time.Duration
+	// accountID is the authenticated user's account, needed to page
+	// through their own statuses.
+	accountID string
+}
+
+func newMastodonBookmarkClient(client *madon.Client, maxRetries int) *MastodonBookmarkClient {
+	rateLimiter := newRateLimiter(150, 5*time.Minute)
+
+	return &MastodonBookmarkClient{
+		client:         client,
+		rateLimiter:    rateLimiter,
+		maxRetries:     maxRetries,
+		retryBaseDelay: time.Second,
+	}
+}
+
+func (bc *MastodonBookmarkClient) GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error) {
+	return bc.GetCollection(ctx, SourceBookmark, limit, nextURL)
+}
+
+// GetCollection fetches a page of the authenticated user's bookmarks,
+// favourites or own statuses, returning the next page link if there is one.
+func (bc *MastodonBookmarkClient) GetCollection(ctx context.Context, source string, limit int, nextURL string) ([]Bookmark, string, error) {
+	if err := bc.rateLimiter.wait(ctx); err != nil {
+		return nil, "", fmt.Errorf("rate limit wait failed: %w", err)
+	}
+
+	if bc.client == nil {
+		return []Bookmark{}, "", nil
+	}
+
+	var requestURL string
+	if nextURL != "" {
+		requestURL = nextURL
+	} else {
+		var path string
+		switch source {
+		case SourceBookmark:
+			path = "/api/v1/bookmarks"
+		case SourceFavourite:
+			path = "/api/v1/favourites"
+		case SourceOwnStatus:
+			if bc.accountID == "" {
+				return nil, "", fmt.Errorf("account ID is required to fetch own statuses")
+			}
+			path = "/api/v1/accounts/" + url.PathEscape(bc.accountID) + "/statuses"
+		default:
+			return nil, "", fmt.Errorf("unknown source: %s", source)
+		}
+
+		reqURL, err := url.Parse(bc.client.InstanceURL + path)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to parse URL: %w", err)
+		}
+
+		params := url.Values{}
+		if limit > 0 {
+			params.Set("limit", strconv.Itoa(limit))
+		}
+		reqURL.RawQuery = params.Encode()
+		requestURL = reqURL.String()
+	}
+
+	resp, err := bc.get(ctx, requestURL)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var nextURLFromHeader string
+	links := link.ParseResponse(resp)
+	for _, l := range links {
+		if l.Rel == "next" {
+			nextURLFromHeader = l.URI
+			break
+		}
+	}
+
+	var statuses []apiStatus
+	if err := json.NewDecoder(resp.Body).Decode(&statuses); err != nil {
+		return nil, "", fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	bookmarks := make([]Bookmark, len(statuses))
+	for i, status := range statuses {
+		bookmarks[i] = convertAPIStatusToBookmark(status)
+	}
+
+	return bookmarks, nextURLFromHeader, nil
+}
+
+// GetStatus fetches a single status by ID, returning errStatusNotFound when
+// the server reports it as gone.
+func (bc *MastodonBookmarkClient) GetStatus(ctx context.Context, statusID string) (Status, error) {
+	if err := bc.rateLimiter.wait(ctx); err != nil {
+		return Status{}, fmt.Errorf("rate limit wait failed: %w", err)
+	}
+
+	if bc.client == nil {
+		return Status{}, fmt.Errorf("mastodon client is not initialized")
+	}
+
+	requestURL := fmt.Sprintf("%s/api/v1/statuses/%s", bc.client.InstanceURL, url.PathEscape(statusID))
+
+	resp, err := bc.get(ctx, requestURL)
+	if err != nil {
+		var statusErr *apiStatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone) {
+			return Status{}, errStatusNotFound
+		}
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+
+	var status apiStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return Status{}, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	return convertAPIStatusToBookmark(status).Status, nil
+}
+
+// GetContext fetches the ancestors and descendants of a status.
+func (bc *MastodonBookmarkClient) GetContext(ctx context.Context, statusID string) (StatusContext, error) {
+	if err := bc.rateLimiter.wait(ctx); err != nil {
+		return StatusContext{}, fmt.Errorf("rate limit wait failed: %w", err)
+	}
+
+	if bc.client == nil {
+		return StatusContext{}, fmt.Errorf("mastodon client is not initialized")
+	}
+
+	requestURL := fmt.Sprintf("%s/api/v1/statuses/%s/context", bc.client.InstanceURL, url.PathEscape(statusID))
+
+	resp, err := bc.get(ctx, requestURL)
+	if err != nil {
+		var statusErr *apiStatusError
+		if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone) {
+			return StatusContext{}, errStatusNotFound
+		}
+		return StatusContext{}, err
+	}
+	defer resp.Body.Close()
+
+	var apiContext struct {
+		Ancestors   []apiStatus `json:"ancestors"`
+		Descendants []apiStatus `json:"descendants"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiContext); err != nil {
+		return StatusContext{}, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+
+	thread := StatusContext{
+		Ancestors:   make([]Status, len(apiContext.Ancestors)),
+		Descendants: make([]Status, len(apiContext.Descendants)),
+	}
+	for i, status := range apiContext.Ancestors {
+		thread.Ancestors[i] = convertAPIStatusToBookmark(status).Status
+	}
+	for i, status := range apiContext.Descendants {
+		thread.Descendants[i] = convertAPIStatusToBookmark(status).Status
+	}
+	return thread, nil
+}
+
+// ResolveStatus looks up a status by its URI or URL through search, letting
+// the server fetch it from its origin if it has not seen it yet. It returns
+// errStatusNotFound when the search comes back empty.
+func (bc *MastodonBookmarkClient) ResolveStatus(ctx context.Context, uri string) (Status, error) {
+	if err := bc.rateLimiter.wait(ctx); err != nil {
+		return Status{}, fmt.Errorf("rate limit wait failed: %w", err)
+	}
+
+	if bc.client == nil {
+		return Status{}, fmt.Errorf("mastodon client is not initialized")
+	}
+
+	query := url.Values{
+		"q":       {uri},
+		"type":    {"statuses"},
+		"resolve": {"true"},
+		"limit":   {"1"},
+	}
+	requestURL := fmt.Sprintf("%s/api/v2/search?%s", bc.client.InstanceURL, query.Encode())
+
+	resp, err := bc.get(ctx, requestURL)
+	if err != nil {
+		return Status{}, err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		Statuses []apiStatus `json:"statuses"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return Status{}, fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+	if len(result.Statuses) == 0 {
+		return Status{}, errStatusNotFound
+	}
+
+	return convertAPIStatusToBookmark(result.Statuses[0]).Status, nil
+}
+
+// get performs an authenticated GET request with retries.
+func (bc *MastodonBookmarkClient) get(ctx context.Context, requestURL string) (*http.Response, error) {
+	return sendWithRetries(ctx, bc.rateLimiter, bc.maxRetries, bc.retryBaseDelay, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		if bc.client.UserToken != nil && bc.client.UserToken.AccessToken != "" {
+			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", bc.client.UserToken.AccessToken))
+		}
+		req.Header.Set("User-Agent", "bookmarchive/1.0")
+		return req, nil
+	})
+}
+
+// sendWithRetries sends the request newRequest builds, retrying server
+// errors, 429 responses and network failures with jittered exponential
+// backoff and waiting at least as long as a Retry-After header asks. A
+// request cannot be sent twice, so newRequest is called for every attempt.
+// Any response other than 200 OK is returned as an *apiStatusError; on
+// success the caller must close the response body.
+func sendWithRetries(ctx context.Context, rateLimiter *RateLimiter, maxRetries int, retryBaseDelay time.Duration, newRequest func() (*http.Request, error)) (*http.Response, error) {
+	var lastErr error
+
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleepContext(ctx, retryDelay(attempt, retryBaseDelay, maxRetryDelay)); err != nil {
+				return nil, err
+			}
+			if err := rateLimiter.wait(ctx); err != nil {
+				return nil, fmt.Errorf("rate limit wait failed: %w", err)
+			}
+			apiRetriesTotal.inc()
+		}
+
+		req, err := newRequest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create request: %w", err)
+		}
+
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		apiRequestDuration.observe(time.Since(start).Seconds())
+		if err != nil {
+			apiRequestsTotal.inc("error")
+			lastErr = err
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+
+		apiRequestsTotal.inc(strconv.Itoa(resp.StatusCode))
+		now := time.Now()
+		rateLimiter.update(resp.Header, now)
+
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		lastErr = &apiStatusError{StatusCode: resp.StatusCode, Body: body}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			if delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+				rateLimiter.block(now.Add(delay))
+			}
+		}
+
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+
+	var statusErr *apiStatusError
+	if errors.As(lastErr, &statusErr) {
+		return nil, lastErr
+	}
+	return nil, fmt.Errorf("HTTP request failed after %d retries: %w", maxRetries, lastErr)
+}
+
+// apiStatus is a status as the API returns it, including the preview card
+// that madon.Status leaves out.
+type apiStatus struct {
+	madon.Status
+	Card *madon.Card `json:"card"`
+}
+
+func convertAPIStatusToBookmark(status apiStatus) Bookmark {
+	bookmark := convertMadonStatusToBookmark(status.Status)
+	if status.Card != nil && status.Card.URL != "" {
+		card := &Card{
+			URL:         status.Card.URL,
+			Title:       status.Card.Title,
+			Description: status.Card.Description,
+			Image:       status.Card.Image,
+		}
+		if status.Card.Type != nil {
+			card.Type = *status.Card.Type
+		}
+		if status.Card.ProviderName != nil {
+			card.ProviderName = *status.Card.ProviderName
+		}
+		bookmark.Status.Card = card
+	}
+	return bookmark
+}
+
+func convertMadonStatusToBookmark(status madon.Status) Bookmark {
+	var account Account
+	if status.Account != nil {
+		account = Account{
+			ID:          string(status.Account.ID),
+			Username:    status.Account.Username,
+			DisplayName: status.Account.DisplayName,
+			Avatar:      status.Account.Avatar,
+			Acct:        status.Account.Acct,
+		}
+	}
+
+	var mediaAttachments []Media
+	for _, media := range status.MediaAttachments {
+		description := ""
+		if media.Description != nil {
+			description = *media.Description
+		}
+
+		mediaAttachments = append(mediaAttachments, Media{
+			ID:          string(media.ID),
+			Type:        media.Type,
+			URL:         media.URL,
+			PreviewURL:  media.PreviewURL,
+			Description: description,
+		})
+	}
+
+	var tags []Tag
+	for _, tag := range status.Tags {
+		tags = append(tags, Tag{
+			Name: tag.Name,
+			URL:  tag.URL,
+		})
+	}
+
+	inReplyToID := ""
+	if status.InReplyToID != nil {
+		inReplyToID = string(*status.InReplyToID)
+	}
+
+	language := ""
+	if status.Language != nil {
+		language = *status.Language
+	}
+
+	serviceStatus := Status{
+		ID:               string(status.ID),
+		URI:              status.URI,
+		URL:              status.URL,
+		InReplyToID:      inReplyToID,
+		Content:          status.Content,
+		SpoilerText:      status.SpoilerText,
+		CreatedAt:        status.CreatedAt,
+		Account:          account,
+		MediaAttachments: mediaAttachments,
+		Tags:             tags,
+		Language:         language,
+	}
+
+	return Bookmark{
+		ID:        string(status.ID),
+		Status:    serviceStatus,
+		CreatedAt: status.CreatedAt,
+	}
+}
+
+func convertBookmarkToDatabase(bookmark Bookmark, indexedFields []string) *DBBookmark {
+	rawJSON, err := json.Marshal(bookmark)
+	if err != nil {
+		rawJSON = []byte(fmt.Sprintf(`{"id":"%s","status_id":"%s","created_at":"%s"}`,
+			bookmark.ID,
+			bookmark.Status.ID,
+			bookmark.CreatedAt.Format(time.RFC3339)))
+	}
+
+	searchFields := buildSearchFields(bookmark, indexedFields)
+
+	// Extract account_id from the status account
+	accountID := ""
+	if bookmark.Status.Account.ID != "" {
+		accountID = bookmark.Status.Account.ID
+	}
+
+	return &DBBookmark{
+		StatusID:     bookmark.Status.ID,
+		CreatedAt:    bookmark.Status.CreatedAt,
+		BookmarkedAt: bookmark.CreatedAt,
+		SearchText:   joinSearchFields(searchFields),
+		SearchFields: searchFields,
+		RawJSON:      string(rawJSON),
+		AccountID:    accountID,
+		HasLink:      statusHasLink(bookmark.Status),
+	}
+}
+
+// buildSearchText joins the text of every search index column, as shown
+// for results without a snippet and kept with revisions.
+func buildSearchText(bookmark Bookmark, indexedFields []string) string {
+	return joinSearchFields(buildSearchFields(bookmark, indexedFields))
+}
+
+func joinSearchFields(fields map[string]string) string {
+	var searchParts []string
+	for _, column := range searchColumns {
+		if text := fields[column.name]; text != "" {
+			searchParts = append(searchParts, text)
+		}
+	}
+	return strings.Join(searchParts, " ")
+}
+
+// buildSearchFields returns the text of each search index column, keyed by
+// the names in searchColumns, drawn from the indexed fields. Columns with
+// no text are left out.
+func buildSearchFields(bookmark Bookmark, indexedFields []string) map[string]string {
+	fields := make(map[string]string)
+
+	shouldIndex := func(field string) bool {
+		for _, f := range indexedFields {
+			if f == field {
+				return true
+			}
+		}
+		return false
+	}
+	add := func(column, text string) {
+		if text == "" {
+			return
+		}
+		if fields[column] != "" {
+			text = fields[column] + " " + text
+		}
+		fields[column] = text
+	}
+
+	if shouldIndex("content") && bookmark.Status.Content != "" {
+		add("content", stripHTML(bookmark.Status.Content))
+	}
+
+	if shouldIndex("spoiler_text") {
+		add("spoiler", bookmark.Status.SpoilerText)
+	}
+
+	if shouldIndex("username") {
+		add("author", bookmark.Status.Account.Username)
+	}
+
+	if shouldIndex("display_name") {
+		add("author", bookmark.Status.Account.DisplayName)
+	}
+
+	if shouldIndex("media_descriptions") {
+		for _, media := range bookmark.Status.MediaAttachments {
+			add("media", media.Description)
+		}
+	}
+
+	if shouldIndex("hashtags") {
+		for _, tag := range bookmark.Status.Tags {
+			add("hashtags", tag.Name)
+		}
+	}
+
+	if shouldIndex("card") && bookmark.Status.Card != nil {
+		card := bookmark.Status.Card
+		for _, part := range []string{card.Title, card.Description, card.ProviderName} {
+			add("links", part)
+		}
+	}
+
+	if shouldIndex("link_text") {
+		add("links", bookmark.LinkText)
+	}
+
+	if shouldIndex("thread") {
+		add("thread", bookmark.ThreadText)
+	}
+
+	return fields
+}
+
+func stripHTML(html string) string {
+	// Use BlueMondaty's StrictPolicy to strip all HTML tags securely
+	policy := bluemonday.StrictPolicy()
+	// Add space when stripping tags to prevent words from being merged
+	policy = policy.AddSpaceWhenStrippingTag(true)
+
+	// Strip all HTML tags and return clean text
+	cleaned := policy.Sanitize(html)
+
+	// Clean up extra whitespace
+	cleaned = strings.ReplaceAll(cleaned, "  ", " ")
+	cleaned = strings.TrimSpace(cleaned)
+
+	return cleaned
+}
+
+// statusHasLink reports whether a status has a preview card or links
+// somewhere in its content. Anchors for mentions and hashtags don't count;
+// servers mark them with a mention or hashtag class or rel="tag". Bare URLs
+// in the text count too, as Misskey notes are converted without anchors.
+func statusHasLink(status Status) bool {
+	if status.Card != nil {
+		return true
+	}
+
+	doc, err := html.Parse(strings.NewReader(status.Content))
+	if err != nil {
+		return false
+	}
+
+	var found func(n *html.Node) bool
+	found = func(n *html.Node) bool {
+		if n.Type == html.ElementNode && n.DataAtom == atom.A {
+			return !isTagAnchor(n)
+		}
+		if n.Type == html.TextNode && (strings.Contains(n.Data, "https://") || strings.Contains(n.Data, "http://")) {
+			return true
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if found(c) {
+				return true
+			}
+		}
+		return false
+	}
+	return found(doc)
+}
+
+// isTagAnchor reports whether an anchor links to a mentioned account or a
+// hashtag rather than to a page.
+func isTagAnchor(n *html.Node) bool {
+	for _, attr := range n.Attr {
+		switch attr.Key {
+		case "class":
+			for _, class := range strings.Fields(attr.Val) {
+				if class == "mention" || class == "hashtag" {
+					return true
+				}
+			}
+		case "rel":
+			if slices.Contains(strings.Fields(attr.Val), "tag") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// =============================================================================
+// SERVER SOFTWARE DETECTION
+// =============================================================================
+
+// Server software families by the API they speak. Mastodon, GoToSocial and
+// Akkoma/Pleroma all implement Mastodon's client API and are read by
+// MastodonBookmarkClient; Misskey and its forks have their own API, read by
+// MisskeyBookmarkClient.
+const (
+	SoftwareMastodon   = "mastodon"
+	SoftwareGoToSocial = "gotosocial"
+	SoftwarePleroma    = "pleroma"
+	SoftwareMisskey    = "misskey"
+)
+
+// softwareFamilies maps the software names servers report in nodeinfo to
+// their family. Software not listed is assumed to speak Mastodon's API.
+var softwareFamilies = map[string]string{
+	"mastodon":   SoftwareMastodon,
+	"hometown":   SoftwareMastodon,
+	"glitch-soc": SoftwareMastodon,
+	"gotosocial": SoftwareGoToSocial,
+	"pleroma":    SoftwarePleroma,
+	"akkoma":     SoftwarePleroma,
+	"misskey":    SoftwareMisskey,
+	"sharkey":    SoftwareMisskey,
+	"firefish":   SoftwareMisskey,
+	"calckey":    SoftwareMisskey,
+	"foundkey":   SoftwareMisskey,
+	"iceshrimp":  SoftwareMisskey,
+	"cherrypick": SoftwareMisskey,
+}
+
+// detectServerSoftware reads a server's nodeinfo and returns the family of
+// the software it runs, along with the software's own name.
+func detectServerSoftware(ctx context.Context, httpClient *http.Client, server string) (family, name string, err error) {
+	server = strings.TrimRight(server, "/")
+
+	var wellKnown struct {
+		Links []struct {
+			Rel  string `json:"rel"`
+			Href string `json:"href"`
+		} `json:"links"`
+	}
+	if err := getJSON(ctx, httpClient, server+"/.well-known/nodeinfo", &wellKnown); err != nil {
+		return "", "", fmt.Errorf("failed to fetch nodeinfo links: %w", err)
+	}
+
+	// Prefer the newest schema the server offers
+	var href, schema string
+	for _, l := range wellKnown.Links {
+		if strings.HasPrefix(l.Rel, "http://nodeinfo.diaspora.software/ns/schema/") && l.Rel > schema {
+			href, schema = l.Href, l.Rel
+		}
+	}
+	if href == "" {
+		return "", "", fmt.Errorf("server publishes no nodeinfo")
+	}
+
+	var nodeinfo struct {
+		Software struct {
+			Name string `json:"name"`
+		} `json:"software"`
+	}
+	if err := getJSON(ctx, httpClient, href, &nodeinfo); err != nil {
+		return "", "", fmt.Errorf("failed to fetch nodeinfo: %w", err)
+	}
+
+	name = strings.ToLower(nodeinfo.Software.Name)
+	family, ok := softwareFamilies[name]
+	if !ok {
+		family = SoftwareMastodon
+	}
+	return family, name, nil
+}
+
+// getJSON fetches a URL without authentication and decodes its JSON body.
+func getJSON(ctx context.Context, httpClient *http.Client, requestURL string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "bookmarchive/1.0")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &apiStatusError{StatusCode: resp.StatusCode}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+	return nil
+}
+
+// =============================================================================
+// MISSKEY BOOKMARK CLIENT
+// =============================================================================
+
+// MisskeyBookmarkClient reads Misskey and its forks, such as Sharkey and
+// Firefish. Their API takes every call as a POST with a JSON body carrying
+// the token, and pages with untilId, which is what the client returns as
+// the next page "URL". Misskey's favorites are its bookmarks and reactions
+// are its favourites.
+type MisskeyBookmarkClient struct {
+	server         string
+	accessToken    string
+	rateLimiter    *RateLimiter
+	maxRetries     int
+	retryBaseDelay time.Duration
+	// accountID is the authenticated user's ID, needed to page through
+	// their notes and reactions.
+	accountID string
+}
+
+type misskeyUser struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Host      string `json:"host"`
+	Name      string `json:"name"`
+	AvatarURL string `json:"avatarUrl"`
+}
+
+type misskeyFile struct {
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	URL          string `json:"url"`
+	ThumbnailURL string `json:"thumbnailUrl"`
+	Comment      string `json:"comment"`
+}
+
+type misskeyNote struct {
+	ID        string        `json:"id"`
+	CreatedAt time.Time     `json:"createdAt"`
+	Text      string        `json:"text"`
+	CW        string        `json:"cw"`
+	User      misskeyUser   `json:"user"`
+	ReplyID   string        `json:"replyId"`
+	Files     []misskeyFile `json:"files"`
+	Tags      []string      `json:"tags"`
+	URI       string        `json:"uri"`
+	URL       string        `json:"url"`
+	Renote    *misskeyNote  `json:"renote"`
+}
+
+// misskeyEntry is an item of the favorites and reactions lists: the note
+// and when the user favorited or reacted to it.
+type misskeyEntry struct {
+	ID        string      `json:"id"`
+	CreatedAt time.Time   `json:"createdAt"`
+	Note      misskeyNote `json:"note"`
+}
+
+// misskeyNotFoundCodes are the error codes Misskey answers with for notes
+// and objects that do not exist.
+var misskeyNotFoundCodes = map[string]bool{
+	"NO_SUCH_NOTE":   true,
+	"NO_SUCH_OBJECT": true,
+}
+
+func newMisskeyBookmarkClient(server, accessToken string, maxRetries int) *MisskeyBookmarkClient {
+	return &MisskeyBookmarkClient{
+		server:         strings.TrimRight(server, "/"),
+		accessToken:    accessToken,
+		rateLimiter:    newRateLimiter(150, 5*time.Minute),
+		maxRetries:     maxRetries,
+		retryBaseDelay: time.Second,
+	}
+}
+
+// call posts params to an API endpoint and decodes the response into result.
+// Errors the server explains with a not-found code are returned as
+// errStatusNotFound.
+func (mc *MisskeyBookmarkClient) call(ctx context.Context, endpoint string, params map[string]interface{}, result interface{}) error {
+	if err := mc.rateLimiter.wait(ctx); err != nil {
+		return fmt.Errorf("rate limit wait failed: %w", err)
+	}
+
+	body := map[string]interface{}{"i": mc.accessToken}
+	for key, value := range params {
+		body[key] = value
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	resp, err := sendWithRetries(ctx, mc.rateLimiter, mc.maxRetries, mc.retryBaseDelay, func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", mc.server+"/api/"+endpoint, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "bookmarchive/1.0")
+		return req, nil
+	})
+	if err != nil {
+		var statusErr *apiStatusError
+		if errors.As(err, &statusErr) {
+			var apiErr struct {
+				Error struct {
+					Code string `json:"code"`
+				} `json:"error"`
+			}
+			json.Unmarshal(statusErr.Body, &apiErr)
+			if statusErr.StatusCode == http.StatusNotFound || misskeyNotFoundCodes[apiErr.Error.Code] {
+				return errStatusNotFound
+			}
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode JSON response: %w", err)
+	}
+	return nil
+}
+
+// verifyCredentials checks the token and returns the account it belongs to.
+func (mc *MisskeyBookmarkClient) verifyCredentials(ctx context.Context) (*UserAccount, error) {
+	var user misskeyUser
+	if err := mc.call(ctx, "i", nil, &user); err != nil {
+		return nil, fmt.Errorf("failed to verify credentials: %w", err)
+	}
+	return &UserAccount{
+		AccountID:   user.ID,
+		Username:    user.Username,
+		DisplayName: user.Name,
+		Acct:        user.Username,
+		Avatar:      user.AvatarURL,
+	}, nil
+}
+
+func (mc *MisskeyBookmarkClient) GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error) {
+	return mc.GetCollection(ctx, SourceBookmark, limit, nextURL)
+}
+
+// GetCollection fetches a page of the user's favorites, reactions or own
+// notes, returning the untilId of the next page while pages come back full.
+func (mc *MisskeyBookmarkClient) GetCollection(ctx context.Context, source string, limit int, nextURL string) ([]Bookmark, string, error) {
+	params := map[string]interface{}{}
+	if limit > 0 {
+		params["limit"] = limit
+	}
+	if nextURL != "" {
+		params["untilId"] = nextURL
+	}
+
+	var bookmarks []Bookmark
+	var lastID string
+	switch source {
+	case SourceBookmark, SourceFavourite:
+		endpoint := "i/favorites"
+		if source == SourceFavourite {
+			if mc.accountID == "" {
+				return nil, "", fmt.Errorf("account ID is required to fetch reactions")
+			}
+			endpoint = "users/reactions"
+			params["userId"] = mc.accountID
+		}
+
+		var entries []misskeyEntry
+		if err := mc.call(ctx, endpoint, params, &entries); err != nil {
+			return nil, "", err
+		}
+		for _, entry := range entries {
+			status := convertMisskeyNote(entry.Note, mc.server)
+			bookmarks = append(bookmarks, Bookmark{ID: status.ID, Status: status, CreatedAt: entry.CreatedAt})
+			lastID = entry.ID
+		}
+	case SourceOwnStatus:
+		if mc.accountID == "" {
+			return nil, "", fmt.Errorf("account ID is required to fetch own statuses")
+		}
+		params["userId"] = mc.accountID
+		params["withReplies"] = true
+		params["withRenotes"] = false
+
+		var notes []misskeyNote
+		if err := mc.call(ctx, "users/notes", params, &notes); err != nil {
+			return nil, "", err
+		}
+		for _, note := range notes {
+			status := convertMisskeyNote(note, mc.server)
+			bookmarks = append(bookmarks, Bookmark{ID: status.ID, Status: status, CreatedAt: status.CreatedAt})
+			lastID = note.ID
+		}
+	default:
+		return nil, "", fmt.Errorf("unknown source: %s", source)
+	}
+
+	if bookmarks == nil {
+		bookmarks = []Bookmark{}
+	}
+	if limit <= 0 || len(bookmarks) < limit {
+		lastID = ""
+	}
+	return bookmarks, lastID, nil
+}
+
+// GetStatus fetches a single note by ID, returning errStatusNotFound when it
+// no longer exists.
+func (mc *MisskeyBookmarkClient) GetStatus(ctx context.Context, statusID string) (Status, error) {
+	var note misskeyNote
+	if err := mc.call(ctx, "notes/show", map[string]interface{}{"noteId": statusID}, &note); err != nil {
+		return Status{}, err
+	}
+	return convertMisskeyNote(note, mc.server), nil
+}
+
+// GetContext fetches the notes a note replies to and the replies to it.
+func (mc *MisskeyBookmarkClient) GetContext(ctx context.Context, statusID string) (StatusContext, error) {
+	var ancestors, descendants []misskeyNote
+	if err := mc.call(ctx, "notes/conversation", map[string]interface{}{"noteId": statusID, "limit": 100}, &ancestors); err != nil {
+		return StatusContext{}, err
+	}
+	if err := mc.call(ctx, "notes/children", map[string]interface{}{"noteId": statusID, "limit": 100}, &descendants); err != nil {
+		return StatusContext{}, err
+	}
+
+	// The conversation comes nearest first; threads read oldest first
+	thread := StatusContext{
+		Ancestors:   make([]Status, len(ancestors)),
+		Descendants: make([]Status, len(descendants)),
+	}
+	for i, note := range ancestors {
+		thread.Ancestors[len(ancestors)-1-i] = convertMisskeyNote(note, mc.server)
+	}
+	for i, note := range descendants {
+		thread.Descendants[i] = convertMisskeyNote(note, mc.server)
+	}
+	return thread, nil
+}
+
+// ResolveStatus looks up a note by its URI or URL, letting the server fetch
+// it from its origin if needed.
+func (mc *MisskeyBookmarkClient) ResolveStatus(ctx context.Context, uri string) (Status, error) {
+	var result struct {
+		Type   string          `json:"type"`
+		Object json.RawMessage `json:"object"`
+	}
+	if err := mc.call(ctx, "ap/show", map[string]interface{}{"uri": uri}, &result); err != nil {
+		return Status{}, err
+	}
+	if result.Type != "Note" {
+		return Status{}, errStatusNotFound
+	}
+
+	var note misskeyNote
+	if err := json.Unmarshal(result.Object, &note); err != nil {
+		return Status{}, fmt.Errorf("failed to decode note: %w", err)
+	}
+	return convertMisskeyNote(note, mc.server), nil
+}
+
+// convertMisskeyNote normalizes a note into a status. Pure renotes are
+// replaced by the note they share. The plain-text body is turned into
+// paragraphs of escaped HTML like the content Mastodon serves.
+func convertMisskeyNote(note misskeyNote, server string) Status {
+	if note.Renote != nil && note.Text == "" && len(note.Files) == 0 {
+		return convertMisskeyNote(*note.Renote, server)
+	}
+
+	noteURL := server + "/notes/" + note.ID
+	status := Status{
+		ID:          note.ID,
+		URI:         note.URI,
+		URL:         note.URL,
+		InReplyToID: note.ReplyID,
+		Content:     misskeyTextToHTML(note.Text),
+		SpoilerText: note.CW,
+		CreatedAt:   note.CreatedAt,
+		Account: Account{
+			ID:          note.User.ID,
+			Username:    note.User.Username,
+			DisplayName: note.User.Name,
+			Avatar:      note.User.AvatarURL,
+			Acct:        note.User.Username,
+		},
+		MediaAttachments: make([]Media, 0, len(note.Files)),
+		Tags:             make([]Tag, 0, len(note.Tags)),
+	}
+	if status.URI == "" {
+		status.URI = noteURL
+	}
+	if status.URL == "" {
+		status.URL = status.URI
+	}
+	if status.Account.DisplayName == "" {
+		status.Account.DisplayName = note.User.Username
+	}
+	if note.User.Host != "" {
+		status.Account.Acct += "@" + note.User.Host
+	}
+
+	for _, file := range note.Files {
+		mediaType, _, _ := strings.Cut(file.Type, "/")
+		switch mediaType {
+		case "image", "video", "audio":
+		default:
+			mediaType = "unknown"
+		}
+		status.MediaAttachments = append(status.MediaAttachments, Media{
+			ID:          file.ID,
+			Type:        mediaType,
+			URL:         file.URL,
+			PreviewURL:  file.ThumbnailURL,
+			Description: file.Comment,
+		})
+	}
+
+	for _, tag := range note.Tags {
+		status.Tags = append(status.Tags, Tag{
+			Name: tag,
+			URL:  server + "/tags/" + url.PathEscape(tag),
+		})
+	}
+
+	return status
+}
+
+// misskeyTextToHTML renders a note's text as HTML paragraphs, with single
+// line breaks kept as <br>.
+func misskeyTextToHTML(text string) string {
+	text = strings.TrimSpace(strings.ReplaceAll(text, "\r\n", "\n"))
+	if text == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		b.WriteString("<p>")
+		b.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>"))
+		b.WriteString("</p>")
+	}
+	return b.String()
+}
+
+// =============================================================================
+// MEDIA ARCHIVER
+// =============================================================================
+
+var (
+	errMediaTooLarge       = errors.New("media file exceeds size limit")
+	errMediaTypeNotAllowed = errors.New("media type not allowed")
+	errNonPublicAddress    = errors.New("address is not public")
+)
+
+// nonPublicPrefixes are the ranges that netip.Addr.IsGlobalUnicast and
+// IsPrivate let through but that are not reachable on the public internet.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.0.2.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("198.51.100.0/24"),
+	netip.MustParsePrefix("203.0.113.0/24"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+	netip.MustParsePrefix("2001:db8::/32"),
+}
+
+// isPublicAddr reports whether addr is a unicast address on the public
+// internet, rather than loopback, link-local, private or reserved.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkFetchURL rejects URLs that are not plain web addresses.
+func checkFetchURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+// newPublicHTTPClient returns a client for the URLs found in statuses, which
+// anyone can write. It only follows http and https URLs and only connects to
+// public addresses. The address is checked once resolved, on every
+// connection, so neither redirects nor DNS answers can point it at the
+// host's own services or its network. Proxies from the environment are not
+// used, as the check would then only see the proxy.
+func newPublicHTTPClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("failed to parse address %q: %w", address, err)
+			}
+			if !isPublicAddr(addrPort.Addr()) {
+				return fmt.Errorf("%w: %s", errNonPublicAddress, addrPort.Addr())
+			}
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			return checkFetchURL(req.URL)
+		},
+	}
+}
+
+// MediaArchiver downloads status attachments and their previews into a
+// content-addressed directory so the archive keeps them when the remote
+// copies go away.
+type MediaArchiver struct {
+	db           *Database
+	directory    string
+	maxFileSize  int64
+	allowedTypes []string
+	httpClient   *http.Client
+}
+
+func newMediaArchiver(cfg *Config, db *Database) (*MediaArchiver, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database cannot be nil")
+	}
+
+	timeout, err := parseDurationOrDefault(cfg.Media.Timeout, 60*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid media timeout: %w", err)
+	}
+
+	directory := mediaDirectory(cfg)
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+
+	return &MediaArchiver{
+		db:           db,
+		directory:    directory,
+		maxFileSize:  cfg.Media.MaxFileSize,
+		allowedTypes: cfg.Media.AllowedTypes,
+		httpClient:   newPublicHTTPClient(timeout),
+	}, nil
+}
+
+// mediaDirectory returns the configured media directory, or a "media"
+// directory next to the database.
+func mediaDirectory(cfg *Config) string {
+	if cfg.Media.Directory != "" {
+		return cfg.Media.Directory
+	}
+	return filepath.Join(filepath.Dir(cfg.Database.Path), "media")
+}
+
+// mediaFilePath returns where a file with the given SHA-256 hash is stored,
+// fanned out by the first two hex digits.
+func mediaFilePath(directory, hash string) string {
+	return filepath.Join(directory, hash[:2], hash)
+}
+
+// isMediaHash reports whether s is a lowercase hex SHA-256 digest.
+func isMediaHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// archiveStatus downloads every attachment and preview of a status that is
+// not archived yet, returning how many files were stored. Files rejected by
+// the size or type limits or hosted on non-public addresses are skipped;
+// other failures are returned after the remaining files have been tried.
+func (ma *MediaArchiver) archiveStatus(ctx context.Context, status Status) (int, error) {
+	stored := 0
+	var errs []error
+
+	for _, media := range status.MediaAttachments {
+		variants := []struct {
+			name string
+			url  string
+		}{
+			{"original", media.URL},
+			{"preview", media.PreviewURL},
+		}
+
+		for _, variant := range variants {
+			if variant.url == "" || (variant.name == "preview" && variant.url == media.URL) {
+				continue
+			}
+
+			existing, err := ma.db.getMediaFile(variant.url)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if existing != nil {
+				continue
+			}
+
+			file, err := ma.download(ctx, variant.url)
+			if errors.Is(err, errMediaTooLarge) || errors.Is(err, errMediaTypeNotAllowed) || errors.Is(err, errNonPublicAddress) {
+				zlog.Debug().Err(err).Str("url", variant.url).Msg("Skipping media file")
+				continue
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to download %s: %w", variant.url, err))
+				continue
+			}
+
+			file.StatusID = status.ID
+			file.MediaID = media.ID
+			file.Variant = variant.name
+			if err := ma.db.insertMediaFile(file); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			stored++
+		}
+	}
+
+	return stored, errors.Join(errs...)
+}
+
+// download fetches a remote file into the media directory, enforcing the
+// size and type limits, and returns its record without status details.
+func (ma *MediaArchiver) download(ctx context.Context, remoteURL string) (*MediaFile, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", remoteURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := checkFetchURL(req.URL); err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "bookmarchive/1.0")
+
+	resp, err := ma.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &apiStatusError{StatusCode: resp.StatusCode}
+	}
+
+	if ma.maxFileSize > 0 && resp.ContentLength > ma.maxFileSize {
+		return nil, errMediaTooLarge
+	}
+
+	mimeType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mimeType == "application/octet-stream" {
+		mimeType = ""
+	}
+
+	tmp, err := os.CreateTemp(ma.directory, ".download-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	body := io.Reader(resp.Body)
+	if ma.maxFileSize > 0 {
+		// Read one byte past the limit to tell a full-size file from a larger one
+		body = io.LimitReader(resp.Body, ma.maxFileSize+1)
+	}
+
+	hash := sha256.New()
+	var head bytes.Buffer
+	size, err := io.Copy(io.MultiWriter(tmp, hash, &limitedBuffer{buf: &head, limit: 512}), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read media file: %w", err)
+	}
+	if ma.maxFileSize > 0 && size > ma.maxFileSize {
+		return nil, errMediaTooLarge
+	}
+
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(http.DetectContentType(head.Bytes()))
+	}
+	if !ma.typeAllowed(mimeType) {
+		return nil, fmt.Errorf("%w: %s", errMediaTypeNotAllowed, mimeType)
+	}
+
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write media file: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	path := mediaFilePath(ma.directory, sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create media directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to store media file: %w", err)
+	}
+
+	return &MediaFile{
+		RemoteURL:    remoteURL,
+		SHA256:       sum,
+		Size:         size,
+		MimeType:     mimeType,
+		DownloadedAt: time.Now(),
+	}, nil
+}
+
+// typeAllowed reports whether a MIME type matches one of the configured
+// types, which are either exact types or prefixes ending in "/".
+func (ma *MediaArchiver) typeAllowed(mimeType string) bool {
+	if len(ma.allowedTypes) == 0 {
+		return true
+	}
+	for _, allowed := range ma.allowedTypes {
+		if mimeType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(mimeType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, for content sniffing.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (lb *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := lb.limit - lb.buf.Len(); remaining > 0 {
+		lb.buf.Write(p[:minInt(remaining, len(p))])
+	}
+	return len(p), nil
+}
+
+// =============================================================================
+// LINK ARCHIVER
+// =============================================================================
+
+// errLinkNotHTML is returned when a linked page is not an HTML document.
+var errLinkNotHTML = errors.New("linked page is not HTML")
+
+// LinkArchiver fetches the pages linked from status cards and keeps their
+// readable text, so an article stays searchable after it moves or vanishes.
+type LinkArchiver struct {
+	db            *Database
+	maxPageSize   int64
+	maxTextLength int
+	httpClient    *http.Client
+}
+
+func newLinkArchiver(cfg *Config, db *Database) (*LinkArchiver, error) {
+	if db == nil {
+		return nil, fmt.Errorf("database cannot be nil")
+	}
+
+	timeout, err := parseDurationOrDefault(cfg.Links.Timeout, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid links timeout: %w", err)
+	}
+
+	return &LinkArchiver{
+		db:            db,
+		maxPageSize:   cfg.Links.MaxPageSize,
+		maxTextLength: cfg.Links.MaxTextLength,
+		httpClient:    newPublicHTTPClient(timeout),
+	}, nil
+}
+
+// snapshot returns the stored snapshot of a page, fetching and storing it
+// first if the URL was never seen. Pages that are not HTML or not on a
+// public address are stored with empty text so they are not tried again.
+func (la *LinkArchiver) snapshot(ctx context.Context, pageURL string) (*LinkSnapshot, error) {
+	existing, err := la.db.getLinkSnapshot(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return existing, nil
+	}
+
+	snapshot, err := la.fetch(ctx, pageURL)
+	if errors.Is(err, errLinkNotHTML) || errors.Is(err, errNonPublicAddress) {
+		zlog.Debug().Err(err).Str("url", pageURL).Msg("Not archiving linked page text")
+		snapshot = &LinkSnapshot{URL: pageURL, FetchedAt: time.Now()}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", pageURL, err)
+	}
+
+	if err := la.db.insertLinkSnapshot(snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// fetch downloads a page and extracts its title and readable text. Pages
+// larger than the size limit are cut off there rather than skipped.
+func (la *LinkArchiver) fetch(ctx context.Context, pageURL string) (*LinkSnapshot, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if err := checkFetchURL(req.URL); err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "bookmarchive/1.0")
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := la.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &apiStatusError{StatusCode: resp.StatusCode}
+	}
+
+	mimeType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && mimeType != "text/html" && mimeType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("%w: %s", errLinkNotHTML, mimeType)
+	}
+
+	body := io.Reader(resp.Body)
+	if la.maxPageSize > 0 {
+		body = io.LimitReader(resp.Body, la.maxPageSize)
+	}
+
+	title, text, err := extractPageText(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse page: %w", err)
+	}
+
+	return &LinkSnapshot{
+		URL:       pageURL,
+		Title:     title,
+		Text:      truncateText(text, la.maxTextLength),
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+// skippedPageElements hold navigation, scripts and other page furniture that
+// is left out of a page's readable text.
+var skippedPageElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Nav:      true,
+	atom.Footer:   true,
+	atom.Aside:    true,
+	atom.Form:     true,
+	atom.Button:   true,
+}
+
+// extractPageText returns the title of an HTML page and the text of its
+// <article>, <main> or <body>, whichever is found first, with whitespace
+// collapsed.
+func extractPageText(r io.Reader) (title, text string, err error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	var find func(n *html.Node, a atom.Atom) *html.Node
+	find = func(n *html.Node, a atom.Atom) *html.Node {
+		if n.Type == html.ElementNode && n.DataAtom == a {
+			return n
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if found := find(c, a); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+
+	var parts []string
+	var collect func(n *html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.ElementNode && skippedPageElements[n.DataAtom] {
+			return
+		}
+		if n.Type == html.TextNode {
+			parts = append(parts, n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+
+	if titleNode := find(doc, atom.Title); titleNode != nil {
+		collect(titleNode)
+		title = strings.Join(strings.Fields(strings.Join(parts, " ")), " ")
+		parts = nil
+	}
+
+	root := doc
+	for _, a := range []atom.Atom{atom.Article, atom.Main, atom.Body} {
+		if found := find(doc, a); found != nil {
+			root = found
+			break
+		}
+	}
+	collect(root)
+
+	return title, strings.Join(strings.Fields(strings.Join(parts, " ")), " "), nil
+}
+
+// truncateText cuts text to at most limit bytes without splitting a UTF-8
+// sequence; a limit of 0 keeps everything.
+func truncateText(text string, limit int) string {
+	if limit <= 0 || len(text) <= limit {
+		return text
+	}
+	for limit > 0 && !utf8.RuneStart(text[limit]) {
+		limit--
+	}
+	return text[:limit]
+}
+
+// =============================================================================
+// USER STREAM
+// =============================================================================
+
+// streamIdleTimeout is how long a stream may stay silent before it is taken
+// for dead. Mastodon sends a heartbeat comment every 15 seconds.
+const streamIdleTimeout = time.Minute
+
+// StreamEvent is an event from the user's stream about a status. Status is
+// the status carried by update, status.update and notification events, and
+// NotificationType the kind of notification, such as "favourite".
+type StreamEvent struct {
+	Event            string
+	StatusID         string
+	Status           *Status
+	NotificationType string
+}
+
+// UserStream follows the user's stream on Mastodon's server-sent events
+// endpoint and hands its events to subscribers. While the stream is down it
+// reconnects with growing delays, and subscribers keep polling meanwhile.
+type UserStream struct {
+	server            string
+	accessToken       string
+	httpClient        *http.Client
+	reconnectDelay    time.Duration
+	maxReconnectDelay time.Duration
+	idleTimeout       time.Duration
+	// url is the stream endpoint, discovered from the instance on the first
+	// connection since some servers run streaming on another host.
+	url string
+
+	mu          sync.Mutex
+	subscribers []chan StreamEvent
+	connected   bool
+}
+
+func newUserStream(server, accessToken string, reconnectDelay, maxReconnectDelay time.Duration) *UserStream {
+	return &UserStream{
+		server:            strings.TrimRight(server, "/"),
+		accessToken:       accessToken,
+		httpClient:        &http.Client{},
+		reconnectDelay:    reconnectDelay,
+		maxReconnectDelay: maxReconnectDelay,
+		idleTimeout:       streamIdleTimeout,
+	}
+}
+
+// subscribe returns a channel receiving the stream's events. Events are
+// dropped for subscribers that fall behind, since the polls they trigger
+// catch up on anything missed.
+func (us *UserStream) subscribe() <-chan StreamEvent {
+	events := make(chan StreamEvent, 16)
+	us.mu.Lock()
+	us.subscribers = append(us.subscribers, events)
+	us.mu.Unlock()
+	return events
+}
+
+func (us *UserStream) publish(event StreamEvent) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	for _, events := range us.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+func (us *UserStream) isConnected() bool {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	return us.connected
+}
+
+func (us *UserStream) setConnected(connected bool) {
+	us.mu.Lock()
+	us.connected = connected
+	us.mu.Unlock()
+}
+
+// run follows the stream until ctx is done. Reconnection delays double from
+// reconnectDelay up to maxReconnectDelay and start over after a successful
+// connection.
+func (us *UserStream) run(ctx context.Context) {
+	failures := 0
+	for {
+		connected, err := us.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			failures = 0
+		}
+		failures++
+		delay := retryDelay(failures, us.reconnectDelay, us.maxReconnectDelay)
+
+		// Only the first failure in a row is worth a warning; servers without
+		// streaming would otherwise fill the log
+		logEvent := zlog.Debug()
+		if failures == 1 {
+			logEvent = zlog.Warn()
+		}
+		logEvent.Err(err).Str("url", us.url).Dur("retry_in", delay).Msg("User stream unavailable, polling until it reconnects")
+
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// connect reads the stream until it ends, fails or stays silent longer than
+// idleTimeout, and reports whether the server accepted the connection.
+func (us *UserStream) connect(ctx context.Context) (bool, error) {
+	if us.url == "" {
+		us.url = us.discoverURL(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", us.url, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+us.accessToken)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("User-Agent", "bookmarchive/1.0")
+
+	resp, err := us.httpClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, &apiStatusError{StatusCode: resp.StatusCode}
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "text/event-stream") {
+		return false, fmt.Errorf("unexpected stream content type %q", contentType)
+	}
+
+	us.setConnected(true)
+	defer us.setConnected(false)
+	zlog.Info().Str("url", us.url).Msg("Connected to user stream")
+
+	// Drop the connection when not even a heartbeat arrives in time
+	idle := time.AfterFunc(us.idleTimeout, cancel)
+	defer idle.Stop()
+
+	var event string
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		idle.Reset(us.idleTimeout)
+
+		line := scanner.Text()
+		if line == "" {
+			if streamEvent, ok := parseStreamEvent(event, strings.Join(data, "\n")); ok {
+				us.publish(streamEvent)
+			}
+			event, data = "", nil
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue // heartbeat
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			event = value
+		case "data":
+			data = append(data, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return true, fmt.Errorf("stream interrupted: %w", err)
+	}
+	return true, fmt.Errorf("stream closed by server")
+}
+
+// discoverURL returns the user stream endpoint on the streaming host the
+// instance advertises, or on the instance itself when it advertises none.
+func (us *UserStream) discoverURL(ctx context.Context) string {
+	base := us.server
+
+	var instance struct {
+		URLs struct {
+			StreamingAPI string `json:"streaming_api"`
+		} `json:"urls"`
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := getJSON(ctx, us.httpClient, us.server+"/api/v1/instance", &instance); err != nil {
+		zlog.Debug().Err(err).Msg("Failed to look up streaming host, using the instance")
+	} else if streaming := instance.URLs.StreamingAPI; streaming != "" {
+		streaming = strings.Replace(streaming, "wss://", "https://", 1)
+		streaming = strings.Replace(streaming, "ws://", "http://", 1)
+		base = strings.TrimRight(streaming, "/")
+	}
+
+	return base + "/api/v1/streaming/user"
+}
+
+// parseStreamEvent decodes the stream events that concern statuses and
+// ignores the rest.
+func parseStreamEvent(name, data string) (StreamEvent, bool) {
+	switch name {
+	case "update", "status.update":
+		var status Status
+		if err := json.Unmarshal([]byte(data), &status); err != nil || status.ID == "" {
+			return StreamEvent{}, false
+		}
+		return StreamEvent{Event: name, StatusID: status.ID, Status: &status}, true
+	case "delete":
+		// The payload is the bare ID, which some servers quote
+		statusID := strings.Trim(strings.TrimSpace(data), `"`)
+		if statusID == "" {
+			return StreamEvent{}, false
+		}
+		return StreamEvent{Event: name, StatusID: statusID}, true
+	case "notification":
+		var notification struct {
+			Type   string  `json:"type"`
+			Status *Status `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(data), &notification); err != nil {
+			return StreamEvent{}, false
+		}
+		event := StreamEvent{Event: name, Status: notification.Status, NotificationType: notification.Type}
+		if notification.Status != nil {
+			event.StatusID = notification.Status.ID
+		}
+		return event, true
+	}
+	return StreamEvent{}, false
+}
+
+// =============================================================================
+// BOOKMARK SERVICE
+// =============================================================================
+
+type BookmarkService struct {
+	config    *Config
+	account   AccountConfig
+	source    string
 	db        *Database
 	client    BookmarkClient
 	ctx       context.Context
 	cancel    context.CancelFunc
 	eventChan chan<- ServerEvent
+	// media downloads the attachments of newly archived statuses; nil when
+	// media archiving is disabled.
+	media *MediaArchiver
+	// links snapshots the pages linked from status cards; nil when link
+	// archiving is disabled.
+	links *LinkArchiver
+	// connection provides the API client shared by every service of the
+	// account; one is created on start when the service runs on its own.
+	connection *accountConnection
+	// refreshDelegated is set on all but one service of an account, so the
+	// account's statuses are refreshed by a single refresher.
+	refreshDelegated bool
+	// health records the service's progress and errors.
+	health *syncHealth
+}
+
+// syncHealth tracks whether one service is keeping its collection up to
+// date, for /readyz and /api/stats. Its methods do nothing on nil, so
+// services built without one still run.
+type syncHealth struct {
+	account string
+	source  string
+
+	mu                  sync.Mutex
+	startedAt           time.Time
+	credentialsVerified bool
+	stopped             bool
+	lastError           string
+	lastErrorAt         *time.Time
+	failedPolls         int
+}
+
+// SyncStatus is a snapshot of a service's syncHealth.
+type SyncStatus struct {
+	Account             string    `json:"account"`
+	Source              string    `json:"source"`
+	StartedAt           time.Time `json:"started_at"`
+	CredentialsVerified bool      `json:"credentials_verified"`
+	// Stopped is set when the service gave up after an error.
+	Stopped     bool       `json:"stopped"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	// FailedPolls counts the polls that failed since the last one that
+	// succeeded.
+	FailedPolls int `json:"failed_polls"`
+}
+
+func newSyncHealth(account, source string) *syncHealth {
+	return &syncHealth{account: account, source: source}
+}
+
+func (h *syncHealth) started() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.startedAt = time.Now()
+	h.stopped = false
+}
+
+func (h *syncHealth) verified() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.credentialsVerified = true
+}
+
+// polled records the outcome of a poll.
+func (h *syncHealth) polled(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err == nil {
+		h.failedPolls = 0
+		return
+	}
+	h.failedPolls++
+	h.setError(err)
+}
+
+// failed records the error the service stopped with.
+func (h *syncHealth) failed(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.stopped = true
+	h.setError(err)
+}
+
+func (h *syncHealth) setError(err error) {
+	now := time.Now()
+	h.lastError = err.Error()
+	h.lastErrorAt = &now
+}
+
+func (h *syncHealth) snapshot() SyncStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return SyncStatus{
+		Account:             h.account,
+		Source:              h.source,
+		StartedAt:           h.startedAt,
+		CredentialsVerified: h.credentialsVerified,
+		Stopped:             h.stopped,
+		LastError:           h.lastError,
+		LastErrorAt:         h.lastErrorAt,
+		FailedPolls:         h.failedPolls,
+	}
+}
+
+func newBookmarkService(cfg *Config, db *Database, eventChan chan<- ServerEvent) (*BookmarkService, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	return newAccountBookmarkService(cfg, db, eventChan, AccountConfig{
+		Server:        cfg.Mastodon.Server,
+		AccessToken:   cfg.Mastodon.AccessToken,
+		ClientTimeout: cfg.Mastodon.ClientTimeout,
+	}, SourceBookmark)
+}
+
+// newAccountBookmarkService creates the service archiving one collection of
+// an account's statuses into the archive named after the account.
+func newAccountBookmarkService(cfg *Config, db *Database, eventChan chan<- ServerEvent, account AccountConfig, source string) (*BookmarkService, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("config cannot be nil")
+	}
+	if db == nil {
+		return nil, fmt.Errorf("database cannot be nil")
+	}
+	if account.Server == "" {
+		return nil, fmt.Errorf("mastodon server URL is required")
+	}
+	if account.AccessToken == "" {
+		return nil, fmt.Errorf("mastodon access token is required")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	service := &BookmarkService{
+		config:    cfg,
+		account:   account,
+		source:    source,
+		db:        db,
+		ctx:       ctx,
+		cancel:    cancel,
+		eventChan: eventChan,
+		health:    newSyncHealth(account.Name, source),
+	}
+
+	return service, nil
+}
+
+// start backfills and then polls the collection until stopped, recording
+// the error it stops with in the service's health.
+func (s *BookmarkService) start() error {
+	s.health.started()
+	err := s.run()
+	if err != nil && !errors.Is(err, context.Canceled) {
+		s.health.failed(err)
+	}
+	return err
+}
+
+func (s *BookmarkService) run() error {
+	if err := s.db.ensureBackfillState(s.account.Name, s.sourceOrDefault()); err != nil {
+		return fmt.Errorf("failed to initialize backfill state: %w", err)
+	}
+
+	client, err := s.createBookmarkClient()
+	if err != nil {
+		return fmt.Errorf("failed to create bookmark client: %w", err)
+	}
+	s.client = client
+	s.health.verified()
+
+	if err := s.runBackfill(); err != nil {
+		return fmt.Errorf("backfill failed: %w", err)
+	}
+
+	return s.startPolling()
+}
+
+func (s *BookmarkService) stop() error {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	return nil
+}
+
+// sourceOrDefault returns the collection this service archives, bookmarks
+// unless configured otherwise.
+func (s *BookmarkService) sourceOrDefault() string {
+	if s.source == "" {
+		return SourceBookmark
+	}
+	return s.source
+}
+
+// fetchPage fetches one page of the collection this service archives,
+// recording how long it took and whether it succeeded.
+func (s *BookmarkService) fetchPage(limit int, nextURL string) ([]Bookmark, string, error) {
+	source := s.sourceOrDefault()
+	start := time.Now()
+	bookmarks, next, err := s.fetchCollectionPage(source, limit, nextURL)
+	fetchDuration.observe(time.Since(start).Seconds(), s.account.Name, source)
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	fetchesTotal.inc(s.account.Name, source, result)
+	return bookmarks, next, err
+}
+
+func (s *BookmarkService) fetchCollectionPage(source string, limit int, nextURL string) ([]Bookmark, string, error) {
+	if source == SourceBookmark {
+		return s.client.GetBookmarks(s.ctx, limit, nextURL)
+	}
+
+	collectionClient, ok := s.client.(CollectionClient)
+	if !ok {
+		return nil, "", fmt.Errorf("bookmark client cannot fetch %s collection", source)
+	}
+	return collectionClient.GetCollection(s.ctx, source, limit, nextURL)
+}
+
+func (s *BookmarkService) createBookmarkClient() (BookmarkClient, error) {
+	if s.connection == nil {
+		s.connection = newAccountConnection(s.account, s.db)
+	}
+
+	client, err := s.connection.bookmarkClient()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.sourceOrDefault() == SourceOwnStatus && s.connection.accountID == "" {
+		return nil, fmt.Errorf("failed to get current account for own statuses")
+	}
+	return client, nil
+}
+
+// accountConnection creates one account's API client on first use and shares
+// it, together with its rate limiter, between the services archiving that
+// account's collections.
+type accountConnection struct {
+	account     AccountConfig
+	db          *Database
+	rateLimiter *RateLimiter
+	mu          sync.Mutex
+	client      BookmarkClient
+	// software is the family of the server's software and accountID the
+	// user's ID on it, both known once client is set.
+	software  string
+	accountID string
+	// stream is the user's stream, shared by the account's services once
+	// streaming is enabled.
+	stream *UserStream
+}
+
+func newAccountConnection(account AccountConfig, db *Database) *accountConnection {
+	return &accountConnection{
+		account:     account,
+		db:          db,
+		rateLimiter: newRateLimiter(150, 5*time.Minute),
+	}
+}
+
+// bookmarkClient returns the account's client, detecting the server's
+// software, verifying the credentials and storing the user's account
+// information on the first successful call.
+func (c *accountConnection) bookmarkClient() (BookmarkClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	mastodonClient, err := newAccountMastodonClient(c.account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mastodon client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mastodonClient.timeout)
+	defer cancel()
+
+	software, softwareName, err := detectServerSoftware(ctx, &http.Client{Timeout: mastodonClient.timeout}, c.account.Server)
+	if err != nil {
+		zlog.Warn().Err(err).Str("account", c.account.Name).Msg("Failed to detect server software, assuming Mastodon")
+		software, softwareName = SoftwareMastodon, ""
+	} else {
+		zlog.Info().Str("account", c.account.Name).Str("software", softwareName).Msg("Detected server software")
+	}
+
+	maxRetries := 3
+	var client BookmarkClient
+	var userAccount *UserAccount
+	if software == SoftwareMisskey {
+		misskeyClient := newMisskeyBookmarkClient(c.account.Server, c.account.AccessToken, maxRetries)
+		misskeyClient.rateLimiter = c.rateLimiter
+
+		userAccount, err = misskeyClient.verifyCredentials(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s credentials: %w", softwareName, err)
+		}
+		misskeyClient.accountID = userAccount.AccountID
+		client = misskeyClient
+	} else {
+		if err := mastodonClient.verifyCredentials(); err != nil {
+			return nil, fmt.Errorf("failed to verify mastodon credentials: %w", err)
+		}
+
+		// After successful verification, store the current user's account information
+		madonClient, err := mastodonClient.getMadonClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get madon client: %w", err)
+		}
+
+		bookmarkClient := newMastodonBookmarkClient(madonClient, maxRetries)
+		bookmarkClient.rateLimiter = c.rateLimiter
+
+		// Get and store current user account information
+		account, err := madonClient.GetCurrentAccount()
+		if err != nil {
+			zlog.Warn().Err(err).Str("account", c.account.Name).Msg("Failed to get current account information")
+		} else {
+			bookmarkClient.accountID = string(account.ID)
+			userAccount = &UserAccount{
+				AccountID:   string(account.ID),
+				Username:    account.Username,
+				DisplayName: account.DisplayName,
+				Acct:        account.Acct,
+				Avatar:      account.Avatar,
+			}
+		}
+		client = bookmarkClient
+	}
+
+	if userAccount != nil {
+		userAccount.OwnerAccount = c.account.Name
+		c.accountID = userAccount.AccountID
+		if err := c.db.insertUserAccount(userAccount); err != nil {
+			zlog.Warn().Err(err).Msg("Failed to store user account information")
+			// Don't fail the creation if we can't store the account info
+		} else {
+			zlog.Info().Str("account", c.account.Name).Str("account_id", userAccount.AccountID).Str("username", userAccount.Username).Msg("Stored user account information")
+		}
+	}
+
+	c.software = software
+	c.client = client
+	return client, nil
+}
+
+// userStream returns the account's stream, starting it the first time it is
+// asked for; it then runs until ctx is done. Misskey servers have no
+// server-sent events stream, so they get none and are only polled.
+func (c *accountConnection) userStream(ctx context.Context, cfg *Config) (*UserStream, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stream != nil || c.software == SoftwareMisskey {
+		return c.stream, nil
+	}
+
+	reconnectDelay, err := parseDurationOrDefault(cfg.Streaming.ReconnectDelay, 5*time.Second)
+	if err != nil || reconnectDelay <= 0 {
+		return nil, fmt.Errorf("invalid streaming reconnect_delay: %q", cfg.Streaming.ReconnectDelay)
+	}
+	maxReconnectDelay, err := parseDurationOrDefault(cfg.Streaming.MaxReconnectDelay, 5*time.Minute)
+	if err != nil || maxReconnectDelay < reconnectDelay {
+		return nil, fmt.Errorf("invalid streaming max_reconnect_delay: %q", cfg.Streaming.MaxReconnectDelay)
+	}
+
+	c.stream = newUserStream(c.account.Server, c.account.AccessToken, reconnectDelay, maxReconnectDelay)
+	go c.stream.run(ctx)
+	return c.stream, nil
+}
+
+func (s *BookmarkService) runBackfill() error {
+	zlog.Info().Str("account", s.account.Name).Str("source", s.sourceOrDefault()).Msg("Starting bookmark backfill")
+
+	state, err := s.db.getBackfillStateForOwner(s.account.Name, s.sourceOrDefault())
+	if err != nil {
+		return fmt.Errorf("failed to get backfill state: %w", err)
+	}
+
+	if state.BackfillComplete {
+		zlog.Info().Msg("Backfill already complete, skipping")
+		return nil
+	}
+
+	batchSize := s.config.Polling.BatchSize
+	if batchSize <= 0 {
+		batchSize = 40
+	}
+
+	nextURL := state.LastProcessedID
+	if nextURL == "" {
+		zlog.Info().Msg("Starting backfill from the beginning")
+	} else {
+		zlog.Info().Str("next_url", nextURL).Msg("Resuming backfill from last position")
+	}
+
+	totalProcessed := 0
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		default:
+		}
+
+		zlog.Debug().Str("next_url", nextURL).Int("batch_size", batchSize).Msg("Fetching bookmark batch")
+
+		bookmarks, newNextURL, err := s.fetchPage(batchSize, nextURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch bookmarks: %w", err)
+		}
+
+		if len(bookmarks) == 0 {
+			zlog.Info().Int("total_processed", totalProcessed).Msg("Backfill complete - no more bookmarks")
+			if err := s.db.updateBackfillStateForOwner(s.account.Name, s.sourceOrDefault(), "", true, nil); err != nil {
+				return fmt.Errorf("failed to mark backfill complete: %w", err)
+			}
+			break
+		}
+
+		if newNextURL == "" {
+			zlog.Info().
+				Int("count", len(bookmarks)).
+				Int("total_processed", totalProcessed+len(bookmarks)).
+				Msg("Processing final bookmark batch - no next URL")
+
+			if err := s.processBookmarkBatch(bookmarks); err != nil {
+				return fmt.Errorf("failed to process final bookmark batch: %w", err)
+			}
+
+			totalProcessed += len(bookmarks)
+			backfillProcessed.set(float64(totalProcessed), s.account.Name, s.sourceOrDefault())
+
+			if err := s.db.updateBackfillStateForOwner(s.account.Name, s.sourceOrDefault(), "", true, nil); err != nil {
+				return fmt.Errorf("failed to mark backfill complete: %w", err)
+			}
+
+			zlog.Info().Int("total_bookmarks", totalProcessed).Msg("Backfill completed successfully")
+			break
+		}
+
+		zlog.Info().
+			Int("count", len(bookmarks)).
+			Int("total_so_far", totalProcessed).
+			Str("next_url", newNextURL).
+			Msg("Processing bookmark batch")
+
+		if err := s.processBookmarkBatch(bookmarks); err != nil {
+			return fmt.Errorf("failed to process bookmark batch: %w", err)
+		}
+
+		totalProcessed += len(bookmarks)
+		backfillProcessed.set(float64(totalProcessed), s.account.Name, s.sourceOrDefault())
+
+		if err := s.db.updateBackfillStateForOwner(s.account.Name, s.sourceOrDefault(), newNextURL, false, nil); err != nil {
+			return fmt.Errorf("failed to update backfill state: %w", err)
+		}
+
+		nextURL = newNextURL
+
+		if err := s.waitBetweenBatches(); err != nil {
+			return err
+		}
+	}
+
+	if s.eventChan != nil {
+		select {
+		case s.eventChan <- ServerEvent{
+			Type: "backfill_complete",
+			Payload: map[string]interface{}{
+				"account":         s.account.Name,
+				"source":          s.sourceOrDefault(),
+				"total_processed": totalProcessed,
+			},
+		}:
+		default:
+		}
+	}
+
+	zlog.Info().Int("total_bookmarks", totalProcessed).Msg("Backfill completed successfully")
+	return nil
+}
+
+// waitBetweenBatches pauses for the configured backfill delay between
+// paginated requests, returning early if the service is stopped.
+func (s *BookmarkService) waitBetweenBatches() error {
+	delay := 10 * time.Second
+	if s.config.Polling.BackfillDelay != "" {
+		if parsedDelay, err := time.ParseDuration(s.config.Polling.BackfillDelay); err == nil {
+			delay = parsedDelay
+		}
+	}
+
+	zlog.Debug().Dur("delay", delay).Msg("Waiting between batches")
+
+	timer := time.NewTimer(delay)
+	select {
+	case <-s.ctx.Done():
+		timer.Stop()
+		return s.ctx.Err()
+	case <-timer.C:
+	}
+	return nil
+}
+
+func (s *BookmarkService) startPolling() error {
+	intervalStr := s.config.Polling.Interval
+	if intervalStr == "" {
+		intervalStr = "5m"
+	}
+
+	interval, err := time.ParseDuration(intervalStr)
+	if err != nil {
+		return fmt.Errorf("invalid polling interval: %w", err)
+	}
+
+	if interval <= 0 {
+		return fmt.Errorf("polling interval must be positive")
+	}
+
+	// Reconciliation runs on the polling goroutine so it never shares the
+	// client with a concurrent poll; an empty interval disables it. Own
+	// statuses only leave their collection by being deleted, which the
+	// refresher already catches without walking the whole timeline.
+	var reconcileC <-chan time.Time
+	if s.config.Polling.ReconcileInterval != "" && s.sourceOrDefault() != SourceOwnStatus {
+		reconcileInterval, err := time.ParseDuration(s.config.Polling.ReconcileInterval)
+		if err != nil {
+			return fmt.Errorf("invalid reconcile interval: %w", err)
+		}
+		if reconcileInterval > 0 {
+			reconcileTicker := time.NewTicker(reconcileInterval)
+			defer reconcileTicker.Stop()
+			reconcileC = reconcileTicker.C
+			zlog.Info().Dur("interval", reconcileInterval).Msg("Scheduling bookmark reconciliation")
+		}
+	}
+
+	var refreshC <-chan time.Time
+	if s.config.Refresh.Enabled && !s.refreshDelegated {
+		refreshInterval, err := parseDurationOrDefault(s.config.Refresh.Interval, 15*time.Minute)
+		if err != nil {
+			return fmt.Errorf("invalid refresh interval: %w", err)
+		}
+		if refreshInterval > 0 {
+			refreshTicker := time.NewTicker(refreshInterval)
+			defer refreshTicker.Stop()
+			refreshC = refreshTicker.C
+			zlog.Info().Dur("interval", refreshInterval).Msg("Scheduling status refresh")
+		}
+	}
+
+	// Stream events trigger polls between ticks, and every poll pushes the
+	// next tick back, so the ticker only polls while the stream is down or
+	// quiet
+	var streamEvents <-chan StreamEvent
+	var minPollInterval time.Duration
+	if s.config.Streaming.Enabled {
+		minPollInterval, err = parseDurationOrDefault(s.config.Streaming.MinPollInterval, time.Minute)
+		if err != nil {
+			return fmt.Errorf("invalid streaming min_poll_interval: %w", err)
+		}
+		stream, err := s.connection.userStream(s.ctx, s.config)
+		if err != nil {
+			return err
+		}
+		if stream != nil {
+			streamEvents = stream.subscribe()
+		}
+	}
+	var streamPoll *time.Timer
+	var streamPollC <-chan time.Time
+	defer func() {
+		if streamPoll != nil {
+			streamPoll.Stop()
+		}
+	}()
+	lastPoll := time.Now()
+
+	zlog.Info().Dur("interval", interval).Msg("Starting bookmark polling")
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			zlog.Info().Msg("Stopping bookmark polling")
+			return s.ctx.Err()
+		case <-ticker.C:
+			zlog.Debug().Msg("Running scheduled bookmark poll")
+			lastPoll = time.Now()
+			err := s.pollBookmarks()
+			s.health.polled(err)
+			if err != nil {
+				zlog.Error().Err(err).Msg("Bookmark polling failed")
+				continue
+			}
+		case event := <-streamEvents:
+			// Poll right away, or once min_poll_interval has passed since
+			// the last poll, folding bursts of events into one poll
+			if s.handleStreamEvent(event) && streamPollC == nil {
+				streamPoll = time.NewTimer(max(minPollInterval-time.Since(lastPoll), 0))
+				streamPollC = streamPoll.C
+			}
+		case <-streamPollC:
+			streamPollC = nil
+			zlog.Debug().Msg("Running stream-triggered bookmark poll")
+			lastPoll = time.Now()
+			ticker.Reset(interval)
+			err := s.pollBookmarks()
+			s.health.polled(err)
+			if err != nil {
+				zlog.Error().Err(err).Msg("Bookmark polling failed")
+				continue
+			}
+		case <-reconcileC:
+			zlog.Debug().Msg("Running scheduled bookmark reconciliation")
+			if err := s.reconcileBookmarks(); err != nil {
+				zlog.Error().Err(err).Msg("Bookmark reconciliation failed")
+				continue
+			}
+		case <-refreshC:
+			zlog.Debug().Msg("Running scheduled status refresh")
+			if err := s.refreshStatuses(); err != nil {
+				zlog.Error().Err(err).Msg("Status refresh failed")
+				continue
+			}
+		}
+	}
+}
+
+// handleStreamEvent reacts to an event from the user's stream and reports
+// whether it calls for a poll. Own statuses are polled when the user posts
+// and favourites on favourite notifications. Mastodon streams nothing when
+// the user bookmarks a status, so bookmarks are left to scheduled polls.
+// Edits and deletions of archived statuses are refreshed right away.
+func (s *BookmarkService) handleStreamEvent(event StreamEvent) bool {
+	switch event.Event {
+	case "status.update", "delete":
+		if s.config.Refresh.Enabled && !s.refreshDelegated {
+			s.refreshStreamedStatus(event.StatusID)
+		}
+		return false
+	case "update":
+		return s.sourceOrDefault() == SourceOwnStatus &&
+			event.Status != nil && event.Status.Account.ID == s.connection.accountID
+	case "notification":
+		return s.sourceOrDefault() == SourceFavourite && event.NotificationType == "favourite"
+	}
+	return false
+}
+
+// refreshStreamedStatus refreshes the archived copies of a status the stream
+// reported edited or deleted. Statuses that are not archived are ignored.
+func (s *BookmarkService) refreshStreamedStatus(statusID string) {
+	statusClient, ok := s.client.(StatusClient)
+	if !ok {
+		return
+	}
+
+	sources, err := s.db.getStatusSources(s.account.Name, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to look up streamed status")
+		return
+	}
+	var copies []*DBBookmark
+	for _, source := range sources {
+		bookmark, err := s.db.getBookmarkForOwner(s.account.Name, source, statusID)
+		if err != nil {
+			zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to look up streamed status")
+			return
+		}
+		if bookmark != nil {
+			copies = append(copies, bookmark)
+		}
+	}
+	if len(copies) == 0 {
+		return
+	}
+
+	minInterval, err := parseDurationOrDefault(s.config.Refresh.MinInterval, time.Hour)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Invalid refresh min_interval")
+		return
+	}
+	maxInterval, err := parseDurationOrDefault(s.config.Refresh.MaxInterval, 30*24*time.Hour)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Invalid refresh max_interval")
+		return
+	}
+
+	zlog.Debug().Str("status_id", statusID).Msg("Refreshing status changed on the stream")
+	changed, gone, err := s.refreshStatus(statusClient, statusID, copies, time.Now(), minInterval, maxInterval)
+	if err != nil {
+		return
+	}
+	updated, deleted := 0, 0
+	if gone {
+		deleted = 1
+	} else if changed {
+		updated = 1
+	}
+	s.sendRefreshEvent(1, updated, deleted)
+}
+
+// pollBookmarks fetches the newest bookmarks and keeps following the next
+// link until it reaches a page that is already fully archived, so bursts of
+// bookmarking between polls larger than one page are not lost. The number of
+// pages walked is capped by polling.max_poll_pages; when the cap is hit the
+// next page link is saved and the following poll finishes that walk before
+// starting again from the newest bookmarks.
+func (s *BookmarkService) pollBookmarks() error {
+	zlog.Debug().Msg("Checking for new bookmarks")
+
+	state, err := s.db.getBackfillStateForOwner(s.account.Name, s.sourceOrDefault())
+	if err != nil {
+		return fmt.Errorf("failed to get backfill state: %w", err)
+	}
+
+	batchSize := s.config.Polling.BatchSize
+	if batchSize <= 0 {
+		batchSize = 40
+	}
+
+	maxPages := s.config.Polling.MaxPollPages
+	if maxPages <= 0 {
+		maxPages = 10
+	}
+
+	// Finish the walk an earlier poll cut short before looking at the newest
+	// bookmarks; afterwards nextURL is reset to "" for the newest page.
+	nextURL := state.PollResumeURL
+	resuming := nextURL != ""
+	if resuming {
+		zlog.Info().Str("next_url", nextURL).Msg("Resuming poll where the page limit stopped the last one")
+	}
+
+	pages := 0
+	totalNew := 0
+	resumeURL := ""
+
+	for {
+		if pages == maxPages {
+			// An empty nextURL means the resumed walk just finished and only
+			// the newest page was left, which the next poll starts with anyway
+			if nextURL != "" {
+				resumeURL = nextURL
+				zlog.Warn().Int("max_pages", maxPages).Msg("Poll page limit reached before finding archived bookmarks; the next poll will continue from here")
+			}
+			break
+		}
+
+		bookmarks, newNextURL, err := s.fetchPage(batchSize, nextURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch bookmarks: %w", err)
+		}
+		pages++
+
+		caughtUp := len(bookmarks) == 0
+		if !caughtUp {
+			newCount, err := s.countUnarchived(bookmarks)
+			if err != nil {
+				return err
+			}
+
+			if newCount == 0 {
+				zlog.Debug().Int("page", pages).Msg("Reached already archived bookmarks")
+				caughtUp = true
+			} else {
+				zlog.Info().Int("count", newCount).Int("page", pages).Msg("Found new bookmarks to process")
+
+				if err := s.processBookmarkBatch(bookmarks); err != nil {
+					return fmt.Errorf("failed to process bookmark batch: %w", err)
+				}
+				totalNew += newCount
+			}
+		}
+
+		if caughtUp || newNextURL == "" || newNextURL == nextURL {
+			if !resuming {
+				break
+			}
+			resuming = false
+			nextURL = ""
+			continue
+		}
+		nextURL = newNextURL
+	}
+
+	if totalNew == 0 {
+		zlog.Debug().Msg("No new bookmarks found")
+	}
+
+	now := time.Now()
+	if err := s.db.updateBackfillStateForOwner(s.account.Name, s.sourceOrDefault(), state.LastProcessedID, state.BackfillComplete, &now); err != nil {
+		return fmt.Errorf("failed to update poll time: %w", err)
+	}
+	if err := s.db.updatePollProgress(s.account.Name, s.sourceOrDefault(), pages, resumeURL); err != nil {
+		return fmt.Errorf("failed to update poll progress: %w", err)
+	}
+
+	zlog.Info().Int("processed", totalNew).Int("pages", pages).Time("poll_time", now).Msg("Bookmark polling completed")
+	return nil
+}
+
+// countUnarchived returns how many of the bookmarks are not yet in the database.
+func (s *BookmarkService) countUnarchived(bookmarks []Bookmark) (int, error) {
+	count := 0
+	for _, bookmark := range bookmarks {
+		existing, err := s.db.getBookmarkForOwner(s.account.Name, s.sourceOrDefault(), bookmark.Status.ID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to check if bookmark exists: %w", err)
+		}
+		if existing == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// reconcileBookmarks walks the complete bookmark list on the server and
+// compares it with the archive. Statuses that are no longer bookmarked get an
+// unbookmarked_at timestamp, statuses bookmarked again have it cleared, and
+// bookmarks missing from the archive are inserted. Nothing is marked unless
+// the full list was fetched successfully, and an empty list never marks
+// anything as removed.
+func (s *BookmarkService) reconcileBookmarks() error {
+	zlog.Info().Msg("Starting bookmark reconciliation")
+
+	batchSize := s.config.Polling.BatchSize
+	if batchSize <= 0 {
+		batchSize = 40
+	}
+
+	states, err := s.db.getBookmarkStates(s.account.Name, s.sourceOrDefault())
+	if err != nil {
+		return fmt.Errorf("failed to get bookmark states: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var missing []Bookmark
+	nextURL := ""
+	pages := 0
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		default:
+		}
+
+		bookmarks, newNextURL, err := s.fetchPage(batchSize, nextURL)
+		if err != nil {
+			return fmt.Errorf("failed to fetch bookmarks: %w", err)
+		}
+		pages++
+
+		for _, bookmark := range bookmarks {
+			if seen[bookmark.Status.ID] {
+				continue
+			}
+			seen[bookmark.Status.ID] = true
+			if _, archived := states[bookmark.Status.ID]; !archived {
+				missing = append(missing, bookmark)
+			}
+		}
+
+		if len(bookmarks) == 0 || newNextURL == "" || newNextURL == nextURL {
+			break
+		}
+		nextURL = newNextURL
+
+		if err := s.waitBetweenBatches(); err != nil {
+			return err
+		}
+	}
+
+	var removed, restored []string
+	for statusID, active := range states {
+		switch {
+		case active && !seen[statusID]:
+			removed = append(removed, statusID)
+		case !active && seen[statusID]:
+			restored = append(restored, statusID)
+		}
+	}
+
+	// An empty list while the archive still has active statuses is far more
+	// likely a token or scope problem than the user clearing the whole
+	// collection, so nothing is flagged on its strength alone.
+	if len(seen) == 0 && len(removed) > 0 {
+		zlog.Warn().
+			Str("account", s.account.Name).
+			Str("source", s.sourceOrDefault()).
+			Int("active", len(removed)).
+			Msg("Server returned an empty collection; not flagging archived statuses as removed")
+		removed = nil
+	}
+
+	now := time.Now()
+	if err := s.db.setBookmarksUnbookmarked(s.account.Name, s.sourceOrDefault(), removed, &now); err != nil {
+		return fmt.Errorf("failed to mark unbookmarked statuses: %w", err)
+	}
+	if err := s.db.setBookmarksUnbookmarked(s.account.Name, s.sourceOrDefault(), restored, nil); err != nil {
+		return fmt.Errorf("failed to restore rebookmarked statuses: %w", err)
+	}
+
+	if len(missing) > 0 {
+		if err := s.processBookmarkBatch(missing); err != nil {
+			return fmt.Errorf("failed to process missing bookmarks: %w", err)
+		}
+	}
+
+	if err := s.db.updateReconcileTime(s.account.Name, s.sourceOrDefault(), now); err != nil {
+		return fmt.Errorf("failed to update reconcile time: %w", err)
+	}
+
+	if s.eventChan != nil {
+		select {
+		case s.eventChan <- ServerEvent{
+			Type: "reconcile_complete",
+			Payload: map[string]interface{}{
+				"account":      s.account.Name,
+				"source":       s.sourceOrDefault(),
+				"pages":        pages,
+				"bookmarked":   len(seen),
+				"unbookmarked": len(removed),
+				"restored":     len(restored),
+				"added":        len(missing),
+			},
+		}:
+		default:
+		}
+	}
+
+	zlog.Info().
+		Int("pages", pages).
+		Int("bookmarked", len(seen)).
+		Int("unbookmarked", len(removed)).
+		Int("restored", len(restored)).
+		Int("added", len(missing)).
+		Msg("Bookmark reconciliation completed")
+	return nil
+}
+
+// refreshStatuses re-fetches a batch of archived statuses whose refresh is
+// due. Changed statuses are updated with their previous copy kept as a
+// revision, statuses that are gone upstream are flagged as deleted, and each
+// status is rescheduled further out the older it is. It covers every
+// collection of the account, fetching a status archived in several of them
+// only once.
+func (s *BookmarkService) refreshStatuses() error {
+	statusClient, ok := s.client.(StatusClient)
+	if !ok {
+		return fmt.Errorf("bookmark client does not support fetching statuses")
+	}
+
+	batchSize := s.config.Refresh.BatchSize
+	if batchSize <= 0 {
+		batchSize = 20
+	}
+	minInterval, err := parseDurationOrDefault(s.config.Refresh.MinInterval, time.Hour)
+	if err != nil {
+		return fmt.Errorf("invalid refresh min_interval: %w", err)
+	}
+	maxInterval, err := parseDurationOrDefault(s.config.Refresh.MaxInterval, 30*24*time.Hour)
+	if err != nil {
+		return fmt.Errorf("invalid refresh max_interval: %w", err)
+	}
+
+	now := time.Now()
+	due, err := s.db.getBookmarksDueForRefresh(s.account.Name, now, batchSize)
+	if err != nil {
+		return fmt.Errorf("failed to get bookmarks due for refresh: %w", err)
+	}
+
+	if len(due) == 0 {
+		zlog.Debug().Msg("No statuses due for refresh")
+		return nil
+	}
+
+	// Group the collection copies of each status, keeping the due order
+	var statusIDs []string
+	copies := make(map[string][]*DBBookmark)
+	for _, bookmark := range due {
+		if _, seen := copies[bookmark.StatusID]; !seen {
+			statusIDs = append(statusIDs, bookmark.StatusID)
+		}
+		copies[bookmark.StatusID] = append(copies[bookmark.StatusID], bookmark)
+	}
+
+	updated, deleted, failed := 0, 0, 0
+	for _, statusID := range statusIDs {
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		default:
+		}
+
+		changed, gone, err := s.refreshStatus(statusClient, statusID, copies[statusID], now, minInterval, maxInterval)
+		switch {
+		case err != nil:
+			failed++
+		case gone:
+			deleted++
+		case changed:
+			updated++
+		}
+	}
+
+	s.sendRefreshEvent(len(statusIDs), updated, deleted)
+
+	zlog.Info().
+		Int("checked", len(statusIDs)).
+		Int("updated", updated).
+		Int("deleted", deleted).
+		Int("failed", failed).
+		Msg("Status refresh completed")
+	return nil
+}
+
+// refreshStatus fetches a status and applies it to each archived copy of it,
+// marking the copies deleted when it is gone upstream. Failures are logged
+// and the copies rescheduled before the error is returned.
+func (s *BookmarkService) refreshStatus(statusClient StatusClient, statusID string, copies []*DBBookmark, now time.Time, minInterval, maxInterval time.Duration) (changed, deleted bool, err error) {
+	if isUnresolvedStatusID(statusID) {
+		// Imported without a server ID, so there is nothing to fetch
+		for _, bookmark := range copies {
+			if err := s.db.scheduleBookmarkRefresh(s.account.Name, bookmark.Source, statusID, nil, now.Add(maxInterval)); err != nil {
+				zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to reschedule status refresh")
+			}
+		}
+		return false, false, nil
+	}
+
+	status, err := statusClient.GetStatus(s.ctx, statusID)
+	if errors.Is(err, errStatusNotFound) {
+		for _, bookmark := range copies {
+			if err := s.db.markBookmarkDeleted(s.account.Name, bookmark.Source, statusID, now); err != nil {
+				zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to mark status deleted")
+			}
+		}
+		zlog.Info().Str("status_id", statusID).Msg("Status was deleted upstream, keeping archived copy")
+		return false, true, nil
+	}
+	if err != nil {
+		zlog.Warn().Err(err).Str("status_id", statusID).Msg("Failed to refresh status")
+		// Retry later without blocking the rest of the queue
+		for _, bookmark := range copies {
+			if err := s.db.scheduleBookmarkRefresh(s.account.Name, bookmark.Source, statusID, nil, now.Add(minInterval)); err != nil {
+				zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to reschedule status refresh")
+			}
+		}
+		return false, false, err
+	}
+
+	for _, bookmark := range copies {
+		nextRefresh := now.Add(refreshDelay(bookmark.CreatedAt, now, minInterval, maxInterval))
+		copyChanged, err := s.applyStatusRefresh(bookmark, status, now, nextRefresh)
+		if err != nil {
+			zlog.Error().Err(err).Str("status_id", statusID).Str("source", bookmark.Source).Msg("Failed to store refreshed status")
+			continue
+		}
+		changed = changed || copyChanged
+	}
+	return changed, false, nil
+}
+
+// sendRefreshEvent tells the web clients that refreshing changed statuses.
+func (s *BookmarkService) sendRefreshEvent(checked, updated, deleted int) {
+	if s.eventChan == nil || (updated == 0 && deleted == 0) {
+		return
+	}
+	select {
+	case s.eventChan <- ServerEvent{
+		Type: "refresh_complete",
+		Payload: map[string]interface{}{
+			"account": s.account.Name,
+			"checked": checked,
+			"updated": updated,
+			"deleted": deleted,
+		},
+	}:
+	default:
+	}
+}
+
+// applyStatusRefresh stores a freshly fetched copy of an archived status in
+// the collection the row belongs to if its content changed, and reschedules
+// its next refresh either way.
+func (s *BookmarkService) applyStatusRefresh(bookmark *DBBookmark, status Status, now, nextRefresh time.Time) (bool, error) {
+	var archived Bookmark
+	if err := json.Unmarshal([]byte(bookmark.RawJSON), &archived); err != nil || archived.Status.ID == "" {
+		// Rows from older versions lack the full status, so take the fresh copy
+		archived = Bookmark{ID: bookmark.StatusID, CreatedAt: bookmark.BookmarkedAt}
+	}
+
+	if archived.Status.ID != "" && !statusContentChanged(archived.Status, status) {
+		return false, s.db.scheduleBookmarkRefresh(s.account.Name, bookmark.Source, bookmark.StatusID, &now, nextRefresh)
+	}
+
+	refreshed := archived
+	refreshed.Status = status
+	refreshed.LinkText = s.linkText(status)
+	refreshed.ThreadText = s.threadText(status.ID)
+	dbBookmark := convertBookmarkToDatabase(refreshed, s.config.Search.IndexedFields)
+
+	if err := s.db.updateBookmarkContent(s.account.Name, bookmark.Source, dbBookmark, now, nextRefresh); err != nil {
+		return false, err
+	}
+
+	zlog.Debug().Str("status_id", bookmark.StatusID).Str("source", bookmark.Source).Msg("Stored updated status content")
+	s.archiveMedia(status)
+	return true, nil
+}
+
+// archiveMedia downloads the attachments of a status when media archiving
+// is enabled. Failures are logged and retried the next time the status is
+// processed, so they never hold up archiving the status itself.
+func (s *BookmarkService) archiveMedia(status Status) {
+	if s.media == nil || len(status.MediaAttachments) == 0 {
+		return
+	}
+
+	stored, err := s.media.archiveStatus(s.ctx, status)
+	if err != nil {
+		zlog.Warn().Err(err).Str("status_id", status.ID).Msg("Failed to archive some media files")
+	}
+	if stored > 0 {
+		zlog.Debug().Int("count", stored).Str("status_id", status.ID).Msg("Archived media files")
+	}
+}
+
+// linkText returns the snapshot text of the page a status's card links to,
+// fetching it on first sight when link archiving is enabled. Failures are
+// logged and leave the status indexed without it.
+func (s *BookmarkService) linkText(status Status) string {
+	if s.links == nil || status.Card == nil || status.Card.URL == "" {
+		return ""
+	}
+
+	snapshot, err := s.links.snapshot(s.ctx, status.Card.URL)
+	if err != nil {
+		zlog.Warn().Err(err).Str("status_id", status.ID).Str("url", status.Card.URL).Msg("Failed to archive linked page")
+		return ""
+	}
+	if snapshot == nil {
+		return ""
+	}
+	return snapshot.Text
+}
+
+// archiveThread fetches and stores the thread around a newly archived status
+// when thread archiving is enabled. Failures are logged and leave the status
+// archived without its thread.
+func (s *BookmarkService) archiveThread(status Status) {
+	if !s.config.Threads.Enabled {
+		return
+	}
+
+	contextClient, ok := s.client.(ContextClient)
+	if !ok {
+		zlog.Debug().Msg("Bookmark client cannot fetch thread context")
+		return
+	}
+
+	thread, err := contextClient.GetContext(s.ctx, status.ID)
+	if err != nil {
+		zlog.Warn().Err(err).Str("status_id", status.ID).Msg("Failed to fetch thread context")
+		return
+	}
+
+	if err := s.db.replaceStatusContext(s.account.Name, status.ID, thread, time.Now()); err != nil {
+		zlog.Error().Err(err).Str("status_id", status.ID).Msg("Failed to store thread context")
+	}
+}
+
+// threadText returns the text of the stored thread around a status for
+// indexing, empty when thread archiving is disabled.
+func (s *BookmarkService) threadText(statusID string) string {
+	if !s.config.Threads.Enabled {
+		return ""
+	}
+
+	thread, err := s.db.getStatusContext(s.account.Name, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to get thread context")
+		return ""
+	}
+	return thread.text()
+}
+
+// statusContentChanged reports whether the parts of a status that are
+// archived and indexed differ, ignoring volatile data such as avatar URLs.
+func statusContentChanged(previous, current Status) bool {
+	if previous.Content != current.Content || previous.SpoilerText != current.SpoilerText {
+		return true
+	}
+
+	if len(previous.MediaAttachments) != len(current.MediaAttachments) {
+		return true
+	}
+	for i := range previous.MediaAttachments {
+		if previous.MediaAttachments[i].ID != current.MediaAttachments[i].ID ||
+			previous.MediaAttachments[i].Description != current.MediaAttachments[i].Description {
+			return true
+		}
+	}
+
+	if len(previous.Tags) != len(current.Tags) {
+		return true
+	}
+	for i := range previous.Tags {
+		if previous.Tags[i].Name != current.Tags[i].Name {
+			return true
+		}
+	}
+
+	// Servers often attach the card only after the status was first fetched
+	if (previous.Card == nil) != (current.Card == nil) {
+		return true
+	}
+	if previous.Card != nil && (previous.Card.URL != current.Card.URL ||
+		previous.Card.Title != current.Card.Title || previous.Card.Description != current.Card.Description) {
+		return true
+	}
+
+	return false
+}
+
+// refreshDelay schedules statuses on a decaying cadence: a status is next
+// refreshed after roughly its own age, bounded by min and max.
+func refreshDelay(createdAt, now time.Time, minDelay, maxDelay time.Duration) time.Duration {
+	age := now.Sub(createdAt)
+	if age < minDelay {
+		return minDelay
+	}
+	if age > maxDelay {
+		return maxDelay
+	}
+	return age
+}
+
+// parseDurationOrDefault parses value, returning def when it is empty.
+func parseDurationOrDefault(value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		return def, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (s *BookmarkService) processBookmarkBatch(bookmarks []Bookmark) error {
+	zlog.Debug().Int("count", len(bookmarks)).Msg("Processing bookmark batch")
+
+	actualProcessed := 0
+	batchesTotal.inc(s.account.Name, s.sourceOrDefault())
+
+	if s.eventChan != nil {
+		select {
+		case s.eventChan <- ServerEvent{
+			Type: "batch_start",
+			Payload: map[string]interface{}{
+				"account":         s.account.Name,
+				"source":          s.sourceOrDefault(),
+				"total_bookmarks": len(bookmarks),
+			},
+		}:
+		default:
+		}
+	}
+
+	for i, bookmark := range bookmarks {
+		select {
+		case <-s.ctx.Done():
+			return s.ctx.Err()
+		default:
+		}
+
+		zlog.Debug().Int("index", i+1).Int("total", len(bookmarks)).Str("bookmark_id", bookmark.ID).Msg("Processing bookmark")
+
+		existingBookmark, err := s.db.getBookmarkForOwner(s.account.Name, s.sourceOrDefault(), bookmark.Status.ID)
+		if err != nil {
+			zlog.Error().Err(err).Str("bookmark_id", bookmark.ID).Msg("Failed to check if bookmark exists")
+			batchBookmarksTotal.inc(s.account.Name, s.sourceOrDefault(), "failed")
+			continue
+		}
+
+		if existingBookmark != nil {
+			if existingBookmark.UnbookmarkedAt != nil {
+				// Showing up in the bookmark list again means it was re-bookmarked
+				if err := s.db.setBookmarksUnbookmarked(s.account.Name, s.sourceOrDefault(), []string{existingBookmark.StatusID}, nil); err != nil {
+					zlog.Error().Err(err).Str("bookmark_id", bookmark.ID).Msg("Failed to restore re-bookmarked status")
+				}
+			}
+			zlog.Debug().Str("bookmark_id", bookmark.ID).Msg("Bookmark already exists in database, skipping")
+			batchBookmarksTotal.inc(s.account.Name, s.sourceOrDefault(), "skipped")
+			continue
+		}
+
+		bookmark.LinkText = s.linkText(bookmark.Status)
+		s.archiveThread(bookmark.Status)
+		bookmark.ThreadText = s.threadText(bookmark.Status.ID)
+		dbBookmark := convertBookmarkToDatabase(bookmark, s.config.Search.IndexedFields)
+		dbBookmark.OwnerAccount = s.account.Name
+		dbBookmark.Source = s.sourceOrDefault()
+
+		if err := s.db.insertBookmark(dbBookmark); err != nil {
+			zlog.Error().Err(err).Str("bookmark_id", bookmark.ID).Msg("Failed to insert new bookmark")
+			batchBookmarksTotal.inc(s.account.Name, s.sourceOrDefault(), "failed")
+			continue
+		}
+
+		actualProcessed++
+		batchBookmarksTotal.inc(s.account.Name, s.sourceOrDefault(), "inserted")
+		zlog.Debug().Str("bookmark_id", bookmark.ID).Msg("New bookmark saved to database")
+
+		s.archiveMedia(bookmark.Status)
+
+		if s.eventChan != nil {
+			select {
+			case s.eventChan <- ServerEvent{
+				Type: "bookmark_processed",
+				Payload: map[string]interface{}{
+					"account":         s.account.Name,
+					"source":          s.sourceOrDefault(),
+					"bookmark_id":     bookmark.ID,
+					"status_id":       bookmark.Status.ID,
+					"username":        bookmark.Status.Account.Username,
+					"content_preview": stripHTML(bookmark.Status.Content)[:minInt(100, len(stripHTML(bookmark.Status.Content)))],
+					"processed_count": actualProcessed,
+					"total_count":     len(bookmarks),
+				},
+			}:
+			default:
+			}
+		}
+	}
+
+	if s.eventChan != nil {
+		select {
+		case s.eventChan <- ServerEvent{
+			Type: "batch_complete",
+			Payload: map[string]interface{}{
+				"account":   s.account.Name,
+				"source":    s.sourceOrDefault(),
+				"processed": actualProcessed,
+				"total":     len(bookmarks),
+				"skipped":   len(bookmarks) - actualProcessed,
+			},
+		}:
+		default:
+		}
+	}
+
+	zlog.Info().Int("processed", actualProcessed).Int("total", len(bookmarks)).Int("skipped", len(bookmarks)-actualProcessed).Msg("Bookmark batch processing completed")
+
+	return nil
+}
+
+// =============================================================================
+// WEB SERVER AND EVENTS
+// =============================================================================
+
+type ServerEvent struct {
+	Type    string      `json:"type"`
+	Payload interface{} `json:"payload"`
+}
+
+type EventBroadcaster struct {
+	clients  map[chan ServerEvent]bool
+	mutex    sync.RWMutex
+	shutdown bool
+}
+
+func newEventBroadcaster() *EventBroadcaster {
+	return &EventBroadcaster{
+		clients: make(map[chan ServerEvent]bool),
+	}
+}
+
+func (eb *EventBroadcaster) addClient(client chan ServerEvent) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+	eb.clients[client] = true
+}
+
+func (eb *EventBroadcaster) removeClient(client chan ServerEvent) {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	if eb.shutdown {
+		return
+	}
+
+	if _, exists := eb.clients[client]; exists {
+		delete(eb.clients, client)
+		close(client)
+	}
+}
+
+// clientCount returns how many event stream clients are connected.
+func (eb *EventBroadcaster) clientCount() int {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+	return len(eb.clients)
+}
+
+func (eb *EventBroadcaster) broadcast(event ServerEvent) {
+	eb.mutex.RLock()
+	defer eb.mutex.RUnlock()
+
+	if eb.shutdown {
+		return
+	}
+
+	for client := range eb.clients {
+		select {
+		case client <- event:
+		default:
+		}
+	}
+}
+
+func (eb *EventBroadcaster) closeAllClients() {
+	eb.mutex.Lock()
+	defer eb.mutex.Unlock()
+
+	eb.shutdown = true
+	for client := range eb.clients {
+		close(client)
+	}
+	eb.clients = make(map[chan ServerEvent]bool)
+}
+
+type WebServer struct {
+	config      *Config
+	db          *Database
+	broadcaster *EventBroadcaster
+	server      *http.Server
+	// rateLimiters holds each account's API rate limiter by account name,
+	// reported in /api/stats.
+	rateLimiters map[string]*RateLimiter
+	// syncHealth holds the health of each service, reported in /readyz and
+	// /api/stats.
+	syncHealth []*syncHealth
+	// loginMu serializes password checks.
+	loginMu sync.Mutex
+	// certificate is the TLS certificate when web.tls_cert is set.
+	certificate *certificateReloader
+}
+
+func newWebServer(cfg *Config, db *Database, eventChan <-chan ServerEvent) *WebServer {
+	broadcaster := newEventBroadcaster()
+
+	go func() {
+		for event := range eventChan {
+			broadcaster.broadcast(event)
+		}
+	}()
+
+	return &WebServer{
+		config:      cfg,
+		db:          db,
+		broadcaster: broadcaster,
+	}
+}
+
+func (ws *WebServer) setupRoutes() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	webSubFS, err := fs.Sub(webFS, "web")
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to create web sub-filesystem")
+		return mux
+	}
+
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.FS(webSubFS))))
+	mux.HandleFunc("GET /{$}", ws.handleIndex)
+	mux.HandleFunc("/api/", ws.handleAPINotFound(mux))
+	mux.HandleFunc("POST /api/search", ws.handleSearch)
+	mux.HandleFunc("GET /api/stats", ws.handleStats)
+	mux.HandleFunc("GET /api/accounts", ws.handleAccounts)
+	mux.HandleFunc("GET /api/events", ws.handleEvents)
+	mux.HandleFunc("GET /media/", ws.handleMedia)
+	mux.HandleFunc("GET /api/bookmarks", ws.handleListBookmarks)
+	mux.HandleFunc("GET /api/bookmarks/{id}", ws.handleGetBookmark)
+	mux.HandleFunc("DELETE /api/bookmarks/{id}", ws.handleDeleteBookmark)
+	mux.HandleFunc("GET /api/bookmarks/{id}/thread", ws.handleThread)
+	mux.HandleFunc("PUT /api/bookmarks/{id}/tags", ws.handleBookmarkAnnotation("tags"))
+	mux.HandleFunc("PUT /api/bookmarks/{id}/note", ws.handleBookmarkAnnotation("note"))
+	mux.HandleFunc("PUT /api/bookmarks/{id}/collections", ws.handleBookmarkAnnotation("collections"))
+	mux.HandleFunc("GET /api/collections", ws.handleCollections)
+	mux.HandleFunc("GET /api/export", ws.handleExport)
+	mux.HandleFunc("GET /feeds/bookmarks.atom", ws.handleFeed("atom"))
+	mux.HandleFunc("GET /feeds/bookmarks.rss", ws.handleFeed("rss"))
+	mux.HandleFunc("GET /feeds/bookmarks.json", ws.handleFeed("json"))
+	mux.HandleFunc("GET /api/session", ws.handleSession)
+	mux.HandleFunc("POST /api/login", ws.handleLogin)
+	mux.HandleFunc("POST /api/logout", ws.handleLogout)
+	mux.HandleFunc("GET /metrics", ws.handleMetrics)
+	mux.HandleFunc("GET /healthz", ws.handleHealth)
+	mux.HandleFunc("GET /readyz", ws.handleReady)
+
+	return mux
+}
+
+func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
+	data, err := webFS.ReadFile("web/index.html")
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		zlog.Error().Err(err).Msg("Failed to read index.html")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	if _, err := w.Write(data); err != nil {
+		zlog.Error().Err(err).Msg("failed to write response data")
+	}
+}
+
+func (ws *WebServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var request SearchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	start := time.Now()
+	results, err := ws.db.searchOrRecentBookmarks(&request)
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) {
+		writeAPIError(w, http.StatusBadRequest, queryErr.Error())
+		return
+	}
+	if err != nil {
+		zlog.Error().Err(err).Str("query", request.Query).Msg("Search failed")
+		writeAPIError(w, http.StatusInternalServerError, "Search failed")
+		return
+	}
+	searchDuration.observe(time.Since(start).Seconds())
+	searchResults.observe(float64(len(results)))
+
+	if err := ws.db.attachMediaFiles(results); err != nil {
+		// Results are still useful with remote media links only
+		zlog.Error().Err(err).Msg("Failed to attach archived media")
+	}
+	if err := ws.db.attachAnnotations(results); err != nil {
+		zlog.Error().Err(err).Msg("Failed to attach annotations")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode search results")
+	}
+}
+
+func (ws *WebServer) handleStats(w http.ResponseWriter, r *http.Request) {
+	// Counts cover every archive unless one account is selected; the
+	// backfill state is that of the first configured collection of the
+	// selected or first configured account.
+	account := r.URL.Query().Get("account")
+	stateAccount := account
+	accounts, err := ws.config.accounts()
+	if err == nil && stateAccount == "" {
+		stateAccount = accounts[0].Name
+	}
+	var sources []string
+	if err == nil {
+		sources, err = ws.config.sources()
+	}
+	if err != nil {
+		zlog.Error().Err(err).Msg("Invalid account configuration")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get stats")
+		return
+	}
+
+	totalCount, removedCount, err := ws.db.getBookmarkCounts(account)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to get bookmark count")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get stats")
+		return
+	}
+
+	sourceCounts, err := ws.db.getSourceCounts(account)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to get collection counts")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get stats")
+		return
+	}
+
+	backfillState, err := ws.db.getBackfillStateForOwner(stateAccount, sources[0])
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to get backfill state")
+		backfillState = &BackfillState{}
+	}
+
+	stats := map[string]interface{}{
+		"account":             account,
+		"total_bookmarks":     totalCount,
+		"active_bookmarks":    totalCount - removedCount,
+		"removed_bookmarks":   removedCount,
+		"source_counts":       sourceCounts,
+		"backfill_complete":   backfillState.BackfillComplete,
+		"last_poll_time":      backfillState.LastPollTime,
+		"last_reconcile_time": backfillState.LastReconcileTime,
+		"last_poll_pages":     backfillState.LastPollPages,
+		"updated_at":          time.Now(),
+	}
+	if rateLimiter, ok := ws.rateLimiters[stateAccount]; ok {
+		stats["rate_limit"] = rateLimiter.state()
+	}
+	for _, health := range ws.syncHealth {
+		if status := health.snapshot(); status.Account == stateAccount && status.Source == sources[0] {
+			stats["credentials_verified"] = status.CredentialsVerified
+			stats["sync_stopped"] = status.Stopped
+			stats["last_error"] = status.LastError
+			stats["last_error_at"] = status.LastErrorAt
+			stats["failed_polls"] = status.FailedPolls
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode stats")
+	}
+}
+
+// handleMedia serves an archived media file by its SHA-256 hash, as linked
+// from the media of search results.
+func (ws *WebServer) handleMedia(w http.ResponseWriter, r *http.Request) {
+	hash := strings.TrimPrefix(r.URL.Path, "/media/")
+	if !isMediaHash(hash) {
+		http.NotFound(w, r)
+		return
+	}
+
+	file, err := ws.db.getMediaFileByHash(hash)
+	if err != nil {
+		zlog.Error().Err(err).Str("sha256", hash).Msg("Failed to look up media file")
+		http.Error(w, "Failed to get media file", http.StatusInternalServerError)
+		return
+	}
+	if file == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(mediaFilePath(mediaDirectory(ws.config), hash))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		zlog.Error().Err(err).Str("sha256", hash).Msg("Failed to open media file")
+		http.Error(w, "Failed to get media file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if file.MimeType != "" {
+		w.Header().Set("Content-Type", file.MimeType)
+	}
+	// Content-addressed files never change
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, "", file.DownloadedAt, f)
+}
+
+// handleThread returns the archived thread around a status. The account
+// query parameter selects whose archive to read, the unnamed one by default.
+func (ws *WebServer) handleThread(w http.ResponseWriter, r *http.Request) {
+	statusID := r.PathValue("id")
+	account := r.URL.Query().Get("account")
+
+	sources, err := ws.db.getStatusSources(account, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to look up bookmark")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get thread")
+		return
+	}
+	if len(sources) == 0 {
+		writeAPIError(w, http.StatusNotFound, "Bookmark not found")
+		return
+	}
+
+	thread, err := ws.db.getStatusContext(account, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to get thread context")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get thread")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(thread); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode thread")
+	}
+}
+
+// Limits on what the annotation endpoints accept.
+const (
+	maxAnnotationBody   = 64 << 10
+	maxTagLength        = 64
+	maxCollectionLength = 100
+	maxNoteLength       = 10000
+)
+
+// normalizeTags lowercases tags and drops a leading #, blanks and
+// duplicates, returning them sorted.
+func normalizeTags(tags []string) ([]string, error) {
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		if tag == "" {
+			continue
+		}
+		if strings.ContainsFunc(tag, unicode.IsSpace) {
+			return nil, fmt.Errorf("tag %q must not contain spaces", tag)
+		}
+		if utf8.RuneCountInString(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, maxTagLength)
+		}
+		normalized = append(normalized, tag)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
+
+// normalizeCollections trims collection names and drops blanks and
+// duplicates, returning them sorted.
+func normalizeCollections(collections []string) ([]string, error) {
+	normalized := []string{}
+	for _, collection := range collections {
+		collection = strings.Join(strings.Fields(collection), " ")
+		if collection == "" {
+			continue
+		}
+		if utf8.RuneCountInString(collection) > maxCollectionLength {
+			return nil, fmt.Errorf("collection name %q is longer than %d characters", collection, maxCollectionLength)
+		}
+		normalized = append(normalized, collection)
+	}
+	slices.Sort(normalized)
+	return slices.Compact(normalized), nil
+}
+
+// handleBookmarkAnnotation returns the handler replacing one kind of
+// annotation of an archived status, given as {"tags": [...]}, {"note": "..."}
+// or {"collections": [...]}, which answers with all its annotations. The
+// account query parameter selects whose archive to change, the unnamed one
+// by default.
+func (ws *WebServer) handleBookmarkAnnotation(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ws.updateBookmarkAnnotation(w, r, kind)
+	}
+}
+
+func (ws *WebServer) updateBookmarkAnnotation(w http.ResponseWriter, r *http.Request, kind string) {
+	statusID := r.PathValue("id")
+	account := r.URL.Query().Get("account")
+
+	var request struct {
+		Tags        []string `json:"tags"`
+		Note        string   `json:"note"`
+		Collections []string `json:"collections"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAnnotationBody)).Decode(&request); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	sources, err := ws.db.getStatusSources(account, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to look up bookmark")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to update bookmark")
+		return
+	}
+	if len(sources) == 0 {
+		writeAPIError(w, http.StatusNotFound, "Bookmark not found")
+		return
+	}
+
+	var update func() (*Annotations, error)
+	switch kind {
+	case "tags":
+		tags, err := normalizeTags(request.Tags)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update = func() (*Annotations, error) { return ws.db.setBookmarkTags(account, statusID, tags) }
+	case "note":
+		note := strings.TrimSpace(request.Note)
+		if utf8.RuneCountInString(note) > maxNoteLength {
+			writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("note is longer than %d characters", maxNoteLength))
+			return
+		}
+		update = func() (*Annotations, error) { return ws.db.setBookmarkNote(account, statusID, note) }
+	case "collections":
+		collections, err := normalizeCollections(request.Collections)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		update = func() (*Annotations, error) { return ws.db.setBookmarkCollections(account, statusID, collections) }
+	}
+
+	annotations, err := update()
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to update annotations")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to update bookmark")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(annotations); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode annotations")
+	}
+}
+
+// handleCollections lists the collections of the archive selected by the
+// account query parameter, or of every archive.
+func (ws *WebServer) handleCollections(w http.ResponseWriter, r *http.Request) {
+	collections, err := ws.db.listCollections(r.URL.Query().Get("account"))
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to list collections")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get collections")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(collections); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode collections")
+	}
+}
+
+// searchRequestFromQuery reads a search request from URL query parameters
+// named like the JSON fields of SearchRequest.
+func searchRequestFromQuery(values url.Values) (*SearchRequest, error) {
+	request := &SearchRequest{
+		Query:           values.Get("query"),
+		FilterByAccount: values.Get("filter_by_account"),
+		FilterByState:   values.Get("filter_by_state"),
+		FilterBySource:  values.Get("filter_by_source"),
+		Account:         values.Get("account"),
+		SortBy:          values.Get("sort_by"),
+	}
+	if request.SortBy != "" && request.SortBy != sortByRank && request.SortBy != sortByBookmarked {
+		return nil, fmt.Errorf("invalid sort_by %q (use %s or %s)", request.SortBy, sortByRank, sortByBookmarked)
+	}
+	for name, target := range map[string]*int{"limit": &request.Limit, "offset": &request.Offset} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", name, value)
+		}
+		*target = n
+	}
+	if _, err := parseSearchQuery(request.Query); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+// handleExport streams the bookmarks matching the search request in the
+// query parameters, all of them by default, in the format parameter's
+// format.
+func (ws *WebServer) handleExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if !slices.Contains(exportFormats, format) {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("Unknown export format %q (use %s)", format, strings.Join(exportFormats, ", ")))
+		return
+	}
+	request, err := searchRequestFromQuery(r.URL.Query())
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	contentType, extension := exportContentType(format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="bookmarchive.`+extension+`"`)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	// Once rows are streaming the status is sent, so failures only end the
+	// response early
+	count, err := exportBookmarks(r.Context(), ws.db, request, format, w)
+	if err != nil && r.Context().Err() == nil {
+		zlog.Error().Err(err).Int("exported", count).Msg("Export failed")
+	}
+}
+
+// APIError is the body of every error response under /api/.
+type APIError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// writeAPIError answers an API request with status and a JSON error body.
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(APIError{Error: message, Status: status}); err != nil {
+		zlog.Debug().Err(err).Msg("Failed to encode API error")
+	}
+}
+
+// writeError answers with a JSON error body under /api/ and plain text
+// elsewhere, for the middleware in front of both.
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIError(w, status, message)
+		return
+	}
+	http.Error(w, message, status)
+}
+
+// handleAPINotFound returns the handler for API requests no other route
+// takes: 405 with the allowed methods when the path has routes for other
+// methods, 404 otherwise.
+func (ws *WebServer) handleAPINotFound(mux *http.ServeMux) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var allowed []string
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete} {
+			probe := *r
+			probe.Method = method
+			if _, pattern := mux.Handler(&probe); pattern != "/api/" {
+				allowed = append(allowed, method)
+				if method == http.MethodGet {
+					allowed = append(allowed, http.MethodHead)
+				}
+			}
+		}
+		if len(allowed) == 0 {
+			writeAPIError(w, http.StatusNotFound, "Not found")
+			return
+		}
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeAPIError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// Page sizes of GET /api/bookmarks
+const (
+	defaultBookmarkPageSize = 50
+	maxBookmarkPageSize     = 200
+)
+
+// BookmarkResource is an archived status as the bookmarks API returns it:
+// the stored status as JSON rather than a string, where and when it was
+// archived, and the archive owner's annotations. Rows from before full
+// statuses were stored have no status, only their search text as Text.
+// Account is empty for the unnamed [mastodon] account, and URL is where the
+// bookmark is read or deleted.
+type BookmarkResource struct {
+	StatusID       string          `json:"status_id"`
+	Account        string          `json:"account"`
+	Source         string          `json:"source"`
+	URL            string          `json:"url"`
+	BookmarkedAt   time.Time       `json:"bookmarked_at"`
+	UnbookmarkedAt *time.Time      `json:"unbookmarked_at,omitempty"`
+	DeletedAt      *time.Time      `json:"deleted_at,omitempty"`
+	Status         json.RawMessage `json:"status"`
+	Text           string          `json:"text,omitempty"`
+	Tags           []string        `json:"tags"`
+	Note           string          `json:"note,omitempty"`
+	Collections    []string        `json:"collections"`
+	// Sources lists every collection of the archive holding the status,
+	// given when a single bookmark is requested.
+	Sources []string `json:"sources,omitempty"`
+}
+
+func newBookmarkResource(bookmark *DBBookmark, annotations *Annotations) *BookmarkResource {
+	resource := &BookmarkResource{
+		StatusID:       bookmark.StatusID,
+		Account:        bookmark.OwnerAccount,
+		Source:         bookmark.Source,
+		URL:            bookmarkResourceURL(bookmark),
+		BookmarkedAt:   bookmark.BookmarkedAt,
+		UnbookmarkedAt: bookmark.UnbookmarkedAt,
+		DeletedAt:      bookmark.DeletedAt,
+		Status:         json.RawMessage("null"),
+		Tags:           []string{},
+		Collections:    []string{},
+	}
+	if annotations != nil {
+		resource.Note = annotations.Note
+		if annotations.Tags != nil {
+			resource.Tags = annotations.Tags
+		}
+		if annotations.Collections != nil {
+			resource.Collections = annotations.Collections
+		}
+	}
+
+	var stored struct {
+		Status json.RawMessage `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(bookmark.RawJSON), &stored); err == nil && len(stored.Status) > 0 && string(stored.Status) != "null" {
+		resource.Status = stored.Status
+	} else {
+		resource.Text = bookmark.SearchText
+	}
+	return resource
+}
+
+// bookmarkResourceURL returns the path of one archived copy of a status in
+// the bookmarks API.
+func bookmarkResourceURL(bookmark *DBBookmark) string {
+	query := url.Values{}
+	if bookmark.OwnerAccount != "" {
+		query.Set("account", bookmark.OwnerAccount)
+	}
+	query.Set("source", bookmark.Source)
+	return "/api/bookmarks/" + url.PathEscape(bookmark.StatusID) + "?" + query.Encode()
+}
+
+// BookmarkPage is one page of GET /api/bookmarks. NextCursor, passed as the
+// cursor parameter, fetches the next page; it is empty on the last one.
+type BookmarkPage struct {
+	Bookmarks  []*BookmarkResource `json:"bookmarks"`
+	NextCursor string              `json:"next_cursor,omitempty"`
+}
+
+// bookmarkCursor is the key of the last bookmark of a page, in the order
+// bookmarks are listed newest first.
+type bookmarkCursor struct {
+	BookmarkedAt time.Time `json:"t"`
+	Account      string    `json:"a"`
+	Source       string    `json:"s"`
+	StatusID     string    `json:"i"`
+}
+
+func (c *bookmarkCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseBookmarkCursor(value string) (*bookmarkCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	var cursor bookmarkCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.StatusID == "" {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	return &cursor, nil
+}
+
+// handleListBookmarks lists archived statuses newest first, a page at a
+// time. It takes the filters of /api/export as query parameters, limit for
+// the page size and cursor for where to continue.
+func (ws *WebServer) handleListBookmarks(w http.ResponseWriter, r *http.Request) {
+	values := r.URL.Query()
+	request, err := searchRequestFromQuery(values)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if values.Has("offset") {
+		writeAPIError(w, http.StatusBadRequest, "offset is not supported; use cursor")
+		return
+	}
+	if request.SortBy == sortByRank {
+		writeAPIError(w, http.StatusBadRequest, "bookmarks are listed newest first; use /api/search to sort by rank")
+		return
+	}
+	request.SortBy = sortByBookmarked
+
+	limit := request.Limit
+	if limit == 0 {
+		limit = defaultBookmarkPageSize
+	}
+	limit = min(limit, maxBookmarkPageSize)
+	// One more than a page tells whether there is a next one
+	request.Limit = limit + 1
+
+	if value := values.Get("cursor"); value != "" {
+		request.after, err = parseBookmarkCursor(value)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	page := BookmarkPage{Bookmarks: []*BookmarkResource{}}
+	var last *DBBookmark
+	err = ws.db.eachBookmark(r.Context(), request, func(bookmark *DBBookmark, annotations *Annotations) error {
+		if len(page.Bookmarks) == limit {
+			page.NextCursor = (&bookmarkCursor{
+				BookmarkedAt: last.BookmarkedAt,
+				Account:      last.OwnerAccount,
+				Source:       last.Source,
+				StatusID:     last.StatusID,
+			}).encode()
+			return nil
+		}
+		page.Bookmarks = append(page.Bookmarks, newBookmarkResource(bookmark, annotations))
+		last = bookmark
+		return nil
+	})
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to list bookmarks")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to list bookmarks")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode bookmarks")
+	}
+}
+
+// bookmarkAccount returns the account query parameter, which selects whose
+// archive a single bookmark is read from or deleted in. With named accounts
+// configured it is required, as several of them may archive the same status;
+// a missing or unknown name is answered with 400 and the configured names.
+// Otherwise it defaults to the unnamed archive.
+func (ws *WebServer) bookmarkAccount(w http.ResponseWriter, r *http.Request) (string, bool) {
+	account := r.URL.Query().Get("account")
+	if len(ws.config.Accounts) == 0 {
+		return account, true
+	}
+
+	names := make([]string, len(ws.config.Accounts))
+	for i, configured := range ws.config.Accounts {
+		names[i] = configured.Name
+	}
+	if account == "" {
+		writeAPIError(w, http.StatusBadRequest, "account is required; configured accounts: "+strings.Join(names, ", "))
+		return "", false
+	}
+	if !slices.Contains(names, account) {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("unknown account %q; configured accounts: %s", account, strings.Join(names, ", ")))
+		return "", false
+	}
+	return account, true
+}
+
+// handleGetBookmark returns one archived status from the archive selected
+// by the account query parameter. source selects the collection, the first
+// holding the status by default.
+func (ws *WebServer) handleGetBookmark(w http.ResponseWriter, r *http.Request) {
+	statusID := r.PathValue("id")
+	account, ok := ws.bookmarkAccount(w, r)
+	if !ok {
+		return
+	}
+	source := r.URL.Query().Get("source")
+
+	sources, err := ws.db.getStatusSources(account, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to look up bookmark")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get bookmark")
+		return
+	}
+	if len(sources) == 0 || (source != "" && !slices.Contains(sources, source)) {
+		writeAPIError(w, http.StatusNotFound, "Bookmark not found")
+		return
+	}
+	if source == "" {
+		source = sources[0]
+	}
+
+	bookmark, err := ws.db.getBookmarkForOwner(account, source, statusID)
+	if err == nil && bookmark == nil {
+		// Removed since the sources were read
+		writeAPIError(w, http.StatusNotFound, "Bookmark not found")
+		return
+	}
+	var annotations map[annotationKey]*Annotations
+	if err == nil {
+		annotations, err = ws.db.getAnnotationsForStatuses([]string{statusID})
+	}
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to get bookmark")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get bookmark")
+		return
+	}
+
+	resource := newBookmarkResource(bookmark, annotations[annotationKey{account, statusID}])
+	resource.Sources = sources
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(resource); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode bookmark")
+	}
+}
+
+// handleDeleteBookmark removes an archived status from the archive selected
+// by the account query parameter, from every collection or only the one
+// named by source. Nothing changes on the server, so a status still
+// bookmarked there is archived again once a poll or backfill finds it.
+func (ws *WebServer) handleDeleteBookmark(w http.ResponseWriter, r *http.Request) {
+	statusID := r.PathValue("id")
+	account, ok := ws.bookmarkAccount(w, r)
+	if !ok {
+		return
+	}
+	source := r.URL.Query().Get("source")
+
+	deleted, err := ws.db.deleteBookmark(account, source, statusID)
+	if err != nil {
+		zlog.Error().Err(err).Str("status_id", statusID).Msg("Failed to delete bookmark")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to delete bookmark")
+		return
+	}
+	if deleted == 0 {
+		writeAPIError(w, http.StatusNotFound, "Bookmark not found")
+		return
+	}
+	zlog.Info().Str("account", account).Str("status_id", statusID).Int64("copies", deleted).Msg("Deleted archived bookmark")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ArchiveAccount describes a configured account for the account selector.
+type ArchiveAccount struct {
+	Name   string       `json:"name"`
+	Server string       `json:"server"`
+	User   *UserAccount `json:"user,omitempty"`
+}
+
+func (ws *WebServer) handleAccounts(w http.ResponseWriter, r *http.Request) {
+	accounts, err := ws.config.accounts()
+	if err != nil {
+		zlog.Error().Err(err).Msg("Invalid account configuration")
+		writeAPIError(w, http.StatusInternalServerError, "Failed to get accounts")
+		return
+	}
+
+	result := make([]ArchiveAccount, 0, len(accounts))
+	for _, account := range accounts {
+		user, err := ws.db.getUserAccountForOwner(account.Name)
+		if err != nil {
+			zlog.Error().Err(err).Str("account", account.Name).Msg("Failed to get user account")
+			writeAPIError(w, http.StatusInternalServerError, "Failed to get accounts")
+			return
+		}
+		result = append(result, ArchiveAccount{Name: account.Name, Server: account.Server, User: user})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode accounts")
+	}
+}
+
+func (ws *WebServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	client := make(chan ServerEvent, 50)
+	ws.broadcaster.addClient(client)
+	defer ws.broadcaster.removeClient(client)
+
+	ctx := r.Context()
+
+	fmt.Fprint(w, "data: {\"type\":\"connected\"}\n\n")
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	go func() {
+		if totalCount, removedCount, err := ws.db.getBookmarkCounts(""); err == nil {
+			select {
+			case client <- ServerEvent{
+				Type: "stats",
+				Payload: map[string]interface{}{
+					"total_bookmarks":   totalCount,
+					"removed_bookmarks": removedCount,
+				},
+			}:
+			case <-ctx.Done():
+				return
+			default:
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-client:
+			if !ok {
+				return
+			}
+
+			data, err := json.Marshal(event)
+			if err != nil {
+				zlog.Debug().Err(err).Msg("Failed to marshal SSE event")
+				continue
+			}
+
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+
+		case <-ticker.C:
+			if _, err := fmt.Fprint(w, "data: {\"type\":\"heartbeat\"}\n\n"); err != nil {
+				return
+			}
+
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (ws *WebServer) start() error {
+	if err := validateAuthConfig(ws.config); err != nil {
+		return err
+	}
+	if err := validateListenConfig(ws.config); err != nil {
+		return err
+	}
+
+	ws.server = &http.Server{
+		Handler:      ws.handler(),
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 0,
+		IdleTimeout:  120 * time.Second,
+	}
+	if ws.config.Web.TLSCert != "" {
+		certificate, err := newCertificateReloader(ws.config.Web.TLSCert, ws.config.Web.TLSKey)
+		if err != nil {
+			return err
+		}
+		ws.certificate = certificate
+		ws.server.TLSConfig = &tls.Config{
+			GetCertificate: certificate.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	}
+
+	listeners, err := ws.listen()
+	if err != nil {
+		return err
+	}
+	for _, listener := range listeners {
+		// A reverse proxy in front of a Unix socket terminates TLS itself
+		useTLS := ws.certificate != nil && listener.Addr().Network() != "unix"
+		zlog.Info().Str("address", listener.Addr().String()).Bool("tls", useTLS).Msg("Starting web server")
+
+		go func() {
+			var err error
+			if useTLS {
+				err = ws.server.ServeTLS(listener, "", "")
+			} else {
+				err = ws.server.Serve(listener)
+			}
+			if err != nil && err != http.ErrServerClosed {
+				zlog.Error().Err(err).Msg("Web server error")
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (ws *WebServer) stop() error {
+	if ws.server == nil {
+		return nil
+	}
+
+	zlog.Info().Msg("Stopping web server")
+
+	if ws.broadcaster != nil {
+		ws.broadcaster.closeAllClients()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+
+	if err := ws.server.Shutdown(ctx); err != nil {
+		if err := ws.server.Close(); err != nil {
+			return fmt.Errorf("failed to force close server: %w", err)
+		}
+		zlog.Debug().Msg("Web server force closed after graceful shutdown timeout")
+		return nil
+	}
+
+	return nil
+}
+
+// =============================================================================
+// WEB SERVER LISTENERS
+// =============================================================================
+
+const (
+	defaultSocketMode = 0o660
+	// systemdFirstFD is the first file descriptor systemd passes activated
+	// sockets on.
+	systemdFirstFD = 3
+)
+
+// certificateReloader serves a TLS certificate that can be reread from its
+// files while the server runs, so renewed certificates take effect on
+// SIGHUP without dropping connections.
+type certificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// reload rereads the certificate files, keeping the current certificate if
+// they cannot be loaded.
+func (c *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	c.mu.Lock()
+	c.certificate = &certificate
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.certificate, nil
+}
+
+// validateListenConfig checks the TLS and Unix socket settings of [web].
+func validateListenConfig(cfg *Config) error {
+	if (cfg.Web.TLSCert == "") != (cfg.Web.TLSKey == "") {
+		return fmt.Errorf("web.tls_cert and web.tls_key must be set together")
+	}
+	if _, err := parseSocketMode(cfg.Web.SocketMode); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseSocketMode reads web.socket_mode as octal permissions, 0660 when
+// unset.
+func parseSocketMode(value string) (os.FileMode, error) {
+	if value == "" {
+		return defaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid web.socket_mode %q (use octal permissions like 0660)", value)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listen opens the web server's sockets: those passed by systemd socket
+// activation when there are any, or else the Unix socket or the TCP
+// address in [web].
+func (ws *WebServer) listen() ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil || len(listeners) > 0 {
+		return listeners, err
+	}
+
+	if ws.config.Web.Socket != "" {
+		mode, err := parseSocketMode(ws.config.Web.SocketMode)
+		if err != nil {
+			return nil, err
+		}
+		listener, err := listenUnixSocket(ws.config.Web.Socket, mode)
+		if err != nil {
+			return nil, err
+		}
+		return []net.Listener{listener}, nil
+	}
+
+	addr := net.JoinHostPort(ws.config.Web.Listen, strconv.Itoa(ws.config.Web.Port))
+	if !ws.authRequired() && !isLoopbackHost(ws.config.Web.Listen) {
+		zlog.Warn().Str("address", addr).Msg("Web server listens beyond this machine without authentication; set auth.tokens or auth.password_hash")
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return []net.Listener{listener}, nil
+}
+
+// listenUnixSocket listens on a Unix socket with the given permissions,
+// replacing a socket file left behind by a process that no longer runs.
+func listenUnixSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+	return listener, nil
+}
+
+// systemdListeners returns the sockets passed by systemd socket activation,
+// or none when the process was not started that way.
+func systemdListeners() ([]net.Listener, error) {
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	// The sockets are for this process, not the ones it starts
+	for _, name := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(name)
+	}
+
+	fds := make([]uintptr, count)
+	for i := range fds {
+		fds[i] = uintptr(systemdFirstFD + i)
+	}
+	return inheritedListeners(fds, names)
+}
+
+// inheritedListeners turns inherited file descriptors into listeners,
+// named for error messages by names where given.
+func inheritedListeners(fds []uintptr, names []string) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for i, fd := range fds {
+		name := fmt.Sprintf("fd %d", fd)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		file := os.NewFile(fd, name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to use socket %s from systemd: %w", name, err)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// reloadCertificate rereads the TLS certificate files after SIGHUP.
+func (ws *WebServer) reloadCertificate() {
+	if ws.certificate == nil {
+		zlog.Info().Msg("No TLS certificate to reload")
+		return
+	}
+	if err := ws.certificate.reload(); err != nil {
+		zlog.Error().Err(err).Msg("Failed to reload TLS certificate; still serving the previous one")
+		return
+	}
+	zlog.Info().Str("certificate", ws.certificate.certFile).Msg("Reloaded TLS certificate")
+}
+
+// =============================================================================
+// WEB AUTHENTICATION
+// =============================================================================
+
+const (
+	sessionCookieName = "bookmarchive_session"
+	// csrfHeader carries a session's CSRF token on requests that change
+	// anything, which other sites cannot set on requests they trigger.
+	csrfHeader             = "X-CSRF-Token"
+	defaultSessionLifetime = 30 * 24 * time.Hour
+	maxLoginBody           = 4 << 10
+)
+
+// Password hashes are PBKDF2 with HMAC-SHA256, stored as
+// pbkdf2-sha256$<iterations>$<salt>$<key> with the salt and key in unpadded
+// base64.
+const (
+	passwordHashScheme     = "pbkdf2-sha256"
+	passwordHashIterations = 600000
+	passwordSaltLength     = 16
+	passwordKeyLength      = 32
+)
+
+// AuthSession tells the web interface whether it needs to log in, and
+// gives a logged-in browser its CSRF token.
+type AuthSession struct {
+	AuthRequired  bool   `json:"auth_required"`
+	Authenticated bool   `json:"authenticated"`
+	CSRFToken     string `json:"csrf_token,omitempty"`
+}
+
+// randomToken returns 32 random bytes in hex, for session and API tokens.
+func randomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := crand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// pbkdf2SHA256 derives a key from a password as in RFC 8018.
+func pbkdf2SHA256(password, salt []byte, iterations, keyLength int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLength; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(binary.BigEndian.AppendUint32(nil, block))
+		u := prf.Sum(nil)
+		t := slices.Clone(u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLength]
+}
+
+// hashPassword returns the hash of a password to set as auth.password_hash.
+func hashPassword(password string, iterations int) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := crand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	key := pbkdf2SHA256([]byte(password), salt, iterations, passwordKeyLength)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+type passwordHash struct {
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+func parsePasswordHash(encoded string) (*passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return nil, fmt.Errorf("password hash is not a %s hash from the hash-password command", passwordHashScheme)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return nil, fmt.Errorf("invalid password hash iterations %q", parts[1])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid password hash salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid password hash key")
+	}
+	return &passwordHash{iterations: iterations, salt: salt, key: key}, nil
+}
+
+// checkPassword reports whether password matches an encoded password hash.
+func checkPassword(encoded, password string) (bool, error) {
+	hash, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	key := pbkdf2SHA256([]byte(password), hash.salt, hash.iterations, len(hash.key))
+	return subtle.ConstantTimeCompare(key, hash.key) == 1, nil
+}
+
+// validateAuthConfig checks the [auth] settings before the web server
+// starts, so a typo fails loudly rather than locking everyone out.
+func validateAuthConfig(cfg *Config) error {
+	for _, token := range cfg.Auth.Tokens {
+		if strings.TrimSpace(token) == "" {
+			return fmt.Errorf("auth.tokens must not contain empty tokens")
+		}
+	}
+	if cfg.Auth.PasswordHash != "" {
+		if _, err := parsePasswordHash(cfg.Auth.PasswordHash); err != nil {
+			return fmt.Errorf("invalid auth.password_hash: %w", err)
+		}
+	}
+	if cfg.Auth.SessionLifetime != "" {
+		if lifetime, err := time.ParseDuration(cfg.Auth.SessionLifetime); err != nil || lifetime <= 0 {
+			return fmt.Errorf("invalid auth.session_lifetime %q", cfg.Auth.SessionLifetime)
+		}
+	}
+	return nil
+}
+
+func (ws *WebServer) authRequired() bool {
+	return len(ws.config.Auth.Tokens) > 0 || ws.config.Auth.PasswordHash != ""
+}
+
+func (ws *WebServer) sessionLifetime() time.Duration {
+	lifetime, err := time.ParseDuration(ws.config.Auth.SessionLifetime)
+	if err != nil || lifetime <= 0 {
+		return defaultSessionLifetime
+	}
+	return lifetime
+}
+
+// handler wraps the routes in the CORS allowlist and, when configured,
+// authentication.
+func (ws *WebServer) handler() http.Handler {
+	return ws.withCORS(ws.withAuth(ws.setupRoutes()))
+}
+
+// withCORS lets scripts on the origins in web.cors_origins call the API.
+// Credentials are never allowed cross-origin, so such clients send a bearer
+// token rather than riding on a browser session.
+func (ws *WebServer) withCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Origin")
+		origin := r.Header.Get("Origin")
+		if origin == "" || !ws.corsAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Cache-Control")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (ws *WebServer) corsAllowed(origin string) bool {
+	for _, allowed := range ws.config.Web.CORSOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicPath reports whether a path is served without authentication:
+// the page and assets of the web interface, which show the login form, the
+// login itself, and the health checks for probes.
+func isPublicPath(path string) bool {
+	switch path {
+	case "/", "/api/login", "/api/session", "/healthz", "/readyz":
+		return true
+	}
+	return strings.HasPrefix(path, "/static/")
+}
+
+// withAuth requires a bearer token or a browser session on everything but
+// the public paths once tokens or a password are configured. Requests on
+// a session that change anything must send its CSRF token.
+func (ws *WebServer) withAuth(next http.Handler) http.Handler {
+	if !ws.authRequired() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isPublicPath(r.URL.Path) || ws.bearerAuthenticated(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		session, err := ws.requestSession(r)
+		if err != nil {
+			zlog.Error().Err(err).Msg("Failed to check session")
+			writeError(w, r, http.StatusInternalServerError, "Internal server error")
+			return
+		}
+		if session == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="bookmarchive"`)
+			writeError(w, r, http.StatusUnauthorized, "Authentication required")
+			return
+		}
+		if !isSafeMethod(r.Method) && subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(session.CSRFToken)) != 1 {
+			writeError(w, r, http.StatusForbidden, "Missing or invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackHost reports whether a listen address only accepts connections
+// from this machine.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// bearerAuthenticated reports whether the request carries one of the
+// configured tokens. Feed readers, which often cannot send headers, may
+// pass it as the token parameter of a feed URL instead.
+func (ws *WebServer) bearerAuthenticated(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok && strings.HasPrefix(r.URL.Path, "/feeds/") {
+		token = r.URL.Query().Get("token")
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return false
+	}
+	// Comparing hashes keeps the comparison constant-time whatever the
+	// token lengths
+	sum := sha256.Sum256([]byte(token))
+	valid := false
+	for _, configured := range ws.config.Auth.Tokens {
+		configuredSum := sha256.Sum256([]byte(configured))
+		if subtle.ConstantTimeCompare(sum[:], configuredSum[:]) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// requestSession returns the live session named by the request's session
+// cookie, or nil.
+func (ws *WebServer) requestSession(r *http.Request) (*WebSession, error) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil || cookie.Value == "" {
+		return nil, nil
+	}
+	return ws.db.getWebSession(cookie.Value)
+}
+
+// secureCookies reports whether session cookies should be sent over HTTPS
+// only, as the request or the configured base URL uses it.
+func (ws *WebServer) secureCookies(r *http.Request) bool {
+	return r.TLS != nil || strings.HasPrefix(ws.config.Web.BaseURL, "https://")
+}
+
+func (ws *WebServer) handleSession(w http.ResponseWriter, r *http.Request) {
+	status := AuthSession{AuthRequired: ws.authRequired(), Authenticated: !ws.authRequired()}
+	if status.AuthRequired {
+		session, err := ws.requestSession(r)
+		if err != nil {
+			zlog.Error().Err(err).Msg("Failed to check session")
+			writeAPIError(w, http.StatusInternalServerError, "Failed to get session")
+			return
+		}
+		if session != nil {
+			status.Authenticated = true
+			status.CSRFToken = session.CSRFToken
+		} else {
+			status.Authenticated = ws.bearerAuthenticated(r)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode session")
+	}
+}
+
+// handleLogin starts a browser session when the posted password matches
+// auth.password_hash. Only JSON bodies are accepted, which a form on
+// another site cannot send without the browser asking first.
+func (ws *WebServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	if ws.config.Auth.PasswordHash == "" {
+		writeAPIError(w, http.StatusNotFound, "Password login is not configured")
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Expected a JSON body")
+		return
+	}
+
+	var body struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxLoginBody)).Decode(&body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+
+	// Checking one password at a time bounds how fast it can be guessed
+	ws.loginMu.Lock()
+	ok, err := checkPassword(ws.config.Auth.PasswordHash, body.Password)
+	ws.loginMu.Unlock()
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to check password")
+		writeAPIError(w, http.StatusInternalServerError, "Login failed")
+		return
+	}
+	if !ok {
+		zlog.Warn().Str("remote_addr", r.RemoteAddr).Msg("Failed web login")
+		writeAPIError(w, http.StatusUnauthorized, "Wrong password")
+		return
+	}
+
+	lifetime := ws.sessionLifetime()
+	token, session, err := ws.db.createWebSession(lifetime)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to create session")
+		writeAPIError(w, http.StatusInternalServerError, "Login failed")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(lifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   ws.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(AuthSession{AuthRequired: true, Authenticated: true, CSRFToken: session.CSRFToken}); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode session")
+	}
+}
+
+// handleLogout ends the browser session, if there is one.
+func (ws *WebServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil && cookie.Value != "" {
+		if err := ws.db.deleteWebSession(cookie.Value); err != nil {
+			zlog.Error().Err(err).Msg("Failed to delete session")
+			writeAPIError(w, http.StatusInternalServerError, "Logout failed")
+			return
+		}
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   ws.secureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// =============================================================================
+// BOOKMARK FEEDS
+// =============================================================================
+
+const (
+	// defaultFeedLimit is how many bookmarks a feed lists unless its limit
+	// parameter asks for more, up to maxFeedLimit.
+	defaultFeedLimit = 50
+	maxFeedLimit     = 200
+)
+
+// feedContentTypes are the media types of the feed formats, keyed by the
+// extension of their endpoint.
+var feedContentTypes = map[string]string{
+	"atom": "application/atom+xml; charset=utf-8",
+	"rss":  "application/rss+xml; charset=utf-8",
+	"json": "application/feed+json; charset=utf-8",
+}
+
+// feedContentPolicy sanitizes status HTML for feed readers, which show it
+// as it is.
+var feedContentPolicy = bluemonday.UGCPolicy()
+
+// feed is a list of bookmarks, newest first, to render in one of the feed
+// formats.
+type feed struct {
+	Title   string
+	FeedURL string
+	HomeURL string
+	// Updated is the latest Updated of the entries, zero for an empty feed.
+	Updated time.Time
+	Entries []feedEntry
+}
+
+// feedEntry is one bookmark in a feed. Published is when it was bookmarked,
+// so statuses bookmarked long after they were posted still show up as new,
+// and Updated when anything about it last changed in the archive.
+type feedEntry struct {
+	ID         string
+	URL        string
+	Title      string
+	Author     string
+	Content    string
+	Published  time.Time
+	Updated    time.Time
+	Categories []string
+	Enclosures []feedEnclosure
+}
+
+// feedEnclosure is a media attachment of a feed entry, linking to the
+// archived copy when there is one.
+type feedEnclosure struct {
+	URL    string
+	Type   string
+	Length int64
+}
+
+// handleFeed serves the newest bookmarks, or those matching the search
+// request in the query parameters, as a feed in the given format. The ETag
+// is a hash of the feed and Last-Modified the time of its latest change, so
+// readers that send either back get 304 Not Modified until it changes.
+func (ws *WebServer) handleFeed(format string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		request, err := searchRequestFromQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if request.Limit == 0 {
+			request.Limit = defaultFeedLimit
+		}
+		request.Limit = min(request.Limit, maxFeedLimit)
+		if request.SortBy == "" {
+			request.SortBy = sortByBookmarked
+		}
+
+		results, err := ws.db.searchOrRecentBookmarks(request)
+		var queryErr *QueryError
+		if errors.As(err, &queryErr) {
+			http.Error(w, queryErr.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			zlog.Error().Err(err).Str("query", request.Query).Msg("Feed search failed")
+			http.Error(w, "Failed to get feed", http.StatusInternalServerError)
+			return
+		}
+		if err := ws.db.attachMediaFiles(results); err != nil {
+			// Entries still link the remote media
+			zlog.Error().Err(err).Msg("Failed to attach archived media")
+		}
+		if err := ws.db.attachAnnotations(results); err != nil {
+			zlog.Error().Err(err).Msg("Failed to attach annotations")
+		}
+
+		baseURL := ws.baseURL(r)
+		f := newBookmarkFeed(results, baseURL, baseURL+r.URL.RequestURI(), request.Query)
+		body, err := renderFeed(f, format)
+		if err != nil {
+			zlog.Error().Err(err).Str("format", format).Msg("Failed to render feed")
+			http.Error(w, "Failed to get feed", http.StatusInternalServerError)
+			return
+		}
+
+		sum := sha256.Sum256(body)
+		w.Header().Set("Content-Type", feedContentTypes[format])
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		http.ServeContent(w, r, "", f.Updated, bytes.NewReader(body))
+	}
+}
+
+// baseURL is the address links in responses start with: web.base_url, or
+// else the scheme and host the request was made to.
+func (ws *WebServer) baseURL(r *http.Request) string {
+	if ws.config.Web.BaseURL != "" {
+		return strings.TrimSuffix(ws.config.Web.BaseURL, "/")
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func newBookmarkFeed(results []*SearchResult, baseURL, feedURL, query string) *feed {
+	f := &feed{
+		Title:   "Bookmarchive",
+		FeedURL: feedURL,
+		HomeURL: baseURL + "/",
+	}
+	if query = strings.TrimSpace(query); query != "" {
+		f.Title += ": " + query
+	}
+	for _, result := range results {
+		entry := newFeedEntry(result, baseURL)
+		if entry.Updated.After(f.Updated) {
+			f.Updated = entry.Updated
+		}
+		f.Entries = append(f.Entries, entry)
+	}
+	return f
+}
+
+func newFeedEntry(result *SearchResult, baseURL string) feedEntry {
+	bookmark := result.Bookmark
+	annotations := result.Annotations
+	if annotations == nil {
+		annotations = &Annotations{}
+	}
+	exported := newExportedBookmark(bookmark, annotations)
+
+	entry := feedEntry{
+		ID:         "urn:bookmarchive:" + strings.Join([]string{bookmark.OwnerAccount, bookmark.Source, bookmark.StatusID}, ":"),
+		URL:        exported.URL,
+		Title:      exported.title(),
+		Author:     exported.AuthorName,
+		Published:  bookmark.BookmarkedAt,
+		Updated:    bookmark.BookmarkedAt,
+		Categories: append(slices.Clone(exported.Hashtags), annotations.Tags...),
+	}
+	if entry.Author == "" {
+		entry.Author = exported.Author
+	}
+	for _, changed := range []*time.Time{bookmark.UnbookmarkedAt, bookmark.DeletedAt, annotations.UpdatedAt} {
+		if changed != nil && changed.After(entry.Updated) {
+			entry.Updated = *changed
+		}
+	}
+
+	var content strings.Builder
+	if exported.SpoilerText != "" {
+		content.WriteString("<p><strong>CW: " + html.EscapeString(exported.SpoilerText) + "</strong></p>")
+	}
+	if exported.Content != "" {
+		content.WriteString(exported.Content)
+	} else {
+		// Search text from before full statuses were stored keeps entities
+		content.WriteString("<p>" + html.EscapeString(html.UnescapeString(exported.Text)) + "</p>")
+	}
+	if annotations.Note != "" {
+		content.WriteString("<p><em>Note: " + html.EscapeString(annotations.Note) + "</em></p>")
+	}
+	entry.Content = feedContentPolicy.Sanitize(content.String())
+
+	var full Bookmark
+	if err := json.Unmarshal([]byte(bookmark.RawJSON), &full); err != nil {
+		return entry
+	}
+	archived := make(map[string]*MediaFile)
+	for _, file := range result.Media {
+		if file.Variant == "original" {
+			archived[file.MediaID] = file
+		}
+	}
+	for _, media := range full.Status.MediaAttachments {
+		if file, ok := archived[media.ID]; ok {
+			entry.Enclosures = append(entry.Enclosures, feedEnclosure{
+				URL:    baseURL + "/media/" + file.SHA256,
+				Type:   file.MimeType,
+				Length: file.Size,
+			})
+		} else if media.URL != "" {
+			entry.Enclosures = append(entry.Enclosures, feedEnclosure{URL: media.URL, Type: remoteMediaType(media.URL)})
+		}
+	}
+	return entry
+}
+
+// remoteMediaType guesses the media type of an attachment that was not
+// archived from the extension of its URL.
+func remoteMediaType(mediaURL string) string {
+	if u, err := url.Parse(mediaURL); err == nil {
+		if mediaType := mime.TypeByExtension(path.Ext(u.Path)); mediaType != "" {
+			return mediaType
+		}
+	}
+	return "application/octet-stream"
+}
+
+func renderFeed(f *feed, format string) ([]byte, error) {
+	switch format {
+	case "atom":
+		return renderAtomFeed(f)
+	case "rss":
+		return renderRSSFeed(f)
+	case "json":
+		return renderJSONFeed(f)
+	}
+	return nil, fmt.Errorf("unknown feed format %q", format)
+}
+
+type atomFeed struct {
+	XMLName   xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title     string      `xml:"title"`
+	ID        string      `xml:"id"`
+	Updated   string      `xml:"updated"`
+	Links     []atomLink  `xml:"link"`
+	Author    atomPerson  `xml:"author"`
+	Generator string      `xml:"generator"`
+	Entries   []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel    string `xml:"rel,attr,omitempty"`
+	Href   string `xml:"href,attr"`
+	Type   string `xml:"type,attr,omitempty"`
+	Length int64  `xml:"length,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title      string         `xml:"title"`
+	ID         string         `xml:"id"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Links      []atomLink     `xml:"link"`
+	Author     *atomPerson    `xml:"author"`
+	Categories []atomCategory `xml:"category"`
+	Content    atomContent    `xml:"content"`
+}
+
+func renderAtomFeed(f *feed) ([]byte, error) {
+	// Atom requires an updated time even for a feed with no entries
+	updated := f.Updated
+	if updated.IsZero() {
+		updated = time.Unix(0, 0)
+	}
+	doc := atomFeed{
+		Title:   f.Title,
+		ID:      f.FeedURL,
+		Updated: updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Rel: "self", Href: f.FeedURL, Type: "application/atom+xml"},
+			{Rel: "alternate", Href: f.HomeURL, Type: "text/html"},
+		},
+		// Entries without an author of their own take the feed's
+		Author:    atomPerson{Name: "Bookmarchive"},
+		Generator: "Bookmarchive " + version,
+	}
+	for _, entry := range f.Entries {
+		item := atomEntry{
+			Title:     entry.Title,
+			ID:        entry.ID,
+			Published: entry.Published.UTC().Format(time.RFC3339),
+			Updated:   entry.Updated.UTC().Format(time.RFC3339),
+			Content:   atomContent{Type: "html", Body: entry.Content},
+		}
+		if entry.URL != "" {
+			item.Links = append(item.Links, atomLink{Rel: "alternate", Href: entry.URL, Type: "text/html"})
+		}
+		for _, enclosure := range entry.Enclosures {
+			item.Links = append(item.Links, atomLink{Rel: "enclosure", Href: enclosure.URL, Type: enclosure.Type, Length: enclosure.Length})
+		}
+		if entry.Author != "" {
+			item.Author = &atomPerson{Name: entry.Author}
+		}
+		for _, category := range entry.Categories {
+			item.Categories = append(item.Categories, atomCategory{Term: category})
+		}
+		doc.Entries = append(doc.Entries, item)
+	}
+	return marshalFeedXML(doc)
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	AtomNS  string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	Self          atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate,omitempty"`
+	Generator     string    `xml:"generator"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link,omitempty"`
+	GUID        rssGUID       `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Description string        `xml:"description"`
+	Categories  []string      `xml:"category"`
+	Enclosure   *rssEnclosure `xml:"enclosure"`
+}
+
+func renderRSSFeed(f *feed) ([]byte, error) {
+	doc := rssFeed{
+		Version: "2.0",
+		AtomNS:  "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       f.Title,
+			Link:        f.HomeURL,
+			Description: "Bookmarks archived by Bookmarchive",
+			Self:        atomLink{Rel: "self", Href: f.FeedURL, Type: "application/rss+xml"},
+			Generator:   "Bookmarchive " + version,
+		},
+	}
+	if !f.Updated.IsZero() {
+		doc.Channel.LastBuildDate = f.Updated.UTC().Format(time.RFC1123Z)
+	}
+	for _, entry := range f.Entries {
+		item := rssItem{
+			Title:       entry.Title,
+			Link:        entry.URL,
+			GUID:        rssGUID{Value: entry.ID},
+			PubDate:     entry.Published.UTC().Format(time.RFC1123Z),
+			Description: entry.Content,
+			Categories:  entry.Categories,
+		}
+		// RSS allows one enclosure per item
+		if len(entry.Enclosures) > 0 {
+			enclosure := entry.Enclosures[0]
+			item.Enclosure = &rssEnclosure{URL: enclosure.URL, Length: enclosure.Length, Type: enclosure.Type}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+	return marshalFeedXML(doc)
+}
+
+func marshalFeedXML(doc any) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode feed: %w", err)
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+// jsonFeed is a JSON Feed 1.1 document, https://jsonfeed.org/version/1.1.
+type jsonFeed struct {
+	Version     string         `json:"version"`
+	Title       string         `json:"title"`
+	HomePageURL string         `json:"home_page_url"`
+	FeedURL     string         `json:"feed_url"`
+	Items       []jsonFeedItem `json:"items"`
+}
+
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url,omitempty"`
+	Title         string               `json:"title,omitempty"`
+	ContentHTML   string               `json:"content_html"`
+	DatePublished time.Time            `json:"date_published"`
+	DateModified  time.Time            `json:"date_modified"`
+	Authors       []jsonFeedAuthor     `json:"authors,omitempty"`
+	Tags          []string             `json:"tags,omitempty"`
+	Attachments   []jsonFeedAttachment `json:"attachments,omitempty"`
+}
+
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedAttachment struct {
+	URL         string `json:"url"`
+	MimeType    string `json:"mime_type"`
+	SizeInBytes int64  `json:"size_in_bytes,omitempty"`
+}
+
+func renderJSONFeed(f *feed) ([]byte, error) {
+	doc := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: f.HomeURL,
+		FeedURL:     f.FeedURL,
+		Items:       []jsonFeedItem{},
+	}
+	for _, entry := range f.Entries {
+		item := jsonFeedItem{
+			ID:            entry.ID,
+			URL:           entry.URL,
+			Title:         entry.Title,
+			ContentHTML:   entry.Content,
+			DatePublished: entry.Published.UTC(),
+			DateModified:  entry.Updated.UTC(),
+			Tags:          entry.Categories,
+		}
+		if entry.Author != "" {
+			item.Authors = []jsonFeedAuthor{{Name: entry.Author}}
+		}
+		for _, enclosure := range entry.Enclosures {
+			item.Attachments = append(item.Attachments, jsonFeedAttachment{URL: enclosure.URL, MimeType: enclosure.Type, SizeInBytes: enclosure.Length})
+		}
+		doc.Items = append(doc.Items, item)
+	}
+	body, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode feed: %w", err)
+	}
+	return append(body, '\n'), nil
+}
+
+// =============================================================================
+// METRICS
+// =============================================================================
+
+// durationBuckets are the upper bounds, in seconds, of the latency
+// histograms.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// resultCountBuckets are the upper bounds of the search result histogram.
+var resultCountBuckets = []float64{0, 1, 5, 10, 20, 50, 100, 200}
+
+// metricFamilies are all the metrics served at /metrics, in the order they
+// were declared.
+var metricFamilies []*metricFamily
+
+var (
+	apiRequestsTotal = newCounter("bookmarchive_api_requests_total",
+		"Requests sent to server APIs by response status code, or error when none came back.", "code")
+	apiRetriesTotal = newCounter("bookmarchive_api_retries_total",
+		"Requests to server APIs sent again after a failure.")
+	apiRequestDuration = newHistogram("bookmarchive_api_request_duration_seconds",
+		"Time taken by each request to a server API.", durationBuckets)
+	fetchesTotal = newCounter("bookmarchive_fetches_total",
+		"Pages of bookmarks, favourites or own posts fetched, by whether the fetch succeeded after any retries.", "account", "source", "result")
+	fetchDuration = newHistogram("bookmarchive_fetch_duration_seconds",
+		"Time taken to fetch a page of a collection, including retries.", durationBuckets, "account", "source")
+	batchesTotal = newCounter("bookmarchive_batches_total",
+		"Batches of fetched statuses processed.", "account", "source")
+	batchBookmarksTotal = newCounter("bookmarchive_batch_bookmarks_total",
+		"Statuses in processed batches by outcome: inserted, skipped as already archived, or failed.", "account", "source", "result")
+	backfillProcessed = newGauge("bookmarchive_backfill_processed_bookmarks",
+		"Statuses processed by the backfill running since startup.", "account", "source")
+	backfillComplete = newGauge("bookmarchive_backfill_complete",
+		"Whether the backfill of a collection has finished.", "account", "source")
+	lastPollTimestamp = newGauge("bookmarchive_last_poll_timestamp_seconds",
+		"Unix time of the last successful poll of a collection.", "account", "source")
+	searchDuration = newHistogram("bookmarchive_search_duration_seconds",
+		"Time taken to answer a search.", durationBuckets)
+	searchResults = newHistogram("bookmarchive_search_results",
+		"Number of results returned by a search.", resultCountBuckets)
+	sseClients = newGauge("bookmarchive_sse_clients",
+		"Browsers connected to the event stream.")
+	databaseSize = newGauge("bookmarchive_database_size_bytes",
+		"Size of the SQLite database, not counting its write-ahead log.")
+)
+
+// metricFamily is a counter, gauge or histogram with one series per
+// combination of label values, written in the Prometheus text format.
+type metricFamily struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+// metricSeries is one series of a family. A histogram keeps its sum in
+// value and a cumulative count per bucket.
+type metricSeries struct {
+	labelValues  []string
+	value        float64
+	count        uint64
+	bucketCounts []uint64
+}
+
+func newMetricFamily(kind, name, help string, buckets []float64, labelNames []string) *metricFamily {
+	family := &metricFamily{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*metricSeries),
+	}
+	// Metrics without labels are reported from the start
+	if len(labelNames) == 0 {
+		family.get(nil)
+	}
+	metricFamilies = append(metricFamilies, family)
+	return family
+}
+
+func newCounter(name, help string, labelNames ...string) *metricFamily {
+	return newMetricFamily("counter", name, help, nil, labelNames)
+}
+
+func newGauge(name, help string, labelNames ...string) *metricFamily {
+	return newMetricFamily("gauge", name, help, nil, labelNames)
+}
+
+func newHistogram(name, help string, buckets []float64, labelNames ...string) *metricFamily {
+	return newMetricFamily("histogram", name, help, buckets, labelNames)
+}
+
+// get returns the series for the label values, creating it if needed. The
+// caller must hold f.mu, except while the family is being created.
+func (f *metricFamily) get(labelValues []string) *metricSeries {
+	if len(labelValues) != len(f.labelNames) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.name, len(f.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	series, ok := f.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: slices.Clone(labelValues)}
+		if f.kind == "histogram" {
+			series.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = series
+	}
+	return series
+}
+
+func (f *metricFamily) add(delta float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value += delta
+}
+
+func (f *metricFamily) inc(labelValues ...string) {
+	f.add(1, labelValues...)
+}
+
+func (f *metricFamily) set(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.get(labelValues).value = value
+}
+
+func (f *metricFamily) observe(value float64, labelValues ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	series := f.get(labelValues)
+	series.value += value
+	series.count++
+	for i, bound := range f.buckets {
+		if value <= bound {
+			series.bucketCounts[i]++
+		}
+	}
+}
+
+// write writes the family in the Prometheus text format, its series
+// ordered by label values.
+func (f *metricFamily) write(w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "), f.name, f.kind)
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		series := f.series[key]
+		if f.kind != "histogram" {
+			fmt.Fprintf(&b, "%s%s %s\n", f.name, f.labels(series.labelValues, ""), formatMetricValue(series.value))
+			continue
+		}
+		for i, bound := range f.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, f.labels(series.labelValues, formatMetricValue(bound)), series.bucketCounts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", f.name, f.labels(series.labelValues, "+Inf"), series.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", f.name, f.labels(series.labelValues, ""), formatMetricValue(series.value))
+		fmt.Fprintf(&b, "%s_count%s %d\n", f.name, f.labels(series.labelValues, ""), series.count)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// labels formats label values as {name="value",...}, adding the le label
+// of a histogram bucket when given.
+func (f *metricFamily) labels(values []string, le string) string {
+	var pairs []string
+	for i, name := range f.labelNames {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
 }
 
-func newBookmarkService(cfg *Config, db *Database, eventChan chan<- ServerEvent) (*BookmarkService, error) {
-	if cfg == nil {
-		return nil, fmt.Errorf("config cannot be nil")
+// handleMetrics serves the metrics in the Prometheus text format, first
+// reading the ones that describe the current state of the archive.
+func (ws *WebServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	sseClients.set(float64(ws.broadcaster.clientCount()))
+
+	if size, err := ws.db.databaseSize(); err != nil {
+		zlog.Error().Err(err).Msg("Failed to get database size for metrics")
+	} else {
+		databaseSize.set(float64(size))
 	}
-	if db == nil {
-		return nil, fmt.Errorf("database cannot be nil")
+
+	states, err := ws.db.getCollectionSyncStates()
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to get collection states for metrics")
 	}
-	if cfg.Mastodon.Server == "" {
-		return nil, fmt.Errorf("mastodon server URL is required")
+	for _, state := range states {
+		complete := 0.0
+		if state.BackfillComplete {
+			complete = 1
+		}
+		backfillComplete.set(complete, state.Account, state.Source)
+		if state.LastPollTime != nil {
+			lastPollTimestamp.set(float64(state.LastPollTime.Unix()), state.Account, state.Source)
+		}
 	}
-	if cfg.Mastodon.AccessToken == "" {
-		return nil, fmt.Errorf("mastodon access token is required")
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	for _, family := range metricFamilies {
+		if err := family.write(w); err != nil {
+			zlog.Debug().Err(err).Msg("Failed to write metrics")
+			return
+		}
+	}
+}
+
+// =============================================================================
+// HEALTH CHECKS
+// =============================================================================
+
+// defaultReadyIntervals is how many polling intervals may pass without a
+// successful poll before a collection is reported as not ready.
+const defaultReadyIntervals = 3
+
+// healthComponent is the state of one part of the service in /healthz and
+// /readyz. Reason says why a failing component fails, without the error
+// itself, which /api/stats reports to authenticated clients.
+type healthComponent struct {
+	Name         string     `json:"name"`
+	Status       string     `json:"status"`
+	Reason       string     `json:"reason,omitempty"`
+	Account      string     `json:"account,omitempty"`
+	Source       string     `json:"source,omitempty"`
+	LastPollTime *time.Time `json:"last_poll_time,omitempty"`
+	FailedPolls  int        `json:"failed_polls,omitempty"`
+}
+
+// healthReport is the body of /healthz and /readyz, listing the names of
+// the failing components.
+type healthReport struct {
+	Status     string            `json:"status"`
+	Failing    []string          `json:"failing,omitempty"`
+	Components []healthComponent `json:"components"`
+	CheckedAt  time.Time         `json:"checked_at"`
+}
+
+func (r *healthReport) add(component healthComponent) {
+	if component.Reason == "" {
+		component.Status = "ok"
+	} else {
+		component.Status = "failing"
+		r.Failing = append(r.Failing, component.Name)
+	}
+	r.Components = append(r.Components, component)
+}
+
+// handleHealth reports whether the process is alive and can reach its
+// database.
+func (ws *WebServer) handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := &healthReport{}
+	report.add(ws.databaseHealth(r.Context()))
+	writeHealthReport(w, report)
+}
+
+// handleReady reports whether the archive is being kept up to date: the
+// database is reachable and every collection has verified credentials, is
+// still running, and polled successfully within the last few intervals.
+func (ws *WebServer) handleReady(w http.ResponseWriter, r *http.Request) {
+	report := &healthReport{}
+	report.add(ws.databaseHealth(r.Context()))
+	for _, health := range ws.syncHealth {
+		report.add(ws.collectionHealth(health.snapshot(), time.Now()))
+	}
+	writeHealthReport(w, report)
+}
+
+func (ws *WebServer) databaseHealth(ctx context.Context) healthComponent {
+	component := healthComponent{Name: "database"}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := ws.db.ping(ctx); err != nil {
+		zlog.Error().Err(err).Msg("Database health check failed")
+		component.Reason = "database unreachable"
+	}
+	return component
+}
+
+// collectionHealth checks one collection's service. Progress counts from
+// the last poll, backfill batch or service start, whichever is latest, so a
+// restart or a long backfill does not look stale.
+func (ws *WebServer) collectionHealth(status SyncStatus, now time.Time) healthComponent {
+	component := healthComponent{
+		Name:        "sync:" + status.Account + "/" + status.Source,
+		Account:     status.Account,
+		Source:      status.Source,
+		FailedPolls: status.FailedPolls,
+	}
+
+	interval, err := parseDurationOrDefault(ws.config.Polling.Interval, 5*time.Minute)
+	if err != nil || interval <= 0 {
+		component.Reason = "invalid polling interval"
+		return component
+	}
+	readyIntervals := ws.config.Polling.ReadyIntervals
+	if readyIntervals <= 0 {
+		readyIntervals = defaultReadyIntervals
+	}
+
+	lastProgress := status.StartedAt
+	state, err := ws.db.getBackfillStateForOwner(status.Account, status.Source)
+	if err != nil {
+		zlog.Debug().Err(err).Str("account", status.Account).Str("source", status.Source).Msg("No backfill state for readiness check")
+	} else {
+		component.LastPollTime = state.LastPollTime
+		if state.LastPollTime != nil && state.LastPollTime.After(lastProgress) {
+			lastProgress = *state.LastPollTime
+		}
+		if !state.BackfillComplete && state.UpdatedAt.After(lastProgress) {
+			lastProgress = state.UpdatedAt
+		}
+	}
+
+	switch {
+	case status.Stopped:
+		component.Reason = "stopped after an error"
+	case status.StartedAt.IsZero():
+		component.Reason = "not started"
+	case !status.CredentialsVerified:
+		component.Reason = "credentials not verified"
+	case status.FailedPolls >= readyIntervals:
+		component.Reason = fmt.Sprintf("last %d polls failed", status.FailedPolls)
+	case now.Sub(lastProgress) > time.Duration(readyIntervals)*interval:
+		component.Reason = fmt.Sprintf("no successful poll for %s", now.Sub(lastProgress).Truncate(time.Second))
+	}
+	return component
+}
+
+func writeHealthReport(w http.ResponseWriter, report *healthReport) {
+	report.CheckedAt = time.Now()
+	report.Status = "ok"
+	code := http.StatusOK
+	if len(report.Failing) > 0 {
+		report.Status = "failing"
+		code = http.StatusServiceUnavailable
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		zlog.Error().Err(err).Msg("Failed to encode health report")
+	}
+}
+
+// =============================================================================
+// MAIN APPLICATION
+// =============================================================================
+
+type BookmarchiveApp struct {
+	config          Config
+	ctx             context.Context
+	cancel          context.CancelFunc
+	db              *Database
+	mastodonClient  *MastodonClient
+	bookmarkService *BookmarkService
+	// bookmarkServices holds one service per configured account and
+	// source; mastodonClient and bookmarkService are the first of them.
+	bookmarkServices []*BookmarkService
+	webServer        *WebServer
+	eventChan        chan ServerEvent
+}
+
+func newBookmarchiveApp(cfg *Config) (*BookmarchiveApp, error) {
+	zlog.Info().Msg("Starting bookmarchive service")
+
 	ctx, cancel := context.WithCancel(context.Background())
 
-	service := &BookmarkService{
-		config:    cfg,
-		db:        db,
-		ctx:       ctx,
-		cancel:    cancel,
-		eventChan: eventChan,
+	db, err := newDatabase(*cfg)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	return service, nil
+	accounts, err := cfg.accounts()
+	if err != nil {
+		db.close()
+		cancel()
+		return nil, fmt.Errorf("invalid account configuration: %w", err)
+	}
+
+	sources, err := cfg.sources()
+	if err != nil {
+		db.close()
+		cancel()
+		return nil, fmt.Errorf("invalid polling sources: %w", err)
+	}
+
+	if len(cfg.Accounts) > 0 {
+		// The archive of a previous single-account setup becomes the first account's
+		if err := db.claimUnownedRows(accounts[0].Name); err != nil {
+			db.close()
+			cancel()
+			return nil, fmt.Errorf("failed to assign existing archive to account %s: %w", accounts[0].Name, err)
+		}
+	}
+
+	var mediaArchiver *MediaArchiver
+	if cfg.Media.Enabled {
+		mediaArchiver, err = newMediaArchiver(cfg, db)
+		if err != nil {
+			db.close()
+			cancel()
+			return nil, fmt.Errorf("failed to initialize media archiver: %w", err)
+		}
+	}
+
+	var linkArchiver *LinkArchiver
+	if cfg.Links.Enabled {
+		linkArchiver, err = newLinkArchiver(cfg, db)
+		if err != nil {
+			db.close()
+			cancel()
+			return nil, fmt.Errorf("failed to initialize link archiver: %w", err)
+		}
+	}
+
+	eventChan := make(chan ServerEvent, 100)
+
+	var mastodonClient *MastodonClient
+	var bookmarkServices []*BookmarkService
+	rateLimiters := make(map[string]*RateLimiter)
+	var syncHealth []*syncHealth
+	for _, account := range accounts {
+		client, err := newAccountMastodonClient(account)
+		if err != nil {
+			db.close()
+			cancel()
+			return nil, fmt.Errorf("failed to create mastodon client for account %q: %w", account.Name, err)
+		}
+		if mastodonClient == nil {
+			mastodonClient = client
+		}
+
+		connection := newAccountConnection(account, db)
+		rateLimiters[account.Name] = connection.rateLimiter
+		for i, source := range sources {
+			service, err := newAccountBookmarkService(cfg, db, eventChan, account, source)
+			if err != nil {
+				db.close()
+				cancel()
+				return nil, fmt.Errorf("failed to create %s service for account %q: %w", source, account.Name, err)
+			}
+			service.media = mediaArchiver
+			service.links = linkArchiver
+			service.connection = connection
+			service.refreshDelegated = i > 0
+			bookmarkServices = append(bookmarkServices, service)
+			syncHealth = append(syncHealth, service.health)
+		}
+	}
+
+	webServer := newWebServer(cfg, db, eventChan)
+	webServer.rateLimiters = rateLimiters
+	webServer.syncHealth = syncHealth
+
+	return &BookmarchiveApp{
+		config:           *cfg,
+		ctx:              ctx,
+		cancel:           cancel,
+		db:               db,
+		mastodonClient:   mastodonClient,
+		bookmarkService:  bookmarkServices[0],
+		bookmarkServices: bookmarkServices,
+		webServer:        webServer,
+		eventChan:        eventChan,
+	}, nil
+}
+
+func (app *BookmarchiveApp) start() error {
+	zlog.Info().Msg("Bookmarchive service started")
+
+	if err := app.webServer.start(); err != nil {
+		return fmt.Errorf("failed to start web server: %w", err)
+	}
+
+	app.checkSearchIndex()
+
+	for _, service := range app.bookmarkServices {
+		go func(service *BookmarkService) {
+			if err := service.start(); err != nil {
+				if err == context.Canceled {
+					zlog.Debug().Str("account", service.account.Name).Str("source", service.sourceOrDefault()).Msg("Bookmark service stopped due to context cancellation")
+				} else {
+					zlog.Error().Err(err).Str("account", service.account.Name).Str("source", service.sourceOrDefault()).Msg("Bookmark service error")
+				}
+			}
+		}(service)
+	}
+
+	return nil
+}
+
+// checkSearchIndex compares the settings the archive's search text was
+// built with to the current ones. A mismatch is logged, or reindexed in the
+// background when search.auto_reindex is set; searches meanwhile use the
+// old text.
+func (app *BookmarchiveApp) checkSearchIndex() {
+	current, err := app.db.searchIndexCurrent(app.config.Search.IndexedFields)
+	if err != nil {
+		zlog.Error().Err(err).Msg("Failed to check search index settings")
+		return
+	}
+	if current {
+		return
+	}
+
+	if !app.config.Search.AutoReindex {
+		zlog.Warn().Msg("Search text was built with different search settings; run bookmarchive reindex or set search.auto_reindex")
+		return
+	}
+
+	go func() {
+		zlog.Info().Msg("Search settings changed, reindexing bookmarks")
+		result, err := reindexBookmarks(app.ctx, app.db, app.config.Search.IndexedFields, defaultReindexBatchSize, func(done, total int) {
+			zlog.Info().Int("done", done).Int("total", total).Msg("Reindexing bookmarks")
+		})
+		if err != nil {
+			if app.ctx.Err() != nil {
+				zlog.Debug().Err(err).Msg("Reindex stopped by shutdown")
+				return
+			}
+			zlog.Error().Err(err).Msg("Failed to reindex bookmarks")
+			return
+		}
+		zlog.Info().Int("reindexed", result.Reindexed).Int("skipped", result.Skipped).Msg("Reindex completed")
+	}()
+}
+
+func (app *BookmarchiveApp) run() error {
+	if err := app.start(); err != nil {
+		return err
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// SIGHUP reloads the TLS certificate; the others shut down
+	for sig := <-sigChan; sig == syscall.SIGHUP; sig = <-sigChan {
+		if app.webServer != nil {
+			app.webServer.reloadCertificate()
+		}
+	}
+	zlog.Info().Msg("Shutdown signal received")
+
+	return app.stop()
+}
+
+func (app *BookmarchiveApp) stop() error {
+	zlog.Info().Msg("Stopping bookmarchive service")
+
+	if app.cancel != nil {
+		app.cancel()
+	}
+
+	if app.eventChan != nil {
+		close(app.eventChan)
+	}
+
+	for _, service := range app.bookmarkServices {
+		if err := service.stop(); err != nil {
+			zlog.Error().Err(err).Str("account", service.account.Name).Msg("Error stopping bookmark service")
+		}
+	}
+
+	if app.webServer != nil {
+		if err := app.webServer.stop(); err != nil {
+			zlog.Debug().Err(err).Msg("Web server stop completed with timeout - this is normal during shutdown")
+		}
+	}
+
+	if app.db != nil {
+		if err := app.db.close(); err != nil {
+			zlog.Error().Err(err).Msg("Error closing database")
+		}
+	}
+
+	zlog.Info().Msg("Bookmarchive service stopped")
+	return nil
+}
+
+// =============================================================================
+// ARCHIVE EXPORT
+// =============================================================================
+
+// exportFormats are the formats the export command and /api/export write.
+var exportFormats = []string{"json", "jsonl", "csv", "markdown", "netscape-html"}
+
+// ExportedBookmark is an archived status as exported: what it says and
+// where it came from, the archive owner's annotations and, in the JSON
+// formats, the stored status as Raw.
+type ExportedBookmark struct {
+	StatusID       string          `json:"status_id"`
+	Account        string          `json:"account,omitempty"`
+	Source         string          `json:"source"`
+	URL            string          `json:"url,omitempty"`
+	Author         string          `json:"author,omitempty"`
+	AuthorName     string          `json:"author_name,omitempty"`
+	Content        string          `json:"content,omitempty"`
+	Text           string          `json:"text"`
+	SpoilerText    string          `json:"spoiler_text,omitempty"`
+	Hashtags       []string        `json:"hashtags"`
+	CreatedAt      time.Time       `json:"created_at"`
+	BookmarkedAt   time.Time       `json:"bookmarked_at"`
+	UnbookmarkedAt *time.Time      `json:"unbookmarked_at,omitempty"`
+	DeletedAt      *time.Time      `json:"deleted_at,omitempty"`
+	Tags           []string        `json:"tags"`
+	Note           string          `json:"note,omitempty"`
+	Collections    []string        `json:"collections"`
+	Raw            json.RawMessage `json:"raw,omitempty"`
+}
+
+func newExportedBookmark(bookmark *DBBookmark, annotations *Annotations) *ExportedBookmark {
+	exported := &ExportedBookmark{
+		StatusID:       bookmark.StatusID,
+		Account:        bookmark.OwnerAccount,
+		Source:         bookmark.Source,
+		Hashtags:       []string{},
+		CreatedAt:      bookmark.CreatedAt,
+		BookmarkedAt:   bookmark.BookmarkedAt,
+		UnbookmarkedAt: bookmark.UnbookmarkedAt,
+		DeletedAt:      bookmark.DeletedAt,
+		Tags:           annotations.Tags,
+		Note:           annotations.Note,
+		Collections:    annotations.Collections,
+	}
+	if json.Valid([]byte(bookmark.RawJSON)) {
+		exported.Raw = json.RawMessage(bookmark.RawJSON)
+	}
+
+	// Rows from before full statuses were stored only have their search text
+	var full Bookmark
+	if err := json.Unmarshal([]byte(bookmark.RawJSON), &full); err != nil || full.Status.ID == "" {
+		exported.Text = bookmark.SearchText
+		return exported
+	}
+
+	status := full.Status
+	exported.URL = status.URL
+	if exported.URL == "" {
+		exported.URL = status.URI
+	}
+	exported.Author = status.Account.Acct
+	if exported.Author == "" {
+		exported.Author = status.Account.Username
+	}
+	exported.AuthorName = status.Account.DisplayName
+	exported.Content = status.Content
+	// stripHTML leaves entities escaped, as the text is meant for HTML
+	exported.Text = html.UnescapeString(stripHTML(status.Content))
+	exported.SpoilerText = status.SpoilerText
+	for _, tag := range status.Tags {
+		exported.Hashtags = append(exported.Hashtags, tag.Name)
+	}
+	return exported
+}
+
+// title is a one-line description of the bookmark for the formats that
+// list bookmarks by title.
+func (b *ExportedBookmark) title() string {
+	text := b.SpoilerText
+	if text == "" {
+		text = strings.Join(strings.Fields(b.Text), " ")
+	}
+	author := b.AuthorName
+	if author == "" {
+		author = b.Author
+	}
+	if author == "" {
+		return truncateText(text, 100)
+	}
+	if text == "" {
+		return author
+	}
+	return author + ": " + truncateText(text, 100)
+}
+
+// exportWriter writes exported bookmarks in one format as they are read.
+type exportWriter interface {
+	write(bookmark *ExportedBookmark) error
+	// close finishes the document after the last bookmark.
+	close() error
+}
+
+// newExportWriter returns the writer for a format, writing the start of the
+// document right away.
+func newExportWriter(format string, w io.Writer) (exportWriter, error) {
+	switch format {
+	case "json":
+		if _, err := io.WriteString(w, "["); err != nil {
+			return nil, err
+		}
+		return &jsonExportWriter{w: w, array: true}, nil
+	case "jsonl":
+		return &jsonExportWriter{w: w}, nil
+	case "csv":
+		writer := &csvExportWriter{w: csv.NewWriter(w)}
+		if err := writer.w.Write(csvExportHeader); err != nil {
+			return nil, err
+		}
+		return writer, nil
+	case "markdown":
+		if _, err := io.WriteString(w, "# Bookmarks\n"); err != nil {
+			return nil, err
+		}
+		return &markdownExportWriter{w: w}, nil
+	case "netscape-html":
+		if _, err := io.WriteString(w, netscapeExportHeader); err != nil {
+			return nil, err
+		}
+		return &netscapeExportWriter{w: w}, nil
+	}
+	return nil, fmt.Errorf("unknown export format %q (use %s)", format, strings.Join(exportFormats, ", "))
+}
+
+// exportContentType returns the media type and file extension of a format.
+func exportContentType(format string) (contentType, extension string) {
+	switch format {
+	case "json":
+		return "application/json", "json"
+	case "jsonl":
+		return "application/jsonl", "jsonl"
+	case "csv":
+		return "text/csv; charset=utf-8", "csv"
+	case "markdown":
+		return "text/markdown; charset=utf-8", "md"
+	}
+	return "text/html; charset=utf-8", "html"
+}
+
+// jsonExportWriter writes a JSON array with a bookmark per line, or JSON
+// Lines without the brackets.
+type jsonExportWriter struct {
+	w       io.Writer
+	array   bool
+	written bool
+}
+
+func (j *jsonExportWriter) write(bookmark *ExportedBookmark) error {
+	data, err := json.Marshal(bookmark)
+	if err != nil {
+		return fmt.Errorf("failed to encode bookmark %s: %w", bookmark.StatusID, err)
+	}
+	if !j.array {
+		_, err = fmt.Fprintf(j.w, "%s\n", data)
+		return err
+	}
+	separator := "\n"
+	if j.written {
+		separator = ",\n"
+	}
+	j.written = true
+	_, err = fmt.Fprintf(j.w, "%s%s", separator, data)
+	return err
+}
+
+func (j *jsonExportWriter) close() error {
+	if !j.array {
+		return nil
+	}
+	_, err := io.WriteString(j.w, "\n]\n")
+	return err
+}
+
+var csvExportHeader = []string{
+	"status_id", "account", "source", "url", "author", "author_name", "created_at", "bookmarked_at",
+	"text", "spoiler_text", "hashtags", "tags", "collections", "note", "unbookmarked_at", "deleted_at",
+}
+
+// csvExportWriter writes a row per bookmark. Hashtags and tags hold no
+// spaces and are separated by them; collections are separated by "; ".
+type csvExportWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvExportWriter) write(bookmark *ExportedBookmark) error {
+	timestamp := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	}
+	return c.w.Write([]string{
+		bookmark.StatusID,
+		bookmark.Account,
+		bookmark.Source,
+		bookmark.URL,
+		bookmark.Author,
+		bookmark.AuthorName,
+		timestamp(&bookmark.CreatedAt),
+		timestamp(&bookmark.BookmarkedAt),
+		bookmark.Text,
+		bookmark.SpoilerText,
+		strings.Join(bookmark.Hashtags, " "),
+		strings.Join(bookmark.Tags, " "),
+		strings.Join(bookmark.Collections, "; "),
+		bookmark.Note,
+		timestamp(bookmark.UnbookmarkedAt),
+		timestamp(bookmark.DeletedAt),
+	})
+}
+
+func (c *csvExportWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// markdownExportWriter writes a section per bookmark, headed by a link to
+// the status, with its text quoted.
+type markdownExportWriter struct {
+	w io.Writer
 }
 
-func (s *BookmarkService) start() error {
-	client, err := s.createBookmarkClient()
-	if err != nil {
-		return fmt.Errorf("failed to create bookmark client: %w", err)
+// markdownEscaper escapes the characters that would start markup inside a
+// line of text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`,
+)
+
+func (m *markdownExportWriter) write(bookmark *ExportedBookmark) error {
+	var b strings.Builder
+	heading := markdownEscaper.Replace(bookmark.title())
+	if bookmark.URL != "" {
+		heading = "[" + heading + "](<" + strings.NewReplacer("<", "%3C", ">", "%3E").Replace(bookmark.URL) + ">)"
 	}
-	s.client = client
+	fmt.Fprintf(&b, "\n## %s\n\n", heading)
+	fmt.Fprintf(&b, "*%s", bookmark.CreatedAt.UTC().Format("2006-01-02"))
+	if bookmark.Author != "" {
+		fmt.Fprintf(&b, " by @%s", markdownEscaper.Replace(bookmark.Author))
+	}
+	b.WriteString("*\n\n")
 
-	if err := s.runBackfill(); err != nil {
-		return fmt.Errorf("backfill failed: %w", err)
+	if bookmark.SpoilerText != "" {
+		fmt.Fprintf(&b, "> **%s**\n>\n", markdownEscaper.Replace(bookmark.SpoilerText))
+	}
+	for _, line := range strings.Split(strings.TrimSpace(bookmark.Text), "\n") {
+		fmt.Fprintf(&b, "> %s\n", markdownEscaper.Replace(strings.TrimSpace(line)))
 	}
 
-	return s.startPolling()
+	var details []string
+	if len(bookmark.Tags) > 0 {
+		details = append(details, "Tags: "+markdownEscaper.Replace("#"+strings.Join(bookmark.Tags, " #")))
+	}
+	if len(bookmark.Collections) > 0 {
+		details = append(details, "Collections: "+markdownEscaper.Replace(strings.Join(bookmark.Collections, ", ")))
+	}
+	if len(details) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", strings.Join(details, " · "))
+	}
+	if bookmark.Note != "" {
+		fmt.Fprintf(&b, "\nNote: %s\n", markdownEscaper.Replace(strings.Join(strings.Fields(bookmark.Note), " ")))
+	}
+
+	_, err := io.WriteString(m.w, b.String())
+	return err
 }
 
-func (s *BookmarkService) stop() error {
-	if s.cancel != nil {
-		s.cancel()
-	}
+func (m *markdownExportWriter) close() error {
 	return nil
 }
 
-func (s *BookmarkService) createBookmarkClient() (BookmarkClient, error) {
-	mastodonClient, err := newMastodonClient(s.config)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create mastodon client: %w", err)
-	}
+const netscapeExportHeader = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`
+
+// netscapeExportWriter writes the bookmark file format browsers and
+// bookmarking services import, with the archive owner's tags and the
+// status's hashtags as TAGS and the note as the description. Statuses
+// without a URL cannot be bookmarks there and are left out.
+type netscapeExportWriter struct {
+	w io.Writer
+}
 
-	if err := mastodonClient.verifyCredentials(); err != nil {
-		return nil, fmt.Errorf("failed to verify mastodon credentials: %w", err)
+func (n *netscapeExportWriter) write(bookmark *ExportedBookmark) error {
+	if bookmark.URL == "" {
+		return nil
 	}
+	tags := slices.Compact(slices.Sorted(slices.Values(append(slices.Clone(bookmark.Tags), bookmark.Hashtags...))))
 
-	// After successful verification, store the current user's account information
-	madonClient, err := mastodonClient.getMadonClient()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get madon client: %w", err)
+	var b strings.Builder
+	fmt.Fprintf(&b, `    <DT><A HREF="%s" ADD_DATE="%d"`, html.EscapeString(bookmark.URL), bookmark.BookmarkedAt.Unix())
+	if len(tags) > 0 {
+		fmt.Fprintf(&b, ` TAGS="%s"`, html.EscapeString(strings.Join(tags, ",")))
 	}
-
-	// Get and store current user account information
-	account, err := madonClient.GetCurrentAccount()
-	if err != nil {
-		zlog.Warn().Err(err).Msg("Failed to get current account information")
-	} else {
-		userAccount := &UserAccount{
-			AccountID:   string(account.ID),
-			Username:    account.Username,
-			DisplayName: account.DisplayName,
-			Acct:        account.Acct,
-			Avatar:      account.Avatar,
-		}
-
-		if err := s.db.insertUserAccount(userAccount); err != nil {
-			zlog.Warn().Err(err).Msg("Failed to store user account information")
-			// Don't fail the creation if we can't store the account info
-		} else {
-			zlog.Info().Str("account_id", userAccount.AccountID).Str("username", userAccount.Username).Msg("Stored user account information")
-		}
+	fmt.Fprintf(&b, ">%s</A>\n", html.EscapeString(bookmark.title()))
+	if bookmark.Note != "" {
+		fmt.Fprintf(&b, "    <DD>%s\n", html.EscapeString(strings.Join(strings.Fields(bookmark.Note), " ")))
 	}
 
-	maxRetries := 3
-	client := newMastodonBookmarkClient(madonClient, maxRetries)
-	return client, nil
+	_, err := io.WriteString(n.w, b.String())
+	return err
 }
 
-func (s *BookmarkService) runBackfill() error {
-	zlog.Info().Msg("Starting bookmark backfill")
+func (n *netscapeExportWriter) close() error {
+	_, err := io.WriteString(n.w, "</DL><p>\n")
+	return err
+}
 
-	state, err := s.db.getBackfillState()
+// exportBookmarks writes the bookmarks matching a search request to w in a
+// format, returning how many it read.
+func exportBookmarks(ctx context.Context, db *Database, request *SearchRequest, format string, w io.Writer) (int, error) {
+	writer, err := newExportWriter(format, w)
 	if err != nil {
-		return fmt.Errorf("failed to get backfill state: %w", err)
+		return 0, err
 	}
 
-	if state.BackfillComplete {
-		zlog.Info().Msg("Backfill already complete, skipping")
-		return nil
+	count := 0
+	err = db.eachBookmark(ctx, request, func(bookmark *DBBookmark, annotations *Annotations) error {
+		count++
+		return writer.write(newExportedBookmark(bookmark, annotations))
+	})
+	if err != nil {
+		return count, err
 	}
-
-	batchSize := s.config.Polling.BatchSize
-	if batchSize <= 0 {
-		batchSize = 40
+	if err := writer.close(); err != nil {
+		return count, fmt.Errorf("failed to finish export: %w", err)
 	}
+	return count, nil
+}
 
-	nextURL := state.LastProcessedID
-	if nextURL == "" {
-		zlog.Info().Msg("Starting backfill from the beginning")
-	} else {
-		zlog.Info().Str("next_url", nextURL).Msg("Resuming backfill from last position")
+// runExport implements the export command.
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "path to configuration file")
+	format := flags.String("format", "json", "export format: "+strings.Join(exportFormats, ", "))
+	output := flags.String("output", "-", "file to write, or - for standard output")
+	var request SearchRequest
+	flags.StringVar(&request.Query, "query", "", "only export bookmarks matching this search query")
+	flags.StringVar(&request.Account, "account", "", "only export this account's archive")
+	flags.StringVar(&request.FilterBySource, "source", "", "only export one collection: bookmark, favourite or own_status")
+	flags.StringVar(&request.FilterByState, "state", "", "only export active or removed bookmarks")
+	myPosts := flags.Bool("my-posts", false, "only export the archive owners' own posts")
+	flags.IntVar(&request.Limit, "limit", 0, "export at most this many bookmarks")
+	flags.Parse(args)
+
+	if !slices.Contains(exportFormats, *format) {
+		return fmt.Errorf("unknown export format %q (use %s)", *format, strings.Join(exportFormats, ", "))
+	}
+	if *myPosts {
+		request.FilterByAccount = "my_posts"
 	}
 
-	totalProcessed := 0
+	cfg := defaultConfig()
+	if err := loadConfig(*configPath, &cfg); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	setupLogging(cfg.Logging.Level, cfg.Logging.Format)
 
-	for {
-		select {
-		case <-s.ctx.Done():
-			return s.ctx.Err()
-		default:
-		}
+	db, err := newDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.close()
 
-		zlog.Debug().Str("next_url", nextURL).Int("batch_size", batchSize).Msg("Fetching bookmark batch")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		bookmarks, newNextURL, err := s.client.GetBookmarks(s.ctx, batchSize, nextURL)
+	var out io.Writer = os.Stdout
+	if *output != "-" && *output != "" {
+		file, err := os.Create(*output)
 		if err != nil {
-			return fmt.Errorf("failed to fetch bookmarks: %w", err)
+			return fmt.Errorf("failed to create output file: %w", err)
 		}
+		defer file.Close()
+		out = file
+	}
 
-		if len(bookmarks) == 0 {
-			zlog.Info().Int("total_processed", totalProcessed).Msg("Backfill complete - no more bookmarks")
-			if err := s.db.updateBackfillState("", true, nil); err != nil {
-				return fmt.Errorf("failed to mark backfill complete: %w", err)
-			}
-			break
+	buffered := bufio.NewWriter(out)
+	count, err := exportBookmarks(ctx, db, &request, *format, buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		if out != os.Stdout {
+			os.Remove(*output)
 		}
+		return err
+	}
+	if out != os.Stdout {
+		fmt.Fprintf(os.Stderr, "Exported %d bookmarks to %s\n", count, *output)
+	}
+	return nil
+}
 
-		if newNextURL == "" {
-			zlog.Info().
-				Int("count", len(bookmarks)).
-				Int("total_processed", totalProcessed+len(bookmarks)).
-				Msg("Processing final bookmark batch - no next URL")
+// =============================================================================
+// ARCHIVE IMPORT
+// =============================================================================
 
-			if err := s.processBookmarkBatch(bookmarks); err != nil {
-				return fmt.Errorf("failed to process final bookmark batch: %w", err)
-			}
+// ImportResult counts what an import did with the entries it read.
+type ImportResult struct {
+	// Imported entries were stored with their full status.
+	Imported int
+	// Duplicates were already archived and left as they were.
+	Duplicates int
+	// Unresolved entries could not be looked up and were stored as minimal
+	// records that hold only their URI.
+	Unresolved int
+}
 
-			totalProcessed += len(bookmarks)
+func (r *ImportResult) add(other ImportResult) {
+	r.Imported += other.Imported
+	r.Duplicates += other.Duplicates
+	r.Unresolved += other.Unresolved
+}
 
-			if err := s.db.updateBackfillState("", true, nil); err != nil {
-				return fmt.Errorf("failed to mark backfill complete: %w", err)
-			}
+// Importer stores the statuses of a Mastodon account archive ("Request your
+// archive") and of bookmarks.csv exports in one account's archive: the
+// account's own posts from outbox.json, its favourites from likes.json and
+// its bookmarks from bookmarks.json and the CSV.
+type Importer struct {
+	db            *Database
+	account       string
+	indexedFields []string
+	// resolver looks up favourited and bookmarked statuses, of which exports
+	// only hold the URI; nil stores them all as unresolved.
+	resolver ResolverClient
+	// accountID is the account's ID on its server, set on its own posts.
+	accountID string
+}
 
-			zlog.Info().Int("total_bookmarks", totalProcessed).Msg("Backfill completed successfully")
-			break
-		}
+// importEntry is one status read from an export: the status itself when the
+// export holds it, otherwise only its URI.
+type importEntry struct {
+	source string
+	uri    string
+	status *Status
+}
 
-		zlog.Info().
-			Int("count", len(bookmarks)).
-			Int("total_so_far", totalProcessed).
-			Str("next_url", newNextURL).
-			Msg("Processing bookmark batch")
+func newImporter(cfg *Config, db *Database, account string) *Importer {
+	return &Importer{
+		db:            db,
+		account:       account,
+		indexedFields: cfg.Search.IndexedFields,
+	}
+}
 
-		if err := s.processBookmarkBatch(bookmarks); err != nil {
-			return fmt.Errorf("failed to process bookmark batch: %w", err)
+// importFile imports an account archive ZIP or a bookmarks CSV.
+func (im *Importer) importFile(ctx context.Context, path string) (ImportResult, error) {
+	var entries []importEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("failed to open archive: %w", err)
+		}
+		defer archive.Close()
+		entries, err = readAccountArchive(&archive.Reader)
+		if err != nil {
+			return ImportResult{}, err
+		}
+	case ".csv":
+		f, err := os.Open(path)
+		if err != nil {
+			return ImportResult{}, fmt.Errorf("failed to open CSV: %w", err)
+		}
+		defer f.Close()
+		entries, err = readBookmarksCSV(f)
+		if err != nil {
+			return ImportResult{}, err
 		}
+	default:
+		return ImportResult{}, fmt.Errorf("unsupported file type %q: expected an archive .zip or a bookmarks .csv", filepath.Ext(path))
+	}
 
-		totalProcessed += len(bookmarks)
+	return im.importEntries(ctx, entries)
+}
 
-		if err := s.db.updateBackfillState(newNextURL, false, nil); err != nil {
-			return fmt.Errorf("failed to update backfill state: %w", err)
-		}
+// importEntries stores entries that are not archived yet, resolving those
+// without a status through the API when a resolver is set.
+func (im *Importer) importEntries(ctx context.Context, entries []importEntry) (ImportResult, error) {
+	var result ImportResult
+	now := time.Now()
 
-		nextURL = newNextURL
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if i > 0 && i%100 == 0 {
+			zlog.Info().Int("done", i).Int("total", len(entries)).Msg("Importing")
+		}
 
-		delay := 10 * time.Second
-		if s.config.Polling.BackfillDelay != "" {
-			if parsedDelay, err := time.ParseDuration(s.config.Polling.BackfillDelay); err == nil {
-				delay = parsedDelay
+		status := entry.status
+		resolved := status != nil
+		if status != nil && entry.source == SourceOwnStatus && status.Account.ID == "" {
+			status.Account.ID = im.accountID
+		}
+		if status == nil && im.resolver != nil {
+			found, err := im.resolver.ResolveStatus(ctx, entry.uri)
+			if err == nil {
+				status = &found
+				resolved = true
+			} else if ctx.Err() != nil {
+				return result, ctx.Err()
+			} else if !errors.Is(err, errStatusNotFound) {
+				zlog.Warn().Err(err).Str("uri", entry.uri).Msg("Failed to resolve imported status")
 			}
 		}
+		if status == nil {
+			// Minimal record keyed by the URI, as there is no server ID for it
+			status = &Status{ID: entry.uri, URI: entry.uri, URL: entry.uri, CreatedAt: now}
+		}
 
-		zlog.Debug().Dur("delay", delay).Msg("Waiting between batches")
+		existing, err := im.db.getBookmarkForOwner(im.account, entry.source, status.ID)
+		if err != nil {
+			return result, fmt.Errorf("failed to check for existing status: %w", err)
+		}
+		if existing != nil {
+			result.Duplicates++
+			continue
+		}
 
-		timer := time.NewTimer(delay)
-		select {
-		case <-s.ctx.Done():
-			timer.Stop()
-			return s.ctx.Err()
-		case <-timer.C:
+		bookmark := Bookmark{ID: status.ID, Status: *status, CreatedAt: status.CreatedAt}
+		if bookmark.CreatedAt.IsZero() {
+			bookmark.CreatedAt = now
+		}
+		dbBookmark := convertBookmarkToDatabase(bookmark, im.indexedFields)
+		dbBookmark.OwnerAccount = im.account
+		dbBookmark.Source = entry.source
+		if err := im.db.insertBookmark(dbBookmark); err != nil {
+			return result, fmt.Errorf("failed to store imported status %s: %w", status.ID, err)
 		}
-	}
 
-	if s.eventChan != nil {
-		select {
-		case s.eventChan <- ServerEvent{
-			Type: "backfill_complete",
-			Payload: map[string]interface{}{
-				"total_processed": totalProcessed,
-			},
-		}:
-		default:
+		if resolved {
+			result.Imported++
+		} else {
+			result.Unresolved++
 		}
 	}
 
-	zlog.Info().Int("total_bookmarks", totalProcessed).Msg("Backfill completed successfully")
-	return nil
+	return result, nil
 }
 
-func (s *BookmarkService) startPolling() error {
-	intervalStr := s.config.Polling.Interval
-	if intervalStr == "" {
-		intervalStr = "5m"
-	}
+// isUnresolvedStatusID reports whether a status ID is the URI of a status
+// imported without being resolved, which the server cannot look up by ID.
+func isUnresolvedStatusID(statusID string) bool {
+	return strings.Contains(statusID, "://")
+}
 
-	interval, err := time.ParseDuration(intervalStr)
-	if err != nil {
-		return fmt.Errorf("invalid polling interval: %w", err)
-	}
+// activityCollection is an ActivityPub OrderedCollection as found in
+// outbox.json, likes.json and bookmarks.json.
+type activityCollection struct {
+	OrderedItems []json.RawMessage `json:"orderedItems"`
+}
 
-	if interval <= 0 {
-		return fmt.Errorf("polling interval must be positive")
-	}
+type activityActor struct {
+	PreferredUsername string `json:"preferredUsername"`
+	Name              string `json:"name"`
+	Icon              struct {
+		URL string `json:"url"`
+	} `json:"icon"`
+}
 
-	zlog.Info().Dur("interval", interval).Msg("Starting bookmark polling")
+type activity struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+type activityNote struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Content string `json:"content"`
+	// ContentMap holds the content by language, one entry for Mastodon posts.
+	ContentMap map[string]string `json:"contentMap"`
+	Summary    string            `json:"summary"`
+	Published  time.Time         `json:"published"`
+	Attachment []struct {
+		MediaType string `json:"mediaType"`
+		URL       string `json:"url"`
+		Name      string `json:"name"`
+	} `json:"attachment"`
+	Tag []struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+		Href string `json:"href"`
+	} `json:"tag"`
+}
 
-	for {
-		select {
-		case <-s.ctx.Done():
-			zlog.Info().Msg("Stopping bookmark polling")
-			return s.ctx.Err()
-		case <-ticker.C:
-			zlog.Debug().Msg("Running scheduled bookmark poll")
-			if err := s.pollBookmarks(); err != nil {
-				zlog.Error().Err(err).Msg("Bookmark polling failed")
-				continue
-			}
-		}
+// readAccountArchive reads the entries of a Mastodon account archive. Boosts
+// in the outbox are left out, as they are not the account's own posts.
+func readAccountArchive(archive *zip.Reader) ([]importEntry, error) {
+	var actor activityActor
+	if found, err := readArchiveJSON(archive, "actor.json", &actor); err != nil {
+		return nil, err
+	} else if !found {
+		zlog.Warn().Msg("Archive has no actor.json, importing own posts without author")
+	}
+	account := Account{
+		Username:    actor.PreferredUsername,
+		DisplayName: actor.Name,
+		Avatar:      actor.Icon.URL,
 	}
-}
 
-func (s *BookmarkService) pollBookmarks() error {
-	zlog.Debug().Msg("Checking for new bookmarks")
+	var entries []importEntry
+	readAny := false
 
-	state, err := s.db.getBackfillState()
+	var outbox activityCollection
+	found, err := readArchiveJSON(archive, "outbox.json", &outbox)
 	if err != nil {
-		return fmt.Errorf("failed to get backfill state: %w", err)
+		return nil, err
 	}
-
-	batchSize := s.config.Polling.BatchSize
-	if batchSize <= 0 {
-		batchSize = 40
+	readAny = readAny || found
+	for _, item := range outbox.OrderedItems {
+		var act activity
+		if err := json.Unmarshal(item, &act); err != nil || act.Type != "Create" {
+			continue
+		}
+		var note activityNote
+		if err := json.Unmarshal(act.Object, &note); err != nil || note.ID == "" {
+			continue
+		}
+		status := convertActivityNote(note, account)
+		entries = append(entries, importEntry{source: SourceOwnStatus, uri: note.ID, status: &status})
 	}
 
-	bookmarks, _, err := s.client.GetBookmarks(s.ctx, batchSize, "")
-	if err != nil {
-		return fmt.Errorf("failed to fetch bookmarks: %w", err)
+	for _, collection := range []struct{ name, source string }{
+		{"likes.json", SourceFavourite},
+		{"bookmarks.json", SourceBookmark},
+	} {
+		var items activityCollection
+		found, err := readArchiveJSON(archive, collection.name, &items)
+		if err != nil {
+			return nil, err
+		}
+		readAny = readAny || found
+		for _, item := range items.OrderedItems {
+			if uri := activityItemURI(item); uri != "" {
+				entries = append(entries, importEntry{source: collection.source, uri: uri})
+			}
+		}
 	}
 
-	if len(bookmarks) == 0 {
-		zlog.Debug().Msg("No new bookmarks found")
-		return nil
+	if !readAny {
+		return nil, fmt.Errorf("archive has no outbox.json, likes.json or bookmarks.json")
 	}
+	return entries, nil
+}
 
-	zlog.Info().Int("count", len(bookmarks)).Msg("Found new bookmarks to process")
-
-	if err := s.processBookmarkBatch(bookmarks); err != nil {
-		return fmt.Errorf("failed to process bookmark batch: %w", err)
+// readArchiveJSON decodes the named file of an archive into v, reporting
+// whether the archive has it.
+func readArchiveJSON(archive *zip.Reader, name string, v interface{}) (bool, error) {
+	f, err := archive.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
 	}
-
-	now := time.Now()
-	if err := s.db.updateBackfillState(state.LastProcessedID, state.BackfillComplete, &now); err != nil {
-		return fmt.Errorf("failed to update poll time: %w", err)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", name, err)
 	}
+	defer f.Close()
 
-	zlog.Info().Int("processed", len(bookmarks)).Time("poll_time", now).Msg("Bookmark polling completed")
-	return nil
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %w", name, err)
+	}
+	return true, nil
 }
 
-func (s *BookmarkService) processBookmarkBatch(bookmarks []Bookmark) error {
-	zlog.Debug().Int("count", len(bookmarks)).Msg("Processing bookmark batch")
+// activityItemURI returns the URI of a collection item, which is either the
+// URI itself or an object with an id.
+func activityItemURI(item json.RawMessage) string {
+	var uri string
+	if err := json.Unmarshal(item, &uri); err == nil {
+		return uri
+	}
+	var object struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(item, &object); err == nil {
+		return object.ID
+	}
+	return ""
+}
 
-	actualProcessed := 0
+// convertActivityNote turns one of the account's own posts from the outbox
+// into a status. Its ID on the server is the last segment of its URI.
+// Attachments are kept for their descriptions only, as their URLs point
+// into the archive.
+func convertActivityNote(note activityNote, account Account) Status {
+	status := Status{
+		ID:               note.ID[strings.LastIndex(note.ID, "/")+1:],
+		URI:              note.ID,
+		URL:              note.URL,
+		Content:          note.Content,
+		SpoilerText:      note.Summary,
+		CreatedAt:        note.Published,
+		Account:          account,
+		MediaAttachments: make([]Media, 0),
+		Tags:             make([]Tag, 0),
+	}
+	if len(note.ContentMap) == 1 {
+		for language := range note.ContentMap {
+			status.Language = language
+		}
+	}
 
-	if s.eventChan != nil {
-		select {
-		case s.eventChan <- ServerEvent{
-			Type: "batch_start",
-			Payload: map[string]interface{}{
-				"total_bookmarks": len(bookmarks),
-			},
-		}:
+	for _, attachment := range note.Attachment {
+		mediaType, _, _ := strings.Cut(attachment.MediaType, "/")
+		switch mediaType {
+		case "image", "video", "audio":
 		default:
+			mediaType = "unknown"
 		}
+		status.MediaAttachments = append(status.MediaAttachments, Media{
+			Type:        mediaType,
+			Description: attachment.Name,
+		})
 	}
 
-	for i, bookmark := range bookmarks {
-		select {
-		case <-s.ctx.Done():
-			return s.ctx.Err()
-		default:
+	for _, tag := range note.Tag {
+		if tag.Type != "Hashtag" {
+			continue
 		}
+		status.Tags = append(status.Tags, Tag{
+			Name: strings.TrimPrefix(tag.Name, "#"),
+			URL:  tag.Href,
+		})
+	}
 
-		zlog.Debug().Int("index", i+1).Int("total", len(bookmarks)).Str("bookmark_id", bookmark.ID).Msg("Processing bookmark")
+	return status
+}
+
+// readBookmarksCSV reads a bookmarks.csv export, which lists one status URL
+// per line. Lines that are not URLs, such as a header, are skipped.
+func readBookmarksCSV(r io.Reader) ([]importEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
 
-		existingBookmark, err := s.db.getBookmark(bookmark.Status.ID)
+	var entries []importEntry
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
 		if err != nil {
-			zlog.Error().Err(err).Str("bookmark_id", bookmark.ID).Msg("Failed to check if bookmark exists")
+			return nil, fmt.Errorf("failed to parse CSV: %w", err)
+		}
+		if len(record) == 0 {
 			continue
 		}
-
-		if existingBookmark != nil {
-			zlog.Debug().Str("bookmark_id", bookmark.ID).Msg("Bookmark already exists in database, skipping")
+		uri := strings.TrimSpace(record[0])
+		if !strings.HasPrefix(uri, "https://") && !strings.HasPrefix(uri, "http://") {
 			continue
 		}
+		entries = append(entries, importEntry{source: SourceBookmark, uri: uri})
+	}
+	return entries, nil
+}
 
-		dbBookmark := convertBookmarkToDatabase(bookmark, s.config.Search.IndexedFields)
+// runImport implements the import command.
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "path to configuration file")
+	accountName := flags.String("account", "", "name of the [[accounts]] entry to import into; defaults to the first account")
+	offline := flags.Bool("offline", false, "store favourites and bookmarks without looking them up through the API")
+	flags.Parse(args)
 
-		if err := s.db.insertBookmark(dbBookmark); err != nil {
-			zlog.Error().Err(err).Str("bookmark_id", bookmark.ID).Msg("Failed to insert new bookmark")
-			continue
-		}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no files given: pass account archive .zip files or bookmarks .csv files")
+	}
 
-		actualProcessed++
-		zlog.Debug().Str("bookmark_id", bookmark.ID).Msg("New bookmark saved to database")
+	cfg := defaultConfig()
+	if err := loadConfig(*configPath, &cfg); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	setupLogging(cfg.Logging.Level, cfg.Logging.Format)
+	if err := cfg.loadCredentials(); err != nil {
+		return fmt.Errorf("failed to load credentials: %w", err)
+	}
 
-		if s.eventChan != nil {
-			select {
-			case s.eventChan <- ServerEvent{
-				Type: "bookmark_processed",
-				Payload: map[string]interface{}{
-					"bookmark_id":     bookmark.ID,
-					"status_id":       bookmark.Status.ID,
-					"username":        bookmark.Status.Account.Username,
-					"content_preview": stripHTML(bookmark.Status.Content)[:minInt(100, len(stripHTML(bookmark.Status.Content)))],
-					"processed_count": actualProcessed,
-					"total_count":     len(bookmarks),
-				},
-			}:
-			default:
+	accounts, err := cfg.accounts()
+	if err != nil {
+		return fmt.Errorf("invalid account configuration: %w", err)
+	}
+	account := accounts[0]
+	if *accountName != "" {
+		found := false
+		for _, candidate := range accounts {
+			if candidate.Name == *accountName {
+				account = candidate
+				found = true
 			}
 		}
+		if !found {
+			return fmt.Errorf("unknown account: %s", *accountName)
+		}
 	}
 
-	if s.eventChan != nil {
-		select {
-		case s.eventChan <- ServerEvent{
-			Type: "batch_complete",
-			Payload: map[string]interface{}{
-				"processed": actualProcessed,
-				"total":     len(bookmarks),
-				"skipped":   len(bookmarks) - actualProcessed,
-			},
-		}:
-		default:
-		}
+	db, err := newDatabase(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
+	defer db.close()
 
-	zlog.Info().Int("processed", actualProcessed).Int("total", len(bookmarks)).Int("skipped", len(bookmarks)-actualProcessed).Msg("Bookmark batch processing completed")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	importer := newImporter(&cfg, db, account.Name)
+	if !*offline {
+		connection := newAccountConnection(account, db)
+		client, err := connection.bookmarkClient()
+		if err != nil {
+			zlog.Warn().Err(err).Msg("Cannot reach the account's server, storing favourites and bookmarks unresolved")
+		} else {
+			importer.accountID = connection.accountID
+			if resolver, ok := client.(ResolverClient); ok {
+				importer.resolver = resolver
+			}
+		}
+	}
+
+	var total ImportResult
+	for _, path := range flags.Args() {
+		result, err := importer.importFile(ctx, path)
+		if err != nil {
+			return fmt.Errorf("failed to import %s: %w", path, err)
+		}
+		fmt.Printf("%s: %d imported, %d duplicates, %d unresolved\n", path, result.Imported, result.Duplicates, result.Unresolved)
+		total.add(result)
+	}
+	if flags.NArg() > 1 {
+		fmt.Printf("Total: %d imported, %d duplicates, %d unresolved\n", total.Imported, total.Duplicates, total.Unresolved)
+	}
 	return nil
 }
 
 // =============================================================================
-// WEB SERVER AND EVENTS
+// SEARCH REINDEX
 // =============================================================================
 
-type ServerEvent struct {
-	Type    string      `json:"type"`
-	Payload interface{} `json:"payload"`
-}
+// searchIndexFingerprintKey is the archive_metadata key holding the
+// fingerprint of the settings stored search text was built with.
+const searchIndexFingerprintKey = "search_index_fingerprint"
 
-type EventBroadcaster struct {
-	clients  map[chan ServerEvent]bool
-	mutex    sync.RWMutex
-	shutdown bool
-}
+const defaultReindexBatchSize = 500
 
-func newEventBroadcaster() *EventBroadcaster {
-	return &EventBroadcaster{
-		clients: make(map[chan ServerEvent]bool),
-	}
+// ReindexResult counts what a reindex did with the archived bookmarks.
+type ReindexResult struct {
+	Reindexed int
+	// Skipped bookmarks have raw JSON that cannot be read back into a
+	// status, such as rows from very old releases; they keep their text.
+	Skipped int
 }
 
-func (eb *EventBroadcaster) addClient(client chan ServerEvent) {
-	eb.mutex.Lock()
-	defer eb.mutex.Unlock()
-	eb.clients[client] = true
+// searchIndexFingerprint identifies the indexed fields and index columns
+// search text is built with, regardless of the order fields are listed in.
+func searchIndexFingerprint(indexedFields []string) string {
+	fields := slices.Clone(indexedFields)
+	slices.Sort(fields)
+	fields = slices.Compact(fields)
+	sum := sha256.Sum256([]byte(strings.Join(fields, ",") + "\n" + searchColumnList("")))
+	return hex.EncodeToString(sum[:])
 }
 
-func (eb *EventBroadcaster) removeClient(client chan ServerEvent) {
-	eb.mutex.Lock()
-	defer eb.mutex.Unlock()
-
-	if eb.shutdown {
-		return
+// searchIndexCurrent reports whether the stored search text was built with
+// the given indexed fields. Archives from before the fingerprint was kept
+// are not current unless they are empty, in which case the fingerprint is
+// recorded.
+func (d *Database) searchIndexCurrent(indexedFields []string) (bool, error) {
+	fingerprint := searchIndexFingerprint(indexedFields)
+	stored, ok, err := d.getMetadata(searchIndexFingerprintKey)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return stored == fingerprint, nil
 	}
 
-	if _, exists := eb.clients[client]; exists {
-		delete(eb.clients, client)
-		close(client)
+	total, _, err := d.getBookmarkCounts("")
+	if err != nil {
+		return false, err
 	}
+	if total > 0 {
+		return false, nil
+	}
+	return true, d.setMetadata(searchIndexFingerprintKey, fingerprint)
 }
 
-func (eb *EventBroadcaster) broadcast(event ServerEvent) {
-	eb.mutex.RLock()
-	defer eb.mutex.RUnlock()
+// reindexBookmarks rebuilds the search text of every archived bookmark from
+// its raw JSON and the stored link snapshots and threads, batchSize rows at
+// a time. progress, when given, is called after each batch with the number
+// of bookmarks done and the total. The fingerprint of indexedFields is
+// recorded once every row is done.
+func reindexBookmarks(ctx context.Context, db *Database, indexedFields []string, batchSize int, progress func(done, total int)) (ReindexResult, error) {
+	var result ReindexResult
+	if batchSize <= 0 {
+		batchSize = defaultReindexBatchSize
+	}
 
-	if eb.shutdown {
-		return
+	total, _, err := db.getBookmarkCounts("")
+	if err != nil {
+		return result, err
 	}
 
-	for client := range eb.clients {
-		select {
-		case client <- event:
-		default:
+	var afterRowID int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, err
 		}
-	}
-}
 
-func (eb *EventBroadcaster) closeAllClients() {
-	eb.mutex.Lock()
-	defer eb.mutex.Unlock()
+		batch, lastRowID, err := db.getBookmarksAfterRow(afterRowID, batchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		afterRowID = lastRowID
 
-	eb.shutdown = true
-	for client := range eb.clients {
-		close(client)
+		updates := make([]*DBBookmark, 0, len(batch))
+		for _, stored := range batch {
+			var bookmark Bookmark
+			if err := json.Unmarshal([]byte(stored.RawJSON), &bookmark); err != nil || bookmark.Status.ID == "" {
+				result.Skipped++
+				continue
+			}
+			bookmark.LinkText, bookmark.ThreadText = storedSearchContext(db, stored.OwnerAccount, bookmark.Status, indexedFields)
+
+			fields := buildSearchFields(bookmark, indexedFields)
+			stored.SearchFields = fields
+			stored.SearchText = joinSearchFields(fields)
+			updates = append(updates, stored)
+		}
+		if err := db.updateSearchText(updates); err != nil {
+			return result, err
+		}
+		result.Reindexed += len(updates)
+
+		if progress != nil {
+			// Bookmarks archived meanwhile are reindexed too
+			done := result.Reindexed + result.Skipped
+			progress(done, max(total, done))
+		}
 	}
-	eb.clients = make(map[chan ServerEvent]bool)
-}
 
-type WebServer struct {
-	config      *Config
-	db          *Database
-	broadcaster *EventBroadcaster
-	server      *http.Server
+	if err := db.setMetadata(searchIndexFingerprintKey, searchIndexFingerprint(indexedFields)); err != nil {
+		return result, err
+	}
+	return result, nil
 }
 
-func newWebServer(cfg *Config, db *Database, eventChan <-chan ServerEvent) *WebServer {
-	broadcaster := newEventBroadcaster()
-
-	go func() {
-		for event := range eventChan {
-			broadcaster.broadcast(event)
+// storedSearchContext returns the archived text of the page a status links
+// to and of the thread around it, for the indexed fields that include them.
+// Nothing is fetched; statuses archived without them get none.
+func storedSearchContext(db *Database, owner string, status Status, indexedFields []string) (linkText, threadText string) {
+	if slices.Contains(indexedFields, "link_text") && status.Card != nil && status.Card.URL != "" {
+		snapshot, err := db.getLinkSnapshot(status.Card.URL)
+		if err != nil {
+			zlog.Warn().Err(err).Str("status_id", status.ID).Msg("Failed to get link snapshot for reindexing")
+		} else if snapshot != nil {
+			linkText = snapshot.Text
 		}
-	}()
+	}
 
-	return &WebServer{
-		config:      cfg,
-		db:          db,
-		broadcaster: broadcaster,
+	if slices.Contains(indexedFields, "thread") {
+		thread, err := db.getStatusContext(owner, status.ID)
+		if err != nil {
+			zlog.Warn().Err(err).Str("status_id", status.ID).Msg("Failed to get thread context for reindexing")
+		} else {
+			threadText = thread.text()
+		}
 	}
+	return linkText, threadText
 }
 
-func (ws *WebServer) setupRoutes() *http.ServeMux {
-	mux := http.NewServeMux()
+// runReindex implements the reindex command.
+func runReindex(args []string) error {
+	flags := flag.NewFlagSet("reindex", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "path to configuration file")
+	batchSize := flags.Int("batch-size", defaultReindexBatchSize, "number of bookmarks to reindex per transaction")
+	flags.Parse(args)
 
-	webSubFS, err := fs.Sub(webFS, "web")
+	cfg := defaultConfig()
+	if err := loadConfig(*configPath, &cfg); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	setupLogging(cfg.Logging.Level, cfg.Logging.Format)
+
+	db, err := newDatabase(cfg)
 	if err != nil {
-		zlog.Error().Err(err).Msg("Failed to create web sub-filesystem")
-		return mux
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
+	defer db.close()
 
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(webSubFS))))
-	mux.HandleFunc("/", ws.handleIndex)
-	mux.HandleFunc("/api/search", ws.handleSearch)
-	mux.HandleFunc("/api/stats", ws.handleStats)
-	mux.HandleFunc("/api/events", ws.handleEvents)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	return mux
+	result, err := reindexBookmarks(ctx, db, cfg.Search.IndexedFields, *batchSize, func(done, total int) {
+		fmt.Printf("Reindexed %d/%d bookmarks\n", done, total)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Done: %d reindexed, %d skipped\n", result.Reindexed, result.Skipped)
+	return nil
 }
 
-func (ws *WebServer) handleIndex(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
+// =============================================================================
+// MIGRATE COMMAND
+// =============================================================================
+
+// runMigrate implements the migrate command. status lists the schema
+// migrations and whether each has been applied; up applies pending ones
+// without starting the archiver.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "path to configuration file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s migrate [--config FILE] status|up\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return fmt.Errorf("expected one of status or up")
 	}
 
-	data, err := webFS.ReadFile("web/index.html")
+	cfg := defaultConfig()
+	if err := loadConfig(*configPath, &cfg); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	setupLogging(cfg.Logging.Level, cfg.Logging.Format)
+
+	db, err := openDatabase(cfg)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		zlog.Error().Err(err).Msg("Failed to read index.html")
-		return
+		return fmt.Errorf("failed to initialize database: %w", err)
 	}
+	defer db.close()
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	if _, err := w.Write(data); err != nil {
-		zlog.Error().Err(err).Msg("failed to write response data")
+	switch flags.Arg(0) {
+	case "status":
+	case "up":
+		if err := db.runMigrations(); err != nil {
+			return err
+		}
+	default:
+		flags.Usage()
+		return fmt.Errorf("unknown migrate subcommand %q", flags.Arg(0))
+	}
+
+	statuses, err := db.migrationStatus()
+	if err != nil {
+		return err
 	}
+	printMigrationStatus(os.Stdout, statuses)
+	return nil
 }
 
-func (ws *WebServer) handleSearch(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+func printMigrationStatus(out io.Writer, statuses []MigrationStatus) {
+	pending := 0
+	for _, status := range statuses {
+		state := "pending"
+		switch {
+		case status.Unknown:
+			state = "applied by a newer release"
+		case status.AppliedAt != nil:
+			state = "applied " + status.AppliedAt.Local().Format(time.DateTime)
+		default:
+			pending++
+		}
+		fmt.Fprintf(out, "%4d  %-32s %s\n", status.Version, status.Name, state)
 	}
+	fmt.Fprintf(out, "Schema version %d, %d pending\n", latestSchemaVersion(), pending)
+}
 
-	var request SearchRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
-		return
+// =============================================================================
+// HASH PASSWORD COMMAND
+// =============================================================================
+
+// runHashPassword reads a password from standard input and prints its hash
+// for auth.password_hash.
+func runHashPassword(args []string) error {
+	flags := flag.NewFlagSet("hash-password", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s hash-password < PASSWORD_FILE\n", os.Args[0])
+		fmt.Fprintln(flags.Output(), "Reads the password from the first line of standard input.")
+		flags.PrintDefaults()
 	}
+	flags.Parse(args)
 
-	results, err := ws.db.searchOrRecentBookmarks(&request)
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	password = strings.TrimRight(password, "\r\n")
+	if password == "" {
+		return fmt.Errorf("the password must not be empty")
+	}
+
+	hash, err := hashPassword(password, passwordHashIterations)
 	if err != nil {
-		zlog.Error().Err(err).Str("query", request.Query).Msg("Search failed")
-		http.Error(w, "Search failed", http.StatusInternalServerError)
-		return
+		return err
 	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Println(hash)
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-cache")
+// =============================================================================
+// LOGIN COMMAND
+// =============================================================================
 
-	if err := json.NewEncoder(w).Encode(results); err != nil {
-		zlog.Error().Err(err).Msg("Failed to encode search results")
+// noRedirectURI asks the server to show the authorization code to the user
+// instead of redirecting to an application.
+const noRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+// Credentials are what the login command obtains for one account. Account
+// is the name of the [[accounts]] entry they belong to, or empty for the
+// [mastodon] section.
+type Credentials struct {
+	Account      string `toml:"account"`
+	Server       string `toml:"server"`
+	ClientID     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	AccessToken  string `toml:"access_token"`
+}
+
+// credentialsFile is the layout of the file named by
+// mastodon.credentials_file.
+type credentialsFile struct {
+	Credentials []Credentials `toml:"credentials"`
+}
+
+// OAuthLogin registers bookmarchive with a server and runs the OAuth
+// authorization code flow to obtain an access token.
+type OAuthLogin struct {
+	server     string
+	scopes     string
+	httpClient *http.Client
+	in         *bufio.Reader
+	out        io.Writer
+}
+
+func newOAuthLogin(server, scopes string, in io.Reader, out io.Writer) (*OAuthLogin, error) {
+	server = strings.TrimRight(strings.TrimSpace(server), "/")
+	if server == "" {
+		return nil, fmt.Errorf("mastodon server URL is required")
+	}
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	if _, err := url.ParseRequestURI(server); err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
 	}
+
+	return &OAuthLogin{
+		server:     server,
+		scopes:     scopes,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		in:         bufio.NewReader(in),
+		out:        out,
+	}, nil
 }
 
-func (ws *WebServer) handleStats(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// loginScopes returns the OAuth scopes needed to archive what cfg
+// configures: bookmarks and the account always, favourites and statuses
+// only when a source or feature reads them.
+func loginScopes(cfg *Config) string {
+	scopes := []string{"read:bookmarks", "read:accounts"}
+	readStatuses := cfg.Refresh.Enabled || cfg.Threads.Enabled
+	sources, _ := cfg.sources()
+	for _, source := range sources {
+		switch source {
+		case SourceFavourite:
+			scopes = append(scopes, "read:favourites")
+		case SourceOwnStatus:
+			readStatuses = true
+		}
+	}
+	if readStatuses {
+		scopes = append(scopes, "read:statuses")
 	}
+	return strings.Join(scopes, " ")
+}
 
-	var totalCount int
-	err := ws.db.db.QueryRow("SELECT COUNT(*) FROM bookmarks").Scan(&totalCount)
-	if err != nil {
-		zlog.Error().Err(err).Msg("Failed to get bookmark count")
-		http.Error(w, "Failed to get stats", http.StatusInternalServerError)
-		return
+// login registers an application and asks the user to authorize it. With
+// local set, the authorization arrives on a redirect listener on the
+// loopback interface; otherwise the user pastes the code the server shows.
+func (l *OAuthLogin) login(ctx context.Context, local bool) (*Credentials, error) {
+	redirectURI := noRedirectURI
+	var listener net.Listener
+	if local {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to start redirect listener: %w", err)
+		}
+		defer listener.Close()
+		redirectURI = fmt.Sprintf("http://%s/callback", listener.Addr())
 	}
 
-	backfillState, err := ws.db.getBackfillState()
+	clientID, clientSecret, err := l.registerApp(ctx, redirectURI)
 	if err != nil {
-		zlog.Error().Err(err).Msg("Failed to get backfill state")
-		backfillState = &BackfillState{}
+		return nil, err
 	}
 
-	stats := map[string]interface{}{
-		"total_bookmarks":   totalCount,
-		"backfill_complete": backfillState.BackfillComplete,
-		"last_poll_time":    backfillState.LastPollTime,
-		"updated_at":        time.Now(),
+	state, err := randomState()
+	if err != nil {
+		return nil, err
 	}
+	fmt.Fprintf(l.out, "Open this URL in your browser and authorize bookmarchive:\n\n  %s\n\n",
+		l.authorizeURL(clientID, redirectURI, state))
 
-	w.Header().Set("Content-Type", "application/json")
-	w.Header().Set("Cache-Control", "no-cache")
+	var code string
+	if local {
+		fmt.Fprintln(l.out, "Waiting for the authorization...")
+		code, err = l.waitForCallback(ctx, listener, state)
+	} else {
+		fmt.Fprint(l.out, "Paste the authorization code: ")
+		code, err = l.in.ReadString('\n')
+		if errors.Is(err, io.EOF) && code != "" {
+			err = nil
+		}
+		code = strings.TrimSpace(code)
+		if err == nil && code == "" {
+			err = fmt.Errorf("no authorization code given")
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get authorization code: %w", err)
+	}
 
-	if err := json.NewEncoder(w).Encode(stats); err != nil {
-		zlog.Error().Err(err).Msg("Failed to encode stats")
+	accessToken, err := l.exchangeCode(ctx, clientID, clientSecret, redirectURI, code)
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (ws *WebServer) handleEvents(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+	username, err := l.verify(ctx, accessToken)
+	if err != nil {
+		return nil, err
 	}
+	fmt.Fprintf(l.out, "Logged in as @%s\n", username)
 
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
-	w.Header().Set("X-Accel-Buffering", "no")
+	return &Credentials{
+		Server:       l.server,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		AccessToken:  accessToken,
+	}, nil
+}
 
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
+func (l *OAuthLogin) registerApp(ctx context.Context, redirectURI string) (string, string, error) {
+	var app struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
 	}
+	form := url.Values{
+		"client_name":   {"bookmarchive"},
+		"redirect_uris": {redirectURI},
+		"scopes":        {l.scopes},
+		"website":       {"https://github.com/hiway/bookmarchive"},
+	}
+	if err := l.postForm(ctx, "/api/v1/apps", form, &app); err != nil {
+		return "", "", fmt.Errorf("failed to register application: %w", err)
+	}
+	if app.ClientID == "" || app.ClientSecret == "" {
+		return "", "", fmt.Errorf("failed to register application: server returned no client credentials")
+	}
+	return app.ClientID, app.ClientSecret, nil
+}
 
-	client := make(chan ServerEvent, 50)
-	ws.broadcaster.addClient(client)
-	defer ws.broadcaster.removeClient(client)
-
-	ctx := r.Context()
+func (l *OAuthLogin) authorizeURL(clientID, redirectURI, state string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {clientID},
+		"redirect_uri":  {redirectURI},
+		"scope":         {l.scopes},
+		"state":         {state},
+	}
+	return l.server + "/oauth/authorize?" + query.Encode()
+}
 
-	fmt.Fprint(w, "data: {\"type\":\"connected\"}\n\n")
-	if flusher, ok := w.(http.Flusher); ok {
-		flusher.Flush()
+// waitForCallback serves the redirect listener until the server sends the
+// browser back with a code for this login's state.
+func (l *OAuthLogin) waitForCallback(ctx context.Context, listener net.Listener, state string) (string, error) {
+	type callback struct {
+		code string
+		err  error
 	}
+	callbacks := make(chan callback, 1)
 
-	go func() {
-		var totalCount int
-		if err := ws.db.db.QueryRow("SELECT COUNT(*) FROM bookmarks").Scan(&totalCount); err == nil {
-			select {
-			case client <- ServerEvent{
-				Type: "stats",
-				Payload: map[string]interface{}{
-					"total_bookmarks": totalCount,
-				},
-			}:
-			case <-ctx.Done():
+	server := &http.Server{
+		ReadHeaderTimeout: 10 * time.Second,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/callback" {
+				http.NotFound(w, r)
 				return
-			default:
 			}
-		}
-	}()
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case event, ok := <-client:
-			if !ok {
+			query := r.URL.Query()
+			if query.Get("state") != state {
+				http.Error(w, "Invalid state", http.StatusBadRequest)
 				return
 			}
 
-			data, err := json.Marshal(event)
-			if err != nil {
-				zlog.Debug().Err(err).Msg("Failed to marshal SSE event")
-				continue
-			}
-
-			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			var result callback
+			if denied := query.Get("error"); denied != "" {
+				result.err = fmt.Errorf("authorization denied: %s", denied)
+				fmt.Fprintln(w, "Authorization failed. You can close this window.")
+			} else if code := query.Get("code"); code != "" {
+				result.code = code
+				fmt.Fprintln(w, "Bookmarchive is authorized. You can close this window.")
+			} else {
+				http.Error(w, "Missing code", http.StatusBadRequest)
 				return
 			}
-
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			}
-
-		case <-ticker.C:
-			if _, err := fmt.Fprint(w, "data: {\"type\":\"heartbeat\"}\n\n"); err != nil {
-				return
+			select {
+			case callbacks <- result:
+			default:
 			}
+		}),
+	}
+	go server.Serve(listener)
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 
-			if flusher, ok := w.(http.Flusher); ok {
-				flusher.Flush()
-			}
-		}
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case result := <-callbacks:
+		return result.code, result.err
 	}
 }
 
-func (ws *WebServer) start() error {
-	addr := fmt.Sprintf("%s:%d", ws.config.Web.Listen, ws.config.Web.Port)
-
-	ws.server = &http.Server{
-		Addr:         addr,
-		Handler:      ws.setupRoutes(),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 0,
-		IdleTimeout:  120 * time.Second,
+func (l *OAuthLogin) exchangeCode(ctx context.Context, clientID, clientSecret, redirectURI, code string) (string, error) {
+	var token struct {
+		AccessToken string `json:"access_token"`
 	}
-
-	zlog.Info().Str("address", addr).Msg("Starting web server")
-
-	go func() {
-		if err := ws.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			zlog.Error().Err(err).Msg("Web server error")
-		}
-	}()
-
-	return nil
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+		"redirect_uri":  {redirectURI},
+		"code":          {code},
+		"scope":         {l.scopes},
+	}
+	if err := l.postForm(ctx, "/oauth/token", form, &token); err != nil {
+		return "", fmt.Errorf("failed to obtain access token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("failed to obtain access token: server returned no token")
+	}
+	return token.AccessToken, nil
 }
 
-func (ws *WebServer) stop() error {
-	if ws.server == nil {
-		return nil
+// verify checks the new token and returns the account's username.
+func (l *OAuthLogin) verify(ctx context.Context, accessToken string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", l.server+"/api/v1/accounts/verify_credentials", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("User-Agent", "bookmarchive/1.0")
 
-	zlog.Info().Msg("Stopping web server")
+	var account struct {
+		Acct string `json:"acct"`
+	}
+	if err := l.do(req, &account); err != nil {
+		return "", fmt.Errorf("failed to verify credentials: %w", err)
+	}
+	return account.Acct, nil
+}
 
-	if ws.broadcaster != nil {
-		ws.broadcaster.closeAllClients()
+func (l *OAuthLogin) postForm(ctx context.Context, path string, form url.Values, result interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", l.server+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", "bookmarchive/1.0")
+	return l.do(req, result)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
-	defer cancel()
+func (l *OAuthLogin) do(req *http.Request, result interface{}) error {
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
 
-	if err := ws.server.Shutdown(ctx); err != nil {
-		if err := ws.server.Close(); err != nil {
-			return fmt.Errorf("failed to force close server: %w", err)
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
 		}
-		zlog.Debug().Msg("Web server force closed after graceful shutdown timeout")
-		return nil
+		json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+		if apiErr.ErrorDescription != "" {
+			return fmt.Errorf("server returned status %d: %s", resp.StatusCode, apiErr.ErrorDescription)
+		}
+		if apiErr.Error != "" {
+			return fmt.Errorf("server returned status %d: %s", resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("server returned status %d", resp.StatusCode)
 	}
 
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
 	return nil
 }
 
-// =============================================================================
-// MAIN APPLICATION
-// =============================================================================
+func randomState() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := crand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
 
-type BookmarchiveApp struct {
-	config          Config
-	ctx             context.Context
-	cancel          context.CancelFunc
-	db              *Database
-	mastodonClient  *MastodonClient
-	bookmarkService *BookmarkService
-	webServer       *WebServer
-	eventChan       chan ServerEvent
+// loadCredentialsFile reads the credentials saved by the login command.
+func loadCredentialsFile(path string) ([]Credentials, error) {
+	var file credentialsFile
+	if _, err := toml.DecodeFile(path, &file); err != nil {
+		return nil, err
+	}
+	return file.Credentials, nil
 }
 
-func newBookmarchiveApp(cfg *Config) (*BookmarchiveApp, error) {
-	zlog.Info().Msg("Starting bookmarchive service")
+// saveCredentialsFile stores creds in the credentials file at path,
+// replacing earlier credentials for the same account.
+func saveCredentialsFile(path string, creds Credentials) error {
+	existing, err := loadCredentialsFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
+	file := credentialsFile{}
+	replaced := false
+	for _, entry := range existing {
+		if entry.Account == creds.Account {
+			entry = creds
+			replaced = true
+		}
+		file.Credentials = append(file.Credentials, entry)
+	}
+	if !replaced {
+		file.Credentials = append(file.Credentials, creds)
+	}
 
-	db, err := newDatabase(*cfg)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	var buf bytes.Buffer
+	buf.WriteString("# Written by bookmarchive login. Keep this file private.\n\n")
+	if err := toml.NewEncoder(&buf).Encode(file); err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
 	}
+	return writeFileAtomic(path, buf.Bytes(), 0600)
+}
 
-	mastodonClient, err := newMastodonClient(cfg)
-	if err != nil {
-		db.close()
-		cancel()
-		return nil, fmt.Errorf("failed to create mastodon client: %w", err)
+// loadCredentials applies the credentials file, when one is configured, to
+// the [mastodon] section and the [[accounts]] entries. Credentials for an
+// account the config does not list add that account.
+func (c *Config) loadCredentials() error {
+	path := c.Mastodon.CredentialsFile
+	if path == "" {
+		return nil
 	}
 
-	eventChan := make(chan ServerEvent, 100)
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read credentials file: %w", err)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		zlog.Warn().
+			Str("path", path).
+			Str("mode", info.Mode().Perm().String()).
+			Msg("Credentials file is accessible by other users")
+	}
 
-	bookmarkService, err := newBookmarkService(cfg, db, eventChan)
+	credentials, err := loadCredentialsFile(path)
 	if err != nil {
-		db.close()
-		cancel()
-		return nil, fmt.Errorf("failed to create bookmark service: %w", err)
+		return fmt.Errorf("failed to read credentials file: %w", err)
 	}
 
-	webServer := newWebServer(cfg, db, eventChan)
+	for _, creds := range credentials {
+		if creds.Account == "" {
+			c.Mastodon.Server = creds.Server
+			c.Mastodon.ClientID = creds.ClientID
+			c.Mastodon.ClientSecret = creds.ClientSecret
+			c.Mastodon.AccessToken = creds.AccessToken
+			continue
+		}
 
-	return &BookmarchiveApp{
-		config:          *cfg,
-		ctx:             ctx,
-		cancel:          cancel,
-		db:              db,
-		mastodonClient:  mastodonClient,
-		bookmarkService: bookmarkService,
-		webServer:       webServer,
-		eventChan:       eventChan,
-	}, nil
+		found := false
+		for i := range c.Accounts {
+			if c.Accounts[i].Name == creds.Account {
+				c.Accounts[i].Server = creds.Server
+				c.Accounts[i].ClientID = creds.ClientID
+				c.Accounts[i].ClientSecret = creds.ClientSecret
+				c.Accounts[i].AccessToken = creds.AccessToken
+				found = true
+			}
+		}
+		if !found {
+			c.Accounts = append(c.Accounts, AccountConfig{
+				Name:         creds.Account,
+				Server:       creds.Server,
+				ClientID:     creds.ClientID,
+				ClientSecret: creds.ClientSecret,
+				AccessToken:  creds.AccessToken,
+			})
+		}
+	}
+	return nil
 }
 
-func (app *BookmarchiveApp) start() error {
-	zlog.Info().Msg("Bookmarchive service started")
+// updateConfigCredentials writes creds into the config file at path, in the
+// [mastodon] section or the [[accounts]] entry named creds.Account, adding
+// the section when it is missing. The rest of the file, comments included,
+// is left as it was.
+func updateConfigCredentials(path string, creds Credentials) error {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
 
-	if err := app.webServer.start(); err != nil {
-		return fmt.Errorf("failed to start web server: %w", err)
+	var lines []string
+	if trimmed := strings.TrimRight(string(content), "\n"); trimmed != "" {
+		lines = strings.Split(trimmed, "\n")
 	}
 
-	go func() {
-		if err := app.bookmarkService.start(); err != nil {
-			if err == context.Canceled {
-				zlog.Debug().Msg("Bookmark service stopped due to context cancellation")
-			} else {
-				zlog.Error().Err(err).Msg("Bookmark service error")
+	header := "[mastodon]"
+	if creds.Account != "" {
+		header = "[[accounts]]"
+	}
+	start, end := findTOMLTable(lines, header, creds.Account)
+	if start < 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		start = len(lines)
+		lines = append(lines, header)
+		if creds.Account != "" {
+			lines = append(lines, "name = "+strconv.Quote(creds.Account))
+		}
+		end = len(lines)
+	}
+
+	values := []struct{ key, value string }{
+		{"server", creds.Server},
+		{"client_id", creds.ClientID},
+		{"client_secret", creds.ClientSecret},
+		{"access_token", creds.AccessToken},
+	}
+	for _, kv := range values {
+		line := kv.key + " = " + strconv.Quote(kv.value)
+		insertAt := start + 1
+		replaced := false
+		for i := start + 1; i < end; i++ {
+			key := tomlLineKey(lines[i])
+			if key == kv.key {
+				indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+				lines[i] = indent + line
+				replaced = true
+				break
+			}
+			if key != "" {
+				insertAt = i + 1
 			}
 		}
-	}()
+		if !replaced {
+			lines = append(lines[:insertAt], append([]string{line}, lines[insertAt:]...)...)
+			end++
+		}
+	}
 
-	return nil
+	return writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0600)
 }
 
-func (app *BookmarchiveApp) run() error {
-	if err := app.start(); err != nil {
-		return err
+// findTOMLTable returns the line range of the table with the given header,
+// from its header line to the next header. For [[accounts]] the entry whose
+// name is name is returned. start is -1 when there is no such table.
+func findTOMLTable(lines []string, header, name string) (start, end int) {
+	start = -1
+	for i := 0; i <= len(lines); i++ {
+		isHeader := i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "[")
+		if i < len(lines) && !isHeader {
+			continue
+		}
+		if start >= 0 {
+			if header != "[[accounts]]" || tomlTableString(lines[start+1:i], "name") == name {
+				return start, i
+			}
+			start = -1
+		}
+		if isHeader && tomlTableHeader(lines[i]) == header {
+			start = i
+		}
 	}
+	return -1, -1
+}
 
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+// tomlTableHeader returns a table header line without spaces or comments.
+func tomlTableHeader(line string) string {
+	line = strings.TrimSpace(line)
+	if i := strings.LastIndex(line, "]"); i >= 0 {
+		line = line[:i+1]
+	}
+	return strings.Join(strings.Fields(line), "")
+}
 
-	<-sigChan
-	zlog.Info().Msg("Shutdown signal received")
+// tomlLineKey returns the key a "key = value" line sets, or "" for other
+// lines.
+func tomlLineKey(line string) string {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "[") {
+		return ""
+	}
+	key, _, found := strings.Cut(line, "=")
+	if !found {
+		return ""
+	}
+	return strings.Trim(strings.TrimSpace(key), `"'`)
+}
 
-	return app.stop()
+// tomlTableString returns the string value of key among a table's lines.
+func tomlTableString(lines []string, key string) string {
+	for _, line := range lines {
+		if tomlLineKey(line) != key {
+			continue
+		}
+		_, value, _ := strings.Cut(line, "=")
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return ""
+		}
+		quote := value[:1]
+		if quote != `"` && quote != "'" {
+			return ""
+		}
+		if end := strings.Index(value[1:], quote); end >= 0 {
+			return value[1 : end+1]
+		}
+	}
+	return ""
 }
 
-func (app *BookmarchiveApp) stop() error {
-	zlog.Info().Msg("Stopping bookmarchive service")
+// writeFileAtomic replaces the file at path with data, so that readers
+// never see a partly written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
 
-	if app.cancel != nil {
-		app.cancel()
+	if err := tmp.Chmod(perm); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
 	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
 
-	if app.eventChan != nil {
-		close(app.eventChan)
+// runLogin implements the login command: it obtains an access token for
+// one account and saves it in the credentials file, when one is given or
+// configured, or else in the config file.
+func runLogin(args []string) error {
+	flags := flag.NewFlagSet("login", flag.ExitOnError)
+	configPath := flags.String("config", "config.toml", "path to configuration file")
+	server := flags.String("server", "", "URL of the Mastodon server to log in to (required)")
+	account := flags.String("account", "", "name of the [[accounts]] entry to log in; empty for [mastodon]")
+	credentialsPath := flags.String("credentials", "", "write the credentials to this file instead of the config")
+	local := flags.Bool("local", false, "receive the authorization on a local redirect listener instead of pasting a code")
+	flags.Parse(args)
+
+	if *server == "" {
+		return fmt.Errorf("--server is required")
 	}
 
-	if app.bookmarkService != nil {
-		if err := app.bookmarkService.stop(); err != nil {
-			zlog.Error().Err(err).Msg("Error stopping bookmark service")
-		}
+	cfg := defaultConfig()
+	if err := loadConfig(*configPath, &cfg); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if app.webServer != nil {
-		if err := app.webServer.stop(); err != nil {
-			zlog.Debug().Err(err).Msg("Web server stop completed with timeout - this is normal during shutdown")
-		}
+	target := *credentialsPath
+	if target == "" {
+		target = cfg.Mastodon.CredentialsFile
 	}
 
-	if app.db != nil {
-		if err := app.db.close(); err != nil {
-			zlog.Error().Err(err).Msg("Error closing database")
+	login, err := newOAuthLogin(*server, loginScopes(&cfg), os.Stdin, os.Stdout)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	creds, err := login.login(ctx, *local)
+	if err != nil {
+		return err
+	}
+	creds.Account = *account
+
+	if target != "" {
+		if err := saveCredentialsFile(target, *creds); err != nil {
+			return err
+		}
+		fmt.Printf("Credentials written to %s\n", target)
+		if cfg.Mastodon.CredentialsFile != target {
+			fmt.Printf("Set credentials_file = %q in the [mastodon] section of %s to use them.\n", target, *configPath)
 		}
+		return nil
 	}
 
-	zlog.Info().Msg("Bookmarchive service stopped")
+	if err := updateConfigCredentials(*configPath, *creds); err != nil {
+		return err
+	}
+	fmt.Printf("Credentials written to %s\n", *configPath)
 	return nil
 }
 
@@ -2183,6 +11734,41 @@ func (app *BookmarchiveApp) stop() error {
 // =============================================================================
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "login":
+			if err := runLogin(os.Args[2:]); err != nil {
+				log.Fatalf("Login failed: %v", err)
+			}
+			return
+		case "import":
+			if err := runImport(os.Args[2:]); err != nil {
+				log.Fatalf("Import failed: %v", err)
+			}
+			return
+		case "reindex":
+			if err := runReindex(os.Args[2:]); err != nil {
+				log.Fatalf("Reindex failed: %v", err)
+			}
+			return
+		case "export":
+			if err := runExport(os.Args[2:]); err != nil {
+				log.Fatalf("Export failed: %v", err)
+			}
+			return
+		case "migrate":
+			if err := runMigrate(os.Args[2:]); err != nil {
+				log.Fatalf("Migrate failed: %v", err)
+			}
+			return
+		case "hash-password":
+			if err := runHashPassword(os.Args[2:]); err != nil {
+				log.Fatalf("Hashing password failed: %v", err)
+			}
+			return
+		}
+	}
+
 	configPath := flag.String("config", "config.toml", "path to configuration file")
 	logLevel := flag.String("l", "", "log level (trace, debug, info, warn, error, fatal, panic)")
 	logLevelLong := flag.String("log-level", "", "log level (trace, debug, info, warn, error, fatal, panic)")
@@ -2203,10 +11789,24 @@ func main() {
 		fmt.Println()
 		fmt.Println("Usage:")
 		fmt.Printf("  %s [options]\n", os.Args[0])
+		fmt.Printf("  %s login --server URL [login options]\n", os.Args[0])
+		fmt.Printf("  %s import [import options] FILE...\n", os.Args[0])
+		fmt.Printf("  %s reindex [--config FILE] [--batch-size N]\n", os.Args[0])
+		fmt.Printf("  %s migrate [--config FILE] status|up\n", os.Args[0])
+		fmt.Printf("  %s export --format FORMAT [export options]\n", os.Args[0])
+		fmt.Printf("  %s hash-password < PASSWORD_FILE\n", os.Args[0])
 		fmt.Println()
 		fmt.Println("Options:")
 		flag.PrintDefaults()
 		fmt.Println()
+		fmt.Println("Commands:")
+		fmt.Println("  login          Authorize bookmarchive with a Mastodon account and save the token")
+		fmt.Println("  import         Import an account archive ZIP or a bookmarks CSV export")
+		fmt.Println("  reindex        Rebuild the search text of every bookmark from the current search settings")
+		fmt.Println("  migrate        Show the database schema migrations, or apply pending ones")
+		fmt.Println("  export         Write the archive as json, jsonl, csv, markdown or netscape-html")
+		fmt.Println("  hash-password  Print the hash of a web interface password for auth.password_hash")
+		fmt.Println()
 		fmt.Println("Configuration:")
 		fmt.Println("  Copy config.toml.sample to config.toml and edit as needed.")
 		fmt.Println("  The application will create a SQLite database at the configured path.")
@@ -2247,6 +11847,10 @@ func main() {
 	}
 	setupLogging(logLevelToUse, cfg.Logging.Format)
 
+	if err := cfg.loadCredentials(); err != nil {
+		log.Fatalf("Failed to load credentials: %v", err)
+	}
+
 	app, err := newBookmarchiveApp(&cfg)
 	if err != nil {
 		log.Fatalf("Failed to create application: %v", err)
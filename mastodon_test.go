@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -19,9 +20,12 @@ import (
 func TestNewMastodonClient_Success(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:        "https://mastodon.example.com",
 			AccessToken:   "test-token",
@@ -55,9 +59,12 @@ func TestNewMastodonClient_Success(t *testing.T) {
 func TestNewMastodonClient_DefaultTimeout(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -79,9 +86,12 @@ func TestNewMastodonClient_DefaultTimeout(t *testing.T) {
 func TestNewMastodonClient_EmptyServer(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "", // Empty server
 			AccessToken: "test-token",
@@ -102,9 +112,12 @@ func TestNewMastodonClient_EmptyServer(t *testing.T) {
 func TestNewMastodonClient_EmptyAccessToken(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "", // Empty access token
@@ -125,9 +138,12 @@ func TestNewMastodonClient_EmptyAccessToken(t *testing.T) {
 func TestNewMastodonClient_InvalidTimeout(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:        "https://mastodon.example.com",
 			AccessToken:   "test-token",
@@ -166,6 +182,26 @@ func TestMastodonClient_InitMadonClient_Success(t *testing.T) {
 	}
 }
 
+func TestMastodonClient_InitMadonClient_UsesClientCredentials(t *testing.T) {
+	client, err := newAccountMastodonClient(AccountConfig{
+		Server:       "https://mastodon.example.com",
+		AccessToken:  "test-token",
+		ClientID:     "app-id",
+		ClientSecret: "app-secret",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	madonClient, err := client.getMadonClient()
+	if err != nil {
+		t.Fatalf("Failed to init madon client: %v", err)
+	}
+	if madonClient.ID != "app-id" || madonClient.Secret != "app-secret" {
+		t.Errorf("Expected registered app credentials, got %q/%q", madonClient.ID, madonClient.Secret)
+	}
+}
+
 func TestMastodonClient_InitMadonClient_AlreadyInitialized(t *testing.T) {
 	// Create a mock madon client
 	mockClient := &madon.Client{}
@@ -373,6 +409,122 @@ func TestRateLimiter_Wait_ContextTimeout(t *testing.T) {
 	}
 }
 
+func TestRateLimiter_Update_FollowsServerHeaders(t *testing.T) {
+	rl := newRateLimiter(150, 5*time.Minute)
+	now := time.Now()
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "300")
+	header.Set("X-RateLimit-Remaining", "1")
+	header.Set("X-RateLimit-Reset", now.Add(time.Minute).UTC().Format(time.RFC3339Nano))
+	rl.update(header, now)
+
+	if delay := rl.reserve(now); delay != 0 {
+		t.Fatalf("Expected the remaining request to be allowed, got delay %v", delay)
+	}
+	delay := rl.reserve(now)
+	if delay <= 0 || delay > time.Minute {
+		t.Errorf("Expected to wait for the server's reset, got %v", delay)
+	}
+
+	state := rl.state()
+	if state.Limit != 300 || state.Remaining == nil || *state.Remaining != 0 || state.ResetAt == nil {
+		t.Errorf("Unexpected limiter state: %+v", state)
+	}
+
+	// Once the server's window has passed the local window applies again
+	if delay := rl.reserve(now.Add(2 * time.Minute)); delay != 0 {
+		t.Errorf("Expected request after reset to be allowed, got delay %v", delay)
+	}
+}
+
+func TestRateLimiter_Update_IgnoresIncompleteHeaders(t *testing.T) {
+	rl := newRateLimiter(1, time.Minute)
+	now := time.Now()
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Remaining", "100")
+	rl.update(header, now)
+
+	rl.reserve(now)
+	if delay := rl.reserve(now); delay == 0 {
+		t.Error("Expected the local window to apply without a reset header")
+	}
+}
+
+func TestRateLimiter_Block(t *testing.T) {
+	rl := newRateLimiter(150, 5*time.Minute)
+	now := time.Now()
+	rl.block(now.Add(30 * time.Second))
+
+	if delay := rl.reserve(now); delay != 30*time.Second {
+		t.Errorf("Expected 30s delay while blocked, got %v", delay)
+	}
+	if state := rl.state(); state.BlockedUntil == nil {
+		t.Error("Expected blocked_until in limiter state")
+	}
+	if delay := rl.reserve(now.Add(31 * time.Second)); delay != 0 {
+		t.Errorf("Expected request after block to be allowed, got %v", delay)
+	}
+}
+
+func TestParseRateLimitReset(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"2024-01-01T12:05:00.000Z", now.Add(5 * time.Minute), true},
+		{"60", now.Add(time.Minute), true},
+		{"1704110700", time.Unix(1704110700, 0), true},
+		{"", time.Time{}, false},
+		{"soon", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRateLimitReset(tt.value, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseRateLimitReset(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		{"-5", 0, false},
+		{"later", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value, now)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.value, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRetryDelay_JitteredAndCapped(t *testing.T) {
+	for attempt := 1; attempt <= 4; attempt++ {
+		full := time.Second << (attempt - 1)
+		for i := 0; i < 20; i++ {
+			delay := retryDelay(attempt, time.Second, maxRetryDelay)
+			if delay < full/2 || delay > full {
+				t.Errorf("retryDelay(%d) = %v, want between %v and %v", attempt, delay, full/2, full)
+			}
+		}
+	}
+	if delay := retryDelay(40, time.Second, maxRetryDelay); delay > maxRetryDelay {
+		t.Errorf("Expected delay capped at %v, got %v", maxRetryDelay, delay)
+	}
+}
+
 // =============================================================================
 // MASTODON BOOKMARK CLIENT TESTS
 // =============================================================================
@@ -749,6 +901,102 @@ func TestMastodonBookmarkClient_GetBookmarks_HTTP429Retry(t *testing.T) {
 	}
 }
 
+func TestMastodonBookmarkClient_GetBookmarks_RetryBuildsFreshRequest(t *testing.T) {
+	var authHeaders []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authHeaders = append(authHeaders, r.Header.Get("Authorization"))
+		if len(authHeaders) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[]")
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 3)
+	bc.retryBaseDelay = time.Millisecond
+
+	if _, _, err := bc.GetBookmarks(context.Background(), 20, ""); err != nil {
+		t.Fatalf("Expected success after retries, got %v", err)
+	}
+	if len(authHeaders) != 3 {
+		t.Fatalf("Expected 3 requests, got %d", len(authHeaders))
+	}
+	for i, header := range authHeaders {
+		if header != "Bearer test-token" {
+			t.Errorf("Request %d: expected Authorization header, got %q", i, header)
+		}
+	}
+}
+
+func TestMastodonBookmarkClient_GetBookmarks_HonorsRetryAfter(t *testing.T) {
+	var requestTimes []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestTimes = append(requestTimes, time.Now())
+		if len(requestTimes) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("X-RateLimit-Limit", "300")
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		w.Header().Set("X-RateLimit-Reset", time.Now().Add(5*time.Minute).UTC().Format(time.RFC3339))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, "[]")
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 2)
+	bc.retryBaseDelay = time.Millisecond
+
+	if _, _, err := bc.GetBookmarks(context.Background(), 20, ""); err != nil {
+		t.Fatalf("Expected success after Retry-After, got %v", err)
+	}
+	if len(requestTimes) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requestTimes))
+	}
+	if gap := requestTimes[1].Sub(requestTimes[0]); gap < 900*time.Millisecond {
+		t.Errorf("Expected retry to wait for Retry-After, waited %v", gap)
+	}
+
+	state := bc.rateLimiter.state()
+	if state.Remaining == nil || *state.Remaining != 42 || state.Limit != 300 {
+		t.Errorf("Expected limiter to follow response headers, got %+v", state)
+	}
+}
+
+func TestMastodonBookmarkClient_GetBookmarks_BackoffRespectsContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 5)
+	bc.retryBaseDelay = time.Hour
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := bc.GetBookmarks(ctx, 20, "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context deadline error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected backoff to stop with the context, took %v", elapsed)
+	}
+}
+
 // Test missing UserToken
 func TestMastodonBookmarkClient_GetBookmarks_MissingUserToken(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -954,3 +1202,236 @@ func TestConvertMadonStatusToBookmark_EmptyCollections(t *testing.T) {
 		t.Errorf("Expected 0 tags, got %d", len(bookmark.Status.Tags))
 	}
 }
+
+// =============================================================================
+// STATUS FETCH TESTS
+// =============================================================================
+
+func TestMastodonBookmarkClient_GetStatus_Success(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/statuses/123" {
+			t.Errorf("Expected path '/api/v1/statuses/123', got '%s'", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer test-token" {
+			t.Errorf("Expected Authorization header 'Bearer test-token', got '%s'", auth)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "123",
+			"content": "<p>Edited content</p>",
+			"created_at": "2023-01-01T00:00:00Z",
+			"account": {"id": "456", "username": "testuser"},
+			"media_attachments": [{"id": "m1", "type": "image", "url": "https://example.com/a.png", "description": "new alt text"}]
+		}`)
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 0)
+
+	status, err := bc.GetStatus(context.Background(), "123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if status.Content != "<p>Edited content</p>" {
+		t.Errorf("Expected edited content, got '%s'", status.Content)
+	}
+	if len(status.MediaAttachments) != 1 || status.MediaAttachments[0].Description != "new alt text" {
+		t.Errorf("Expected media description to be converted, got %+v", status.MediaAttachments)
+	}
+}
+
+func TestMastodonBookmarkClient_GetStatus_ConvertsCard(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"id": "123",
+			"content": "<p>Worth a read</p>",
+			"created_at": "2023-01-01T00:00:00Z",
+			"account": {"id": "456", "username": "testuser"},
+			"card": {
+				"url": "https://example.com/article",
+				"title": "An article",
+				"description": "What the article is about",
+				"type": "link",
+				"provider_name": "Example News",
+				"image": "https://example.com/article.png"
+			}
+		}`)
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 0)
+
+	status, err := bc.GetStatus(context.Background(), "123")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := &Card{
+		URL:          "https://example.com/article",
+		Title:        "An article",
+		Description:  "What the article is about",
+		Type:         "link",
+		ProviderName: "Example News",
+		Image:        "https://example.com/article.png",
+	}
+	if status.Card == nil || *status.Card != *expected {
+		t.Errorf("Expected card %+v, got %+v", expected, status.Card)
+	}
+}
+
+func TestMastodonBookmarkClient_GetContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/statuses/missing/context" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path != "/api/v1/statuses/2/context" {
+			t.Errorf("Expected path '/api/v1/statuses/2/context', got '%s'", r.URL.Path)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{
+			"ancestors": [{"id": "1", "content": "<p>question</p>", "created_at": "2023-01-01T00:00:00Z", "account": {"id": "9", "username": "asker"}}],
+			"descendants": [{"id": "3", "in_reply_to_id": "2", "content": "<p>thanks</p>", "created_at": "2023-01-01T02:00:00Z", "account": {"id": "9", "username": "asker"}}]
+		}`)
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 0)
+
+	thread, err := bc.GetContext(context.Background(), "2")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(thread.Ancestors) != 1 || thread.Ancestors[0].Account.Username != "asker" {
+		t.Errorf("Unexpected ancestors: %+v", thread.Ancestors)
+	}
+	if len(thread.Descendants) != 1 || thread.Descendants[0].InReplyToID != "2" {
+		t.Errorf("Unexpected descendants: %+v", thread.Descendants)
+	}
+
+	if _, err := bc.GetContext(context.Background(), "missing"); !errors.Is(err, errStatusNotFound) {
+		t.Errorf("Expected errStatusNotFound, got %v", err)
+	}
+}
+
+func TestMastodonBookmarkClient_ResolveStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/search" || r.URL.Query().Get("resolve") != "true" || r.URL.Query().Get("type") != "statuses" {
+			t.Errorf("Unexpected request: %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("q") == "https://other.example.com/@bob/7" {
+			fmt.Fprint(w, `{"accounts": [], "statuses": [{"id": "7", "content": "<p>found</p>", "account": {"id": "2", "username": "bob"}}], "hashtags": []}`)
+			return
+		}
+		fmt.Fprint(w, `{"accounts": [], "statuses": [], "hashtags": []}`)
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{
+		InstanceURL: server.URL,
+		UserToken:   &madon.UserToken{AccessToken: "test-token"},
+	}, 0)
+
+	status, err := bc.ResolveStatus(context.Background(), "https://other.example.com/@bob/7")
+	if err != nil {
+		t.Fatalf("Expected status to resolve, got %v", err)
+	}
+	if status.ID != "7" || status.Account.Username != "bob" {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	if _, err := bc.ResolveStatus(context.Background(), "https://gone.example.com/@carol/8"); !errors.Is(err, errStatusNotFound) {
+		t.Errorf("Expected errStatusNotFound for empty search, got %v", err)
+	}
+}
+
+func TestMastodonBookmarkClient_GetStatus_NotFound(t *testing.T) {
+	for _, code := range []int{http.StatusNotFound, http.StatusGone} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+
+		bc := newMastodonBookmarkClient(&madon.Client{InstanceURL: server.URL}, 0)
+
+		_, err := bc.GetStatus(context.Background(), "123")
+		if err != errStatusNotFound {
+			t.Errorf("Expected errStatusNotFound for status %d, got %v", code, err)
+		}
+		server.Close()
+	}
+}
+
+func TestMastodonBookmarkClient_GetStatus_ServerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	bc := newMastodonBookmarkClient(&madon.Client{InstanceURL: server.URL}, 0)
+
+	_, err := bc.GetStatus(context.Background(), "123")
+	if err == nil || err == errStatusNotFound {
+		t.Fatalf("Expected a non-not-found error, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "API request failed with status 403") {
+		t.Errorf("Expected error about status 403, got '%s'", err.Error())
+	}
+}
+
+func TestMastodonBookmarkClient_GetCollection_Paths(t *testing.T) {
+	testCases := []struct {
+		source string
+		path   string
+	}{
+		{SourceBookmark, "/api/v1/bookmarks"},
+		{SourceFavourite, "/api/v1/favourites"},
+		{SourceOwnStatus, "/api/v1/accounts/456/statuses"},
+	}
+
+	for _, tc := range testCases {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != tc.path {
+				t.Errorf("%s: expected path '%s', got '%s'", tc.source, tc.path, r.URL.Path)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `[{"id": "123", "content": "<p>Hello</p>", "account": {"id": "456", "username": "testuser"}}]`)
+		}))
+
+		bc := newMastodonBookmarkClient(&madon.Client{InstanceURL: server.URL}, 0)
+		bc.accountID = "456"
+
+		bookmarks, _, err := bc.GetCollection(context.Background(), tc.source, 20, "")
+		server.Close()
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", tc.source, err)
+		}
+		if len(bookmarks) != 1 || bookmarks[0].Status.ID != "123" {
+			t.Errorf("%s: unexpected statuses %+v", tc.source, bookmarks)
+		}
+	}
+}
+
+func TestMastodonBookmarkClient_GetCollection_OwnStatusNeedsAccount(t *testing.T) {
+	bc := newMastodonBookmarkClient(&madon.Client{InstanceURL: "https://example.com"}, 0)
+
+	if _, _, err := bc.GetCollection(context.Background(), SourceOwnStatus, 20, ""); err == nil {
+		t.Error("Expected error without the user's account ID")
+	}
+	if _, _, err := bc.GetCollection(context.Background(), "boosts", 20, ""); err == nil {
+		t.Error("Expected error for unknown source")
+	}
+}
@@ -0,0 +1,503 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// =============================================================================
+// MEDIA ARCHIVER TESTS
+// =============================================================================
+
+// pngHeader is enough of a PNG file for content sniffing to report image/png.
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+// mediaTestServer serves fixed bodies by path and counts requests per path.
+type mediaTestServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+type mediaTestFile struct {
+	body        []byte
+	contentType string
+	// chunked omits the Content-Length header so the size limit has to be
+	// enforced while reading.
+	chunked bool
+}
+
+func newMediaTestServer(t *testing.T, files map[string]mediaTestFile) *mediaTestServer {
+	t.Helper()
+
+	ms := &mediaTestServer{requests: make(map[string]int)}
+	ms.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms.mu.Lock()
+		ms.requests[r.URL.Path]++
+		ms.mu.Unlock()
+
+		file, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if file.contentType != "" {
+			w.Header().Set("Content-Type", file.contentType)
+		}
+		if file.chunked {
+			w.(http.Flusher).Flush()
+		}
+		w.Write(file.body)
+	}))
+	t.Cleanup(ms.Close)
+	return ms
+}
+
+func (ms *mediaTestServer) requestCount(path string) int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.requests[path]
+}
+
+func newTestMediaArchiver(t *testing.T, db *Database, maxFileSize int64, allowedTypes []string) *MediaArchiver {
+	t.Helper()
+
+	cfg := &Config{}
+	cfg.Media.Directory = filepath.Join(t.TempDir(), "media")
+	cfg.Media.MaxFileSize = maxFileSize
+	cfg.Media.AllowedTypes = allowedTypes
+
+	archiver, err := newMediaArchiver(cfg, db)
+	if err != nil {
+		t.Fatalf("Failed to create media archiver: %v", err)
+	}
+	// The test servers listen on loopback, which the archiver refuses
+	archiver.httpClient = &http.Client{}
+	return archiver
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestMediaArchiver_ArchiveStatus_StoresOriginalAndPreview(t *testing.T) {
+	original := append(append([]byte{}, pngHeader...), []byte("original")...)
+	preview := append(append([]byte{}, pngHeader...), []byte("preview")...)
+	server := newMediaTestServer(t, map[string]mediaTestFile{
+		"/original.png": {body: original, contentType: "image/png"},
+		"/preview.png":  {body: preview},
+	})
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestMediaArchiver(t, db, 1024, []string{"image/"})
+
+	status := Status{
+		ID: "status-1",
+		MediaAttachments: []Media{{
+			ID:         "media-1",
+			Type:       "image",
+			URL:        server.URL + "/original.png",
+			PreviewURL: server.URL + "/preview.png",
+		}},
+	}
+
+	stored, err := archiver.archiveStatus(context.Background(), status)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if stored != 2 {
+		t.Fatalf("Expected 2 files stored, got %d", stored)
+	}
+
+	files, err := db.getMediaFilesForStatuses([]string{"status-1"})
+	if err != nil {
+		t.Fatalf("Failed to get media files: %v", err)
+	}
+	if len(files["status-1"]) != 2 {
+		t.Fatalf("Expected 2 media records, got %d", len(files["status-1"]))
+	}
+
+	for _, file := range files["status-1"] {
+		expected := original
+		if file.Variant == "preview" {
+			expected = preview
+		}
+		if file.SHA256 != sha256Hex(expected) {
+			t.Errorf("Expected %s hash %s, got %s", file.Variant, sha256Hex(expected), file.SHA256)
+		}
+		if file.Size != int64(len(expected)) {
+			t.Errorf("Expected %s size %d, got %d", file.Variant, len(expected), file.Size)
+		}
+		if file.MimeType != "image/png" {
+			t.Errorf("Expected %s MIME type image/png, got %s", file.Variant, file.MimeType)
+		}
+		if file.MediaID != "media-1" {
+			t.Errorf("Expected media ID media-1, got %s", file.MediaID)
+		}
+
+		data, err := os.ReadFile(mediaFilePath(archiver.directory, file.SHA256))
+		if err != nil {
+			t.Fatalf("Failed to read stored %s: %v", file.Variant, err)
+		}
+		if !bytes.Equal(data, expected) {
+			t.Errorf("Stored %s does not match the download", file.Variant)
+		}
+	}
+
+	// Archived URLs are not downloaded again
+	if _, err := archiver.archiveStatus(context.Background(), status); err != nil {
+		t.Fatalf("Expected no error on second run, got %v", err)
+	}
+	if count := server.requestCount("/original.png"); count != 1 {
+		t.Errorf("Expected original to be downloaded once, got %d requests", count)
+	}
+}
+
+func TestMediaArchiver_ArchiveStatus_SizeLimit(t *testing.T) {
+	large := append(append([]byte{}, pngHeader...), bytes.Repeat([]byte("x"), 100)...)
+	server := newMediaTestServer(t, map[string]mediaTestFile{
+		"/declared.png": {body: large, contentType: "image/png"},
+		"/chunked.png":  {body: large, contentType: "image/png", chunked: true},
+		"/small.png":    {body: pngHeader, contentType: "image/png"},
+	})
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestMediaArchiver(t, db, 64, []string{"image/"})
+
+	status := Status{
+		ID: "status-1",
+		MediaAttachments: []Media{
+			{ID: "media-1", URL: server.URL + "/declared.png"},
+			{ID: "media-2", URL: server.URL + "/chunked.png"},
+			{ID: "media-3", URL: server.URL + "/small.png"},
+		},
+	}
+
+	stored, err := archiver.archiveStatus(context.Background(), status)
+	if err != nil {
+		t.Fatalf("Expected oversized files to be skipped without error, got %v", err)
+	}
+	if stored != 1 {
+		t.Fatalf("Expected only the small file to be stored, got %d", stored)
+	}
+
+	for _, path := range []string{"/declared.png", "/chunked.png"} {
+		file, err := db.getMediaFile(server.URL + path)
+		if err != nil {
+			t.Fatalf("Failed to get media file: %v", err)
+		}
+		if file != nil {
+			t.Errorf("Expected %s to be skipped", path)
+		}
+	}
+
+	if _, err := os.Stat(mediaFilePath(archiver.directory, sha256Hex(large))); !os.IsNotExist(err) {
+		t.Error("Expected no file to be kept for an oversized download")
+	}
+}
+
+func TestMediaArchiver_ArchiveStatus_TypeLimit(t *testing.T) {
+	server := newMediaTestServer(t, map[string]mediaTestFile{
+		"/page.html": {body: []byte("<html><body>not media</body></html>"), contentType: "text/html; charset=utf-8"},
+		"/sniffed":   {body: []byte("<html><body>no content type</body></html>")},
+		"/image.png": {body: pngHeader, contentType: "image/png"},
+	})
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestMediaArchiver(t, db, 0, []string{"image/", "video/mp4"})
+
+	status := Status{
+		ID: "status-1",
+		MediaAttachments: []Media{
+			{ID: "media-1", URL: server.URL + "/page.html"},
+			{ID: "media-2", URL: server.URL + "/sniffed"},
+			{ID: "media-3", URL: server.URL + "/image.png"},
+		},
+	}
+
+	stored, err := archiver.archiveStatus(context.Background(), status)
+	if err != nil {
+		t.Fatalf("Expected disallowed types to be skipped without error, got %v", err)
+	}
+	if stored != 1 {
+		t.Fatalf("Expected only the image to be stored, got %d", stored)
+	}
+
+	file, err := db.getMediaFile(server.URL + "/image.png")
+	if err != nil || file == nil {
+		t.Fatalf("Expected image to be archived, got %v, %v", file, err)
+	}
+
+	if !archiver.typeAllowed("video/mp4") || archiver.typeAllowed("video/webm") {
+		t.Error("Expected exact types to match only themselves")
+	}
+}
+
+func TestMediaArchiver_ArchiveStatus_DeduplicatesByHash(t *testing.T) {
+	server := newMediaTestServer(t, map[string]mediaTestFile{
+		"/a.png": {body: pngHeader, contentType: "image/png"},
+		"/b.png": {body: pngHeader, contentType: "image/png"},
+	})
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestMediaArchiver(t, db, 0, nil)
+
+	for i, path := range []string{"/a.png", "/b.png"} {
+		status := Status{
+			ID:               []string{"status-1", "status-2"}[i],
+			MediaAttachments: []Media{{ID: "media", URL: server.URL + path}},
+		}
+		if _, err := archiver.archiveStatus(context.Background(), status); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	files, err := db.getMediaFilesForStatuses([]string{"status-1", "status-2"})
+	if err != nil {
+		t.Fatalf("Failed to get media files: %v", err)
+	}
+	if len(files["status-1"]) != 1 || len(files["status-2"]) != 1 {
+		t.Fatalf("Expected one record per status, got %v", files)
+	}
+	if files["status-1"][0].SHA256 != files["status-2"][0].SHA256 {
+		t.Error("Expected identical content to share a hash")
+	}
+
+	hash := sha256Hex(pngHeader)
+	entries, err := os.ReadDir(filepath.Join(archiver.directory, hash[:2]))
+	if err != nil {
+		t.Fatalf("Failed to read media directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected one stored file for identical content, got %d", len(entries))
+	}
+}
+
+func TestMediaArchiver_ArchiveStatus_ReportsFailures(t *testing.T) {
+	server := newMediaTestServer(t, map[string]mediaTestFile{})
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	archiver := newTestMediaArchiver(t, db, 0, nil)
+
+	status := Status{
+		ID:               "status-1",
+		MediaAttachments: []Media{{ID: "media-1", URL: server.URL + "/missing.png"}},
+	}
+
+	stored, err := archiver.archiveStatus(context.Background(), status)
+	if err == nil {
+		t.Fatal("Expected an error for a failed download")
+	}
+	if stored != 0 {
+		t.Errorf("Expected nothing stored, got %d", stored)
+	}
+}
+
+func TestMediaArchiver_ArchiveStatus_RefusesNonPublicAddresses(t *testing.T) {
+	server := newMediaTestServer(t, map[string]mediaTestFile{
+		"/image.png": {body: pngHeader, contentType: "image/png"},
+	})
+
+	db := setupTestDatabase(t)
+	defer db.close()
+	cfg := &Config{}
+	cfg.Media.Directory = filepath.Join(t.TempDir(), "media")
+	archiver, err := newMediaArchiver(cfg, db)
+	if err != nil {
+		t.Fatalf("Failed to create media archiver: %v", err)
+	}
+
+	status := Status{
+		ID:               "status-1",
+		MediaAttachments: []Media{{ID: "media-1", URL: server.URL + "/image.png"}},
+	}
+	stored, err := archiver.archiveStatus(context.Background(), status)
+	if err != nil {
+		t.Fatalf("Expected the refused file to be skipped without error, got %v", err)
+	}
+	if stored != 0 || server.requestCount("/image.png") != 0 {
+		t.Errorf("Expected no request to a loopback address, got %d stored and %d requests", stored, server.requestCount("/image.png"))
+	}
+
+	if _, err := archiver.download(context.Background(), server.URL+"/image.png"); !errors.Is(err, errNonPublicAddress) {
+		t.Errorf("Expected errNonPublicAddress, got %v", err)
+	}
+	if _, err := archiver.download(context.Background(), "file:///etc/passwd"); err == nil || !strings.Contains(err.Error(), "unsupported URL scheme") {
+		t.Errorf("Expected a file URL to be refused, got %v", err)
+	}
+
+	redirect := httptest.NewRequest("GET", "ftp://files.example/image.png", nil)
+	if err := archiver.httpClient.CheckRedirect(redirect, []*http.Request{httptest.NewRequest("GET", "https://cdn.example/image.png", nil)}); err == nil {
+		t.Error("Expected a redirect to an ftp URL to be refused")
+	}
+}
+
+func TestIsPublicAddr(t *testing.T) {
+	tests := map[string]bool{
+		"93.184.216.34":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"0.0.0.0":          false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+		"224.0.0.1":        false,
+	}
+
+	for address, expected := range tests {
+		if got := isPublicAddr(netip.MustParseAddr(address)); got != expected {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", address, got, expected)
+		}
+	}
+}
+
+func TestBookmarkService_ProcessBookmarkBatch_ArchivesMedia(t *testing.T) {
+	server := newMediaTestServer(t, map[string]mediaTestFile{
+		"/image.png": {body: pngHeader, contentType: "image/png"},
+	})
+
+	service, db := newReconcileTestService(t, &MockPagedBookmarkClient{})
+	service.media = newTestMediaArchiver(t, db, 0, nil)
+
+	bookmark := testBookmark("status-1")
+	bookmark.Status.MediaAttachments = []Media{{ID: "media-1", URL: server.URL + "/image.png"}}
+
+	if err := service.processBookmarkBatch([]Bookmark{bookmark}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	file, err := db.getMediaFile(server.URL + "/image.png")
+	if err != nil || file == nil {
+		t.Fatalf("Expected media of a new bookmark to be archived, got %v, %v", file, err)
+	}
+}
+
+func TestIsMediaHash(t *testing.T) {
+	tests := map[string]bool{
+		sha256Hex([]byte("x")):                 true,
+		"":                                     false,
+		"../../etc/passwd":                     false,
+		sha256Hex([]byte("x"))[:63]:            false,
+		"G" + sha256Hex([]byte("x"))[1:]:       false,
+		"A" + sha256Hex([]byte("x"))[1:] + "0": false,
+	}
+	for input, expected := range tests {
+		if got := isMediaHash(input); got != expected {
+			t.Errorf("isMediaHash(%q) = %v, expected %v", input, got, expected)
+		}
+	}
+}
+
+// =============================================================================
+// MEDIA WEB SERVER TESTS
+// =============================================================================
+
+func TestWebServer_HandleMedia(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	cfg := &Config{}
+	cfg.Media.Directory = t.TempDir()
+
+	hash := sha256Hex(pngHeader)
+	path := mediaFilePath(cfg.Media.Directory, hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create media directory: %v", err)
+	}
+	if err := os.WriteFile(path, pngHeader, 0644); err != nil {
+		t.Fatalf("Failed to write media file: %v", err)
+	}
+	if err := db.insertMediaFile(&MediaFile{
+		RemoteURL: "https://example.com/a.png",
+		StatusID:  "status-1",
+		MediaID:   "media-1",
+		Variant:   "original",
+		SHA256:    hash,
+		Size:      int64(len(pngHeader)),
+		MimeType:  "image/png",
+	}); err != nil {
+		t.Fatalf("Failed to insert media file: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(cfg, db, eventChan)
+	mux := webServer.setupRoutes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/media/"+hash, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); contentType != "image/png" {
+		t.Errorf("Expected Content-Type image/png, got %s", contentType)
+	}
+	if !bytes.Equal(w.Body.Bytes(), pngHeader) {
+		t.Error("Expected the archived file to be served")
+	}
+
+	for _, target := range []string{"/media/" + sha256Hex([]byte("unknown")), "/media/not-a-hash", "/media/../main.go"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code == http.StatusOK {
+			t.Errorf("Expected %s not to be served", target)
+		}
+	}
+}
+
+func TestDatabase_AttachMediaFiles(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	if err := db.insertBookmark(createTestBookmark("status-1", "media search test")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	if err := db.insertMediaFile(&MediaFile{
+		RemoteURL: "https://example.com/a.png",
+		StatusID:  "status-1",
+		MediaID:   "media-1",
+		Variant:   "original",
+		SHA256:    sha256Hex(pngHeader),
+		MimeType:  "image/png",
+	}); err != nil {
+		t.Fatalf("Failed to insert media file: %v", err)
+	}
+
+	results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: "media"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if err := db.attachMediaFiles(results); err != nil {
+		t.Fatalf("Failed to attach media: %v", err)
+	}
+
+	if len(results) != 1 || len(results[0].Media) != 1 {
+		t.Fatalf("Expected one result with one media file, got %+v", results)
+	}
+	if results[0].Media[0].SHA256 != sha256Hex(pngHeader) {
+		t.Errorf("Expected attached media hash %s, got %s", sha256Hex(pngHeader), results[0].Media[0].SHA256)
+	}
+}
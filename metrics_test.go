@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// METRICS TESTS
+// =============================================================================
+
+func TestMetricFamily_Write(t *testing.T) {
+	counter := &metricFamily{name: "test_total", help: "Things counted.", kind: "counter",
+		labelNames: []string{"name"}, series: make(map[string]*metricSeries)}
+	counter.inc("b")
+	counter.add(2.5, "a")
+	counter.inc(`quote"back\slash` + "\n")
+
+	var buf bytes.Buffer
+	if err := counter.write(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	expected := `# HELP test_total Things counted.
+# TYPE test_total counter
+test_total{name="a"} 2.5
+test_total{name="b"} 1
+test_total{name="quote\"back\\slash\n"} 1
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestMetricFamily_WriteHistogram(t *testing.T) {
+	histogram := &metricFamily{name: "test_seconds", help: "Time taken.", kind: "histogram",
+		buckets: []float64{0.1, 1}, series: make(map[string]*metricSeries)}
+	histogram.get(nil)
+	histogram.observe(0.05)
+	histogram.observe(0.5)
+	histogram.observe(2)
+
+	var buf bytes.Buffer
+	if err := histogram.write(&buf); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	expected := `# HELP test_seconds Time taken.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="0.1"} 1
+test_seconds_bucket{le="1"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 2.55
+test_seconds_count 3
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+// metricValue returns the value of one series as written to /metrics, or
+// an empty string when it is missing.
+func metricValue(t *testing.T, series string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, family := range metricFamilies {
+		if err := family.write(&buf); err != nil {
+			t.Fatalf("Failed to write metrics: %v", err)
+		}
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestBookmarkService_ProcessBookmarkBatch_Metrics(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	service := &BookmarkService{
+		config:  &Config{},
+		db:      db,
+		ctx:     context.Background(),
+		account: AccountConfig{Name: "metrics"},
+	}
+	bookmarks := []Bookmark{testBookmark("status-1"), testBookmark("status-2")}
+	if err := service.processBookmarkBatch(bookmarks[:1]); err != nil {
+		t.Fatalf("Failed to process batch: %v", err)
+	}
+	if err := service.processBookmarkBatch(bookmarks); err != nil {
+		t.Fatalf("Failed to process batch: %v", err)
+	}
+
+	for series, expected := range map[string]string{
+		`bookmarchive_batches_total{account="metrics",source="bookmark"}`:                           "2",
+		`bookmarchive_batch_bookmarks_total{account="metrics",source="bookmark",result="inserted"}`: "2",
+		`bookmarchive_batch_bookmarks_total{account="metrics",source="bookmark",result="skipped"}`:  "1",
+	} {
+		if value := metricValue(t, series); value != expected {
+			t.Errorf("Expected %s to be %s, got %q", series, expected, value)
+		}
+	}
+}
+
+func TestWebServer_HandleMetrics(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	if err := db.ensureBackfillState("alice", SourceBookmark); err != nil {
+		t.Fatalf("Failed to create backfill state: %v", err)
+	}
+	polledAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.updateBackfillStateForOwner("alice", SourceBookmark, "", true, &polledAt); err != nil {
+		t.Fatalf("Failed to update backfill state: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(&Config{}, db, eventChan)
+	mux := webServer.setupRoutes()
+
+	searchesBefore := metricValue(t, "bookmarchive_search_duration_seconds_count")
+	req := httptest.NewRequest(http.MethodPost, "/api/search", strings.NewReader(`{"query":""}`))
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected search to succeed, got %d: %s", w.Code, w.Body.String())
+	}
+	if searchesAfter := metricValue(t, "bookmarchive_search_duration_seconds_count"); searchesAfter == searchesBefore {
+		t.Errorf("Expected search count to increase from %s", searchesBefore)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if contentType := w.Header().Get("Content-Type"); !strings.HasPrefix(contentType, "text/plain; version=0.0.4") {
+		t.Errorf("Expected Prometheus text format, got %s", contentType)
+	}
+
+	body := w.Body.String()
+	for _, expected := range []string{
+		"# TYPE bookmarchive_api_requests_total counter",
+		"# TYPE bookmarchive_api_request_duration_seconds histogram",
+		"bookmarchive_api_retries_total ",
+		`bookmarchive_backfill_complete{account="alice",source="bookmark"} 1`,
+		`bookmarchive_last_poll_timestamp_seconds{account="alice",source="bookmark"} 1.7092944e+09`,
+		"bookmarchive_search_results_bucket{le=\"+Inf\"}",
+		"bookmarchive_sse_clients 0",
+		"bookmarchive_database_size_bytes ",
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", expected, body)
+		}
+	}
+	if strings.Contains(body, "bookmarchive_database_size_bytes 0\n") {
+		t.Error("Expected a non-zero database size")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// SEARCH REINDEX TESTS
+// =============================================================================
+
+func TestSearchIndexFingerprint(t *testing.T) {
+	base := searchIndexFingerprint([]string{"content", "username"})
+	if got := searchIndexFingerprint([]string{"username", "content", "content"}); got != base {
+		t.Error("Expected the fingerprint to ignore field order and duplicates")
+	}
+	if got := searchIndexFingerprint([]string{"content"}); got == base {
+		t.Error("Expected a different fingerprint for different fields")
+	}
+}
+
+func TestDatabase_SearchIndexCurrent(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	current, err := db.searchIndexCurrent([]string{"content"})
+	if err != nil || !current {
+		t.Fatalf("Expected an empty archive to be current, got %v (%v)", current, err)
+	}
+	if _, ok, _ := db.getMetadata(searchIndexFingerprintKey); !ok {
+		t.Error("Expected the fingerprint of an empty archive to be recorded")
+	}
+
+	if err := db.insertBookmark(convertBookmarkToDatabase(testBookmark("status-1"), []string{"content"})); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	if current, err := db.searchIndexCurrent([]string{"content"}); err != nil || !current {
+		t.Errorf("Expected unchanged settings to be current, got %v (%v)", current, err)
+	}
+	if current, err := db.searchIndexCurrent([]string{"content", "username"}); err != nil || current {
+		t.Errorf("Expected changed settings not to be current, got %v (%v)", current, err)
+	}
+}
+
+func TestDatabase_SearchIndexCurrent_ArchiveWithoutFingerprint(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	if err := db.insertBookmark(createTestBookmark("status-1", "legacy text")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	if current, err := db.searchIndexCurrent([]string{"content"}); err != nil || current {
+		t.Errorf("Expected an archive without a fingerprint not to be current, got %v (%v)", current, err)
+	}
+}
+
+// insertReindexTestBookmarks archives statuses indexed with content only,
+// with a stored link snapshot and thread, and a row whose raw JSON is not a
+// bookmark.
+func insertReindexTestBookmarks(t *testing.T, db *Database) {
+	t.Helper()
+
+	withCard := testBookmark("status-1")
+	withCard.Status.Account = Account{ID: "1", Username: "alice", DisplayName: "Alice Liddell"}
+	withCard.Status.Card = &Card{URL: "https://example.com/article", Title: "An article"}
+	for _, bookmark := range []Bookmark{withCard, testBookmark("status-2"), testBookmark("status-3")} {
+		if err := db.insertBookmark(convertBookmarkToDatabase(bookmark, []string{"content"})); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+	if err := db.insertBookmark(createTestBookmark("legacy-1", "legacy walrus")); err != nil {
+		t.Fatalf("Failed to insert legacy bookmark: %v", err)
+	}
+
+	if err := db.insertLinkSnapshot(&LinkSnapshot{URL: "https://example.com/article", Text: "archived axolotl page", FetchedAt: time.Now()}); err != nil {
+		t.Fatalf("Failed to insert link snapshot: %v", err)
+	}
+	thread := StatusContext{Descendants: []Status{{ID: "reply-1", Content: "<p>a reply about quokkas</p>"}}}
+	if err := db.replaceStatusContext("", "status-2", thread, time.Now()); err != nil {
+		t.Fatalf("Failed to store thread: %v", err)
+	}
+}
+
+func TestReindexBookmarks(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertReindexTestBookmarks(t, db)
+
+	search := func(query string) []string {
+		t.Helper()
+		results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		var ids []string
+		for _, result := range results {
+			ids = append(ids, result.Bookmark.StatusID)
+		}
+		return ids
+	}
+	if ids := search("author:alice"); len(ids) != 0 {
+		t.Fatalf("Expected no author matches before reindexing, got %v", ids)
+	}
+
+	indexedFields := []string{"content", "username", "display_name", "link_text", "thread"}
+	var calls []int
+	result, err := reindexBookmarks(context.Background(), db, indexedFields, 2, func(done, total int) {
+		if total != 4 {
+			t.Errorf("Expected a total of 4, got %d", total)
+		}
+		calls = append(calls, done)
+	})
+	if err != nil {
+		t.Fatalf("Reindex failed: %v", err)
+	}
+	if result.Reindexed != 3 || result.Skipped != 1 {
+		t.Errorf("Expected 3 reindexed and 1 skipped, got %+v", result)
+	}
+	if len(calls) != 2 || calls[1] != 4 {
+		t.Errorf("Expected progress after each batch of 2, got %v", calls)
+	}
+
+	for query, expected := range map[string]string{
+		"author:liddell": "status-1",
+		"links:axolotl":  "status-1",
+		"thread:quokkas": "status-2",
+		"walrus":         "legacy-1",
+	} {
+		if ids := search(query); len(ids) != 1 || ids[0] != expected {
+			t.Errorf("Expected %q to find %s after reindexing, got %v", query, expected, ids)
+		}
+	}
+
+	bookmark, err := db.getBookmark("status-1")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if bookmark.SearchText != "content for status-1 alice Alice Liddell archived axolotl page" {
+		t.Errorf("Unexpected search text after reindexing: %q", bookmark.SearchText)
+	}
+
+	if current, err := db.searchIndexCurrent(indexedFields); err != nil || !current {
+		t.Errorf("Expected the reindexed settings to be current, got %v (%v)", current, err)
+	}
+}
+
+func TestReindexBookmarks_Canceled(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertReindexTestBookmarks(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := reindexBookmarks(ctx, db, []string{"content", "username"}, 2, nil); err == nil {
+		t.Fatal("Expected a canceled reindex to fail")
+	}
+	if current, _ := db.searchIndexCurrent([]string{"content", "username"}); current {
+		t.Error("Expected an interrupted reindex not to record the new settings")
+	}
+}
+
+func TestBookmarchiveApp_CheckSearchIndex_AutoReindex(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertReindexTestBookmarks(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := &BookmarchiveApp{config: defaultConfig(), ctx: ctx, db: db}
+	app.config.Search.IndexedFields = []string{"content", "username"}
+
+	// Without auto_reindex a mismatch is only reported
+	app.checkSearchIndex()
+	time.Sleep(50 * time.Millisecond)
+	if current, _ := db.searchIndexCurrent(app.config.Search.IndexedFields); current {
+		t.Fatal("Expected no reindex without auto_reindex")
+	}
+
+	app.config.Search.AutoReindex = true
+	app.checkSearchIndex()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if current, _ := db.searchIndexCurrent(app.config.Search.IndexedFields); current {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the automatic reindex")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "author:alice"})
+	if err != nil || len(results) != 1 {
+		t.Errorf("Expected the automatic reindex to index authors, got %d results (%v)", len(results), err)
+	}
+}
@@ -840,3 +840,97 @@ func TestMastodonClientIntegration_StoreAccountData(t *testing.T) {
 		}
 	*/
 }
+
+// =============================================================================
+// BOOKMARK STATE FILTER TESTS
+// =============================================================================
+
+func TestDatabase_SearchOrRecentBookmarks_StateFilter(t *testing.T) {
+	db := setupFilterTestDatabase(t)
+	defer db.close()
+
+	for _, id := range []string{"status-1", "status-2", "status-3"} {
+		if err := db.insertBookmark(createTestBookmarkWithAccount(id, "shared content", "user-1", "alice")); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+	now := time.Now()
+	if err := db.setBookmarksUnbookmarked("", SourceBookmark, []string{"status-2"}, &now); err != nil {
+		t.Fatalf("Failed to mark bookmark removed: %v", err)
+	}
+
+	testCases := []struct {
+		query    string
+		state    string
+		expected int
+	}{
+		{"", "", 3},
+		{"", "all", 3},
+		{"", "active", 2},
+		{"", "removed", 1},
+		{"shared", "active", 2},
+		{"shared", "removed", 1},
+	}
+
+	for _, tc := range testCases {
+		results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: tc.query, Limit: 10, FilterByState: tc.state})
+		if err != nil {
+			t.Fatalf("Search %q with state %q failed: %v", tc.query, tc.state, err)
+		}
+		if len(results) != tc.expected {
+			t.Errorf("Search %q with state %q: expected %d results, got %d", tc.query, tc.state, tc.expected, len(results))
+		}
+		if tc.state == "removed" {
+			for _, result := range results {
+				if result.Bookmark.UnbookmarkedAt == nil {
+					t.Errorf("Expected removed result %s to carry unbookmarked_at", result.Bookmark.StatusID)
+				}
+			}
+		}
+	}
+}
+
+func TestDatabase_SearchOrRecentBookmarks_SourceFilter(t *testing.T) {
+	db := setupFilterTestDatabase(t)
+	defer db.close()
+
+	for _, source := range []string{SourceBookmark, SourceFavourite, SourceOwnStatus} {
+		bookmark := createTestBookmarkWithAccount("status-1", "shared content", "user-1", "alice")
+		bookmark.Source = source
+		if err := db.insertBookmark(bookmark); err != nil {
+			t.Fatalf("Failed to insert %s: %v", source, err)
+		}
+	}
+
+	testCases := []struct {
+		query    string
+		source   string
+		expected int
+	}{
+		{"shared", "", 3},
+		{"shared", "all", 3},
+		{"shared", SourceFavourite, 1},
+		{"", SourceOwnStatus, 1},
+	}
+
+	for _, tc := range testCases {
+		results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: tc.query, Limit: 10, FilterBySource: tc.source})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != tc.expected {
+			t.Errorf("query %q source %q: expected %d results, got %d", tc.query, tc.source, tc.expected, len(results))
+		}
+		if tc.source == SourceFavourite && len(results) == 1 && results[0].Bookmark.Source != SourceFavourite {
+			t.Errorf("Expected favourite result, got %s", results[0].Bookmark.Source)
+		}
+	}
+
+	counts, err := db.getSourceCounts("")
+	if err != nil {
+		t.Fatalf("Failed to count collections: %v", err)
+	}
+	if counts[SourceBookmark] != 1 || counts[SourceFavourite] != 1 || counts[SourceOwnStatus] != 1 {
+		t.Errorf("Unexpected collection counts: %v", counts)
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// SEARCH QUERY TESTS
+// =============================================================================
+
+func TestParseSearchQuery_Terms(t *testing.T) {
+	tests := []struct {
+		query   string
+		match   string
+		filters int
+	}{
+		{"golang", "golang*", 0},
+		{`"exact phrase" rust`, `"exact phrase" rust*`, 0},
+		{"go rust OR zig", "go* (rust* OR zig*)", 0},
+		{"rust OR zig", "rust* OR zig*", 0},
+		{"-python golang", "golang*", 1},
+		{"NOT python", "", 1},
+		{"c++ node.js", `"c++"* "node.js"*`, 0},
+		{`say "hi"`, `say* "hi"`, 0},
+		{"NEAR", `"NEAR"*`, 0},
+		{"https://example.com/page", `"https://example.com/page"*`, 0},
+		{"- ... golang", "golang*", 0},
+		{"from:alice tag:golang has:media", "", 3},
+		{`from:"alice" -tag:#Go lang:EN`, "", 3},
+		{"before:2024 after:2023-06 bookmarked-before:2024-01-15 bookmarked-after:2023-01-01T00:00:00Z", "", 4},
+		{"FROM:alice Has:CW", "", 2},
+		{"author:alice", "author_text : alice*", 0},
+		{`Media:"bar chart" OR content:go`, `media_text : "bar chart" OR content_text : go*`, 0},
+		{"golang -hashtags:rust", "golang*", 1},
+		{"author:... golang", "golang*", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			parsed, err := parseSearchQuery(tt.query)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if parsed.Match != tt.match {
+				t.Errorf("Expected match %q, got %q", tt.match, parsed.Match)
+			}
+			if len(parsed.filters) != tt.filters {
+				t.Errorf("Expected %d filters, got %d", tt.filters, len(parsed.filters))
+			}
+		})
+	}
+}
+
+func TestParseSearchQuery_Errors(t *testing.T) {
+	tests := []struct {
+		query   string
+		message string
+	}{
+		{`"unclosed phrase`, "missing closing quote"},
+		{`from:"alice`, "missing closing quote"},
+		{"OR rust", "OR must be between"},
+		{"rust OR", "OR must be between"},
+		{"rust OR OR zig", "OR must be between"},
+		{"rust OR -zig", "OR can only join"},
+		{"rust OR tag:zig", "OR can only join"},
+		{"AND rust", "AND must be between"},
+		{"rust NOT", "NOT must be followed"},
+		{"NOT NOT rust", "NOT must be followed"},
+		{"from:", "from: needs a value"},
+		{"author:", "author: needs a value"},
+		{`media:"bar chart`, "missing closing quote"},
+		{"from:@", "from: needs an account name"},
+		{"tag:#", "tag: needs a hashtag"},
+		{"has:poll", "unknown has:poll"},
+		{"before:yesterday", "invalid date"},
+		{"after:2024-13", "invalid date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			_, err := parseSearchQuery(tt.query)
+			var queryErr *QueryError
+			if !errors.As(err, &queryErr) {
+				t.Fatalf("Expected QueryError, got %v", err)
+			}
+			if !strings.Contains(queryErr.Message, tt.message) {
+				t.Errorf("Expected message containing %q, got %q", tt.message, queryErr.Message)
+			}
+		})
+	}
+}
+
+func TestParseQueryDate(t *testing.T) {
+	tests := []struct {
+		value string
+		start string
+		end   string
+	}{
+		{"2024", "2024-01-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"2024-02", "2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"},
+		{"2024-02-29", "2024-02-29T00:00:00Z", "2024-03-01T00:00:00Z"},
+		{"2024-02-29t12:00:00+02:00", "2024-02-29T10:00:00Z", "2024-02-29T10:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		start, end, err := parseQueryDate(tt.value)
+		if err != nil {
+			t.Errorf("parseQueryDate(%q) failed: %v", tt.value, err)
+			continue
+		}
+		if start.Format(time.RFC3339) != tt.start || end.Format(time.RFC3339) != tt.end {
+			t.Errorf("parseQueryDate(%q) = %s, %s; want %s, %s", tt.value, start.Format(time.RFC3339), end.Format(time.RFC3339), tt.start, tt.end)
+		}
+	}
+}
+
+// insertQueryTestBookmarks archives statuses that differ in each attribute
+// the query language filters on.
+func insertQueryTestBookmarks(t *testing.T, db *Database) {
+	t.Helper()
+
+	date := func(value string) time.Time {
+		parsed, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			t.Fatalf("Invalid test date: %v", err)
+		}
+		return parsed
+	}
+
+	bookmarks := []Bookmark{
+		{
+			ID:        "alice-go",
+			CreatedAt: date("2024-05-01"),
+			Status: Status{
+				ID: "alice-go", URI: "https://mastodon.example/users/alice/statuses/1",
+				Content:          `<p>Generics in golang, see <a href="https://go.dev/blog">the blog</a></p>`,
+				CreatedAt:        date("2023-12-30"),
+				Account:          Account{ID: "1", Username: "alice", Acct: "alice"},
+				MediaAttachments: []Media{{ID: "m1", Type: "image", Description: "gopher"}},
+				Tags:             []Tag{{Name: "GoLang"}},
+				Language:         "en",
+			},
+		},
+		{
+			ID:        "bob-rust",
+			CreatedAt: date("2024-06-01"),
+			Status: Status{
+				ID: "bob-rust", URI: "https://other.example/users/bob/statuses/2",
+				Content:     `<p>Ownership in rust for <span class="h-card"><a href="https://mastodon.example/@alice" class="u-url mention">@<span>alice</span></a></span></p>`,
+				SpoilerText: "long post",
+				CreatedAt:   date("2024-05-31"),
+				Account:     Account{ID: "2", Username: "bob", Acct: "bob@other.example"},
+				Tags:        []Tag{{Name: "rust"}},
+				Language:    "de",
+			},
+		},
+		{
+			ID:        "carol-zig",
+			CreatedAt: date("2024-07-01"),
+			Status: Status{
+				ID: "carol-zig", URI: "https://old.example/users/carol/statuses/3",
+				Content:          "<p>Comptime in zig and golang</p>",
+				CreatedAt:        date("2024-06-30"),
+				Account:          Account{ID: "3", Username: "carol"},
+				MediaAttachments: []Media{{ID: "m2", Type: "gifv"}},
+				Card:             &Card{URL: "https://ziglang.org", Title: "Zig"},
+			},
+		},
+	}
+
+	for _, bookmark := range bookmarks {
+		dbBookmark := convertBookmarkToDatabase(bookmark, []string{"content", "spoiler_text", "media_descriptions", "hashtags"})
+		dbBookmark.BookmarkedAt = bookmark.CreatedAt
+		if err := db.insertBookmark(dbBookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+}
+
+func TestDatabase_SearchQueryLanguage(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertQueryTestBookmarks(t, db)
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"golang", []string{"alice-go", "carol-zig"}},
+		{"golang -zig", []string{"alice-go"}},
+		{"golang NOT zig", []string{"alice-go"}},
+		{"rust OR zig", []string{"bob-rust", "carol-zig"}},
+		{`"comptime in zig"`, []string{"carol-zig"}},
+		{`"zig comptime"`, nil},
+		{"from:alice", []string{"alice-go"}},
+		{"from:@Bob@other.example", []string{"bob-rust"}},
+		{"from:bob@elsewhere.example", nil},
+		{"from:carol@old.example", []string{"carol-zig"}},
+		{"-from:alice", []string{"bob-rust", "carol-zig"}},
+		{"tag:golang", []string{"alice-go"}},
+		{"-tag:#golang", []string{"bob-rust", "carol-zig"}},
+		{"has:media", []string{"alice-go", "carol-zig"}},
+		{"has:image", []string{"alice-go"}},
+		{"has:video", []string{"carol-zig"}},
+		{"has:link", []string{"alice-go", "carol-zig"}},
+		{"-has:link", []string{"bob-rust"}},
+		{"has:cw", []string{"bob-rust"}},
+		{"-has:cw", []string{"alice-go", "carol-zig"}},
+		{"lang:de", []string{"bob-rust"}},
+		{"-lang:en", []string{"bob-rust", "carol-zig"}},
+		{"before:2024", []string{"alice-go"}},
+		{"after:2024-05", []string{"carol-zig"}},
+		{"bookmarked-before:2024-06", []string{"alice-go"}},
+		{"bookmarked-after:2024-05-31", []string{"bob-rust", "carol-zig"}},
+		{"golang has:media after:2023", []string{"carol-zig"}},
+		{"-golang", []string{"bob-rust"}},
+		{"media:gopher", []string{"alice-go"}},
+		{"content:gopher", nil},
+		{"hashtags:rust", []string{"bob-rust"}},
+		{"spoiler:long OR media:gopher", []string{"alice-go", "bob-rust"}},
+		{"content:golang -hashtags:golang", []string{"carol-zig"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: tt.query, EnableHighlighting: true})
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			var ids []string
+			for _, result := range results {
+				ids = append(ids, result.Bookmark.StatusID)
+			}
+			sort.Strings(ids)
+			if strings.Join(ids, ",") != strings.Join(tt.expected, ",") {
+				t.Errorf("Expected %v, got %v", tt.expected, ids)
+			}
+		})
+	}
+}
+
+func TestDatabase_SearchQueryLanguage_HasLink(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	statuses := []Status{
+		{ID: "mention-word", Content: "<p>Worth a mention, and a mention again</p>"},
+		{ID: "mention-word-link", Content: `<p>Worth a mention: <a href="https://example.com">example.com</a></p>`},
+		{ID: "mention-only", Content: `<p><a href="https://mastodon.example/@alice" class="u-url mention">@alice</a> hi</p>`},
+		{ID: "misskey-url", Content: misskeyTextToHTML("Notes at https://example.com/notes")},
+		{ID: "misskey-text", Content: misskeyTextToHTML("@alice@misskey.example hi #golang")},
+	}
+	for _, status := range statuses {
+		status.CreatedAt = time.Now()
+		dbBookmark := convertBookmarkToDatabase(Bookmark{ID: status.ID, Status: status, CreatedAt: status.CreatedAt}, []string{"content"})
+		if err := db.insertBookmark(dbBookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: "has:link"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	var ids []string
+	for _, result := range results {
+		ids = append(ids, result.Bookmark.StatusID)
+	}
+	sort.Strings(ids)
+	if strings.Join(ids, ",") != "mention-word-link,misskey-url" {
+		t.Errorf("Expected mention-word-link and misskey-url, got %v", ids)
+	}
+}
+
+func TestDatabase_SearchQueryLanguage_CombinesWithRequestFilters(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertQueryTestBookmarks(t, db)
+
+	now := time.Now()
+	if err := db.setBookmarksUnbookmarked("", SourceBookmark, []string{"carol-zig"}, &now); err != nil {
+		t.Fatalf("Failed to mark bookmark removed: %v", err)
+	}
+
+	results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: "has:media", FilterByState: "active"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.StatusID != "alice-go" {
+		t.Errorf("Expected only the active bookmark with media, got %d results", len(results))
+	}
+}
+
+func TestDatabase_SearchSnippetFromMatchingColumn(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertQueryTestBookmarks(t, db)
+
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "gopher", EnableHighlighting: true})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+	if results[0].Snippet != "<mark>gopher</mark>" {
+		t.Errorf("Expected the snippet from the media description, got %q", results[0].Snippet)
+	}
+}
+
+func TestDatabase_SearchColumnWeights(t *testing.T) {
+	open := func(t *testing.T, weights map[string]float64) *Database {
+		cfg := Config{}
+		cfg.Database.Path = filepath.Join(t.TempDir(), "test.db")
+		cfg.Search.Weights = weights
+		db, err := newDatabase(cfg)
+		if err != nil {
+			t.Fatalf("Failed to create database: %v", err)
+		}
+		t.Cleanup(func() { db.close() })
+
+		bookmarks := []Bookmark{
+			{ID: "in-content", Status: Status{ID: "in-content", Content: "<p>Spotted a kestrel over the field today</p>",
+				Account: Account{ID: "1", Username: "alice", DisplayName: "Alice"}}},
+			{ID: "in-author", Status: Status{ID: "in-author", Content: "<p>Spotted a hawk over the field today</p>",
+				Account: Account{ID: "2", Username: "kestrel", DisplayName: "Kestrel"}}},
+		}
+		for _, bookmark := range bookmarks {
+			if err := db.insertBookmark(convertBookmarkToDatabase(bookmark, []string{"content", "username", "display_name"})); err != nil {
+				t.Fatalf("Failed to insert bookmark: %v", err)
+			}
+		}
+		return db
+	}
+	first := func(t *testing.T, db *Database) string {
+		results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "kestrel"})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("Expected 2 results, got %d", len(results))
+		}
+		return results[0].Bookmark.StatusID
+	}
+
+	if got := first(t, open(t, nil)); got != "in-content" {
+		t.Errorf("Expected a content match to rank first by default, got %s", got)
+	}
+	if got := first(t, open(t, map[string]float64{"author": 10, "content": 0.1})); got != "in-author" {
+		t.Errorf("Expected an author match to rank first when author weighs more, got %s", got)
+	}
+}
+
+func TestSearchWeights(t *testing.T) {
+	weights, err := searchWeights(map[string]float64{"author": 2})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(weights) != len(searchColumns) || weights[0] != searchColumns[0].weight || weights[2] != 2 {
+		t.Errorf("Expected defaults with author overridden, got %v", weights)
+	}
+	if rank := bm25Rank(weights); !strings.HasPrefix(rank, "bm25(bookmarks_fts, 0, 1, 1, 2, ") {
+		t.Errorf("Unexpected rank expression %q", rank)
+	}
+
+	for _, configured := range []map[string]float64{{"title": 1}, {"content": -1}} {
+		if _, err := searchWeights(configured); err == nil {
+			t.Errorf("Expected an error for weights %v", configured)
+		}
+	}
+
+	cfg := Config{}
+	cfg.Database.Path = filepath.Join(t.TempDir(), "test.db")
+	cfg.Search.Weights = map[string]float64{"title": 1}
+	if _, err := newDatabase(cfg); err == nil || !strings.Contains(err.Error(), `unknown search weight "title"`) {
+		t.Errorf("Expected newDatabase to reject an unknown weight, got %v", err)
+	}
+}
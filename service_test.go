@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -16,15 +19,20 @@ import (
 func TestNewBookmarkService_Success(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -81,9 +89,12 @@ func TestNewBookmarkService_NilConfig(t *testing.T) {
 func TestNewBookmarkService_NilDatabase(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -106,9 +117,12 @@ func TestNewBookmarkService_NilDatabase(t *testing.T) {
 func TestNewBookmarkService_EmptyMastodonServer(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "", // Empty server
 			AccessToken: "test-token",
@@ -134,9 +148,12 @@ func TestNewBookmarkService_EmptyMastodonServer(t *testing.T) {
 func TestNewBookmarkService_EmptyAccessToken(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "", // Empty access token
@@ -162,9 +179,12 @@ func TestNewBookmarkService_EmptyAccessToken(t *testing.T) {
 func TestBookmarkService_Stop(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -220,9 +240,12 @@ func TestBookmarkService_CreateBookmarkClient_Success(t *testing.T) {
 	// but we're testing the error handling path
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "https://mastodon.example.com",
 			AccessToken: "test-token",
@@ -286,7 +309,9 @@ func (m *MockBookmarkClient) GetBookmarks(ctx context.Context, limit int, nextUR
 func TestBookmarkService_ProcessBookmarkBatch_Success(t *testing.T) {
 	cfg := &Config{
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content", "username"},
 		},
@@ -352,7 +377,9 @@ func TestBookmarkService_ProcessBookmarkBatch_Success(t *testing.T) {
 func TestBookmarkService_ProcessBookmarkBatch_SkipExisting(t *testing.T) {
 	cfg := &Config{
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -411,7 +438,9 @@ func TestBookmarkService_ProcessBookmarkBatch_SkipExisting(t *testing.T) {
 func TestBookmarkService_ProcessBookmarkBatch_ContextCancelled(t *testing.T) {
 	cfg := &Config{
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -449,14 +478,20 @@ func TestBookmarkService_ProcessBookmarkBatch_ContextCancelled(t *testing.T) {
 func TestBookmarkService_PollBookmarks_Success(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -507,9 +542,13 @@ func TestBookmarkService_PollBookmarks_Success(t *testing.T) {
 func TestBookmarkService_PollBookmarks_NoNewBookmarks(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -536,9 +575,13 @@ func TestBookmarkService_PollBookmarks_NoNewBookmarks(t *testing.T) {
 func TestBookmarkService_PollBookmarks_GetBackfillStateError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -567,9 +610,13 @@ func TestBookmarkService_PollBookmarks_GetBackfillStateError(t *testing.T) {
 func TestBookmarkService_PollBookmarks_FetchError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -600,9 +647,13 @@ func TestBookmarkService_PollBookmarks_FetchError(t *testing.T) {
 func TestBookmarkService_RunBackfill_AlreadyComplete(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -633,15 +684,21 @@ func TestBookmarkService_RunBackfill_AlreadyComplete(t *testing.T) {
 func TestBookmarkService_RunBackfill_NoBookmarks(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     2,
 			BackfillDelay: "1ms", // Very short delay for testing
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -678,15 +735,21 @@ func TestBookmarkService_RunBackfill_NoBookmarks(t *testing.T) {
 func TestBookmarkService_RunBackfill_WithBookmarksNoNextURL(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     2,
 			BackfillDelay: "1ms",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -743,9 +806,13 @@ func TestBookmarkService_RunBackfill_WithBookmarksNoNextURL(t *testing.T) {
 func TestBookmarkService_RunBackfill_ContextCancelled(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -773,9 +840,13 @@ func TestBookmarkService_RunBackfill_ContextCancelled(t *testing.T) {
 func TestBookmarkService_StartPolling_InvalidInterval(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			Interval: "invalid-duration",
 		},
@@ -799,9 +870,13 @@ func TestBookmarkService_StartPolling_InvalidInterval(t *testing.T) {
 func TestBookmarkService_StartPolling_ZeroInterval(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			Interval: "0s",
 		},
@@ -826,9 +901,13 @@ func TestBookmarkService_StartPolling_ZeroInterval(t *testing.T) {
 func TestBookmarkService_StartPolling_DefaultInterval(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			Interval: "", // Empty - should default to 5m
 		},
@@ -851,9 +930,13 @@ func TestBookmarkService_StartPolling_DefaultInterval(t *testing.T) {
 func TestBookmarkService_RunBackfill_GetStateError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -882,9 +965,13 @@ func TestBookmarkService_RunBackfill_GetStateError(t *testing.T) {
 func TestBookmarkService_RunBackfill_FetchError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     20,
 			BackfillDelay: "1ms",
@@ -916,15 +1003,21 @@ func TestBookmarkService_RunBackfill_FetchError(t *testing.T) {
 func TestBookmarkService_RunBackfill_ProcessBatchError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     2,
 			BackfillDelay: "1ms",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -965,15 +1058,21 @@ func TestBookmarkService_RunBackfill_ProcessBatchError(t *testing.T) {
 func TestBookmarkService_RunBackfill_UpdateStateError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     2,
 			BackfillDelay: "1ms",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -1022,15 +1121,21 @@ func TestBookmarkService_RunBackfill_UpdateStateError(t *testing.T) {
 func TestBookmarkService_RunBackfill_WithNextURL(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     2,
 			BackfillDelay: "1ms",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -1089,9 +1194,12 @@ func TestBookmarkService_RunBackfill_WithNextURL(t *testing.T) {
 func TestBookmarkService_Start_CreateClientError(t *testing.T) {
 	cfg := &Config{
 		Mastodon: struct {
-			Server        string `toml:"server"`
-			AccessToken   string `toml:"access_token"`
-			ClientTimeout string `toml:"client_timeout"`
+			Server          string `toml:"server"`
+			AccessToken     string `toml:"access_token"`
+			ClientTimeout   string `toml:"client_timeout"`
+			ClientID        string `toml:"client_id"`
+			ClientSecret    string `toml:"client_secret"`
+			CredentialsFile string `toml:"credentials_file"`
 		}{
 			Server:      "", // Invalid server to trigger error
 			AccessToken: "test-token",
@@ -1120,7 +1228,9 @@ func TestBookmarkService_Start_CreateClientError(t *testing.T) {
 func TestBookmarkService_ProcessBookmarkBatch_DatabaseError(t *testing.T) {
 	cfg := &Config{
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -1157,14 +1267,20 @@ func TestBookmarkService_ProcessBookmarkBatch_DatabaseError(t *testing.T) {
 func TestBookmarkService_PollBookmarks_ProcessBatchError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -1211,9 +1327,13 @@ func TestBookmarkService_PollBookmarks_ProcessBatchError(t *testing.T) {
 func TestBookmarkService_PollBookmarks_UpdateStateError(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: 20,
 		},
@@ -1261,15 +1381,21 @@ func TestBookmarkService_PollBookmarks_UpdateStateError(t *testing.T) {
 func TestBookmarkService_RunBackfill_DefaultBatchSize(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize:     0, // Zero should default to 40
 			BackfillDelay: "1ms",
 		},
 		Search: struct {
-			IndexedFields []string `toml:"indexed_fields"`
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
 		}{
 			IndexedFields: []string{"content"},
 		},
@@ -1303,9 +1429,13 @@ func TestBookmarkService_RunBackfill_DefaultBatchSize(t *testing.T) {
 func TestBookmarkService_PollBookmarks_DefaultBatchSize(t *testing.T) {
 	cfg := &Config{
 		Polling: struct {
-			Interval      string `toml:"interval"`
-			BatchSize     int    `toml:"batch_size"`
-			BackfillDelay string `toml:"backfill_delay"`
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
 		}{
 			BatchSize: -1, // Negative should default to 40
 		},
@@ -1372,3 +1502,838 @@ func drainEventChannel(eventChan chan ServerEvent) []ServerEvent {
 }
 
 // Helper function to drain events from a channel - moved setupTestDatabase to database_test.go
+
+// =============================================================================
+// RECONCILIATION TESTS
+// =============================================================================
+
+// MockPagedBookmarkClient serves a fixed set of pages keyed by the next URL
+// that leads to them; the first page is keyed by the empty string.
+type MockPagedBookmarkClient struct {
+	pages     map[string][]Bookmark
+	nextURLs  map[string]string
+	errAt     string
+	callCount int
+}
+
+func (m *MockPagedBookmarkClient) GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error) {
+	m.callCount++
+	if m.errAt != "" && nextURL == m.errAt {
+		return nil, "", errors.New("page fetch failed")
+	}
+	return m.pages[nextURL], m.nextURLs[nextURL], nil
+}
+
+// MockCollectionClient serves favourites and own statuses from per-source
+// MockPagedBookmarkClients.
+type MockCollectionClient struct {
+	MockPagedBookmarkClient
+	collections map[string]*MockPagedBookmarkClient
+}
+
+func (m *MockCollectionClient) GetCollection(ctx context.Context, source string, limit int, nextURL string) ([]Bookmark, string, error) {
+	collection, ok := m.collections[source]
+	if !ok {
+		return nil, "", fmt.Errorf("unexpected source %s", source)
+	}
+	return collection.GetBookmarks(ctx, limit, nextURL)
+}
+
+func newReconcileTestService(t *testing.T, client BookmarkClient) (*BookmarkService, *Database) {
+	t.Helper()
+
+	cfg := &Config{
+		Polling: struct {
+			Interval          string   `toml:"interval"`
+			BatchSize         int      `toml:"batch_size"`
+			BackfillDelay     string   `toml:"backfill_delay"`
+			ReconcileInterval string   `toml:"reconcile_interval"`
+			MaxPollPages      int      `toml:"max_poll_pages"`
+			ReadyIntervals    int      `toml:"ready_intervals"`
+			Sources           []string `toml:"sources"`
+		}{
+			BatchSize:     2,
+			BackfillDelay: "1ms",
+		},
+		Search: struct {
+			IndexedFields []string           `toml:"indexed_fields"`
+			Weights       map[string]float64 `toml:"weights"`
+			AutoReindex   bool               `toml:"auto_reindex"`
+		}{
+			IndexedFields: []string{"content"},
+		},
+	}
+
+	db := setupTestDatabase(t)
+	t.Cleanup(func() { db.close() })
+
+	return &BookmarkService{
+		config: cfg,
+		db:     db,
+		ctx:    context.Background(),
+		client: client,
+	}, db
+}
+
+func testBookmark(statusID string) Bookmark {
+	return Bookmark{
+		ID:        statusID,
+		Status:    Status{ID: statusID, Content: "content for " + statusID},
+		CreatedAt: time.Now(),
+	}
+}
+
+func TestBookmarkService_ReconcileBookmarks_MarksRemoved(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"":       {testBookmark("status-1"), testBookmark("status-2")},
+			"page-2": {testBookmark("status-4")},
+		},
+		nextURLs: map[string]string{"": "page-2"},
+	}
+	service, db := newReconcileTestService(t, client)
+
+	for _, id := range []string{"status-1", "status-2", "status-3", "status-4"} {
+		if err := db.insertBookmark(createTestBookmark(id, "content")); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	if err := service.reconcileBookmarks(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if client.callCount != 2 {
+		t.Errorf("Expected 2 page fetches, got %d", client.callCount)
+	}
+
+	removed, err := db.getBookmark("status-3")
+	if err != nil || removed == nil {
+		t.Fatalf("Expected removed bookmark to be kept, got %v (err %v)", removed, err)
+	}
+	if removed.UnbookmarkedAt == nil {
+		t.Error("Expected status-3 to be marked as unbookmarked")
+	}
+
+	for _, id := range []string{"status-1", "status-2", "status-4"} {
+		bookmark, err := db.getBookmark(id)
+		if err != nil || bookmark == nil {
+			t.Fatalf("Failed to get bookmark %s: %v", id, err)
+		}
+		if bookmark.UnbookmarkedAt != nil {
+			t.Errorf("Expected %s to remain active", id)
+		}
+	}
+
+	state, err := db.getBackfillState()
+	if err != nil {
+		t.Fatalf("Failed to get backfill state: %v", err)
+	}
+	if state.LastReconcileTime == nil {
+		t.Error("Expected last reconcile time to be recorded")
+	}
+}
+
+func TestBookmarkService_ReconcileBookmarks_OnlyTouchesOwnArchive(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"": {testBookmark("status-1")},
+		},
+	}
+	service, db := newReconcileTestService(t, client)
+	service.account = AccountConfig{Name: "alice"}
+
+	if err := db.ensureBackfillState("alice", SourceBookmark); err != nil {
+		t.Fatalf("Failed to create backfill state: %v", err)
+	}
+	for _, owner := range []string{"alice", "bob"} {
+		for _, id := range []string{"status-1", "status-2"} {
+			bookmark := createTestBookmark(id, "content")
+			bookmark.OwnerAccount = owner
+			if err := db.insertBookmark(bookmark); err != nil {
+				t.Fatalf("Failed to insert bookmark: %v", err)
+			}
+		}
+	}
+
+	if err := service.reconcileBookmarks(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	removed, err := db.getBookmarkForOwner("alice", SourceBookmark, "status-2")
+	if err != nil || removed == nil || removed.UnbookmarkedAt == nil {
+		t.Errorf("Expected alice's status-2 to be marked as unbookmarked, got %+v (err %v)", removed, err)
+	}
+	untouched, err := db.getBookmarkForOwner("bob", SourceBookmark, "status-2")
+	if err != nil || untouched == nil || untouched.UnbookmarkedAt != nil {
+		t.Errorf("Expected bob's status-2 to remain active, got %+v (err %v)", untouched, err)
+	}
+
+	state, err := db.getBackfillStateForOwner("alice", SourceBookmark)
+	if err != nil || state.LastReconcileTime == nil {
+		t.Errorf("Expected alice's reconcile time to be recorded, got %+v (err %v)", state, err)
+	}
+}
+
+func TestBookmarkService_ReconcileBookmarks_RestoresAndAddsMissing(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"": {testBookmark("status-1"), testBookmark("status-new")},
+		},
+	}
+	service, db := newReconcileTestService(t, client)
+
+	if err := db.insertBookmark(createTestBookmark("status-1", "content")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := db.setBookmarksUnbookmarked("", SourceBookmark, []string{"status-1"}, &past); err != nil {
+		t.Fatalf("Failed to mark bookmark removed: %v", err)
+	}
+
+	if err := service.reconcileBookmarks(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	restored, err := db.getBookmark("status-1")
+	if err != nil || restored == nil {
+		t.Fatalf("Failed to get restored bookmark: %v", err)
+	}
+	if restored.UnbookmarkedAt != nil {
+		t.Error("Expected re-bookmarked status to be active again")
+	}
+
+	added, err := db.getBookmark("status-new")
+	if err != nil {
+		t.Fatalf("Failed to get added bookmark: %v", err)
+	}
+	if added == nil {
+		t.Error("Expected bookmark missing from the archive to be inserted")
+	}
+}
+
+func TestBookmarkService_ReconcileBookmarks_PartialWalkChangesNothing(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"": {testBookmark("status-1")},
+		},
+		nextURLs: map[string]string{"": "page-2"},
+		errAt:    "page-2",
+	}
+	service, db := newReconcileTestService(t, client)
+
+	if err := db.insertBookmark(createTestBookmark("status-2", "content")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	err := service.reconcileBookmarks()
+	if err == nil {
+		t.Fatal("Expected error when a page fetch fails")
+	}
+
+	bookmark, err := db.getBookmark("status-2")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if bookmark.UnbookmarkedAt != nil {
+		t.Error("Expected no bookmarks to be marked after an incomplete walk")
+	}
+}
+
+func TestBookmarkService_ReconcileBookmarks_EmptyListChangesNothing(t *testing.T) {
+	client := &MockPagedBookmarkClient{pages: map[string][]Bookmark{}}
+	service, db := newReconcileTestService(t, client)
+
+	for _, id := range []string{"status-1", "status-2"} {
+		if err := db.insertBookmark(createTestBookmark(id, "content")); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	if err := service.reconcileBookmarks(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, id := range []string{"status-1", "status-2"} {
+		bookmark, err := db.getBookmark(id)
+		if err != nil || bookmark == nil {
+			t.Fatalf("Failed to get bookmark %s: %v", id, err)
+		}
+		if bookmark.UnbookmarkedAt != nil {
+			t.Errorf("Expected %s to stay active after an empty server list", id)
+		}
+	}
+}
+
+func TestBookmarkService_ProcessBookmarkBatch_RestoresUnbookmarked(t *testing.T) {
+	service, db := newReconcileTestService(t, &MockPagedBookmarkClient{})
+
+	if err := db.insertBookmark(createTestBookmark("status-1", "content")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	now := time.Now()
+	if err := db.setBookmarksUnbookmarked("", SourceBookmark, []string{"status-1"}, &now); err != nil {
+		t.Fatalf("Failed to mark bookmark removed: %v", err)
+	}
+
+	if err := service.processBookmarkBatch([]Bookmark{testBookmark("status-1")}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	bookmark, err := db.getBookmark("status-1")
+	if err != nil || bookmark == nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if bookmark.UnbookmarkedAt != nil {
+		t.Error("Expected bookmark seen while polling to be active again")
+	}
+}
+
+// =============================================================================
+// INCREMENTAL POLLING TESTS
+// =============================================================================
+
+func TestBookmarkService_PollBookmarks_FollowsNextUntilArchived(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"":       {testBookmark("status-5"), testBookmark("status-4")},
+			"page-2": {testBookmark("status-3"), testBookmark("status-2")},
+			"page-3": {testBookmark("status-1"), testBookmark("status-0")},
+			"page-4": {testBookmark("status-old")},
+		},
+		nextURLs: map[string]string{"": "page-2", "page-2": "page-3", "page-3": "page-4"},
+	}
+	service, db := newReconcileTestService(t, client)
+
+	// status-1 and status-0 were archived by an earlier poll
+	for _, id := range []string{"status-1", "status-0"} {
+		if err := db.insertBookmark(createTestBookmark(id, "content")); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	if err := service.pollBookmarks(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if client.callCount != 3 {
+		t.Errorf("Expected polling to stop at the first fully archived page (3 fetches), got %d", client.callCount)
+	}
+
+	for _, id := range []string{"status-5", "status-4", "status-3", "status-2"} {
+		bookmark, err := db.getBookmark(id)
+		if err != nil {
+			t.Fatalf("Failed to get bookmark %s: %v", id, err)
+		}
+		if bookmark == nil {
+			t.Errorf("Expected %s from an overflow page to be archived", id)
+		}
+	}
+
+	state, err := db.getBackfillState()
+	if err != nil {
+		t.Fatalf("Failed to get backfill state: %v", err)
+	}
+	if state.LastPollPages != 3 {
+		t.Errorf("Expected 3 pages recorded, got %d", state.LastPollPages)
+	}
+	if state.LastPollTime == nil {
+		t.Error("Expected poll time to be recorded")
+	}
+}
+
+func TestBookmarkService_PollBookmarks_RespectsPageCap(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"":       {testBookmark("status-3")},
+			"page-2": {testBookmark("status-2")},
+			"page-3": {testBookmark("status-1")},
+		},
+		nextURLs: map[string]string{"": "page-2", "page-2": "page-3"},
+	}
+	service, db := newReconcileTestService(t, client)
+	service.config.Polling.MaxPollPages = 2
+
+	if err := service.pollBookmarks(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if client.callCount != 2 {
+		t.Errorf("Expected 2 fetches with a page cap of 2, got %d", client.callCount)
+	}
+
+	bookmark, err := db.getBookmark("status-1")
+	if err != nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if bookmark != nil {
+		t.Error("Expected page beyond the cap not to be fetched")
+	}
+
+	state, err := db.getBackfillState()
+	if err != nil {
+		t.Fatalf("Failed to get backfill state: %v", err)
+	}
+	if state.LastPollPages != 2 {
+		t.Errorf("Expected 2 pages recorded, got %d", state.LastPollPages)
+	}
+	if state.PollResumeURL != "page-3" {
+		t.Errorf("Expected poll to record page-3 as its resume point, got %q", state.PollResumeURL)
+	}
+}
+
+func TestBookmarkService_PollBookmarks_ResumesAfterPageCap(t *testing.T) {
+	client := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"":       {testBookmark("status-3")},
+			"page-2": {testBookmark("status-2")},
+			"page-3": {testBookmark("status-1")},
+			"page-4": {testBookmark("status-0")},
+		},
+		nextURLs: map[string]string{"": "page-2", "page-2": "page-3", "page-3": "page-4"},
+	}
+	service, db := newReconcileTestService(t, client)
+	service.config.Polling.MaxPollPages = 2
+
+	// status-0 was archived before the burst of new bookmarks
+	if err := db.insertBookmark(createTestBookmark("status-0", "content")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	if err := service.pollBookmarks(); err != nil {
+		t.Fatalf("Expected no error on first poll, got %v", err)
+	}
+
+	// A new bookmark arrives before the next poll, which has room to finish
+	// the interrupted walk and then check the newest page
+	client.pages[""] = []Bookmark{testBookmark("status-4"), testBookmark("status-3")}
+	service.config.Polling.MaxPollPages = 4
+
+	if err := service.pollBookmarks(); err != nil {
+		t.Fatalf("Expected no error on second poll, got %v", err)
+	}
+
+	for _, id := range []string{"status-4", "status-3", "status-2", "status-1"} {
+		bookmark, err := db.getBookmark(id)
+		if err != nil {
+			t.Fatalf("Failed to get bookmark %s: %v", id, err)
+		}
+		if bookmark == nil {
+			t.Errorf("Expected %s to be archived after the resumed poll", id)
+		}
+	}
+
+	state, err := db.getBackfillState()
+	if err != nil {
+		t.Fatalf("Failed to get backfill state: %v", err)
+	}
+	if state.PollResumeURL != "" {
+		t.Errorf("Expected resume point to be cleared once caught up, got %q", state.PollResumeURL)
+	}
+}
+
+// =============================================================================
+// STATUS REFRESH TESTS
+// =============================================================================
+
+// MockPagedStatusClient serves GetStatus from a map; missing IDs are reported as
+// deleted upstream.
+type MockPagedStatusClient struct {
+	MockPagedBookmarkClient
+	statuses map[string]Status
+	fetched  []string
+}
+
+func (m *MockPagedStatusClient) GetStatus(ctx context.Context, statusID string) (Status, error) {
+	m.fetched = append(m.fetched, statusID)
+	status, ok := m.statuses[statusID]
+	if !ok {
+		return Status{}, errStatusNotFound
+	}
+	return status, nil
+}
+
+func TestBookmarkService_RefreshStatuses(t *testing.T) {
+	original := testBookmark("status-edited")
+	original.Status.Content = "original wording"
+	unchanged := testBookmark("status-same")
+
+	client := &MockPagedStatusClient{
+		statuses: map[string]Status{
+			"status-edited": {ID: "status-edited", Content: "revised wording", CreatedAt: original.Status.CreatedAt},
+			"status-same":   unchanged.Status,
+		},
+	}
+	service, db := newReconcileTestService(t, client)
+
+	for _, bookmark := range []Bookmark{original, unchanged, testBookmark("status-gone")} {
+		if err := db.insertBookmark(convertBookmarkToDatabase(bookmark, service.config.Search.IndexedFields)); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	if err := service.refreshStatuses(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(client.fetched) != 3 {
+		t.Errorf("Expected 3 statuses to be fetched, got %d", len(client.fetched))
+	}
+
+	// Edited status is updated, searchable by its new text, and keeps a revision
+	edited, err := db.getBookmark("status-edited")
+	if err != nil || edited == nil {
+		t.Fatalf("Failed to get edited bookmark: %v", err)
+	}
+	if !strings.Contains(edited.SearchText, "revised wording") {
+		t.Errorf("Expected search text to be updated, got '%s'", edited.SearchText)
+	}
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "revised"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.StatusID != "status-edited" {
+		t.Errorf("Expected FTS index to find the revised text, got %d results", len(results))
+	}
+	revisions, err := db.getBookmarkRevisions("", SourceBookmark, "status-edited")
+	if err != nil {
+		t.Fatalf("Failed to get revisions: %v", err)
+	}
+	if len(revisions) != 1 || !strings.Contains(revisions[0].SearchText, "original wording") {
+		t.Errorf("Expected one revision with the original text, got %+v", revisions)
+	}
+
+	// Unchanged status gets no revision
+	revisions, err = db.getBookmarkRevisions("", SourceBookmark, "status-same")
+	if err != nil {
+		t.Fatalf("Failed to get revisions: %v", err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("Expected no revisions for unchanged status, got %d", len(revisions))
+	}
+
+	// Deleted status is flagged but kept
+	gone, err := db.getBookmark("status-gone")
+	if err != nil || gone == nil {
+		t.Fatalf("Expected deleted status to be kept: %v", err)
+	}
+	if gone.DeletedAt == nil {
+		t.Error("Expected deleted status to be flagged")
+	}
+
+	// Everything was rescheduled, so nothing is due right away
+	client.fetched = nil
+	if err := service.refreshStatuses(); err != nil {
+		t.Fatalf("Expected no error on second run, got %v", err)
+	}
+	if len(client.fetched) != 0 {
+		t.Errorf("Expected no statuses due immediately after refresh, got %v", client.fetched)
+	}
+}
+
+func TestBookmarkService_RefreshStatuses_SkipsUnresolvedImports(t *testing.T) {
+	client := &MockPagedStatusClient{statuses: map[string]Status{}}
+	service, db := newReconcileTestService(t, client)
+
+	uri := "https://other.example.com/users/bob/statuses/7"
+	imported := convertBookmarkToDatabase(Bookmark{ID: uri, Status: Status{ID: uri, URI: uri}}, service.config.Search.IndexedFields)
+	if err := db.insertBookmark(imported); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	if err := service.refreshStatuses(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(client.fetched) != 0 {
+		t.Errorf("Expected unresolved import not to be fetched, got %v", client.fetched)
+	}
+
+	bookmark, err := db.getBookmark(uri)
+	if err != nil || bookmark == nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if bookmark.DeletedAt != nil {
+		t.Error("Expected unresolved import not to be flagged as deleted")
+	}
+}
+
+func TestBookmarkService_RefreshStatuses_PicksUpLateCard(t *testing.T) {
+	original := testBookmark("status-1")
+	withCard := original.Status
+	withCard.Card = &Card{URL: "https://example.com/article", Title: "Late preview card"}
+
+	client := &MockPagedStatusClient{statuses: map[string]Status{"status-1": withCard}}
+	service, db := newReconcileTestService(t, client)
+	service.config.Search.IndexedFields = []string{"content", "card"}
+
+	if err := db.insertBookmark(convertBookmarkToDatabase(original, service.config.Search.IndexedFields)); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	if err := service.refreshStatuses(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	refreshed, err := db.getBookmark("status-1")
+	if err != nil || refreshed == nil {
+		t.Fatalf("Failed to get bookmark: %v", err)
+	}
+	if !strings.Contains(refreshed.SearchText, "Late preview card") {
+		t.Errorf("Expected a card attached after archiving to be indexed, got '%s'", refreshed.SearchText)
+	}
+}
+
+func TestBookmarkService_RefreshStatuses_UnsupportedClient(t *testing.T) {
+	service, _ := newReconcileTestService(t, &MockPagedBookmarkClient{})
+
+	if err := service.refreshStatuses(); err == nil {
+		t.Error("Expected error when the client cannot fetch statuses")
+	}
+}
+
+func TestRefreshDelay(t *testing.T) {
+	now := time.Now()
+	minDelay, maxDelay := time.Hour, 30*24*time.Hour
+
+	testCases := []struct {
+		age      time.Duration
+		expected time.Duration
+	}{
+		{10 * time.Minute, minDelay},
+		{3 * 24 * time.Hour, 3 * 24 * time.Hour},
+		{365 * 24 * time.Hour, maxDelay},
+	}
+
+	for _, tc := range testCases {
+		if got := refreshDelay(now.Add(-tc.age), now, minDelay, maxDelay); got != tc.expected {
+			t.Errorf("refreshDelay(age %v) = %v, expected %v", tc.age, got, tc.expected)
+		}
+	}
+}
+
+func TestBookmarkService_RunBackfill_Favourites(t *testing.T) {
+	favourites := &MockPagedBookmarkClient{
+		pages: map[string][]Bookmark{
+			"": {testBookmark("status-1"), testBookmark("status-2")},
+		},
+	}
+	client := &MockCollectionClient{
+		collections: map[string]*MockPagedBookmarkClient{SourceFavourite: favourites},
+	}
+	service, db := newReconcileTestService(t, client)
+	service.source = SourceFavourite
+
+	// The same status can be both bookmarked and favourited
+	if err := db.insertBookmark(createTestBookmark("status-1", "content")); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	if err := db.ensureBackfillState("", SourceFavourite); err != nil {
+		t.Fatalf("Failed to create backfill state: %v", err)
+	}
+
+	if err := service.runBackfill(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if client.callCount != 0 || favourites.callCount != 1 {
+		t.Errorf("Expected only the favourites collection to be fetched, got %d bookmark and %d favourite calls",
+			client.callCount, favourites.callCount)
+	}
+
+	for _, id := range []string{"status-1", "status-2"} {
+		favourite, err := db.getBookmarkForOwner("", SourceFavourite, id)
+		if err != nil || favourite == nil {
+			t.Fatalf("Expected favourite %s to be archived: %v", id, err)
+		}
+		if favourite.Source != SourceFavourite {
+			t.Errorf("Expected source %s, got %s", SourceFavourite, favourite.Source)
+		}
+	}
+
+	favouriteState, err := db.getBackfillStateForOwner("", SourceFavourite)
+	if err != nil || !favouriteState.BackfillComplete {
+		t.Errorf("Expected favourites backfill to be complete, got %+v (%v)", favouriteState, err)
+	}
+	bookmarkState, err := db.getBackfillState()
+	if err != nil || bookmarkState.BackfillComplete {
+		t.Errorf("Expected bookmark backfill state to be independent, got %+v (%v)", bookmarkState, err)
+	}
+}
+
+func TestBookmarkService_FetchPage_UnsupportedClient(t *testing.T) {
+	service, _ := newReconcileTestService(t, &MockPagedBookmarkClient{})
+	service.source = SourceOwnStatus
+
+	if _, _, err := service.fetchPage(20, ""); err == nil {
+		t.Error("Expected error when the client cannot fetch own statuses")
+	}
+}
+
+func TestBookmarkService_RefreshStatuses_FetchesSharedStatusOnce(t *testing.T) {
+	original := testBookmark("status-shared")
+	original.Status.Content = "original wording"
+
+	client := &MockPagedStatusClient{
+		statuses: map[string]Status{
+			"status-shared": {ID: "status-shared", Content: "revised wording", CreatedAt: original.Status.CreatedAt},
+		},
+	}
+	service, db := newReconcileTestService(t, client)
+
+	for _, source := range []string{SourceBookmark, SourceFavourite} {
+		bookmark := convertBookmarkToDatabase(original, service.config.Search.IndexedFields)
+		bookmark.Source = source
+		if err := db.insertBookmark(bookmark); err != nil {
+			t.Fatalf("Failed to insert %s: %v", source, err)
+		}
+	}
+
+	if err := service.refreshStatuses(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(client.fetched) != 1 {
+		t.Errorf("Expected a status in two collections to be fetched once, got %v", client.fetched)
+	}
+
+	for _, source := range []string{SourceBookmark, SourceFavourite} {
+		bookmark, err := db.getBookmarkForOwner("", source, "status-shared")
+		if err != nil || bookmark == nil {
+			t.Fatalf("Failed to get %s copy: %v", source, err)
+		}
+		if !strings.Contains(bookmark.SearchText, "revised wording") {
+			t.Errorf("Expected %s copy to be updated, got '%s'", source, bookmark.SearchText)
+		}
+	}
+}
+
+// =============================================================================
+// ACCOUNT CONNECTION TESTS
+// =============================================================================
+
+func TestAccountConnection_SharedBetweenCollections(t *testing.T) {
+	var mu sync.Mutex
+	credentialChecks := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/accounts/verify_credentials" {
+			http.NotFound(w, r)
+			return
+		}
+		mu.Lock()
+		credentialChecks++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": "42", "username": "alice", "acct": "alice", "display_name": "Alice"}`)
+	}))
+	defer server.Close()
+
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	cfg := &Config{}
+	account := AccountConfig{Name: "alice", Server: server.URL, AccessToken: "test-token"}
+	connection := newAccountConnection(account, db)
+
+	var clients []BookmarkClient
+	for _, source := range []string{SourceBookmark, SourceFavourite, SourceOwnStatus} {
+		service, err := newAccountBookmarkService(cfg, db, nil, account, source)
+		if err != nil {
+			t.Fatalf("Failed to create %s service: %v", source, err)
+		}
+		service.connection = connection
+
+		client, err := service.createBookmarkClient()
+		if err != nil {
+			t.Fatalf("Failed to create %s client: %v", source, err)
+		}
+		clients = append(clients, client)
+	}
+
+	for _, client := range clients[1:] {
+		if client != clients[0] {
+			t.Error("Expected every collection of an account to share one client and rate limiter")
+		}
+	}
+
+	// verifyCredentials and the account lookup happen once for the account
+	if credentialChecks != 2 {
+		t.Errorf("Expected 2 credential requests for the account, got %d", credentialChecks)
+	}
+
+	user, err := db.getUserAccountForOwner("alice")
+	if err != nil || user == nil || user.AccountID != "42" {
+		t.Errorf("Expected user account to be stored, got %+v (err %v)", user, err)
+	}
+}
+
+// =============================================================================
+// THREAD CONTEXT TESTS
+// =============================================================================
+
+// MockContextClient serves thread context for statuses and records which
+// ones were asked for.
+type MockContextClient struct {
+	MockPagedBookmarkClient
+	threads map[string]StatusContext
+	fetched []string
+}
+
+func (m *MockContextClient) GetContext(ctx context.Context, statusID string) (StatusContext, error) {
+	m.fetched = append(m.fetched, statusID)
+	thread, ok := m.threads[statusID]
+	if !ok {
+		return StatusContext{}, errStatusNotFound
+	}
+	return thread, nil
+}
+
+func TestBookmarkService_ProcessBookmarkBatch_ArchivesThread(t *testing.T) {
+	client := &MockContextClient{
+		threads: map[string]StatusContext{
+			"status-2": {
+				Ancestors:   []Status{{ID: "status-1", Content: "<p>How do I configure the frobnicator?</p>"}},
+				Descendants: []Status{{ID: "status-3", Content: "<p>That worked, thanks</p>"}},
+			},
+		},
+	}
+	service, db := newReconcileTestService(t, client)
+	service.config.Threads.Enabled = true
+	service.config.Search.IndexedFields = []string{"content", "thread"}
+
+	if err := service.processBookmarkBatch([]Bookmark{testBookmark("status-2"), testBookmark("status-9")}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	thread, err := db.getStatusContext("", "status-2")
+	if err != nil {
+		t.Fatalf("Failed to get status context: %v", err)
+	}
+	if len(thread.Ancestors) != 1 || len(thread.Descendants) != 1 {
+		t.Errorf("Expected the thread to be stored, got %+v", thread)
+	}
+
+	results, err := db.searchBookmarksWithFTS5(&SearchRequest{Query: "frobnicator"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 1 || results[0].Bookmark.StatusID != "status-2" {
+		t.Errorf("Expected the thread to be searchable, got %+v", results)
+	}
+
+	// A failed context fetch still archives the status
+	if bookmark, err := db.getBookmark("status-9"); err != nil || bookmark == nil {
+		t.Errorf("Expected status without context to be archived, got %v, %v", bookmark, err)
+	}
+}
+
+func TestBookmarkService_ProcessBookmarkBatch_ThreadsDisabled(t *testing.T) {
+	client := &MockContextClient{}
+	service, _ := newReconcileTestService(t, client)
+
+	if err := service.processBookmarkBatch([]Bookmark{testBookmark("status-1")}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(client.fetched) != 0 {
+		t.Errorf("Expected no context fetches when threads are disabled, got %v", client.fetched)
+	}
+}
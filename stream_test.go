@@ -0,0 +1,442 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// USER STREAM TESTS
+// =============================================================================
+
+// fakeStreamingServer serves the user stream over server-sent events. Each
+// connection writes the frames sent on the returned channel until it is
+// told to hang up by an empty frame.
+type fakeStreamingServer struct {
+	*httptest.Server
+	frames chan string
+
+	mu          sync.Mutex
+	connections int
+}
+
+func newFakeStreamingServer(t *testing.T) *fakeStreamingServer {
+	t.Helper()
+
+	fake := &fakeStreamingServer{frames: make(chan string, 16)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/streaming/user", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fake.mu.Lock()
+		fake.connections++
+		fake.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case frame := <-fake.frames:
+				if frame == "" {
+					return
+				}
+				fmt.Fprint(w, frame)
+				w.(http.Flusher).Flush()
+			}
+		}
+	})
+	fake.Server = httptest.NewServer(mux)
+	t.Cleanup(fake.Close)
+	return fake
+}
+
+func (f *fakeStreamingServer) connectionCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.connections
+}
+
+func streamFrame(event, data string) string {
+	return fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+}
+
+func startTestStream(t *testing.T, server string) (*UserStream, <-chan StreamEvent) {
+	t.Helper()
+
+	stream := newUserStream(server, "test-token", time.Millisecond, 10*time.Millisecond)
+	events := stream.subscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		stream.run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return stream, events
+}
+
+func receiveStreamEvent(t *testing.T, events <-chan StreamEvent) StreamEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for stream event")
+		return StreamEvent{}
+	}
+}
+
+func TestUserStream_DeliversEvents(t *testing.T) {
+	fake := newFakeStreamingServer(t)
+	stream, events := startTestStream(t, fake.URL)
+
+	fake.frames <- ":thump\n\n"
+	fake.frames <- streamFrame("filters_changed", "")
+	fake.frames <- streamFrame("update", `{"id":"101","content":"<p>hello</p>","account":{"id":"7","username":"alice"}}`)
+	fake.frames <- streamFrame("delete", "102")
+
+	update := receiveStreamEvent(t, events)
+	if update.Event != "update" || update.StatusID != "101" || update.Status == nil || update.Status.Account.ID != "7" {
+		t.Errorf("Unexpected update event %+v", update)
+	}
+	deletion := receiveStreamEvent(t, events)
+	if deletion.Event != "delete" || deletion.StatusID != "102" {
+		t.Errorf("Unexpected delete event %+v", deletion)
+	}
+	if !stream.isConnected() {
+		t.Error("Expected stream to be connected")
+	}
+}
+
+func TestUserStream_ReconnectsAfterServerCloses(t *testing.T) {
+	fake := newFakeStreamingServer(t)
+	_, events := startTestStream(t, fake.URL)
+
+	fake.frames <- streamFrame("delete", "1")
+	receiveStreamEvent(t, events)
+	fake.frames <- ""
+	fake.frames <- streamFrame("delete", "2")
+
+	if event := receiveStreamEvent(t, events); event.StatusID != "2" {
+		t.Errorf("Expected event from the new connection, got %+v", event)
+	}
+	if count := fake.connectionCount(); count != 2 {
+		t.Errorf("Expected 2 connections, got %d", count)
+	}
+}
+
+func TestUserStream_ReconnectsWhenIdle(t *testing.T) {
+	fake := newFakeStreamingServer(t)
+
+	stream := newUserStream(fake.URL, "test-token", time.Millisecond, 10*time.Millisecond)
+	stream.idleTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go stream.run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.connectionCount() < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected a silent stream to be reconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUserStream_Unavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	stream := newUserStream(server.URL, "test-token", time.Millisecond, 10*time.Millisecond)
+	connected, err := stream.connect(context.Background())
+	if connected || err == nil {
+		t.Errorf("Expected failed connection, got %v and %v", connected, err)
+	}
+	if stream.isConnected() {
+		t.Error("Expected stream to be disconnected")
+	}
+}
+
+func TestUserStream_DiscoversStreamingHost(t *testing.T) {
+	fake := newFakeStreamingServer(t)
+
+	instance := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/instance" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"uri":"example.com","urls":{"streaming_api":%q}}`, strings.Replace(fake.URL, "http://", "ws://", 1))
+	}))
+	defer instance.Close()
+
+	_, events := startTestStream(t, instance.URL)
+	fake.frames <- streamFrame("delete", "1")
+	receiveStreamEvent(t, events)
+}
+
+func TestParseStreamEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		event    string
+		data     string
+		ok       bool
+		statusID string
+		notified string
+	}{
+		{"Update", "update", `{"id":"1","content":"hi"}`, true, "1", ""},
+		{"Edit", "status.update", `{"id":"2","content":"edited"}`, true, "2", ""},
+		{"Delete", "delete", "3", true, "3", ""},
+		{"QuotedDelete", "delete", `"4"`, true, "4", ""},
+		{"Notification", "notification", `{"id":"n1","type":"favourite","status":{"id":"5"}}`, true, "5", "favourite"},
+		{"FollowNotification", "notification", `{"id":"n2","type":"follow"}`, true, "", "follow"},
+		{"MalformedUpdate", "update", `{"id":`, false, "", ""},
+		{"EmptyDelete", "delete", "", false, "", ""},
+		{"OtherEvent", "filters_changed", "", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, ok := parseStreamEvent(tt.event, tt.data)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok=%v, got %v", tt.ok, ok)
+			}
+			if event.StatusID != tt.statusID {
+				t.Errorf("Expected status ID %q, got %q", tt.statusID, event.StatusID)
+			}
+			if event.NotificationType != tt.notified {
+				t.Errorf("Expected notification type %q, got %q", tt.notified, event.NotificationType)
+			}
+		})
+	}
+}
+
+// MockStreamClient reports each poll on a channel, so tests can wait for the
+// polls the stream triggers, and serves statuses from a map.
+type MockStreamClient struct {
+	polled   chan struct{}
+	statuses map[string]Status
+}
+
+func (m *MockStreamClient) GetBookmarks(ctx context.Context, limit int, nextURL string) ([]Bookmark, string, error) {
+	select {
+	case m.polled <- struct{}{}:
+	default:
+	}
+	return []Bookmark{}, "", nil
+}
+
+func (m *MockStreamClient) GetCollection(ctx context.Context, source string, limit int, nextURL string) ([]Bookmark, string, error) {
+	return m.GetBookmarks(ctx, limit, nextURL)
+}
+
+func (m *MockStreamClient) GetStatus(ctx context.Context, statusID string) (Status, error) {
+	status, ok := m.statuses[statusID]
+	if !ok {
+		return Status{}, errStatusNotFound
+	}
+	return status, nil
+}
+
+// startStreamTestService runs the polling loop of a service following the
+// fake server's stream, once that stream is connected.
+func startStreamTestService(t *testing.T, source string, client BookmarkClient) *fakeStreamingServer {
+	t.Helper()
+
+	fake := newFakeStreamingServer(t)
+	service, db := newReconcileTestService(t, client)
+	service.source = source
+	if err := db.ensureBackfillState("", source); err != nil {
+		t.Fatalf("Failed to create backfill state: %v", err)
+	}
+	service.config.Polling.Interval = "1h"
+	service.config.Streaming.Enabled = true
+	service.config.Streaming.MinPollInterval = "0s"
+	service.config.Streaming.ReconnectDelay = "1ms"
+	service.config.Streaming.MaxReconnectDelay = "10ms"
+	service.connection = &accountConnection{
+		account:   AccountConfig{Server: fake.URL, AccessToken: "test-token"},
+		db:        db,
+		software:  SoftwareMastodon,
+		accountID: "me",
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	service.ctx = ctx
+	done := make(chan error, 1)
+	go func() { done <- service.startPolling() }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Errorf("Expected polling to stop with context.Canceled, got %v", err)
+		}
+	})
+
+	// Frames sent before the subscription would reach no one
+	deadline := time.Now().Add(5 * time.Second)
+	for fake.connectionCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Stream did not connect")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return fake
+}
+
+func TestBookmarkService_StreamTriggersPoll(t *testing.T) {
+	client := &MockStreamClient{polled: make(chan struct{}, 1)}
+	fake := startStreamTestService(t, SourceFavourite, client)
+
+	select {
+	case <-client.polled:
+		t.Fatal("Expected no poll before a stream event")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	fake.frames <- streamFrame("notification", `{"id":"n1","type":"favourite","status":{"id":"9"}}`)
+	select {
+	case <-client.polled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stream event to trigger a poll")
+	}
+}
+
+func TestBookmarkService_StreamUpdatesDoNotPollBookmarks(t *testing.T) {
+	client := &MockStreamClient{polled: make(chan struct{}, 1)}
+	fake := startStreamTestService(t, SourceBookmark, client)
+
+	// A busy home timeline says nothing about bookmarks
+	for i := range 20 {
+		fake.frames <- streamFrame("update", fmt.Sprintf(`{"id":"%d","account":{"id":"someone"}}`, i))
+	}
+	fake.frames <- streamFrame("notification", `{"id":"n1","type":"mention","status":{"id":"9"}}`)
+	select {
+	case <-client.polled:
+		t.Fatal("Expected unrelated stream events not to trigger a poll")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBookmarkService_StreamRefreshesEditedStatus(t *testing.T) {
+	archived := testBookmark("status-edited")
+	archived.Status.Content = "original wording"
+	client := &MockStreamClient{
+		polled: make(chan struct{}, 1),
+		statuses: map[string]Status{
+			"status-edited": {ID: "status-edited", Content: "revised wording", CreatedAt: archived.Status.CreatedAt},
+		},
+	}
+
+	service, db := newReconcileTestService(t, client)
+	service.config.Refresh.Enabled = true
+	if err := db.insertBookmark(convertBookmarkToDatabase(archived, service.config.Search.IndexedFields)); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	event, ok := parseStreamEvent("status.update", `{"id":"status-edited","content":"revised wording"}`)
+	if !ok {
+		t.Fatal("Failed to parse status.update event")
+	}
+	if service.handleStreamEvent(event) {
+		t.Error("Expected an edit not to trigger a poll")
+	}
+
+	edited, err := db.getBookmark("status-edited")
+	if err != nil || edited == nil {
+		t.Fatalf("Failed to get edited bookmark: %v", err)
+	}
+	if !strings.Contains(edited.SearchText, "revised wording") {
+		t.Errorf("Expected search text to be updated, got %q", edited.SearchText)
+	}
+
+	// A deletion of an archived status marks it deleted upstream
+	delete(client.statuses, "status-edited")
+	service.handleStreamEvent(StreamEvent{Event: "delete", StatusID: "status-edited"})
+	deleted, err := db.getBookmark("status-edited")
+	if err != nil || deleted == nil {
+		t.Fatalf("Failed to get deleted bookmark: %v", err)
+	}
+	if deleted.DeletedAt == nil {
+		t.Error("Expected status to be marked deleted")
+	}
+}
+
+func TestBookmarkService_HandleStreamEvent(t *testing.T) {
+	own := &Status{ID: "1", Account: Account{ID: "me"}}
+	other := &Status{ID: "2", Account: Account{ID: "someone"}}
+
+	tests := []struct {
+		name   string
+		source string
+		event  StreamEvent
+		poll   bool
+	}{
+		{"BookmarksIgnoreUpdates", SourceBookmark, StreamEvent{Event: "update", StatusID: "2", Status: other}, false},
+		{"BookmarksIgnoreNotifications", SourceBookmark, StreamEvent{Event: "notification", NotificationType: "favourite"}, false},
+		{"FavouritesOnFavourite", SourceFavourite, StreamEvent{Event: "notification", NotificationType: "favourite"}, true},
+		{"FavouritesIgnoreMentions", SourceFavourite, StreamEvent{Event: "notification", NotificationType: "mention"}, false},
+		{"FavouritesIgnoreUpdates", SourceFavourite, StreamEvent{Event: "update", StatusID: "2", Status: other}, false},
+		{"OwnStatusesOnOwnPost", SourceOwnStatus, StreamEvent{Event: "update", StatusID: "1", Status: own}, true},
+		{"OwnStatusesIgnoreOthers", SourceOwnStatus, StreamEvent{Event: "update", StatusID: "2", Status: other}, false},
+		{"OwnStatusesIgnoreNotifications", SourceOwnStatus, StreamEvent{Event: "notification"}, false},
+		{"DeleteNeverPolls", SourceBookmark, StreamEvent{Event: "delete", StatusID: "3"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &BookmarkService{
+				config:     &Config{},
+				source:     tt.source,
+				connection: &accountConnection{accountID: "me"},
+			}
+			if poll := service.handleStreamEvent(tt.event); poll != tt.poll {
+				t.Errorf("Expected poll=%v, got %v", tt.poll, poll)
+			}
+		})
+	}
+}
+
+func TestAccountConnection_UserStream(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Streaming.Enabled = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	connection := &accountConnection{account: AccountConfig{Server: server.URL, AccessToken: "test-token"}}
+	stream, err := connection.userStream(ctx, &cfg)
+	if err != nil || stream == nil {
+		t.Fatalf("Expected a stream, got %v and %v", stream, err)
+	}
+	again, _ := connection.userStream(ctx, &cfg)
+	if again != stream {
+		t.Error("Expected the account's services to share one stream")
+	}
+
+	misskey := &accountConnection{account: connection.account, software: SoftwareMisskey}
+	if stream, err := misskey.userStream(ctx, &cfg); stream != nil || err != nil {
+		t.Errorf("Expected no stream for Misskey, got %v and %v", stream, err)
+	}
+
+	cfg.Streaming.ReconnectDelay = "soon"
+	invalid := &accountConnection{account: connection.account}
+	if _, err := invalid.userStream(ctx, &cfg); err == nil {
+		t.Error("Expected error for invalid reconnect_delay")
+	}
+}
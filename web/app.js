@@ -3,7 +3,10 @@ class BookmarchiveClient {
     constructor() {
         this.searchInput = document.getElementById('search-input');
         this.searchForm = document.getElementById('search-form');
+        this.archiveFilter = document.getElementById('archive-filter');
         this.accountFilter = document.getElementById('account-filter');
+        this.sourceFilter = document.getElementById('source-filter');
+        this.stateFilter = document.getElementById('state-filter');
         this.resultsContainer = document.getElementById('results-container');
         this.searchStatus = document.getElementById('search-status');
         this.loadingIndicator = document.getElementById('loading-indicator');
@@ -12,19 +15,37 @@ class BookmarchiveClient {
         this.connectionStatus = document.getElementById('connection-status');
         this.activityStatus = document.getElementById('activity-status');
         this.lastUpdate = document.getElementById('last-update');
+        this.resultsSection = document.getElementById('results-section');
+        this.loginSection = document.getElementById('login-section');
+        this.loginForm = document.getElementById('login-form');
+        this.loginPassword = document.getElementById('login-password');
+        this.loginError = document.getElementById('login-error');
+        this.logoutButton = document.getElementById('logout-button');
 
         this.searchTimeout = null;
         this.currentQuery = '';
         this.isSearching = false;
         this.eventSource = null;
+        this.csrfToken = '';
 
         this.init();
     }
 
-    init() {
+    async init() {
         this.setupEventListeners();
-        this.setupServerSentEvents();
         this.setupKeyboardShortcuts();
+
+        // With authentication configured nothing loads until logged in
+        const session = await this.loadSession();
+        if (session.auth_required && !session.authenticated) {
+            this.showLogin();
+            return;
+        }
+        this.csrfToken = session.csrf_token || '';
+        this.logoutButton.hidden = !session.auth_required;
+
+        this.setupServerSentEvents();
+        this.loadAccounts();
         this.loadInitialStats();
         this.loadRecentBookmarks(); // Load recent bookmarks on startup
         
@@ -51,11 +72,36 @@ class BookmarchiveClient {
             }
         });
 
+        // Archive selection change
+        this.archiveFilter.addEventListener('change', () => {
+            this.loadInitialStats();
+            this.handleFilterChange();
+        });
+
         // Account filter change
         this.accountFilter.addEventListener('change', () => {
             this.handleFilterChange();
         });
 
+        // Collection filter change
+        this.sourceFilter.addEventListener('change', () => {
+            this.handleFilterChange();
+        });
+
+        // Bookmark state filter change
+        this.stateFilter.addEventListener('change', () => {
+            this.handleFilterChange();
+        });
+
+        this.loginForm.addEventListener('submit', (e) => {
+            e.preventDefault();
+            this.login();
+        });
+
+        this.logoutButton.addEventListener('click', () => {
+            this.logout();
+        });
+
         // Handle result navigation with arrow keys
         document.addEventListener('keydown', (e) => {
             if (e.target === this.searchInput) return;
@@ -73,6 +119,84 @@ class BookmarchiveClient {
         });
     }
 
+    async loadSession() {
+        try {
+            const response = await fetch('/api/session');
+            if (response.ok) {
+                return await response.json();
+            }
+        } catch (error) {
+            console.error('Failed to load session:', error);
+        }
+        return { auth_required: false, authenticated: true };
+    }
+
+    // Like fetch, but sends the session's CSRF token with requests that
+    // change anything, and shows the login form once the session has ended
+    async apiFetch(url, options = {}) {
+        const method = (options.method || 'GET').toUpperCase();
+        if (this.csrfToken && method !== 'GET' && method !== 'HEAD') {
+            options = { ...options, headers: { ...options.headers, 'X-CSRF-Token': this.csrfToken } };
+        }
+        const response = await fetch(url, options);
+        if (response.status === 401) {
+            this.showLogin();
+        }
+        return response;
+    }
+
+    // errorMessage reads the message of an API error response
+    async errorMessage(response) {
+        try {
+            const body = await response.json();
+            return body.error || `HTTP ${response.status}`;
+        } catch (error) {
+            return `HTTP ${response.status}`;
+        }
+    }
+
+    showLogin() {
+        if (this.eventSource) {
+            this.eventSource.close();
+            this.eventSource = null;
+        }
+        this.resultsSection.hidden = true;
+        this.logoutButton.hidden = true;
+        this.loginSection.hidden = false;
+        this.loginPassword.focus();
+    }
+
+    async login() {
+        this.loginError.hidden = true;
+        try {
+            const response = await fetch('/api/login', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ password: this.loginPassword.value }),
+            });
+            if (!response.ok) {
+                this.loginError.textContent = await this.errorMessage(response);
+                this.loginError.hidden = false;
+                this.loginPassword.select();
+                return;
+            }
+            window.location.reload();
+        } catch (error) {
+            console.error('Login error:', error);
+            this.loginError.textContent = 'Login failed. Please try again.';
+            this.loginError.hidden = false;
+        }
+    }
+
+    async logout() {
+        try {
+            await this.apiFetch('/api/logout', { method: 'POST' });
+        } catch (error) {
+            console.error('Logout error:', error);
+        }
+        window.location.reload();
+    }
+
     setupServerSentEvents() {
         this.connectToEventStream();
     }
@@ -172,6 +296,12 @@ class BookmarchiveClient {
             case 'batch_start':
                 this.updateActivityStatus('Processing');
                 break;
+            case 'reconcile_complete':
+                this.loadInitialStats();
+                if (!this.searchInput.value.trim()) {
+                    this.loadRecentBookmarks();
+                }
+                break;
             case 'bookmark_processed':
                 // Don't update status for individual bookmark processing
                 // Keep status as "Processing" until batch is complete
@@ -195,8 +325,9 @@ class BookmarchiveClient {
 
     setupKeyboardShortcuts() {
         document.addEventListener('keydown', (e) => {
-            // Focus search with '/' key (like many search interfaces)
-            if (e.key === '/' && document.activeElement !== this.searchInput) {
+            // Focus search with '/' key (like many search interfaces),
+            // unless typing into a field such as a note
+            if (e.key === '/' && !e.target.closest('input, textarea, select')) {
                 e.preventDefault();
                 this.searchInput.focus();
                 this.searchInput.select();
@@ -249,7 +380,7 @@ class BookmarchiveClient {
         this.showLoading();
 
         try {
-            const response = await fetch('/api/search', {
+            const response = await this.apiFetch('/api/search', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -260,10 +391,19 @@ class BookmarchiveClient {
                     offset: 0,
                     enable_highlighting: true,
                     snippet_length: 200,
-                    filter_by_account: this.accountFilter.value
+                    filter_by_account: this.accountFilter.value,
+                    filter_by_source: this.sourceFilter.value,
+                    filter_by_state: this.stateFilter.value,
+                    account: this.archiveFilter.value
                 })
             });
 
+            if (response.status === 400) {
+                // The query could not be parsed; say why instead of failing
+                this.showError(await this.errorMessage(response));
+                return;
+            }
+
             if (!response.ok) {
                 throw new Error(`HTTP error! status: ${response.status}`);
             }
@@ -343,6 +483,15 @@ class BookmarchiveClient {
         if (isRecentBookmark) {
             card.classList.add('recent-bookmark');
         }
+        const source = bookmark.source || 'bookmark';
+        const isRemoved = Boolean(bookmark.unbookmarked_at);
+        if (isRemoved) {
+            card.classList.add('removed-bookmark');
+        }
+        const isDeleted = Boolean(bookmark.deleted_at);
+        if (isDeleted) {
+            card.classList.add('deleted-status');
+        }
         card.setAttribute('tabindex', '0');
         card.setAttribute('role', 'article');
         card.setAttribute('aria-label', `${isRecentBookmark ? 'Recent bookmark' : 'Search result'} ${index + 1}`);
@@ -434,6 +583,13 @@ class BookmarchiveClient {
             
             <div class="result-content">
                 <div class="result-snippet">${this.sanitizeHTML(content)}</div>
+                ${this.renderCard(fullBookmark && fullBookmark.status ? fullBookmark.status.card : null)}
+                ${this.renderMedia(result.media)}
+                <details class="result-thread">
+                    <summary>Thread</summary>
+                    <div class="result-thread-body"></div>
+                </details>
+                <div class="result-annotations"></div>
             </div>
             
             <footer class="result-footer">
@@ -446,6 +602,21 @@ class BookmarchiveClient {
                         Recent Bookmark
                     </div>
                 `}
+                ${source !== 'bookmark' ? `
+                    <div class="result-source">
+                        ${source === 'favourite' ? 'Favourite' : 'Own post'}
+                    </div>
+                ` : ''}
+                ${isRemoved ? `
+                    <div class="result-removed" title="Removed from ${source === 'bookmark' ? 'bookmarks' : 'the collection'} ${this.formatDate(bookmark.unbookmarked_at)}">
+                        Removed
+                    </div>
+                ` : ''}
+                ${isDeleted ? `
+                    <div class="result-deleted" title="Deleted by its author ${this.formatDate(bookmark.deleted_at)}">
+                        Deleted upstream
+                    </div>
+                ` : ''}
                 <div class="result-actions">
                     <a href="${this.escapeHTML(statusUrl)}" 
                        target="_blank" 
@@ -457,9 +628,20 @@ class BookmarchiveClient {
             </footer>
         `;
 
+        // Load the archived thread the first time it is expanded
+        const thread = card.querySelector('.result-thread');
+        thread.addEventListener('toggle', () => {
+            if (thread.open && !thread.dataset.loaded) {
+                thread.dataset.loaded = 'true';
+                this.loadThread(thread.querySelector('.result-thread-body'), bookmark);
+            }
+        });
+
+        this.renderAnnotations(card.querySelector('.result-annotations'), bookmark, result.annotations);
+
         // Add enter key handler for keyboard accessibility
         card.addEventListener('keydown', (e) => {
-            if (e.key === 'Enter') {
+            if (e.key === 'Enter' && !e.target.closest('.result-annotations')) {
                 e.preventDefault();
                 const link = card.querySelector('.result-link');
                 if (link) {
@@ -545,6 +727,13 @@ class BookmarchiveClient {
     updateStats(stats) {
         this.totalCount.textContent = stats.total_bookmarks || '--';
         this.lastUpdate.textContent = new Date().toLocaleTimeString();
+        if (stats.sync_stopped) {
+            this.activityStatus.textContent = 'Sync stopped';
+            this.activityStatus.title = stats.last_error || '';
+        } else if (stats.failed_polls > 0) {
+            this.activityStatus.textContent = 'Sync error';
+            this.activityStatus.title = stats.last_error || '';
+        }
     }
 
     updateResultsCount(count) {
@@ -559,9 +748,36 @@ class BookmarchiveClient {
         }
     }
 
+    async loadAccounts() {
+        try {
+            const response = await this.apiFetch('/api/accounts');
+            if (!response.ok) {
+                return;
+            }
+
+            const accounts = await response.json();
+            // A single archive needs no selector
+            if (!accounts || accounts.length < 2) {
+                return;
+            }
+
+            accounts.forEach(account => {
+                const option = document.createElement('option');
+                option.value = account.name;
+                option.textContent = account.user ? `@${account.user.acct}` : account.name;
+                this.archiveFilter.appendChild(option);
+            });
+            this.archiveFilter.hidden = false;
+        } catch (error) {
+            console.error('Failed to load accounts:', error);
+        }
+    }
+
     async loadInitialStats() {
         try {
-            const response = await fetch('/api/stats');
+            const account = this.archiveFilter.value;
+            const url = account ? `/api/stats?account=${encodeURIComponent(account)}` : '/api/stats';
+            const response = await this.apiFetch(url);
             if (response.ok) {
                 const stats = await response.json();
                 this.updateStats(stats);
@@ -580,7 +796,7 @@ class BookmarchiveClient {
         try {
             this.showLoading();
             
-            const response = await fetch('/api/search', {
+            const response = await this.apiFetch('/api/search', {
                 method: 'POST',
                 headers: {
                     'Content-Type': 'application/json',
@@ -591,7 +807,10 @@ class BookmarchiveClient {
                     offset: 0,
                     enable_highlighting: false,
                     snippet_length: 200,
-                    filter_by_account: this.accountFilter.value
+                    filter_by_account: this.accountFilter.value,
+                    filter_by_source: this.sourceFilter.value,
+                    filter_by_state: this.stateFilter.value,
+                    account: this.archiveFilter.value
                 })
             });
 
@@ -672,6 +891,200 @@ class BookmarchiveClient {
         return content;
     }
 
+    async loadThread(container, bookmark) {
+        container.textContent = 'Loading thread...';
+
+        try {
+            const params = new URLSearchParams();
+            if (bookmark.owner_account) {
+                params.set('account', bookmark.owner_account);
+            }
+            const response = await this.apiFetch(`/api/bookmarks/${encodeURIComponent(bookmark.status_id)}/thread?${params}`);
+            if (!response.ok) {
+                throw new Error(`HTTP ${response.status}`);
+            }
+            const thread = await response.json();
+
+            if (thread.ancestors.length === 0 && thread.descendants.length === 0) {
+                container.textContent = 'No thread archived for this post.';
+                return;
+            }
+
+            container.innerHTML = [
+                ...thread.ancestors.map(status => this.renderThreadStatus(status, 'ancestor')),
+                '<div class="result-thread-current">This post</div>',
+                ...thread.descendants.map(status => this.renderThreadStatus(status, 'descendant')),
+            ].join('');
+        } catch (error) {
+            console.error('Failed to load thread:', error);
+            container.textContent = 'Failed to load thread.';
+        }
+    }
+
+    renderThreadStatus(status, relation) {
+        const account = status.account || {};
+        const name = account.display_name || account.username || 'unknown';
+        return `
+            <div class="result-thread-status result-thread-${relation}">
+                <div class="result-thread-author">${this.escapeHTML(name)}</div>
+                <div class="result-thread-content">${this.sanitizeHTML(status.content || '')}</div>
+            </div>
+        `;
+    }
+
+    renderAnnotations(container, bookmark, annotations) {
+        const current = {
+            tags: (annotations && annotations.tags) || [],
+            note: (annotations && annotations.note) || '',
+            collections: (annotations && annotations.collections) || [],
+        };
+        const isEmpty = current.tags.length === 0 && current.collections.length === 0 && !current.note;
+
+        container.innerHTML = `
+            ${current.tags.length > 0 ? `
+                <ul class="result-tags" aria-label="Your tags">
+                    ${current.tags.map(tag => `<li>#${this.escapeHTML(tag)}</li>`).join('')}
+                </ul>
+            ` : ''}
+            ${current.collections.length > 0 ? `
+                <div class="result-collections">
+                    In ${current.collections.map(name => `<span>${this.escapeHTML(name)}</span>`).join(', ')}
+                </div>
+            ` : ''}
+            ${current.note ? `<p class="result-note">${this.escapeHTML(current.note)}</p>` : ''}
+            <button type="button" class="result-annotate">${isEmpty ? 'Add tags or a note' : 'Edit tags and note'}</button>
+        `;
+        container.querySelector('.result-annotate').addEventListener('click', () => {
+            this.editAnnotations(container, bookmark, current);
+        });
+    }
+
+    editAnnotations(container, bookmark, current) {
+        container.innerHTML = `
+            <form class="result-annotation-form">
+                <label>
+                    Tags
+                    <input name="tags" type="text" placeholder="reading, later" autocomplete="off">
+                </label>
+                <label>
+                    Collections
+                    <input name="collections" type="text" placeholder="Reading list, Recipes" autocomplete="off">
+                </label>
+                <label>
+                    Note
+                    <textarea name="note" rows="3"></textarea>
+                </label>
+                <div class="result-annotation-actions">
+                    <button type="submit">Save</button>
+                    <button type="button" class="result-annotation-cancel">Cancel</button>
+                </div>
+                <div class="result-annotation-error" role="alert"></div>
+            </form>
+        `;
+
+        // Values are set through the DOM so they need no escaping
+        const form = container.querySelector('form');
+        form.elements.tags.value = current.tags.join(', ');
+        form.elements.collections.value = current.collections.join(', ');
+        form.elements.note.value = current.note;
+        form.elements.tags.focus();
+
+        form.querySelector('.result-annotation-cancel').addEventListener('click', () => {
+            this.renderAnnotations(container, bookmark, current);
+        });
+        form.addEventListener('keydown', (e) => {
+            if (e.key === 'Escape') {
+                e.stopPropagation();
+                this.renderAnnotations(container, bookmark, current);
+            }
+        });
+        form.addEventListener('submit', async (e) => {
+            e.preventDefault();
+            const saveButton = form.querySelector('button[type="submit"]');
+            saveButton.disabled = true;
+            try {
+                const annotations = await this.saveAnnotations(bookmark, current, {
+                    tags: form.elements.tags.value.split(/[\s,]+/).filter(Boolean),
+                    collections: form.elements.collections.value.split(',').map(name => name.trim()).filter(Boolean),
+                    note: form.elements.note.value.trim(),
+                });
+                this.renderAnnotations(container, bookmark, annotations);
+                this.announceToScreenReader('Saved tags and note');
+            } catch (error) {
+                console.error('Failed to save annotations:', error);
+                form.querySelector('.result-annotation-error').textContent = `Failed to save: ${error.message}`;
+                saveButton.disabled = false;
+            }
+        });
+    }
+
+    async saveAnnotations(bookmark, current, edited) {
+        const params = new URLSearchParams();
+        if (bookmark.owner_account) {
+            params.set('account', bookmark.owner_account);
+        }
+        const base = `/api/bookmarks/${encodeURIComponent(bookmark.status_id)}`;
+
+        // Only send what changed; each response holds all the annotations
+        let annotations = current;
+        for (const kind of ['tags', 'collections', 'note']) {
+            if (JSON.stringify(edited[kind]) === JSON.stringify(current[kind])) {
+                continue;
+            }
+            const response = await this.apiFetch(`${base}/${kind}?${params}`, {
+                method: 'PUT',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ [kind]: edited[kind] }),
+            });
+            if (!response.ok) {
+                throw new Error(await this.errorMessage(response));
+            }
+            annotations = await response.json();
+        }
+        return annotations;
+    }
+
+    renderCard(card) {
+        if (!card || !card.url) return '';
+
+        // Only link to http(s) pages; the card comes from the remote server
+        if (!/^https?:\/\//i.test(card.url)) return '';
+
+        return `
+            <a class="result-link-card" href="${this.escapeHTML(card.url)}" target="_blank" rel="noopener noreferrer">
+                ${card.provider_name ? `<span class="result-link-card-provider">${this.escapeHTML(card.provider_name)}</span>` : ''}
+                <span class="result-link-card-title">${this.escapeHTML(card.title || card.url)}</span>
+                ${card.description ? `<span class="result-link-card-description">${this.escapeHTML(card.description)}</span>` : ''}
+            </a>
+        `;
+    }
+
+    renderMedia(media) {
+        if (!media || media.length === 0) return '';
+
+        // Group the archived variants by attachment, showing the preview and
+        // linking to the original when both were downloaded
+        const attachments = new Map();
+        for (const file of media) {
+            const attachment = attachments.get(file.media_id) || {};
+            attachment[file.variant] = file;
+            attachments.set(file.media_id, attachment);
+        }
+
+        const items = [];
+        for (const attachment of attachments.values()) {
+            const original = attachment.original || attachment.preview;
+            const thumbnail = attachment.preview || attachment.original;
+            const href = `/media/${this.escapeHTML(original.sha256)}`;
+            const content = thumbnail.mime_type.startsWith('image/')
+                ? `<img src="/media/${this.escapeHTML(thumbnail.sha256)}" alt="Archived attachment" loading="lazy">`
+                : `<span class="result-media-file">${this.escapeHTML(original.mime_type || 'Attachment')}</span>`;
+            items.push(`<a href="${href}" target="_blank" rel="noopener noreferrer">${content}</a>`);
+        }
+
+        return `<div class="result-media">${items.join('')}</div>`;
+    }
+
     escapeHTML(text) {
         const div = document.createElement('div');
         div.textContent = text;
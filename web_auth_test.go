@@ -0,0 +1,261 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// =============================================================================
+// WEB AUTHENTICATION TESTS
+// =============================================================================
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// Test vectors for PBKDF2-HMAC-SHA256 with password "password" and salt "salt"
+	for iterations, expected := range map[int]string{
+		1:    "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b",
+		2:    "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43",
+		4096: "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+	} {
+		if got := hex.EncodeToString(pbkdf2SHA256([]byte("password"), []byte("salt"), iterations, 32)); got != expected {
+			t.Errorf("Unexpected key for %d iterations: %s", iterations, got)
+		}
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := hashPassword("correct horse", 1000)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "pbkdf2-sha256$1000$") {
+		t.Errorf("Unexpected hash format %q", hash)
+	}
+	if ok, err := checkPassword(hash, "correct horse"); err != nil || !ok {
+		t.Errorf("Expected the password to match, got %v (%v)", ok, err)
+	}
+	if ok, _ := checkPassword(hash, "battery staple"); ok {
+		t.Error("Expected a wrong password not to match")
+	}
+	for _, invalid := range []string{"secret", "bcrypt$10$c2FsdA$a2V5", "pbkdf2-sha256$0$c2FsdA$a2V5", "pbkdf2-sha256$1000$!!$a2V5"} {
+		if _, err := checkPassword(invalid, "secret"); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestValidateAuthConfig(t *testing.T) {
+	cfg := &Config{}
+	if err := validateAuthConfig(cfg); err != nil {
+		t.Errorf("Expected no authentication to be valid, got %v", err)
+	}
+
+	cfg.Auth.PasswordHash = "plaintext"
+	if err := validateAuthConfig(cfg); err == nil {
+		t.Error("Expected a password that is not a hash to be rejected")
+	}
+	cfg.Auth.PasswordHash = ""
+	cfg.Auth.Tokens = []string{" "}
+	if err := validateAuthConfig(cfg); err == nil {
+		t.Error("Expected an empty token to be rejected")
+	}
+	cfg.Auth.Tokens = nil
+	cfg.Auth.SessionLifetime = "a month"
+	if err := validateAuthConfig(cfg); err == nil {
+		t.Error("Expected an invalid session lifetime to be rejected")
+	}
+}
+
+func TestDatabase_WebSessions(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	token, session, err := db.createWebSession(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if token == "" || session.CSRFToken == "" || token == session.CSRFToken {
+		t.Fatalf("Expected distinct session and CSRF tokens, got %q and %q", token, session.CSRFToken)
+	}
+
+	got, err := db.getWebSession(token)
+	if err != nil || got == nil || got.CSRFToken != session.CSRFToken {
+		t.Fatalf("Expected to find the session, got %+v (%v)", got, err)
+	}
+	if got, _ := db.getWebSession("not-a-session"); got != nil {
+		t.Error("Expected no session for an unknown token")
+	}
+
+	expired, _, err := db.createWebSession(-time.Minute)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	if got, _ := db.getWebSession(expired); got != nil {
+		t.Error("Expected an expired session not to be found")
+	}
+
+	if err := db.deleteWebSession(token); err != nil {
+		t.Fatalf("Failed to delete session: %v", err)
+	}
+	if got, _ := db.getWebSession(token); got != nil {
+		t.Error("Expected a deleted session not to be found")
+	}
+}
+
+func serveAuthTest(handler http.Handler, method, target, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebServer_Auth_BearerToken(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	cfg := &Config{}
+	cfg.Auth.Tokens = []string{"api-token"}
+	handler := newWebServer(cfg, db, eventChan).handler()
+
+	w := serveAuthTest(handler, "GET", "/api/collections", "", nil)
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Expected 401 with a challenge without a token, got %d", w.Code)
+	}
+	if w := serveAuthTest(handler, "GET", "/api/collections", "", http.Header{"Authorization": {"Bearer wrong"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", w.Code)
+	}
+	if w := serveAuthTest(handler, "GET", "/api/collections", "", http.Header{"Authorization": {"Bearer api-token"}}); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with the token, got %d", w.Code)
+	}
+	// Bearer requests carry no ambient credentials, so need no CSRF token
+	if w := serveAuthTest(handler, "PUT", "/api/bookmarks/status-1/note", `{"note": "x"}`, http.Header{"Authorization": {"Bearer api-token"}}); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the token to reach the handler, got %d", w.Code)
+	}
+
+	// Only feeds take the token as a parameter
+	if w := serveAuthTest(handler, "GET", "/feeds/bookmarks.atom?token=api-token", "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 for a feed with the token parameter, got %d", w.Code)
+	}
+	if w := serveAuthTest(handler, "GET", "/api/collections?token=api-token", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for the token parameter outside feeds, got %d", w.Code)
+	}
+
+	for _, public := range []string{"/", "/static/app.js", "/api/session", "/healthz"} {
+		if w := serveAuthTest(handler, "GET", public, "", nil); w.Code != http.StatusOK {
+			t.Errorf("Expected %s to be public, got %d", public, w.Code)
+		}
+	}
+}
+
+func TestWebServer_Auth_PasswordSession(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	bookmark := createTestBookmark("status-1", "a post")
+	if err := db.insertBookmark(bookmark); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	hash, err := hashPassword("secret", 1000)
+	if err != nil {
+		t.Fatalf("Failed to hash password: %v", err)
+	}
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	cfg := &Config{}
+	cfg.Auth.PasswordHash = hash
+	handler := newWebServer(cfg, db, eventChan).handler()
+	jsonBody := http.Header{"Content-Type": {"application/json"}}
+
+	var status AuthSession
+	w := serveAuthTest(handler, "GET", "/api/session", "", nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || !status.AuthRequired || status.Authenticated {
+		t.Errorf("Expected the session to require a login, got %+v (%v)", status, err)
+	}
+
+	if w := serveAuthTest(handler, "POST", "/api/login", `{"password": "wrong"}`, jsonBody); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong password, got %d", w.Code)
+	}
+	if w := serveAuthTest(handler, "POST", "/api/login", `password=secret`, http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}); w.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 for a form login, got %d", w.Code)
+	}
+
+	w = serveAuthTest(handler, "POST", "/api/login", `{"password": "secret"}`, jsonBody)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Fatalf("Expected an HttpOnly SameSite session cookie, got %+v", cookie)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil || status.CSRFToken == "" {
+		t.Fatalf("Expected a CSRF token, got %+v (%v)", status, err)
+	}
+	session := http.Header{"Cookie": {cookie.Name + "=" + cookie.Value}}
+
+	if w := serveAuthTest(handler, "GET", "/api/collections", "", session); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with the session, got %d", w.Code)
+	}
+	if w := serveAuthTest(handler, "PUT", "/api/bookmarks/status-1/note", `{"note": "x"}`, session); w.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 without the CSRF token, got %d", w.Code)
+	}
+	withCSRF := http.Header{"Cookie": session["Cookie"], csrfHeader: {status.CSRFToken}}
+	if w := serveAuthTest(handler, "PUT", "/api/bookmarks/status-1/note", `{"note": "x"}`, withCSRF); w.Code != http.StatusOK {
+		t.Errorf("Expected 200 with the CSRF token, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serveAuthTest(handler, "POST", "/api/logout", "", withCSRF); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204 for logout, got %d", w.Code)
+	}
+	if w := serveAuthTest(handler, "GET", "/api/collections", "", session); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 after logout, got %d", w.Code)
+	}
+}
+
+func TestWebServer_CORS(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	cfg := &Config{}
+	cfg.Web.CORSOrigins = []string{"https://dashboard.example.com/"}
+	cfg.Auth.Tokens = []string{"api-token"}
+	handler := newWebServer(cfg, db, eventChan).handler()
+
+	preflight := http.Header{"Origin": {"https://dashboard.example.com"}, "Access-Control-Request-Method": {"PUT"}}
+	w := serveAuthTest(handler, "OPTIONS", "/api/bookmarks/status-1/tags", "", preflight)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://dashboard.example.com" {
+		t.Errorf("Expected an allowed preflight, got %d with origin %q", w.Code, w.Header().Get("Access-Control-Allow-Origin"))
+	}
+	if !strings.Contains(w.Header().Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("Expected the Authorization header to be allowed, got %q", w.Header().Get("Access-Control-Allow-Headers"))
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("Expected credentials never to be allowed cross-origin")
+	}
+
+	w = serveAuthTest(handler, "GET", "/api/collections", "", http.Header{"Origin": {"https://evil.example"}, "Authorization": {"Bearer api-token"}})
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("Expected no CORS header for another origin, got %q", got)
+	}
+	w = serveAuthTest(handler, "GET", "/api/events", "", http.Header{"Origin": {"https://evil.example"}})
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" || w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the event stream to be closed to other origins, got %d with %q", w.Code, got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -218,8 +219,14 @@ func TestEventBroadcaster_CloseAllClients(t *testing.T) {
 func TestNewWebServer(t *testing.T) {
 	cfg := &Config{
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   8080,
@@ -348,7 +355,7 @@ func TestWebServer_HandleIndex_NotFound(t *testing.T) {
 	req := httptest.NewRequest("GET", "/nonexistent", nil)
 	w := httptest.NewRecorder()
 
-	webServer.handleIndex(w, req)
+	webServer.setupRoutes().ServeHTTP(w, req)
 
 	if w.Code != http.StatusNotFound {
 		t.Errorf("Expected status 404, got %d", w.Code)
@@ -369,7 +376,7 @@ func TestWebServer_HandleSearch_InvalidMethod(t *testing.T) {
 	req := httptest.NewRequest("GET", "/api/search", nil)
 	w := httptest.NewRecorder()
 
-	webServer.handleSearch(w, req)
+	webServer.setupRoutes().ServeHTTP(w, req)
 
 	if w.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected status 405, got %d", w.Code)
@@ -496,6 +503,34 @@ func TestWebServer_HandleSearch_EmptyQuery(t *testing.T) {
 	close(eventChan)
 }
 
+func TestWebServer_HandleSearch_MalformedQuery(t *testing.T) {
+	cfg := &Config{}
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(cfg, db, eventChan)
+
+	for _, query := range []string{`"unclosed phrase`, "has:poll", "before:yesterday", "rust OR"} {
+		requestBody, err := json.Marshal(SearchRequest{Query: query})
+		if err != nil {
+			t.Fatalf("Failed to marshal search request: %v", err)
+		}
+		req := httptest.NewRequest("POST", "/api/search", bytes.NewReader(requestBody))
+		w := httptest.NewRecorder()
+
+		webServer.handleSearch(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %q, got %d", query, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), "invalid search query") {
+			t.Errorf("Expected the query error in the body for %q, got %q", query, w.Body.String())
+		}
+	}
+}
+
 func TestWebServer_HandleStats_InvalidMethod(t *testing.T) {
 	cfg := &Config{}
 	db := setupTestDatabase(t)
@@ -507,7 +542,7 @@ func TestWebServer_HandleStats_InvalidMethod(t *testing.T) {
 	req := httptest.NewRequest("POST", "/api/stats", nil)
 	w := httptest.NewRecorder()
 
-	webServer.handleStats(w, req)
+	webServer.setupRoutes().ServeHTTP(w, req)
 
 	if w.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected status 405, got %d", w.Code)
@@ -517,6 +552,43 @@ func TestWebServer_HandleStats_InvalidMethod(t *testing.T) {
 	close(eventChan)
 }
 
+func TestWebServer_HandleStats_RateLimit(t *testing.T) {
+	cfg := &Config{}
+	cfg.Mastodon.Server = "https://mastodon.example.com"
+	cfg.Mastodon.AccessToken = "token"
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(cfg, db, eventChan)
+
+	rateLimiter := newRateLimiter(150, 5*time.Minute)
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "300")
+	header.Set("X-RateLimit-Remaining", "12")
+	header.Set("X-RateLimit-Reset", time.Now().Add(time.Minute).UTC().Format(time.RFC3339))
+	rateLimiter.update(header, time.Now())
+	webServer.rateLimiters = map[string]*RateLimiter{"": rateLimiter}
+
+	req := httptest.NewRequest("GET", "/api/stats", nil)
+	w := httptest.NewRecorder()
+	webServer.handleStats(w, req)
+
+	var stats struct {
+		RateLimit *RateLimiterState `json:"rate_limit"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if stats.RateLimit == nil {
+		t.Fatalf("Expected rate_limit in stats, got %s", w.Body.String())
+	}
+	if stats.RateLimit.Limit != 300 || stats.RateLimit.Remaining == nil || *stats.RateLimit.Remaining != 12 {
+		t.Errorf("Unexpected rate_limit: %+v", stats.RateLimit)
+	}
+}
+
 func TestWebServer_HandleStats_Success(t *testing.T) {
 	cfg := &Config{}
 	db := setupTestDatabase(t)
@@ -573,6 +645,171 @@ func TestWebServer_HandleStats_Success(t *testing.T) {
 	close(eventChan)
 }
 
+func TestWebServer_HandleAccounts(t *testing.T) {
+	cfg := &Config{Accounts: []AccountConfig{
+		{Name: "alice", Server: "https://mastodon.social"},
+		{Name: "bob", Server: "https://fosstodon.org"},
+	}}
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	if err := db.insertUserAccount(&UserAccount{OwnerAccount: "bob", AccountID: "42", Username: "bob", DisplayName: "Bob", Acct: "bob"}); err != nil {
+		t.Fatalf("Failed to insert user account: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	webServer := newWebServer(cfg, db, eventChan)
+
+	req := httptest.NewRequest("GET", "/api/accounts", nil)
+	w := httptest.NewRecorder()
+
+	webServer.handleAccounts(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var accounts []ArchiveAccount
+	if err := json.Unmarshal(w.Body.Bytes(), &accounts); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("Expected 2 accounts, got %d", len(accounts))
+	}
+	if accounts[0].Name != "alice" || accounts[0].User != nil {
+		t.Errorf("Unexpected first account: %+v", accounts[0])
+	}
+	if accounts[1].User == nil || accounts[1].User.Username != "bob" {
+		t.Errorf("Expected bob's user account, got %+v", accounts[1])
+	}
+}
+
+func TestWebServer_HandleThread(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	bookmark := createTestBookmark("status-2", "a reply")
+	bookmark.OwnerAccount = "alice"
+	if err := db.insertBookmark(bookmark); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+	thread := StatusContext{
+		Ancestors:   []Status{{ID: "status-1", Content: "the question"}},
+		Descendants: []Status{{ID: "status-3", Content: "a follow-up", InReplyToID: "status-2"}},
+	}
+	if err := db.replaceStatusContext("alice", "status-2", thread, time.Now()); err != nil {
+		t.Fatalf("Failed to store status context: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/bookmarks/status-2/thread?account=alice", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	var response StatusContext
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Ancestors) != 1 || response.Ancestors[0].Content != "the question" {
+		t.Errorf("Unexpected ancestors: %+v", response.Ancestors)
+	}
+	if len(response.Descendants) != 1 || response.Descendants[0].InReplyToID != "status-2" {
+		t.Errorf("Unexpected descendants: %+v", response.Descendants)
+	}
+
+	// Statuses outside the selected archive are not found
+	for _, target := range []string{"/api/bookmarks/status-2/thread", "/api/bookmarks/unknown/thread?account=alice"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestWebServer_HandleBookmarkAnnotations(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	bookmark := createTestBookmark("status-1", "a post")
+	bookmark.OwnerAccount = "alice"
+	if err := db.insertBookmark(bookmark); err != nil {
+		t.Fatalf("Failed to insert bookmark: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	put := func(target, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("PUT", target, strings.NewReader(body)))
+		return w
+	}
+
+	w := put("/api/bookmarks/status-1/tags?account=alice", `{"tags": ["#Later", "botany", "later"]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var annotations Annotations
+	if err := json.Unmarshal(w.Body.Bytes(), &annotations); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(annotations.Tags) != 2 || annotations.Tags[0] != "botany" || annotations.Tags[1] != "later" {
+		t.Errorf("Expected normalized tags, got %v", annotations.Tags)
+	}
+
+	if w := put("/api/bookmarks/status-1/note?account=alice", `{"note": " remember the walrus "}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the note, got %d", w.Code)
+	}
+	if w := put("/api/bookmarks/status-1/collections?account=alice", `{"collections": ["Reading list"]}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for collections, got %d", w.Code)
+	}
+
+	// Search results carry the annotations
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader(`{"query": "notes:walrus"}`)))
+	var results []*SearchResult
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatalf("Failed to unmarshal search results: %v", err)
+	}
+	if len(results) != 1 || results[0].Annotations == nil || results[0].Annotations.Note != "remember the walrus" ||
+		len(results[0].Annotations.Collections) != 1 {
+		t.Fatalf("Expected the annotated bookmark in search results, got %+v", results)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/api/collections?account=alice", nil))
+	var collections []*Collection
+	if err := json.Unmarshal(w.Body.Bytes(), &collections); err != nil {
+		t.Fatalf("Failed to unmarshal collections: %v", err)
+	}
+	if len(collections) != 1 || collections[0].Name != "Reading list" || collections[0].Count != 1 {
+		t.Errorf("Unexpected collections: %+v", collections)
+	}
+
+	for _, tc := range []struct {
+		target string
+		body   string
+		code   int
+	}{
+		{"/api/bookmarks/status-1/tags", `{"tags": ["later"]}`, http.StatusNotFound},
+		{"/api/bookmarks/unknown/note?account=alice", `{"note": "hi"}`, http.StatusNotFound},
+		{"/api/bookmarks/status-1/tags?account=alice", `{"tags": ["two words"]}`, http.StatusBadRequest},
+		{"/api/bookmarks/status-1/note?account=alice", `not json`, http.StatusBadRequest},
+	} {
+		if w := put(tc.target, tc.body); w.Code != tc.code {
+			t.Errorf("Expected %d for %s with %s, got %d", tc.code, tc.target, tc.body, w.Code)
+		}
+	}
+}
+
 func TestWebServer_HandleEvents_InvalidMethod(t *testing.T) {
 	cfg := &Config{}
 	db := setupTestDatabase(t)
@@ -584,7 +821,7 @@ func TestWebServer_HandleEvents_InvalidMethod(t *testing.T) {
 	req := httptest.NewRequest("POST", "/api/events", nil)
 	w := httptest.NewRecorder()
 
-	webServer.handleEvents(w, req)
+	webServer.setupRoutes().ServeHTTP(w, req)
 
 	if w.Code != http.StatusMethodNotAllowed {
 		t.Errorf("Expected status 405, got %d", w.Code)
@@ -614,12 +851,10 @@ func TestWebServer_HandleEvents_SSEHeaders(t *testing.T) {
 
 	// Check SSE headers
 	expectedHeaders := map[string]string{
-		"Content-Type":                 "text/event-stream",
-		"Cache-Control":                "no-cache",
-		"Connection":                   "keep-alive",
-		"Access-Control-Allow-Origin":  "*",
-		"Access-Control-Allow-Headers": "Cache-Control",
-		"X-Accel-Buffering":            "no",
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"Connection":        "keep-alive",
+		"X-Accel-Buffering": "no",
 	}
 
 	for header, expectedValue := range expectedHeaders {
@@ -732,8 +967,14 @@ func TestWebServer_HandleEvents_Heartbeat(t *testing.T) {
 func TestWebServer_Start_Success(t *testing.T) {
 	cfg := &Config{
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   0, // Use port 0 for testing to get any available port
@@ -783,8 +1024,14 @@ func TestWebServer_Stop_WithoutStart(t *testing.T) {
 func TestWebServer_Stop_AfterStart(t *testing.T) {
 	cfg := &Config{
 		Web: struct {
-			Listen string `toml:"listen"`
-			Port   int    `toml:"port"`
+			Listen      string   `toml:"listen"`
+			Port        int      `toml:"port"`
+			BaseURL     string   `toml:"base_url"`
+			CORSOrigins []string `toml:"cors_origins"`
+			TLSCert     string   `toml:"tls_cert"`
+			TLSKey      string   `toml:"tls_key"`
+			Socket      string   `toml:"socket"`
+			SocketMode  string   `toml:"socket_mode"`
 		}{
 			Listen: "127.0.0.1",
 			Port:   0, // Use port 0 for testing
@@ -909,3 +1156,291 @@ func TestWebServer_HandleSearch_DatabaseError(t *testing.T) {
 	// Clean up
 	close(eventChan)
 }
+
+func serveAPITest(mux *http.ServeMux, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(method, target, nil))
+	return w
+}
+
+func decodeAPIError(t *testing.T, w *httptest.ResponseRecorder) APIError {
+	t.Helper()
+	if contentType := w.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected a JSON error, got %s: %s", contentType, w.Body.String())
+	}
+	var apiError APIError
+	if err := json.Unmarshal(w.Body.Bytes(), &apiError); err != nil {
+		t.Fatalf("Failed to decode error: %v", err)
+	}
+	if apiError.Status != w.Code || apiError.Error == "" {
+		t.Errorf("Expected an error with status %d, got %+v", w.Code, apiError)
+	}
+	return apiError
+}
+
+func TestWebServer_ListBookmarks_Pagination(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	// Pairs share a bookmark time, so pages must break ties by key
+	var expected []string
+	for i := 5; i >= 0; i-- {
+		bookmark := convertBookmarkToDatabase(testBookmark(fmt.Sprintf("status-%d", i)), []string{"content"})
+		bookmark.OwnerAccount = "alice"
+		bookmark.BookmarkedAt = time.Date(2024, 1, 1+i/2, 0, 0, 0, 0, time.UTC)
+		if err := db.insertBookmark(bookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+		expected = append(expected, bookmark.StatusID)
+	}
+	favourite := convertBookmarkToDatabase(testBookmark("status-5"), []string{"content"})
+	favourite.OwnerAccount = "alice"
+	favourite.Source = SourceFavourite
+	if err := db.insertBookmark(favourite); err != nil {
+		t.Fatalf("Failed to insert favourite: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	var listed []string
+	target := "/api/bookmarks?account=alice&filter_by_source=bookmark&limit=4"
+	for pages := 0; target != ""; pages++ {
+		if pages == 3 {
+			t.Fatal("Expected two pages")
+		}
+		w := serveAPITest(mux, "GET", target)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var page BookmarkPage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("Failed to decode page: %v", err)
+		}
+		for _, bookmark := range page.Bookmarks {
+			if bookmark.Source != SourceBookmark {
+				t.Errorf("Expected only bookmarks, got %s", bookmark.Source)
+			}
+			listed = append(listed, bookmark.StatusID)
+		}
+		target = ""
+		if page.NextCursor != "" {
+			target = "/api/bookmarks?account=alice&filter_by_source=bookmark&limit=4&cursor=" + page.NextCursor
+		}
+	}
+	if strings.Join(listed, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %v newest first, got %v", expected, listed)
+	}
+
+	for _, target := range []string{
+		"/api/bookmarks?cursor=nonsense",
+		"/api/bookmarks?offset=10",
+		"/api/bookmarks?sort_by=rank",
+		"/api/bookmarks?limit=-1",
+	} {
+		w := serveAPITest(mux, "GET", target)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, w.Code)
+		}
+		decodeAPIError(t, w)
+	}
+}
+
+func TestWebServer_GetBookmark(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := serveAPITest(mux, "GET", "/api/bookmarks/status-1?account=alice")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var resource struct {
+		BookmarkResource
+		Status Status `json:"status"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resource); err != nil {
+		t.Fatalf("Failed to decode bookmark: %v", err)
+	}
+	if resource.Status.URL != "https://example.social/@bob/1" || resource.Status.Account.Username != "bob" {
+		t.Errorf("Expected the parsed status, got %+v", resource.Status)
+	}
+	if resource.Note != "read <this>" || strings.Join(resource.Tags, ",") != "later" || strings.Join(resource.Sources, ",") != SourceBookmark {
+		t.Errorf("Unexpected bookmark: %+v", resource.BookmarkResource)
+	}
+
+	// Legacy rows only have their text
+	w = serveAPITest(mux, "GET", "/api/bookmarks/legacy-1")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":null`) || !strings.Contains(w.Body.String(), "legacy text") {
+		t.Errorf("Expected the legacy bookmark's text, got %d: %s", w.Code, w.Body.String())
+	}
+
+	for _, target := range []string{
+		"/api/bookmarks/status-1",
+		"/api/bookmarks/status-1?account=alice&source=favourite",
+		"/api/bookmarks/unknown?account=alice",
+	} {
+		w := serveAPITest(mux, "GET", target)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for %s, got %d", target, w.Code)
+		}
+		decodeAPIError(t, w)
+	}
+}
+
+func TestWebServer_DeleteBookmark(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	insertExportTestBookmarks(t, db)
+	favourite := convertBookmarkToDatabase(testBookmark("status-1"), []string{"content"})
+	favourite.OwnerAccount = "alice"
+	favourite.Source = SourceFavourite
+	if err := db.insertBookmark(favourite); err != nil {
+		t.Fatalf("Failed to insert favourite: %v", err)
+	}
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	// Removing one copy keeps the annotations shared with the other
+	if w := serveAPITest(mux, "DELETE", "/api/bookmarks/status-1?account=alice&source=favourite"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	collections, err := db.listCollections("alice")
+	if err != nil {
+		t.Fatalf("Failed to list collections: %v", err)
+	}
+	if len(collections) != 1 {
+		t.Errorf("Expected the collection to remain, got %+v", collections)
+	}
+
+	if w := serveAPITest(mux, "DELETE", "/api/bookmarks/status-1?account=alice"); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveAPITest(mux, "GET", "/api/bookmarks/status-1?account=alice"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected the bookmark to be gone, got %d", w.Code)
+	}
+	collections, err = db.listCollections("alice")
+	if err != nil {
+		t.Fatalf("Failed to list collections: %v", err)
+	}
+	if len(collections) != 0 {
+		t.Errorf("Expected the emptied collection to be removed, got %+v", collections)
+	}
+	results, err := db.searchOrRecentBookmarks(&SearchRequest{Query: "mosses", Account: "alice"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("Expected the bookmark to leave the search index, got %d results", len(results))
+	}
+
+	w := serveAPITest(mux, "DELETE", "/api/bookmarks/status-1?account=alice")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted bookmark, got %d", w.Code)
+	}
+	decodeAPIError(t, w)
+}
+
+func TestWebServer_BookmarkAccounts(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+	for _, owner := range []string{"alice", "bob"} {
+		bookmark := testBookmark("status-1")
+		bookmark.Status.Content = "archived by " + owner
+		dbBookmark := convertBookmarkToDatabase(bookmark, []string{"content"})
+		dbBookmark.OwnerAccount = owner
+		if err := db.insertBookmark(dbBookmark); err != nil {
+			t.Fatalf("Failed to insert bookmark: %v", err)
+		}
+	}
+
+	cfg := &Config{Accounts: []AccountConfig{
+		{Name: "alice", Server: "https://mastodon.social"},
+		{Name: "bob", Server: "https://fosstodon.org"},
+	}}
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(cfg, db, eventChan).setupRoutes()
+
+	// Both archives hold the status, so neither is picked for the caller
+	for _, method := range []string{"GET", "DELETE"} {
+		for _, target := range []string{"/api/bookmarks/status-1", "/api/bookmarks/status-1?account=carol"} {
+			w := serveAPITest(mux, method, target)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s %s, got %d", method, target, w.Code)
+			}
+			if apiError := decodeAPIError(t, w); !strings.Contains(apiError.Error, "alice, bob") {
+				t.Errorf("Expected the error to list the accounts, got %q", apiError.Error)
+			}
+		}
+	}
+
+	// Each listed copy says whose it is and where to find it
+	w := serveAPITest(mux, "GET", "/api/bookmarks")
+	var page BookmarkPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatalf("Failed to decode page: %v", err)
+	}
+	urls := make(map[string]string)
+	for _, bookmark := range page.Bookmarks {
+		urls[bookmark.Account] = bookmark.URL
+	}
+	if urls["bob"] != "/api/bookmarks/status-1?account=bob&source=bookmark" || len(urls) != 2 {
+		t.Fatalf("Expected a URL per account, got %v", urls)
+	}
+
+	w = serveAPITest(mux, "GET", urls["bob"])
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "archived by bob") {
+		t.Fatalf("Expected bob's copy, got %d: %s", w.Code, w.Body.String())
+	}
+
+	if w := serveAPITest(mux, "DELETE", urls["bob"]); w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", w.Code, w.Body.String())
+	}
+	if w := serveAPITest(mux, "GET", urls["bob"]); w.Code != http.StatusNotFound {
+		t.Errorf("Expected bob's copy to be gone, got %d", w.Code)
+	}
+	w = serveAPITest(mux, "GET", urls["alice"])
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "archived by alice") {
+		t.Errorf("Expected alice's copy to remain, got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestWebServer_APIRouteErrors(t *testing.T) {
+	db := setupTestDatabase(t)
+	defer db.close()
+
+	eventChan := make(chan ServerEvent, 10)
+	defer close(eventChan)
+	mux := newWebServer(&Config{}, db, eventChan).setupRoutes()
+
+	w := serveAPITest(mux, "GET", "/api/nonexistent")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", w.Code)
+	}
+	decodeAPIError(t, w)
+
+	w = serveAPITest(mux, "POST", "/api/bookmarks/status-1")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405, got %d", w.Code)
+	}
+	if allow := w.Header().Get("Allow"); allow != "GET, HEAD, DELETE" {
+		t.Errorf("Expected GET, HEAD and DELETE to be allowed, got %q", allow)
+	}
+	decodeAPIError(t, w)
+
+	// Handlers answer with the same bodies
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/api/search", strings.NewReader("{")))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid JSON, got %d", w.Code)
+	}
+	decodeAPIError(t, w)
+}